@@ -0,0 +1,709 @@
+// Package ast declares the types used to represent syntax trees
+// for PL/0 programs.
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pos is a source position.
+type Pos struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number (in characters), starting at 1
+}
+
+// IsValid reports whether the position is valid.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// Before reports whether p is before q.
+func (p Pos) Before(q Pos) bool {
+	return p.Offset < q.Offset
+}
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Operator is an operator of expressions and conditions.
+type Operator string
+
+const (
+	// OpAdd is operator '+'.
+	OpAdd Operator = "+"
+	// OpSub is operator '-'.
+	OpSub Operator = "-"
+	// OpMul is operator '*'.
+	OpMul Operator = "*"
+	// OpDiv is operator '/'.
+	OpDiv Operator = "/"
+	// OpMod is operator 'mod'.
+	OpMod Operator = "mod"
+	// OpOdd is operator 'odd'.
+	OpOdd Operator = "odd"
+	// OpEq is operator '='.
+	OpEq Operator = "="
+	// OpNeq is operator '<>'.
+	OpNeq Operator = "<>"
+	// OpLs is operator '<'.
+	OpLs Operator = "<"
+	// OpGr is operator '>'.
+	OpGr Operator = ">"
+	// OpLsEq is operator '<='.
+	OpLsEq Operator = "<="
+	// OpGrEq is operator '>='.
+	OpGrEq Operator = ">="
+	// OpAnd is operator 'and'.
+	OpAnd Operator = "and"
+	// OpOr is operator 'or'.
+	OpOr Operator = "or"
+	// OpNot is operator 'not'.
+	OpNot Operator = "not"
+)
+
+// IsLogical reports whether op is 'and', 'or' or 'not',
+// whose operands are conditions.
+func (op Operator) IsLogical() bool {
+	return op == OpAnd || op == OpOr || op == OpNot
+}
+
+// IsRelational reports whether op is a relational operator.
+func (op Operator) IsRelational() bool {
+	switch op {
+	case OpEq, OpNeq, OpLs, OpGr, OpLsEq, OpGrEq:
+		return true
+	}
+	return false
+}
+
+// Node is a node of syntax trees.
+type Node interface {
+	Pos() Pos // position of first character belonging to the node
+	End() Pos // position of first character immediately after the node
+}
+
+// Expr is an expression node.
+// Conditions are also represented by Expr.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Stmt is a statement node.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Decl is a declaration node.
+type Decl interface {
+	Node
+	declNode()
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+// BadExpr is a placeholder for an expression containing syntax errors.
+type BadExpr struct {
+	From, To Pos
+}
+
+// Ident is an identifier.
+type Ident struct {
+	NamePos Pos
+	Name    string
+}
+
+// NumberLit is a number literal.
+type NumberLit struct {
+	ValuePos Pos
+	Value    int
+	Text     string
+}
+
+// StringLit is a string literal, which is only an item of write statements.
+type StringLit struct {
+	ValuePos Pos
+	Value    string
+	Text     string // with quotes and escape sequences
+}
+
+// WidthExpr is X ':' Width, an item of write statements which writes X
+// in the field of Width characters.
+type WidthExpr struct {
+	X     Expr
+	Colon Pos
+	Width Expr
+}
+
+// ParenExpr is a parenthesized expression.
+type ParenExpr struct {
+	Lparen Pos
+	X      Expr
+	Rparen Pos
+}
+
+// UnaryExpr is a unary expression: '+' X, '-' X or 'odd' X.
+type UnaryExpr struct {
+	OpPos Pos
+	Op    Operator
+	X     Expr
+}
+
+// BinaryExpr is a binary expression or a relational condition.
+type BinaryExpr struct {
+	X     Expr
+	OpPos Pos
+	Op    Operator
+	Y     Expr
+}
+
+// IndexExpr is an array element: Name '[' Indices ']',
+// where the indices are separated by commas.
+type IndexExpr struct {
+	Name    *Ident
+	Lbrack  Pos
+	Indices []Expr
+	Rbrack  Pos
+}
+
+// CallExpr is a function call: Func '(' Args ')'.
+// In a call statement of Wirth's PL/0, it is Func without parentheses,
+// whose positions are invalid.
+type CallExpr struct {
+	Func   *Ident
+	Lparen Pos
+	Args   []Expr
+	Rparen Pos
+}
+
+// Pos returns the position of the node.
+func (x *BadExpr) Pos() Pos { return x.From }
+
+// Pos returns the position of the node.
+func (x *Ident) Pos() Pos { return x.NamePos }
+
+// Pos returns the position of the node.
+func (x *NumberLit) Pos() Pos { return x.ValuePos }
+
+// Pos returns the position of the node.
+func (x *StringLit) Pos() Pos { return x.ValuePos }
+
+// Pos returns the position of the node.
+func (x *WidthExpr) Pos() Pos { return x.X.Pos() }
+
+// Pos returns the position of the node.
+func (x *ParenExpr) Pos() Pos { return x.Lparen }
+
+// Pos returns the position of the node.
+func (x *UnaryExpr) Pos() Pos { return x.OpPos }
+
+// Pos returns the position of the node.
+func (x *BinaryExpr) Pos() Pos { return x.X.Pos() }
+
+// Pos returns the position of the node.
+func (x *IndexExpr) Pos() Pos { return x.Name.Pos() }
+
+// Pos returns the position of the node.
+func (x *CallExpr) Pos() Pos { return x.Func.Pos() }
+
+// End returns the end position of the node.
+func (x *BadExpr) End() Pos { return x.To }
+
+// End returns the end position of the node.
+func (x *Ident) End() Pos { return advance(x.NamePos, len(x.Name)) }
+
+// End returns the end position of the node.
+func (x *NumberLit) End() Pos { return advance(x.ValuePos, len(x.Text)) }
+
+// End returns the end position of the node.
+func (x *StringLit) End() Pos {
+	if !x.ValuePos.IsValid() {
+		return x.ValuePos
+	}
+	return Pos{
+		Offset: x.ValuePos.Offset + len(x.Text),
+		Line:   x.ValuePos.Line,
+		Column: x.ValuePos.Column + utf8.RuneCountInString(x.Text),
+	}
+}
+
+// End returns the end position of the node.
+func (x *WidthExpr) End() Pos { return x.Width.End() }
+
+// End returns the end position of the node.
+func (x *ParenExpr) End() Pos { return advance(x.Rparen, 1) }
+
+// End returns the end position of the node.
+func (x *UnaryExpr) End() Pos { return x.X.End() }
+
+// End returns the end position of the node.
+func (x *BinaryExpr) End() Pos { return x.Y.End() }
+
+// End returns the end position of the node.
+func (x *IndexExpr) End() Pos { return advance(x.Rbrack, 1) }
+
+// End returns the end position of the node.
+func (x *CallExpr) End() Pos {
+	if !x.Rparen.IsValid() {
+		return x.Func.End()
+	}
+	return advance(x.Rparen, 1)
+}
+
+func (*BadExpr) exprNode()    {}
+func (*Ident) exprNode()      {}
+func (*NumberLit) exprNode()  {}
+func (*StringLit) exprNode()  {}
+func (*WidthExpr) exprNode()  {}
+func (*ParenExpr) exprNode()  {}
+func (*UnaryExpr) exprNode()  {}
+func (*BinaryExpr) exprNode() {}
+func (*IndexExpr) exprNode()  {}
+func (*CallExpr) exprNode()   {}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// BadStmt is a placeholder for a statement containing syntax errors.
+type BadStmt struct {
+	From, To Pos
+}
+
+// EmptyStmt is an empty statement.
+type EmptyStmt struct {
+	At Pos // position of the following token
+}
+
+// AssignStmt is an assignment: Name ['[' Indices ']'] ':=' Value.
+type AssignStmt struct {
+	Name    *Ident
+	Indices []Expr // nil for scalar variables
+	Assign  Pos
+	Value   Expr
+}
+
+// CompoundStmt is 'begin' List 'end'.
+type CompoundStmt struct {
+	Begin  Pos
+	List   []Stmt
+	EndPos Pos // position of 'end'
+}
+
+// IfStmt is 'if' Cond 'then' Then ['else' Else].
+type IfStmt struct {
+	If   Pos
+	Cond Expr
+	Then Stmt
+	Else Stmt // nil if there is no else part
+}
+
+// WhileStmt is 'while' Cond 'do' Body.
+type WhileStmt struct {
+	While Pos
+	Cond  Expr
+	Body  Stmt
+}
+
+// RepeatStmt is 'repeat' Body 'until' Cond.
+type RepeatStmt struct {
+	Repeat Pos
+	Body   Stmt
+	Cond   Expr
+}
+
+// ForStmt is 'for' Var ':=' From ('to' | 'downto') To ['step' Step] 'do' Body.
+type ForStmt struct {
+	For  Pos
+	Var  *Ident
+	From Expr
+	Down bool // 'downto'
+	To   Expr
+	Step Expr // nil if there is no step
+	Body Stmt
+}
+
+// BranchStmt is 'break' or 'continue' of the innermost loop.
+type BranchStmt struct {
+	Branch   Pos
+	Continue bool // 'continue'
+}
+
+// CaseStmt is 'case' X 'of' Clauses ['else' Else] 'end'.
+// The clauses are separated by semicolons.
+type CaseStmt struct {
+	Case    Pos
+	X       Expr
+	Clauses []*CaseClause
+	Else    Stmt // nil if there is no else part
+	EndPos  Pos  // position of 'end'
+}
+
+// CaseClause is Labels ':' Body in a case statement.
+// The labels are constants separated by commas.
+type CaseClause struct {
+	Labels []Expr
+	Colon  Pos
+	Body   Stmt
+}
+
+// ReturnStmt is 'return' [Result].
+type ReturnStmt struct {
+	Return Pos
+	Result Expr // nil in procedures
+}
+
+// WriteStmt is 'write' Items, which are separated by commas.
+// Each item is an expression, *StringLit or *WidthExpr.
+type WriteStmt struct {
+	Write Pos
+	Items []Expr
+}
+
+// WritelnStmt is 'writeln' [Items], which writes a newline after the items.
+type WritelnStmt struct {
+	Writeln Pos
+	Items   []Expr // nil if there are no items
+}
+
+// CallStmt is a procedure call: ['call'] X.
+type CallStmt struct {
+	Call Pos // invalid if there is no 'call'
+	X    *CallExpr
+}
+
+// InputStmt is '?' Name of Wirth's PL/0, which reads a number into Name.
+type InputStmt struct {
+	Question Pos
+	Name     *Ident
+}
+
+// OutputStmt is '!' X of Wirth's PL/0, which writes X and a newline.
+type OutputStmt struct {
+	Exclamation Pos
+	X           Expr
+}
+
+// Pos returns the position of the node.
+func (s *BadStmt) Pos() Pos { return s.From }
+
+// Pos returns the position of the node.
+func (s *EmptyStmt) Pos() Pos { return s.At }
+
+// Pos returns the position of the node.
+func (s *AssignStmt) Pos() Pos { return s.Name.Pos() }
+
+// Pos returns the position of the node.
+func (s *CompoundStmt) Pos() Pos { return s.Begin }
+
+// Pos returns the position of the node.
+func (s *IfStmt) Pos() Pos { return s.If }
+
+// Pos returns the position of the node.
+func (s *WhileStmt) Pos() Pos { return s.While }
+
+// Pos returns the position of the node.
+func (s *RepeatStmt) Pos() Pos { return s.Repeat }
+
+// Pos returns the position of the node.
+func (s *ForStmt) Pos() Pos { return s.For }
+
+// Pos returns the position of the node.
+func (s *BranchStmt) Pos() Pos { return s.Branch }
+
+// Pos returns the position of the node.
+func (s *CaseStmt) Pos() Pos { return s.Case }
+
+// Pos returns the position of the node.
+func (c *CaseClause) Pos() Pos { return c.Labels[0].Pos() }
+
+// Pos returns the position of the node.
+func (s *ReturnStmt) Pos() Pos { return s.Return }
+
+// Pos returns the position of the node.
+func (s *WriteStmt) Pos() Pos { return s.Write }
+
+// Pos returns the position of the node.
+func (s *WritelnStmt) Pos() Pos { return s.Writeln }
+
+// Pos returns the position of the node.
+func (s *CallStmt) Pos() Pos {
+	if !s.Call.IsValid() {
+		return s.X.Pos()
+	}
+	return s.Call
+}
+
+// Pos returns the position of the node.
+func (s *InputStmt) Pos() Pos { return s.Question }
+
+// Pos returns the position of the node.
+func (s *OutputStmt) Pos() Pos { return s.Exclamation }
+
+// End returns the end position of the node.
+func (s *BadStmt) End() Pos { return s.To }
+
+// End returns the end position of the node.
+func (s *EmptyStmt) End() Pos { return s.At }
+
+// End returns the end position of the node.
+func (s *AssignStmt) End() Pos { return s.Value.End() }
+
+// End returns the end position of the node.
+func (s *CompoundStmt) End() Pos { return advance(s.EndPos, len("end")) }
+
+// End returns the end position of the node.
+func (s *IfStmt) End() Pos {
+	if s.Else != nil {
+		return s.Else.End()
+	}
+	return s.Then.End()
+}
+
+// End returns the end position of the node.
+func (s *WhileStmt) End() Pos { return s.Body.End() }
+
+// End returns the end position of the node.
+func (s *RepeatStmt) End() Pos { return s.Cond.End() }
+
+// End returns the end position of the node.
+func (s *ForStmt) End() Pos { return s.Body.End() }
+
+// End returns the end position of the node.
+func (s *BranchStmt) End() Pos {
+	if s.Continue {
+		return advance(s.Branch, len("continue"))
+	}
+	return advance(s.Branch, len("break"))
+}
+
+// End returns the end position of the node.
+func (s *CaseStmt) End() Pos { return advance(s.EndPos, len("end")) }
+
+// End returns the end position of the node.
+func (c *CaseClause) End() Pos { return c.Body.End() }
+
+// End returns the end position of the node.
+func (s *ReturnStmt) End() Pos {
+	if s.Result == nil {
+		return advance(s.Return, len("return"))
+	}
+	return s.Result.End()
+}
+
+// End returns the end position of the node.
+func (s *WriteStmt) End() Pos {
+	if len(s.Items) == 0 {
+		return advance(s.Write, len("write"))
+	}
+	return s.Items[len(s.Items)-1].End()
+}
+
+// End returns the end position of the node.
+func (s *WritelnStmt) End() Pos {
+	if len(s.Items) == 0 {
+		return advance(s.Writeln, len("writeln"))
+	}
+	return s.Items[len(s.Items)-1].End()
+}
+
+// End returns the end position of the node.
+func (s *CallStmt) End() Pos { return s.X.End() }
+
+// End returns the end position of the node.
+func (s *InputStmt) End() Pos { return s.Name.End() }
+
+// End returns the end position of the node.
+func (s *OutputStmt) End() Pos { return s.X.End() }
+
+func (*BadStmt) stmtNode()      {}
+func (*EmptyStmt) stmtNode()    {}
+func (*AssignStmt) stmtNode()   {}
+func (*CompoundStmt) stmtNode() {}
+func (*IfStmt) stmtNode()       {}
+func (*WhileStmt) stmtNode()    {}
+func (*RepeatStmt) stmtNode()   {}
+func (*ForStmt) stmtNode()      {}
+func (*BranchStmt) stmtNode()   {}
+func (*CaseStmt) stmtNode()     {}
+func (*ReturnStmt) stmtNode()   {}
+func (*WriteStmt) stmtNode()    {}
+func (*WritelnStmt) stmtNode()  {}
+func (*CallStmt) stmtNode()     {}
+func (*InputStmt) stmtNode()    {}
+func (*OutputStmt) stmtNode()   {}
+
+// ----------------------------------------------------------------------------
+// Declarations
+
+// ConstSpec is Name '=' Value in a const declaration.
+type ConstSpec struct {
+	Name  *Ident
+	Value *NumberLit
+}
+
+// VarSpec is Name ['[' Sizes ']'] in a var declaration,
+// where the sizes of the dimensions are separated by commas.
+type VarSpec struct {
+	Name   *Ident
+	Sizes  []Expr // *NumberLit or *Ident, nil for scalar variables
+	Rbrack Pos
+}
+
+// Param is a function parameter: ['var'] Name ['[' [','...] ']'].
+type Param struct {
+	Var    Pos // position of 'var' of a scalar reference parameter, invalid otherwise
+	Name   *Ident
+	Ref    bool // array reference parameter
+	Rank   int  // number of dimensions of the array reference, 1 if 0
+	Rbrack Pos
+}
+
+// ConstDecl is 'const' Specs ';'.
+type ConstDecl struct {
+	Const     Pos
+	Specs     []*ConstSpec
+	Semicolon Pos
+}
+
+// VarDecl is 'var' Specs ';'.
+type VarDecl struct {
+	Var       Pos
+	Specs     []*VarSpec
+	Semicolon Pos
+}
+
+// FuncDecl is 'function' Name '(' Params ')' Body ';',
+// or 'procedure' Name '(' Params ')' Body ';', which returns no value.
+// Procedures of Wirth's PL/0 are 'procedure' Name ';' Body ';'.
+type FuncDecl struct {
+	Func      Pos // position of 'function' or 'procedure'
+	Proc      bool
+	Name      *Ident
+	Params    []*Param
+	Body      *Block
+	Semicolon Pos
+}
+
+// Pos returns the position of the node.
+func (s *ConstSpec) Pos() Pos { return s.Name.Pos() }
+
+// Pos returns the position of the node.
+func (s *VarSpec) Pos() Pos { return s.Name.Pos() }
+
+// Pos returns the position of the node.
+func (p *Param) Pos() Pos {
+	if p.Var.IsValid() {
+		return p.Var
+	}
+	return p.Name.Pos()
+}
+
+// Pos returns the position of the node.
+func (d *ConstDecl) Pos() Pos { return d.Const }
+
+// Pos returns the position of the node.
+func (d *VarDecl) Pos() Pos { return d.Var }
+
+// Pos returns the position of the node.
+func (d *FuncDecl) Pos() Pos { return d.Func }
+
+// End returns the end position of the node.
+func (s *ConstSpec) End() Pos { return s.Value.End() }
+
+// End returns the end position of the node.
+func (s *VarSpec) End() Pos {
+	if s.Sizes != nil {
+		return advance(s.Rbrack, 1)
+	}
+	return s.Name.End()
+}
+
+// End returns the end position of the node.
+func (p *Param) End() Pos {
+	if p.Ref {
+		return advance(p.Rbrack, 1)
+	}
+	return p.Name.End()
+}
+
+// End returns the end position of the node.
+func (d *ConstDecl) End() Pos { return advance(d.Semicolon, 1) }
+
+// End returns the end position of the node.
+func (d *VarDecl) End() Pos { return advance(d.Semicolon, 1) }
+
+// End returns the end position of the node.
+func (d *FuncDecl) End() Pos { return advance(d.Semicolon, 1) }
+
+func (*ConstDecl) declNode() {}
+func (*VarDecl) declNode()   {}
+func (*FuncDecl) declNode()  {}
+
+// ----------------------------------------------------------------------------
+// Comments
+
+// Comment is a comment: '{' ... '}', '(*' ... '*)' or '//' to the end of line.
+// Text includes the delimiters, but not the newline of a line comment.
+type Comment struct {
+	From, To Pos
+	Text     string
+}
+
+// Pos returns the position of the node.
+func (c *Comment) Pos() Pos { return c.From }
+
+// End returns the end position of the node.
+func (c *Comment) End() Pos { return c.To }
+
+// IsLine reports whether the comment is a '//' line comment.
+func (c *Comment) IsLine() bool { return strings.HasPrefix(c.Text, "//") }
+
+// ----------------------------------------------------------------------------
+// Blocks and programs
+
+// Block is declarations followed by a statement.
+type Block struct {
+	Decls []Decl
+	Body  Stmt
+}
+
+// Program is Block '.'.
+type Program struct {
+	Block    *Block
+	Period   Pos
+	Comments []*Comment // all comments in the source, in order of position
+}
+
+// Pos returns the position of the node.
+func (b *Block) Pos() Pos {
+	if len(b.Decls) > 0 {
+		return b.Decls[0].Pos()
+	}
+	return b.Body.Pos()
+}
+
+// End returns the end position of the node.
+func (b *Block) End() Pos { return b.Body.End() }
+
+// Pos returns the position of the node.
+func (p *Program) Pos() Pos { return p.Block.Pos() }
+
+// End returns the end position of the node.
+func (p *Program) End() Pos { return advance(p.Period, 1) }
+
+// advance returns the position n characters after p on the same line.
+func advance(p Pos, n int) Pos {
+	if !p.IsValid() {
+		return p
+	}
+	return Pos{Offset: p.Offset + n, Line: p.Line, Column: p.Column + n}
+}
@@ -0,0 +1,180 @@
+package ast_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"kkpl0/ast"
+	"kkpl0/pl0compiler"
+)
+
+const testSource = `const n = 3; { count }
+var a[n], i;
+function f(x, ap[]) return ap[x] * (-x);
+begin
+  i := 0;
+  while i < n do begin a[i] := i + 1; i := i + 1 end;
+  if odd f(1, a) then write (i) else writeln;
+  repeat i := i - 1 until i = 0
+end.`
+
+func parse(t *testing.T, src string) *ast.Program {
+	prog, err := pl0compiler.Parse("test", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestInspect(t *testing.T) {
+	prog := parse(t, testSource)
+	var types []string
+	depth := 0
+	ast.Inspect(prog, func(node ast.Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		if _, ok := node.(*ast.FuncDecl); ok {
+			types = append(types, "FuncDecl")
+			return false
+		}
+		types = append(types, fmt.Sprintf("%d%s", depth,
+			strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")))
+		depth++
+		return true
+	})
+	want := "0Program 1Block 2ConstDecl 3ConstSpec 4Ident 4NumberLit " +
+		"2VarDecl 3VarSpec 4Ident 4Ident 3VarSpec 4Ident FuncDecl " +
+		"2CompoundStmt 3AssignStmt 4Ident 4NumberLit " +
+		"3WhileStmt 4BinaryExpr 5Ident 5Ident 4CompoundStmt " +
+		"5AssignStmt 6Ident 6Ident 6BinaryExpr 7Ident 7NumberLit " +
+		"5AssignStmt 6Ident 6BinaryExpr 7Ident 7NumberLit " +
+		"3IfStmt 4UnaryExpr 5CallExpr 6Ident 6NumberLit 6Ident " +
+		"4WriteStmt 5ParenExpr 6Ident 4WritelnStmt " +
+		"3RepeatStmt 4AssignStmt 5Ident 5BinaryExpr 6Ident 6NumberLit " +
+		"4BinaryExpr 5Ident 5NumberLit"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := ast.Marshal(&ast.WriteStmt{
+		Write: ast.Pos{Offset: 6, Line: 1, Column: 7},
+		Items: []ast.Expr{&ast.Ident{NamePos: ast.Pos{Offset: 12, Line: 1, Column: 13}, Name: "n"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"WriteStmt","Write":{"offset":6,"line":1,"column":7},` +
+		`"Items":[{"type":"Ident","NamePos":{"offset":12,"line":1,"column":13},"Name":"n"}]}`
+	if string(data) != want {
+		t.Errorf("Got: %s\nWant: %s", data, want)
+	}
+
+	// positions may be omitted
+	node, err := ast.UnmarshalNode([]byte(`{"type": "IfStmt",
+		"Cond": {"type": "UnaryExpr", "Op": "odd", "X": {"type": "NumberLit", "Value": 1}},
+		"Then": {"type": "WritelnStmt"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNode := &ast.IfStmt{
+		Cond: &ast.UnaryExpr{Op: ast.OpOdd, X: &ast.NumberLit{Value: 1}},
+		Then: &ast.WritelnStmt{},
+	}
+	if !reflect.DeepEqual(node, wantNode) {
+		t.Errorf("Got: %#v", node)
+	}
+}
+
+var jsonErrorTargets = []struct {
+	json    string
+	wantMsg string
+}{
+	{`{"type": 1}`, "ast: missing node type"},
+	{`null`, "ast: null node"},
+	{`{"Name": "x"}`, "ast: missing node type"},
+	{`{"type": "Foo"}`, `ast: unknown node type "Foo"`},
+	{`{"type": "WidthExpr"}`, "ast: missing X of WidthExpr"},
+	{`{"type": "WriteStmt", "Items": [{"type": "WritelnStmt"}]}`,
+		"ast: Items of WriteStmt: ast: WritelnStmt is not Expr"},
+	{`{"type": "Ident", "Name": 1}`,
+		"ast: Name of Ident: json: cannot unmarshal number into Go value of type string"},
+	{`{"type": "CompoundStmt", "List": [null]}`, "ast: List of CompoundStmt: null element"},
+}
+
+func TestJSONErrors(t *testing.T) {
+	for nth, target := range jsonErrorTargets {
+		_, err := ast.UnmarshalNode([]byte(target.json))
+		if err == nil {
+			t.Errorf("#%d: No error", nth)
+		} else if err.Error() != target.wantMsg {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, err, target.wantMsg)
+		}
+	}
+	if _, err := ast.Unmarshal([]byte(`{"type": "WritelnStmt"}`)); err == nil {
+		t.Errorf("No error for a statement as a program")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "examples", "*.pl0"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No examples: %v", err)
+	}
+	wirthFiles, _ := filepath.Glob(filepath.Join("..", "..", "examples", "wirth", "*.pl0"))
+	sources := map[string]string{
+		"test": testSource,
+		"case": "var i; begin case i of 1, 2: i := 0; 3: begin end else write i end; case i of 0: end end.",
+	}
+	dialects := make(map[string]pl0compiler.Dialect)
+	for _, file := range append(files, wirthFiles...) {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[file] = string(src)
+	}
+	for _, file := range wirthFiles {
+		dialects[file] = pl0compiler.DialectWirth
+	}
+
+	for name, src := range sources {
+		prog, err := pl0compiler.ParseDialect(name, []byte(src), dialects[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ast.MarshalIndent(prog, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ast.Unmarshal(data)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, prog) {
+			t.Errorf("%s: syntax tree differs after round trip", name)
+		}
+
+		// the code generator accepts the syntax tree from JSON
+		c := pl0compiler.NewCompiler(name)
+		c.Dialect = dialects[name]
+		want, err := c.CompileSource([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c = pl0compiler.NewCompiler(name)
+		if err := c.Compile(got); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if !reflect.DeepEqual(c.Instructions(), want) {
+			t.Errorf("%s: instructions differ", name)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// The JSON form of a syntax tree represents each node as an object,
+// whose "type" member is the name of the node type (such as "AssignStmt"),
+// and whose other members are the fields of the node with the same names.
+// Positions are objects {"offset": ..., "line": ..., "column": ...},
+// and absent optional nodes (such as Else of IfStmt) are null.
+//
+//	{"type": "WriteStmt", "Write": {"offset": 6, "line": 1, "column": 7},
+//	 "Items": [{"type": "Ident", "NamePos": {...}, "Name": "n"}]}
+//
+// Positions may be omitted in the JSON form given to Unmarshal.
+
+// nodeTypes are the node types by names.
+var nodeTypes = make(map[string]reflect.Type)
+
+// optionalFields are the fields of node types which may be nil.
+var optionalFields = map[string]bool{
+	"CaseStmt.Else":     true,
+	"ForStmt.Step":      true,
+	"IfStmt.Else":       true,
+	"ReturnStmt.Result": true,
+}
+
+func init() {
+	for _, node := range []Node{
+		&BadExpr{}, &Ident{}, &NumberLit{}, &StringLit{}, &WidthExpr{}, &ParenExpr{}, &UnaryExpr{},
+		&BinaryExpr{}, &IndexExpr{}, &CallExpr{},
+		&BadStmt{}, &EmptyStmt{}, &AssignStmt{}, &CompoundStmt{}, &IfStmt{},
+		&WhileStmt{}, &RepeatStmt{}, &ForStmt{}, &BranchStmt{}, &CaseStmt{}, &CaseClause{}, &ReturnStmt{}, &WriteStmt{}, &WritelnStmt{},
+		&CallStmt{}, &InputStmt{}, &OutputStmt{},
+		&ConstSpec{}, &VarSpec{}, &Param{}, &ConstDecl{}, &VarDecl{}, &FuncDecl{},
+		&Comment{}, &Block{}, &Program{},
+	} {
+		t := reflect.TypeOf(node).Elem()
+		nodeTypes[t.Name()] = t
+	}
+}
+
+var (
+	nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+	posType  = reflect.TypeOf(Pos{})
+)
+
+// Marshal returns the JSON form of the syntax tree.
+func Marshal(node Node) ([]byte, error) {
+	return json.Marshal(encodeNode(reflect.ValueOf(node)))
+}
+
+// MarshalIndent is like Marshal but indents the output.
+func MarshalIndent(node Node, prefix string, indent string) ([]byte, error) {
+	return json.MarshalIndent(encodeNode(reflect.ValueOf(node)), prefix, indent)
+}
+
+// encodeNode returns the node as an ordered list of members.
+func encodeNode(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	elem := v.Elem()
+	if v.Kind() == reflect.Interface {
+		// Expr, Stmt or Decl
+		elem = v.Elem().Elem()
+	}
+	t := elem.Type()
+	obj := jsonObject{{"type", t.Name()}}
+	for i := 0; i < t.NumField(); i++ {
+		obj = append(obj, jsonMember{t.Field(i).Name, encodeValue(elem.Field(i))})
+	}
+	return obj
+}
+
+func encodeValue(v reflect.Value) interface{} {
+	switch {
+	case v.Type() == posType:
+		pos := v.Interface().(Pos)
+		return jsonObject{{"offset", pos.Offset}, {"line", pos.Line}, {"column", pos.Column}}
+	case isNodeType(v.Type()):
+		return encodeNode(v)
+	case v.Kind() == reflect.Slice && isNodeType(v.Type().Elem()):
+		if v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = encodeNode(v.Index(i))
+		}
+		return list
+	}
+	return v.Interface()
+}
+
+// isNodeType reports whether t is a node interface or a pointer to a node.
+func isNodeType(t reflect.Type) bool {
+	return t.Kind() == reflect.Interface && t.Implements(nodeType) ||
+		t.Kind() == reflect.Ptr && t.Implements(nodeType)
+}
+
+// jsonObject is a JSON object which keeps the order of the members.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	name  string
+	value interface{}
+}
+
+func (obj jsonObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, m := range obj {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(m.name)
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, name...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
+
+// Unmarshal parses the JSON form of a program.
+func Unmarshal(data []byte) (*Program, error) {
+	node, err := UnmarshalNode(data)
+	if err != nil {
+		return nil, err
+	}
+	prog, ok := node.(*Program)
+	if !ok {
+		return nil, fmt.Errorf("ast: %T is not a Program", node)
+	}
+	return prog, nil
+}
+
+// UnmarshalNode parses the JSON form of a node.
+func UnmarshalNode(data []byte) (Node, error) {
+	v, err := decodeNode(data, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsNil() {
+		return nil, fmt.Errorf("ast: null node")
+	}
+	return v.Interface().(Node), nil
+}
+
+// decodeNode decodes a node, which must be assignable to t.
+// It returns a nil value of t for null.
+func decodeNode(data []byte, t reflect.Type) (reflect.Value, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return reflect.Value{}, fmt.Errorf("ast: %s", err)
+	}
+	if members == nil {
+		return reflect.Zero(t), nil
+	}
+	var name string
+	if err := json.Unmarshal(members["type"], &name); err != nil {
+		return reflect.Value{}, fmt.Errorf("ast: missing node type")
+	}
+	nt, ok := nodeTypes[name]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("ast: unknown node type %q", name)
+	}
+	if !reflect.PtrTo(nt).AssignableTo(t) {
+		return reflect.Value{}, fmt.Errorf("ast: %s is not %s", name, typeName(t))
+	}
+
+	v := reflect.New(nt)
+	for i := 0; i < nt.NumField(); i++ {
+		field := nt.Field(i)
+		data, ok := members[field.Name]
+		if !ok || string(data) == "null" {
+			if isNodeType(field.Type) && !optionalFields[name+"."+field.Name] {
+				return reflect.Value{}, fmt.Errorf("ast: missing %s of %s", field.Name, name)
+			}
+			continue
+		}
+		if err := decodeValue(data, v.Elem().Field(i)); err != nil {
+			return reflect.Value{}, fmt.Errorf("ast: %s of %s: %s", field.Name, name, err)
+		}
+	}
+	return v, nil
+}
+
+func decodeValue(data []byte, v reflect.Value) error {
+	t := v.Type()
+	switch {
+	case t == posType:
+		var pos struct{ Offset, Line, Column int }
+		if err := json.Unmarshal(data, &pos); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(Pos{Offset: pos.Offset, Line: pos.Line, Column: pos.Column}))
+	case isNodeType(t):
+		node, err := decodeNode(data, t)
+		if err != nil {
+			return err
+		}
+		v.Set(node)
+	case t.Kind() == reflect.Slice && isNodeType(t.Elem()):
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(t, len(list), len(list))
+		for i, elem := range list {
+			node, err := decodeNode(elem, t.Elem())
+			if err != nil {
+				return err
+			}
+			if node.IsNil() {
+				return fmt.Errorf("null element")
+			}
+			s.Index(i).Set(node)
+		}
+		v.Set(s)
+	default:
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+	return nil
+}
+
+func typeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem().Name()
+	}
+	return t.Name()
+}
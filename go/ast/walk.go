@@ -0,0 +1,160 @@
+package ast
+
+// Visitor visits nodes by Walk.
+// If Visit returns a non-nil visitor w, the children of the node are
+// visited with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the syntax tree in depth-first order.
+// It starts by calling v.Visit(node). Comments of programs are not visited.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	// expressions
+	case *BadExpr, *Ident, *NumberLit, *StringLit:
+		// nothing to do
+	case *WidthExpr:
+		Walk(v, n.X)
+		Walk(v, n.Width)
+	case *ParenExpr:
+		Walk(v, n.X)
+	case *UnaryExpr:
+		Walk(v, n.X)
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+	case *IndexExpr:
+		Walk(v, n.Name)
+		for _, index := range n.Indices {
+			Walk(v, index)
+		}
+	case *CallExpr:
+		Walk(v, n.Func)
+		for _, arg := range n.Args {
+			Walk(v, arg)
+		}
+
+	// statements
+	case *BadStmt, *EmptyStmt, *BranchStmt:
+		// nothing to do
+	case *AssignStmt:
+		Walk(v, n.Name)
+		for _, index := range n.Indices {
+			Walk(v, index)
+		}
+		Walk(v, n.Value)
+	case *CompoundStmt:
+		for _, s := range n.List {
+			Walk(v, s)
+		}
+	case *IfStmt:
+		Walk(v, n.Cond)
+		Walk(v, n.Then)
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+	case *WhileStmt:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+	case *RepeatStmt:
+		Walk(v, n.Body)
+		Walk(v, n.Cond)
+	case *ForStmt:
+		Walk(v, n.Var)
+		Walk(v, n.From)
+		Walk(v, n.To)
+		if n.Step != nil {
+			Walk(v, n.Step)
+		}
+		Walk(v, n.Body)
+	case *CaseStmt:
+		Walk(v, n.X)
+		for _, clause := range n.Clauses {
+			Walk(v, clause)
+		}
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+	case *CaseClause:
+		for _, label := range n.Labels {
+			Walk(v, label)
+		}
+		Walk(v, n.Body)
+	case *ReturnStmt:
+		if n.Result != nil {
+			Walk(v, n.Result)
+		}
+	case *WriteStmt:
+		for _, item := range n.Items {
+			Walk(v, item)
+		}
+	case *WritelnStmt:
+		for _, item := range n.Items {
+			Walk(v, item)
+		}
+	case *CallStmt:
+		Walk(v, n.X)
+	case *InputStmt:
+		Walk(v, n.Name)
+	case *OutputStmt:
+		Walk(v, n.X)
+
+	// declarations
+	case *ConstSpec:
+		Walk(v, n.Name)
+		Walk(v, n.Value)
+	case *VarSpec:
+		Walk(v, n.Name)
+		for _, size := range n.Sizes {
+			Walk(v, size)
+		}
+	case *Param:
+		Walk(v, n.Name)
+	case *ConstDecl:
+		for _, spec := range n.Specs {
+			Walk(v, spec)
+		}
+	case *VarDecl:
+		for _, spec := range n.Specs {
+			Walk(v, spec)
+		}
+	case *FuncDecl:
+		Walk(v, n.Name)
+		for _, param := range n.Params {
+			Walk(v, param)
+		}
+		Walk(v, n.Body)
+
+	// blocks and programs
+	case *Block:
+		for _, decl := range n.Decls {
+			Walk(v, decl)
+		}
+		Walk(v, n.Body)
+	case *Program:
+		Walk(v, n.Block)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the syntax tree in depth-first order.
+// It starts by calling f(node). If f returns true, Inspect calls f for
+// each of the children of the node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"kkpl0/ast"
+	"kkpl0/diag"
+	"kkpl0/pl0compiler"
+	"kkpl0/pl0core"
+)
+
+// isJSON reports whether the source file is a syntax tree in JSON.
+func isJSON(srcFile string) bool {
+	return strings.HasSuffix(srcFile, ".json")
+}
+
+func outputFile(srcFile string, ext string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(srcFile, ".json"), ".pl0") + ext
+}
+
+func writeInstructions(file string, instructions []pl0core.Instruction) error {
+	wf, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer wf.Close()
+
+	w := bufio.NewWriter(wf)
+	err = pl0core.WriteInstructions(w, instructions)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// parse parses the source file in the dialect into a syntax tree.
+func parse(srcFile string, src []byte, dialect pl0compiler.Dialect) (*ast.Program, error) {
+	if isJSON(srcFile) {
+		return ast.Unmarshal(src)
+	}
+	return pl0compiler.ParseDialect(srcFile, src, dialect)
+}
+
+// options are the options of the compiler.
+type options struct {
+	fold            bool
+	tailCalls       bool
+	boundsCheck     bool
+	inlineThreshold int
+	maxLevel        int
+	staticLinks     bool
+	dialect         pl0compiler.Dialect
+}
+
+// compile compiles the source file.
+func compile(srcFile string, src []byte, opts options) ([]pl0core.Instruction, error) {
+	c := pl0compiler.NewCompiler(srcFile)
+	c.Fold = opts.fold
+	c.TailCalls = opts.tailCalls
+	c.BoundsCheck = opts.boundsCheck
+	c.InlineThreshold = opts.inlineThreshold
+	c.MaxLevel = opts.maxLevel
+	c.StaticLinks = opts.staticLinks
+	c.Dialect = opts.dialect
+	if !isJSON(srcFile) {
+		return c.CompileSource(src)
+	}
+	prog, err := ast.Unmarshal(src)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Compile(prog); err != nil {
+		return nil, err
+	}
+	return c.Instructions(), nil
+}
+
+// printErrors prints compile errors in text.
+func printErrors(srcFile string, src []byte, errors pl0compiler.ErrorList) error {
+	if isJSON(srcFile) {
+		// no source lines to show
+		for _, e := range errors {
+			fmt.Fprintln(os.Stderr, e.Error())
+		}
+	} else {
+		fmt.Fprint(os.Stderr, errors.Format(src))
+	}
+	return fmt.Errorf("%d error(s) found", len(errors))
+}
+
+func run(srcFile string, outFile string, debug bool, emitAST bool, opts options, format diag.Format) error {
+	src, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		return err
+	}
+
+	if emitAST {
+		prog, err := parse(srcFile, src, opts.dialect)
+		if errors, ok := err.(pl0compiler.ErrorList); ok && format == diag.FormatText {
+			return printErrors(srcFile, src, errors)
+		} else if err != nil {
+			return err
+		}
+		data, err := ast.MarshalIndent(prog, "", "  ")
+		if err != nil {
+			return err
+		}
+		if outFile == "" {
+			outFile = outputFile(srcFile, ".json")
+		}
+		return ioutil.WriteFile(outFile, append(data, '\n'), 0666)
+	}
+
+	instructions, err := compile(srcFile, src, opts)
+	if errors, ok := err.(pl0compiler.ErrorList); ok && format == diag.FormatText {
+		return printErrors(srcFile, src, errors)
+	} else if err != nil {
+		return err
+	}
+	if debug {
+		for i, inst := range instructions {
+			fmt.Printf("%d:\t%s\n", i, inst)
+		}
+	}
+
+	if outFile == "" {
+		outFile = outputFile(srcFile, ".pl0vm")
+	}
+	return writeInstructions(outFile, instructions)
+}
+
+// diagnostics returns the error of run as diagnostics.
+func diagnostics(srcFile string, err error) []diag.Diagnostic {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case pl0compiler.ErrorList:
+		return e.Diagnostics()
+	}
+	return []diag.Diagnostic{diag.Fatal(srcFile, err)}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [options] source\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"A source ending with .json is read as a syntax tree in JSON.\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var debug bool
+	var emitAST bool
+	var opts options
+	var outFile string
+	var formatName string
+	var dialectName string
+
+	flag.BoolVar(&debug, "debug", false, "debug flag")
+	flag.BoolVar(&emitAST, "ast", false,
+		"write the syntax tree in JSON instead of code (default output: source with .json)")
+	flag.BoolVar(&opts.fold, "fold", true, "fold constant expressions")
+	flag.BoolVar(&opts.tailCalls, "tailcall", true, "compile return f(...) as tail calls by TCL")
+	flag.BoolVar(&opts.boundsCheck, "boundscheck", true, "check array indices at runtime by CHK")
+	flag.IntVar(&opts.inlineThreshold, "inline", pl0compiler.DefaultInlineThreshold,
+		"maximum size of inlined functions in syntax tree nodes (0: no inlining)")
+	flag.IntVar(&opts.maxLevel, "maxlevel", pl0compiler.DefaultMaxLevel,
+		"maximum nesting level of functions")
+	flag.BoolVar(&opts.staticLinks, "staticlink", false,
+		"call functions with static links instead of the display (LODS, CALS, ...)")
+	flag.StringVar(&dialectName, "dialect", "kk", "dialect of the source: kk, pl0prime or wirth")
+	flag.StringVar(&outFile, "o", "", "output file (default: source with .pl0vm)")
+	flag.StringVar(&formatName, "format", "text",
+		"diagnostics format: text, json or sarif (json and sarif are written to stderr)")
+	flag.Usage = usage
+	flag.Parse()
+
+	format, err := diag.ParseFormat(formatName)
+	if err != nil || flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	if opts.dialect, err = pl0compiler.LookupDialect(dialectName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	err = run(flag.Arg(0), outFile, debug, emitAST, opts, format)
+	if format != diag.FormatText {
+		if werr := diag.Write(os.Stderr, format, "pl0c", diagnostics(flag.Arg(0), err)); werr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", werr)
+			os.Exit(1)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
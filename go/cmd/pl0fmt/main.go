@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"kkpl0/format"
+	"kkpl0/pl0compiler"
+)
+
+var (
+	list   = flag.Bool("l", false, "list files whose formatting differs from pl0fmt's")
+	write  = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff = flag.Bool("d", false, "display diffs instead of rewriting files")
+
+	dialectName = flag.String("dialect", "kk", "dialect of the source: kk, pl0prime or wirth")
+)
+
+// formatFile formats a source file in the dialect, and writes the result
+// as specified by the flags.
+func formatFile(file string, dialect pl0compiler.Dialect) error {
+	var src []byte
+	var err error
+	if file == "" {
+		src, err = ioutil.ReadAll(os.Stdin)
+		file = "<standard input>"
+	} else {
+		src, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := format.SourceDialect(file, src, dialect)
+	if errors, ok := err.(pl0compiler.ErrorList); ok {
+		fmt.Fprint(os.Stderr, errors.Format(src))
+		return fmt.Errorf("%s: %d error(s) found", file, len(errors))
+	} else if err != nil {
+		return err
+	}
+
+	changed := !bytes.Equal(src, res)
+	if *list && changed {
+		fmt.Println(file)
+	}
+	if *write && changed {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(file, res, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if *doDiff && changed {
+		d, err := diff(file, src, res)
+		if err != nil {
+			return fmt.Errorf("computing diff: %s", err)
+		}
+		os.Stdout.Write(d)
+	}
+	if !*list && !*write && !*doDiff {
+		os.Stdout.Write(res)
+	}
+	return nil
+}
+
+// diff returns the unified diff of the source and the formatted source
+// by the diff command.
+func diff(file string, src []byte, res []byte) ([]byte, error) {
+	f1, err := writeTempFile("pl0fmt", src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTempFile("pl0fmt", res)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+
+	data, err := exec.Command("diff", "-u",
+		"--label", file+".orig", "--label", file, f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match.
+		return data, nil
+	}
+	return data, err
+}
+
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [options] [source...]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	dialect, err := pl0compiler.LookupDialect(*dialectName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "Error: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		if err := formatFile("", dialect); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	status := 0
+	for _, file := range flag.Args() {
+		if err := formatFile(file, dialect); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
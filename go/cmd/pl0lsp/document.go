@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"kkpl0/ast"
+	"kkpl0/pl0compiler"
+)
+
+// document is an opened text document and the result of its analysis.
+type document struct {
+	uri        string
+	version    int
+	text       string
+	lineStarts []int
+
+	prog   *ast.Program // may contain bad nodes of syntax errors
+	info   *pl0compiler.Info
+	errors pl0compiler.ErrorList
+}
+
+func newDocument(uri string, version int, text string) *document {
+	doc := &document{uri: uri, version: version, text: text}
+	doc.lineStarts = []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			doc.lineStarts = append(doc.lineStarts, i+1)
+		}
+	}
+	doc.analyze()
+	return doc
+}
+
+// analyze compiles the document to collect symbols and errors.
+// Since the parser recovers from syntax errors, symbols are available
+// even while editing.
+func (doc *document) analyze() {
+	prog, err := pl0compiler.Parse(doc.uri, []byte(doc.text))
+	if err != nil {
+		doc.errors = append(doc.errors, err.(pl0compiler.ErrorList)...)
+	}
+	c := pl0compiler.NewCompiler(doc.uri)
+	if err = c.Compile(prog); err != nil {
+		doc.errors = append(doc.errors, err.(pl0compiler.ErrorList)...)
+	}
+	doc.errors.Sort()
+	doc.prog = prog
+	doc.info = c.Info()
+}
+
+// toPosition converts a source position to an LSP position.
+func (doc *document) toPosition(pos ast.Pos) Position {
+	if !pos.IsValid() {
+		return Position{}
+	}
+	line := pos.Line - 1
+	if line >= len(doc.lineStarts) {
+		line = len(doc.lineStarts) - 1
+	}
+	start := doc.lineStarts[line]
+	end := pos.Offset
+	if end > len(doc.text) {
+		end = len(doc.text)
+	}
+	if end < start {
+		end = start
+	}
+	return Position{Line: line, Character: utf16Len(doc.text[start:end])}
+}
+
+// toRange converts a node range to an LSP range.
+func (doc *document) toRange(node ast.Node) Range {
+	return Range{doc.toPosition(node.Pos()), doc.toPosition(node.End())}
+}
+
+// fromPosition converts an LSP position to a source position.
+func (doc *document) fromPosition(p Position) ast.Pos {
+	if p.Line < 0 {
+		return ast.Pos{Offset: 0, Line: 1, Column: 1}
+	}
+	if p.Line >= len(doc.lineStarts) {
+		return doc.endPos()
+	}
+	offset := doc.lineStarts[p.Line]
+	column := 1
+	for units := 0; units < p.Character && offset < len(doc.text); column++ {
+		r, size := utf8.DecodeRuneInString(doc.text[offset:])
+		if r == '\n' {
+			break
+		}
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return ast.Pos{Offset: offset, Line: p.Line + 1, Column: column}
+}
+
+func (doc *document) endPos() ast.Pos {
+	line := len(doc.lineStarts)
+	start := doc.lineStarts[line-1]
+	column := utf8.RuneCountInString(doc.text[start:]) + 1
+	return ast.Pos{Offset: len(doc.text), Line: line, Column: column}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+// diagnostics returns the compile errors as diagnostics.
+func (doc *document) diagnostics() []Diagnostic {
+	diags := []Diagnostic{}
+	for _, e := range doc.errors {
+		diags = append(diags, doc.diagnostic(e))
+	}
+	return diags
+}
+
+// diagnostic returns the error as a diagnostic.
+// If the error has no range, the range covers the word at the error.
+func (doc *document) diagnostic(e *pl0compiler.Error) Diagnostic {
+	msg := e.Msg
+	if e.Suggestion != "" {
+		msg += "\nsuggestion: " + e.Suggestion
+	}
+	d := Diagnostic{
+		Severity: DiagnosticSeverityError,
+		Code:     e.Code,
+		Source:   "pl0",
+		Message:  msg,
+	}
+	if e.Pos.IsValid() && e.Pos.Before(e.End) {
+		d.Range = Range{doc.toPosition(e.Pos), doc.toPosition(e.End)}
+		return d
+	}
+
+	start := e.Pos
+	if !start.IsValid() {
+		start = ast.Pos{Offset: 0, Line: 1, Column: 1}
+	}
+	end := start.Offset
+	for end < len(doc.text) && isWordChar(doc.text[end]) {
+		end++
+	}
+	if end == start.Offset && end < len(doc.text) && doc.text[end] != '\n' {
+		_, size := utf8.DecodeRuneInString(doc.text[end:])
+		end += size
+	}
+	endPos := ast.Pos{Offset: end, Line: start.Line, Column: start.Column + (end - start.Offset)}
+	d.Range = Range{doc.toPosition(start), doc.toPosition(endPos)}
+	return d
+}
+
+func isWordChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' ||
+		'0' <= ch && ch <= '9' || ch == '_'
+}
+
+// identAt returns the identifier at pos and its symbol.
+func (doc *document) identAt(pos ast.Pos) (*ast.Ident, *pl0compiler.Symbol) {
+	for _, ids := range []map[*ast.Ident]*pl0compiler.Symbol{doc.info.Defs, doc.info.Uses} {
+		for id, sym := range ids {
+			if id.Name != "" && !pos.Before(id.Pos()) && !id.End().Before(pos) {
+				return id, sym
+			}
+		}
+	}
+	return nil, nil
+}
+
+// references returns the identifiers referring to sym in order of position.
+func (doc *document) references(sym *pl0compiler.Symbol, includeDecl bool) []*ast.Ident {
+	var ids []*ast.Ident
+	for id, s := range doc.info.Uses {
+		if s == sym {
+			ids = append(ids, id)
+		}
+	}
+	if includeDecl && sym.Kind != pl0compiler.SymbolBuiltin {
+		ids = append(ids, sym.Decl)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Pos().Before(ids[j].Pos())
+	})
+	return ids
+}
+
+// refBrackets returns the brackets of an array reference parameter
+// of the rank: "[]", "[,]" and so on.
+func refBrackets(rank int) string {
+	return "[" + strings.Repeat(",", rank-1) + "]"
+}
+
+// describe returns the declaration of sym in PL/0 syntax.
+func describe(sym *pl0compiler.Symbol) string {
+	switch sym.Kind {
+	case pl0compiler.SymbolConst:
+		return fmt.Sprintf("const %s = %d", sym.Name, sym.Value)
+	case pl0compiler.SymbolVarArray:
+		dims := make([]string, len(sym.Dims))
+		for i, dim := range sym.Dims {
+			dims[i] = strconv.Itoa(dim)
+		}
+		return fmt.Sprintf("var %s[%s]", sym.Name, strings.Join(dims, ", "))
+	case pl0compiler.SymbolVarRef:
+		return fmt.Sprintf("param %s%s", sym.Name, refBrackets(sym.Rank))
+	case pl0compiler.SymbolVarParam:
+		return "param var " + sym.Name
+	case pl0compiler.SymbolFunc, pl0compiler.SymbolProc:
+		params := make([]string, len(sym.Params))
+		for i, param := range sym.Params {
+			params[i] = param.Name
+			if param.Kind == pl0compiler.SymbolVarRef {
+				params[i] += refBrackets(param.Rank)
+			} else if param.Kind == pl0compiler.SymbolVarParam {
+				params[i] = "var " + params[i]
+			}
+		}
+		return fmt.Sprintf("%s %s(%s)", sym.Kind, sym.Name, strings.Join(params, ", "))
+	case pl0compiler.SymbolBuiltin:
+		params := []string{"x"}
+		if sym.Builtin.Params == 2 {
+			params = []string{"a", "b"}
+		}
+		return fmt.Sprintf("function %s(%s)", sym.Name, strings.Join(params, ", "))
+	}
+	if sym.Param {
+		return "param " + sym.Name
+	}
+	return "var " + sym.Name
+}
+
+// hoverText returns the description of sym in markdown.
+func hoverText(sym *pl0compiler.Symbol, doc string) string {
+	text := "```pl0\n" + describe(sym) + "\n```\n"
+	if doc != "" {
+		text += "\n" + doc + "\n"
+	}
+	switch sym.Kind {
+	case pl0compiler.SymbolConst:
+		return text
+	case pl0compiler.SymbolFunc, pl0compiler.SymbolProc:
+		return text + fmt.Sprintf("\n%s (level %d, offset %d: code address)",
+			sym.Kind, sym.Address.Level, sym.Address.Offset)
+	case pl0compiler.SymbolBuiltin:
+		return text + fmt.Sprintf("\n%s (operation type %d)", sym.Kind, sym.Builtin.OpType)
+	}
+	return text + fmt.Sprintf("\n%s (level %d, offset %d)",
+		sym.Kind, sym.Address.Level, sym.Address.Offset)
+}
+
+// docComment returns the text of the comments documenting the declaration:
+// the comments on their own lines just before it, or else the comments
+// following it on the same line.
+func (doc *document) docComment(decl *ast.Ident) string {
+	var before, after []string
+	line := decl.Pos().Line
+	comments := doc.prog.Comments
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		if c.Pos().Line == line && !c.Pos().Before(decl.End()) {
+			after = append([]string{commentText(c)}, after...)
+		} else if c.End().Line == line-1 && c.End().Before(decl.Pos()) && doc.startsLine(c) {
+			before = append([]string{commentText(c)}, before...)
+			line = c.Pos().Line
+		} else if c.End().Line < line-1 {
+			break
+		}
+	}
+	if len(before) > 0 {
+		return strings.Join(before, "\n")
+	}
+	return strings.Join(after, "\n")
+}
+
+// startsLine reports whether the comment is the first on its line.
+func (doc *document) startsLine(c *ast.Comment) bool {
+	start := doc.lineStarts[c.Pos().Line-1]
+	return strings.TrimSpace(doc.text[start:c.Pos().Offset]) == ""
+}
+
+// commentText returns the text of the comment without the delimiters.
+func commentText(c *ast.Comment) string {
+	text := c.Text
+	switch {
+	case strings.HasPrefix(text, "//"):
+		text = text[2:]
+	case strings.HasPrefix(text, "{"):
+		text = strings.TrimSuffix(text[1:], "}")
+	case strings.HasPrefix(text, "(*"):
+		text = strings.TrimSuffix(text[2:], "*)")
+	}
+	return strings.TrimSpace(text)
+}
+
+// documentSymbols returns the symbols declared in the block.
+func (doc *document) documentSymbols(block *ast.Block) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for _, decl := range block.Decls {
+		switch d := decl.(type) {
+		case *ast.ConstDecl:
+			for _, spec := range d.Specs {
+				syms = append(syms, doc.documentSymbol(spec.Name, spec, SymbolKindConstant))
+			}
+		case *ast.VarDecl:
+			for _, spec := range d.Specs {
+				kind := SymbolKindVariable
+				if spec.Sizes != nil {
+					kind = SymbolKindArray
+				}
+				syms = append(syms, doc.documentSymbol(spec.Name, spec, kind))
+			}
+		case *ast.FuncDecl:
+			fsym := doc.documentSymbol(d.Name, d, SymbolKindFunction)
+			for _, param := range d.Params {
+				kind := SymbolKindVariable
+				if param.Ref {
+					kind = SymbolKindArray
+				}
+				fsym.Children = append(fsym.Children, doc.documentSymbol(param.Name, param, kind))
+			}
+			fsym.Children = append(fsym.Children, doc.documentSymbols(d.Body)...)
+			syms = append(syms, fsym)
+		}
+	}
+	return syms
+}
+
+func (doc *document) documentSymbol(name *ast.Ident, node ast.Node, kind SymbolKind) DocumentSymbol {
+	ds := DocumentSymbol{
+		Name:           name.Name,
+		Kind:           kind,
+		Range:          doc.toRange(node),
+		SelectionRange: doc.toRange(name),
+	}
+	if name.Name == "" {
+		// missing by a syntax error, but the name must not be empty
+		ds.Name = "?"
+	}
+	if sym := doc.info.Defs[name]; sym != nil {
+		ds.Detail = describe(sym)
+	}
+	return ds
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s\n"+
+			"PL/0 language server communicating over stdin and stdout.\n",
+		os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	s := newServer(newConn(os.Stdin, os.Stdout))
+	status, err := s.serve()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+	os.Exit(status)
+}
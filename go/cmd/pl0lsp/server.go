@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"kkpl0/pl0compiler"
+)
+
+// server is PL/0 language server.
+type server struct {
+	conn     *conn
+	docs     map[string]*document
+	shutdown bool
+}
+
+func newServer(c *conn) *server {
+	return &server{
+		conn: c,
+		docs: make(map[string]*document),
+	}
+}
+
+// serve handles messages until exit. It returns the exit status.
+func (s *server) serve() (int, error) {
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return 1, nil
+		}
+		if rerr, ok := err.(*responseError); ok {
+			if err = s.conn.reply(nil, nil, rerr); err != nil {
+				return 1, err
+			}
+			continue
+		}
+		if err != nil {
+			return 1, err
+		}
+
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0, nil
+			}
+			return 1, nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.isNotification() {
+			continue
+		}
+		if err = s.conn.reply(msg.ID, result, rerr); err != nil {
+			return 1, err
+		}
+	}
+}
+
+func (s *server) handle(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return s.initialize()
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		item := params.TextDocument
+		return nil, s.update(item.URI, item.Version, item.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		changes := params.ContentChanges
+		if len(changes) == 0 {
+			return nil, nil
+		}
+		// full synchronization: the last change is the whole content
+		doc := params.TextDocument
+		return nil, s.update(doc.URI, doc.Version, changes[len(changes)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		return s.definition(&params), nil
+	case "textDocument/references":
+		var params ReferenceParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		return s.references(&params), nil
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		return s.hover(&params), nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		return s.documentSymbol(&params), nil
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if rerr := unmarshalParams(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		return s.completion(&params), nil
+	}
+	return nil, &responseError{codeMethodNotFound, "method not found: " + msg.Method}
+}
+
+func unmarshalParams(msg *message, params interface{}) *responseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &responseError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *server) initialize() (interface{}, *responseError) {
+	result := &InitializeResult{ServerInfo: ServerInfo{Name: "pl0lsp"}}
+	caps := &result.Capabilities
+	caps.TextDocumentSync = TextDocumentSyncKindFull
+	caps.DefinitionProvider = true
+	caps.ReferencesProvider = true
+	caps.HoverProvider = true
+	caps.DocumentSymbolProvider = true
+	return result, nil
+}
+
+// update analyzes the new content of a document and publishes diagnostics.
+func (s *server) update(uri string, version int, text string) *responseError {
+	doc := newDocument(uri, version, text)
+	s.docs[uri] = doc
+
+	params := &PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: doc.diagnostics(),
+	}
+	if err := s.conn.notify("textDocument/publishDiagnostics", params); err != nil {
+		return &responseError{codeInternalError, err.Error()}
+	}
+	return nil
+}
+
+func (s *server) definition(params *TextDocumentPositionParams) []Location {
+	locs := []Location{}
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return locs
+	}
+	_, sym := doc.identAt(doc.fromPosition(params.Position))
+	if sym == nil || sym.Kind == pl0compiler.SymbolBuiltin {
+		return locs
+	}
+	return append(locs, Location{URI: doc.uri, Range: doc.toRange(sym.Decl)})
+}
+
+func (s *server) references(params *ReferenceParams) []Location {
+	locs := []Location{}
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return locs
+	}
+	_, sym := doc.identAt(doc.fromPosition(params.Position))
+	if sym == nil {
+		return locs
+	}
+	for _, id := range doc.references(sym, params.Context.IncludeDeclaration) {
+		locs = append(locs, Location{URI: doc.uri, Range: doc.toRange(id)})
+	}
+	return locs
+}
+
+func (s *server) hover(params *TextDocumentPositionParams) *Hover {
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return nil
+	}
+	id, sym := doc.identAt(doc.fromPosition(params.Position))
+	if sym == nil {
+		return nil
+	}
+	r := doc.toRange(id)
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: hoverText(sym, doc.docComment(sym.Decl))},
+		Range:    &r,
+	}
+}
+
+func (s *server) documentSymbol(params *DocumentSymbolParams) []DocumentSymbol {
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return []DocumentSymbol{}
+	}
+	return doc.documentSymbols(doc.prog.Block)
+}
+
+var completionItemKinds = map[pl0compiler.SymbolKind]CompletionItemKind{
+	pl0compiler.SymbolVarScalar: CompletionItemKindVariable,
+	pl0compiler.SymbolConst:     CompletionItemKindConstant,
+	pl0compiler.SymbolFunc:      CompletionItemKindFunction,
+	pl0compiler.SymbolVarArray:  CompletionItemKindVariable,
+	pl0compiler.SymbolVarRef:    CompletionItemKindVariable,
+	pl0compiler.SymbolProc:      CompletionItemKindFunction,
+	pl0compiler.SymbolBuiltin:   CompletionItemKindFunction,
+	pl0compiler.SymbolVarParam:  CompletionItemKindVariable,
+}
+
+func (s *server) completion(params *TextDocumentPositionParams) []CompletionItem {
+	items := []CompletionItem{}
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return items
+	}
+
+	pos := doc.fromPosition(params.Position)
+	scope := doc.info.Universe.Innermost(pos)
+	for _, sym := range scope.Visible(pos) {
+		items = append(items, CompletionItem{
+			Label:  sym.Name,
+			Kind:   completionItemKinds[sym.Kind],
+			Detail: describe(sym),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
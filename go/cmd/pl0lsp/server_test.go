@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///test.pl0"
+
+const testSource = `const size = 3;
+var a[size], n;
+function sum(ap[], len)
+  var i, s;
+begin
+  i := 0; s := 0;
+  while i < len do
+  begin
+    s := s + ap[i];
+    i := i + 1
+  end;
+  return s
+end;
+begin
+  n := sum(a, size);
+  write n
+end.
+`
+
+// client sends messages to the server and collects its outputs.
+type client struct {
+	t      *testing.T
+	input  bytes.Buffer
+	nextID int
+}
+
+func (c *client) send(method string, id int, params interface{}) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id > 0 {
+		msg["id"] = id
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fmt.Fprintf(&c.input, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (c *client) request(method string, params interface{}) {
+	c.nextID++
+	c.send(method, c.nextID, params)
+}
+
+// run runs the server and returns the received messages.
+func (c *client) run() []map[string]json.RawMessage {
+	var output bytes.Buffer
+	s := newServer(newConn(&c.input, &output))
+	status, err := s.serve()
+	if err != nil || status != 0 {
+		c.t.Fatalf("serve: %d, %v", status, err)
+	}
+
+	var msgs []map[string]json.RawMessage
+	out := newConn(&output, nil)
+	for {
+		msg, err := out.readRaw()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			c.t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func (c *conn) readRaw() (map[string]json.RawMessage, error) {
+	var length int
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fmt.Sscanf(line, "Content-Length: %d", &length)
+	if _, err = c.reader.ReadString('\n'); err != nil {
+		return nil, err
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(c.reader, body); err != nil {
+		return nil, err
+	}
+	var msg map[string]json.RawMessage
+	err = json.Unmarshal(body, &msg)
+	return msg, err
+}
+
+func position(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     Position{line, character},
+	}
+}
+
+func runSession(t *testing.T, text string, requests func(c *client)) []map[string]json.RawMessage {
+	c := &client{t: t}
+	c.request("initialize", map[string]interface{}{})
+	c.send("initialized", 0, map[string]interface{}{})
+	c.send("textDocument/didOpen", 0, map[string]interface{}{
+		"textDocument": TextDocumentItem{URI: testURI, LanguageID: "pl0", Version: 1, Text: text},
+	})
+	requests(c)
+	c.request("shutdown", nil)
+	c.send("exit", 0, nil)
+	return c.run()
+}
+
+func resultOf(t *testing.T, msgs []map[string]json.RawMessage, id int, v interface{}) {
+	for _, msg := range msgs {
+		if string(msg["id"]) == fmt.Sprint(id) {
+			if err := json.Unmarshal(msg["result"], v); err != nil {
+				t.Fatalf("#%d: %s: %s", id, err, msg["result"])
+			}
+			return
+		}
+	}
+	t.Fatalf("#%d: No response", id)
+}
+
+func diagnosticsOf(t *testing.T, msgs []map[string]json.RawMessage) []Diagnostic {
+	var diags []Diagnostic
+	for _, msg := range msgs {
+		if string(msg["method"]) == `"textDocument/publishDiagnostics"` {
+			var params PublishDiagnosticsParams
+			if err := json.Unmarshal(msg["params"], &params); err != nil {
+				t.Fatal(err)
+			}
+			diags = params.Diagnostics
+		}
+	}
+	return diags
+}
+
+func TestInitialize(t *testing.T) {
+	msgs := runSession(t, testSource, func(c *client) {})
+	var result InitializeResult
+	resultOf(t, msgs, 1, &result)
+	if !result.Capabilities.DefinitionProvider ||
+		result.Capabilities.TextDocumentSync != TextDocumentSyncKindFull {
+		t.Errorf("Got: %+v", result)
+	}
+	if diags := diagnosticsOf(t, msgs); len(diags) != 0 {
+		t.Errorf("Got diagnostics: %+v", diags)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	text := strings.Replace(testSource, "write n", "write m", 1)
+	msgs := runSession(t, text, func(c *client) {})
+	diags := diagnosticsOf(t, msgs)
+	want := Diagnostic{
+		Range:    Range{Position{15, 8}, Position{15, 9}},
+		Severity: DiagnosticSeverityError,
+		Code:     "undefined-symbol",
+		Source:   "pl0",
+		Message:  "Undefined symbol: m\nsuggestion: did you mean 'n'?",
+	}
+	if len(diags) != 1 || diags[0] != want {
+		t.Errorf("Got: %+v\nWant: %+v", diags, want)
+	}
+}
+
+func TestDefinitionAndReferences(t *testing.T) {
+	msgs := runSession(t, testSource, func(c *client) {
+		c.request("textDocument/definition", position(8, 13)) // ap in s + ap[i]
+		params := position(1, 4)                              // a in var a[size]
+		params["context"] = ReferenceContext{IncludeDeclaration: true}
+		c.request("textDocument/references", params)
+		c.request("textDocument/definition", position(5, 2)) // i in i := 0
+	})
+
+	var defs []Location
+	resultOf(t, msgs, 2, &defs)
+	want := Location{testURI, Range{Position{2, 13}, Position{2, 15}}}
+	if len(defs) != 1 || defs[0] != want {
+		t.Errorf("definition: Got: %+v\nWant: %+v", defs, want)
+	}
+
+	var refs []Location
+	resultOf(t, msgs, 3, &refs)
+	wantRefs := []Range{
+		{Position{1, 4}, Position{1, 5}},
+		{Position{14, 11}, Position{14, 12}},
+	}
+	if len(refs) != len(wantRefs) {
+		t.Fatalf("references: Got: %+v", refs)
+	}
+	for i, ref := range refs {
+		if ref.Range != wantRefs[i] {
+			t.Errorf("references[%d]: Got: %+v\nWant: %+v", i, ref.Range, wantRefs[i])
+		}
+	}
+
+	resultOf(t, msgs, 4, &defs)
+	want = Location{testURI, Range{Position{3, 6}, Position{3, 7}}}
+	if len(defs) != 1 || defs[0] != want {
+		t.Errorf("definition: Got: %+v\nWant: %+v", defs, want)
+	}
+}
+
+func TestHover(t *testing.T) {
+	msgs := runSession(t, testSource, func(c *client) {
+		c.request("textDocument/hover", position(8, 9))   // s
+		c.request("textDocument/hover", position(14, 8))  // sum
+		c.request("textDocument/hover", position(14, 15)) // size
+	})
+	wants := []string{
+		"```pl0\nvar s\n```\n\nvar (level 1, offset 3)",
+		"```pl0\nfunction sum(ap[], len)\n```\n\nfunction (level 0, offset 2: code address)",
+		"```pl0\nconst size = 3\n```\n",
+	}
+	for i, want := range wants {
+		var hover Hover
+		resultOf(t, msgs, i+2, &hover)
+		if hover.Contents.Value != want {
+			t.Errorf("#%d: Got: %q\nWant: %q", i, hover.Contents.Value, want)
+		}
+	}
+}
+
+func TestHoverComments(t *testing.T) {
+	source := `const size = 3; // number of elements
+var a[size]; { the array }
+(* sum of the array
+   elements *)
+function sum(ap[], len)
+  // index
+  var i;
+begin
+  return 0
+end;
+begin
+  a[0] := sum(a, size)
+end.
+`
+	msgs := runSession(t, source, func(c *client) {
+		c.request("textDocument/hover", position(11, 17)) // size
+		c.request("textDocument/hover", position(11, 2))  // a
+		c.request("textDocument/hover", position(11, 10)) // sum
+		c.request("textDocument/hover", position(6, 6))   // i
+	})
+	wants := []string{
+		"```pl0\nconst size = 3\n```\n\nnumber of elements\n",
+		"```pl0\nvar a[3]\n```\n\nthe array\n\narray (level 0, offset 2)",
+		"```pl0\nfunction sum(ap[], len)\n```\n\nsum of the array\n   elements\n\nfunction (level 0, offset 2: code address)",
+		"```pl0\nvar i\n```\n\nindex\n\nvar (level 1, offset 2)",
+	}
+	for i, want := range wants {
+		var hover Hover
+		resultOf(t, msgs, i+2, &hover)
+		if hover.Contents.Value != want {
+			t.Errorf("#%d: Got: %q\nWant: %q", i, hover.Contents.Value, want)
+		}
+	}
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	msgs := runSession(t, testSource, func(c *client) {
+		c.request("textDocument/documentSymbol", map[string]interface{}{
+			"textDocument": map[string]string{"uri": testURI},
+		})
+	})
+	var syms []DocumentSymbol
+	resultOf(t, msgs, 2, &syms)
+
+	var names []string
+	var walk func(syms []DocumentSymbol, prefix string)
+	walk = func(syms []DocumentSymbol, prefix string) {
+		for _, sym := range syms {
+			names = append(names, prefix+sym.Name)
+			walk(sym.Children, prefix+sym.Name+".")
+		}
+	}
+	walk(syms, "")
+	want := "size a n sum sum.ap sum.len sum.i sum.s"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("Got: %s\nWant: %s", got, want)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	labels := func(msgs []map[string]json.RawMessage, id int) string {
+		var items []CompletionItem
+		resultOf(t, msgs, id, &items)
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Label)
+		}
+		return strings.Join(names, " ")
+	}
+
+	msgs := runSession(t, testSource, func(c *client) {
+		c.request("textDocument/completion", position(5, 2))  // in sum
+		c.request("textDocument/completion", position(15, 2)) // in main
+		// symbols are available even if the source has syntax errors
+		text := strings.Replace(testSource, "write n", "write ", 1)
+		c.send("textDocument/didChange", 0, map[string]interface{}{
+			"textDocument":   VersionedTextDocumentIdentifier{URI: testURI, Version: 2},
+			"contentChanges": []TextDocumentContentChangeEvent{{Text: text}},
+		})
+		c.request("textDocument/completion", position(15, 8))
+	})
+
+	if got, want := labels(msgs, 2), "a abs ap i len max min n s size sqr sum"; got != want {
+		t.Errorf("in sum: Got: %s\nWant: %s", got, want)
+	}
+	if got, want := labels(msgs, 3), "a abs max min n size sqr sum"; got != want {
+		t.Errorf("in main: Got: %s\nWant: %s", got, want)
+	}
+	if got, want := labels(msgs, 4), "a abs max min n size sqr sum"; got != want {
+		t.Errorf("while editing: Got: %s\nWant: %s", got, want)
+	}
+}
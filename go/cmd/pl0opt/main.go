@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"kkpl0/pl0core"
+)
+
+func readInstructions(file string) ([]pl0core.Instruction, error) {
+	rf, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer rf.Close()
+
+	return pl0core.ReadInstructions(bufio.NewReader(rf))
+}
+
+func writeInstructions(file string, instructions []pl0core.Instruction) error {
+	wf, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer wf.Close()
+
+	w := bufio.NewWriter(wf)
+	err = pl0core.WriteInstructions(w, instructions)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func printStats(stats *pl0core.OptimizeStats) {
+	fmt.Printf("instructions:        %d -> %d", stats.Before, stats.After)
+	if stats.Before > 0 {
+		fmt.Printf(" (%+.1f%%)", float64(stats.After-stats.Before)*100/float64(stats.Before))
+	}
+	fmt.Println()
+	fmt.Printf("jumps threaded:      %d\n", stats.JumpsThreaded)
+	fmt.Printf("jumps removed:       %d\n", stats.JumpsRemoved)
+	fmt.Printf("unreachable removed: %d\n", stats.Unreachable)
+	fmt.Printf("loads combined:      %d\n", stats.LoadsCombined)
+	fmt.Printf("branches simplified: %d\n", stats.BranchesSimplified)
+}
+
+func run(file string, outFile string, debug bool, showStats bool) error {
+	instructions, err := readInstructions(file)
+	if err != nil {
+		return err
+	}
+	if err = pl0core.Verify(instructions); err != nil {
+		return err
+	}
+
+	optimized, stats := pl0core.Optimize(instructions)
+	if debug {
+		for i, inst := range optimized {
+			fmt.Printf("%d:\t%s\n", i, inst)
+		}
+	}
+	if showStats {
+		printStats(stats)
+	}
+
+	if outFile == "" {
+		outFile = strings.TrimSuffix(file, ".pl0vm") + ".opt.pl0vm"
+	}
+	return writeInstructions(outFile, optimized)
+}
+
+// printError prints the error of run.
+func printError(err error) {
+	switch e := err.(type) {
+	case *pl0core.Error:
+		fmt.Fprintf(os.Stderr, "Error: pc %d: %s\n", e.PC, e.Msg)
+	case pl0core.ErrorList:
+		for _, ve := range e {
+			printError(ve)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [options] program\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var debug bool
+	var showStats bool
+	var outFile string
+
+	flag.BoolVar(&debug, "debug", false, "print the optimized instructions")
+	flag.BoolVar(&showStats, "stats", false, "print statistics of the optimization")
+	flag.StringVar(&outFile, "o", "", "output file (default: program with .opt.pl0vm)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), outFile, debug, showStats); err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+}
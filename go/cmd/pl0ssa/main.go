@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"kkpl0/pl0compiler"
+	"kkpl0/pl0core"
+	"kkpl0/ssa"
+)
+
+func writeInstructions(file string, instructions []pl0core.Instruction) error {
+	wf, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer wf.Close()
+
+	w := bufio.NewWriter(wf)
+	err = pl0core.WriteInstructions(w, instructions)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+type options struct {
+	passes      []ssa.Pass
+	boundsCheck bool
+	dump        bool
+	debug       bool
+}
+
+// build builds the program in SSA form from the source file.
+func build(srcFile string, src []byte, opts options) (*ssa.Program, error) {
+	prog, err := pl0compiler.Parse(srcFile, src)
+	if err != nil {
+		return nil, err
+	}
+	c := pl0compiler.NewCompiler(srcFile)
+	c.BoundsCheck = opts.boundsCheck
+	if err := c.Compile(prog); err != nil {
+		return nil, err
+	}
+	return ssa.Build(prog, c.Info())
+}
+
+func run(srcFile string, outFile string, opts options) error {
+	src, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		return err
+	}
+	p, err := build(srcFile, src, opts)
+	if err != nil {
+		return err
+	}
+	if err = p.Verify(); err != nil {
+		return err
+	}
+	if opts.dump {
+		fmt.Printf("; build\n%s\n", p)
+	}
+	for _, pass := range opts.passes {
+		for _, f := range p.Funcs {
+			pass.Run(f)
+		}
+		if err = p.Verify(); err != nil {
+			return fmt.Errorf("after %s: %v", pass.Name, err)
+		}
+		if opts.dump {
+			fmt.Printf("; after %s\n%s\n", pass.Name, p)
+		}
+	}
+
+	instructions := ssa.Lower(p)
+	if err = pl0core.Verify(instructions); err != nil {
+		return err
+	}
+	if opts.debug {
+		for i, inst := range instructions {
+			fmt.Printf("%d:\t%s\n", i, inst)
+		}
+	}
+	if outFile == "" {
+		outFile = strings.TrimSuffix(srcFile, ".pl0") + ".pl0vm"
+	}
+	return writeInstructions(outFile, instructions)
+}
+
+// printError prints the error of run.
+func printError(err error) {
+	switch e := err.(type) {
+	case pl0compiler.ErrorList:
+		for _, ce := range e {
+			fmt.Fprintln(os.Stderr, ce)
+		}
+	case pl0core.ErrorList:
+		for _, ve := range e {
+			fmt.Fprintf(os.Stderr, "Error: pc %d: %s\n", ve.PC, ve.Msg)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [options] source\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var opts options
+	var passNames string
+	var outFile string
+
+	var names []string
+	for _, pass := range ssa.Passes {
+		names = append(names, pass.Name)
+	}
+	flag.StringVar(&passNames, "passes", strings.Join(names, ","), "comma-separated optimization passes")
+	flag.BoolVar(&opts.boundsCheck, "boundscheck", true, "check array indices at runtime by CHK")
+	flag.BoolVar(&opts.dump, "dump", false, "print the IR after building and after each pass")
+	flag.BoolVar(&opts.debug, "debug", false, "print the generated instructions")
+	flag.StringVar(&outFile, "o", "", "output file (default: source with .pl0vm)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	passes, err := ssa.LookupPasses(passNames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	opts.passes = passes
+
+	if err := run(flag.Arg(0), outFile, opts); err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"kkpl0/diag"
+	"kkpl0/pl0compiler"
+	"kkpl0/vet"
+)
+
+// vetFile checks a source file in the dialect, and returns its diagnostics.
+func vetFile(file string, dialect pl0compiler.Dialect, checks []*vet.Check,
+	format diag.Format) ([]diag.Diagnostic, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := vet.CheckSourceDialect(file, src, dialect, checks)
+	if errors, ok := err.(pl0compiler.ErrorList); ok {
+		if format == diag.FormatText {
+			fmt.Fprint(os.Stderr, errors.Format(src))
+		}
+		return errors.Diagnostics(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var diags []diag.Diagnostic
+	for _, f := range findings {
+		if format == diag.FormatText {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s (%s)\n",
+				file, f.Pos.Line, f.Pos.Column, f.Msg, f.Check)
+		}
+		diags = append(diags, f.Diagnostic(file))
+	}
+	return diags, nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [options] source...\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var formatName, dialectName string
+
+	enabled := make(map[*vet.Check]*bool)
+	for _, check := range vet.Checks {
+		enabled[check] = flag.Bool(check.Name, true, check.Doc)
+	}
+	flag.StringVar(&formatName, "format", "text",
+		"diagnostics format: text, json or sarif (written to stderr)")
+	flag.StringVar(&dialectName, "dialect", "kk", "dialect of the source: kk, pl0prime or wirth")
+	flag.Usage = usage
+	flag.Parse()
+
+	format, err := diag.ParseFormat(formatName)
+	if err != nil || flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	dialect, err := pl0compiler.LookupDialect(dialectName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var checks []*vet.Check
+	for _, check := range vet.Checks {
+		if *enabled[check] {
+			checks = append(checks, check)
+		}
+	}
+
+	var diags []diag.Diagnostic
+	for _, file := range flag.Args() {
+		fileDiags, err := vetFile(file, dialect, checks, format)
+		if err != nil {
+			if format == diag.FormatText {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			fileDiags = []diag.Diagnostic{diag.Fatal(file, err)}
+		}
+		diags = append(diags, fileDiags...)
+	}
+
+	if format != diag.FormatText {
+		if err = diag.Write(os.Stderr, format, "pl0vet", diags); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(diags) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"kkpl0/diag"
+	"kkpl0/pl0core"
+)
+
+func readInstructions(file string) ([]pl0core.Instruction, error) {
+	rf, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer rf.Close()
+
+	return pl0core.ReadInstructions(bufio.NewReader(rf))
+}
+
+func run(file string, debug bool) error {
+	instructions, err := readInstructions(file)
+	if err != nil {
+		return err
+	}
+	if err = pl0core.Verify(instructions); err != nil {
+		return err
+	}
+	if debug {
+		for i, inst := range instructions {
+			fmt.Printf("%d:\t%s\n", i, inst)
+		}
+	}
+
+	vm := pl0core.NewPL0VM()
+	vm.Debug = debug
+	return vm.Run(instructions)
+}
+
+// diagnostics returns the error of run as diagnostics.
+func diagnostics(file string, err error) []diag.Diagnostic {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *pl0core.Error:
+		return []diag.Diagnostic{e.Diagnostic(file)}
+	case pl0core.ErrorList:
+		diags := make([]diag.Diagnostic, len(e))
+		for i, ve := range e {
+			diags[i] = ve.Diagnostic(file)
+		}
+		return diags
+	}
+	return []diag.Diagnostic{diag.Fatal(file, err)}
+}
+
+// printError prints the error of run in text.
+func printError(err error) {
+	switch e := err.(type) {
+	case *pl0core.Error:
+		fmt.Fprintf(os.Stderr, "Error: pc %d: %s\n", e.PC, e.Msg)
+	case pl0core.ErrorList:
+		for _, ve := range e {
+			printError(ve)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"Usage: %s [options] program\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var debug bool
+	var formatName string
+
+	flag.BoolVar(&debug, "debug", false, "debug flag")
+	flag.StringVar(&formatName, "format", "text",
+		"diagnostics format: text, json or sarif (written to stderr)")
+	flag.Usage = usage
+	flag.Parse()
+
+	format, err := diag.ParseFormat(formatName)
+	if err != nil || flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	err = run(flag.Arg(0), debug)
+	if format != diag.FormatText {
+		if werr := diag.Write(os.Stderr, format, "pl0vm", diagnostics(flag.Arg(0), err)); werr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", werr)
+			os.Exit(1)
+		}
+	} else if err != nil {
+		printError(err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,131 @@
+// Package diag provides diagnostics shared by the PL/0 compiler,
+// the instruction verifier and the PL/0 VM,
+// and writes them as JSON or SARIF.
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity string
+
+const (
+	// SeverityError is an error.
+	SeverityError Severity = "error"
+	// SeverityWarning is a warning.
+	SeverityWarning Severity = "warning"
+	// SeverityNote is a note.
+	SeverityNote Severity = "note"
+)
+
+// Position is a position in a source. Line and Column are 1-based.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Range is a range in a source. End is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location is a location in a file.
+// Range is nil if no source position is known,
+// and Address is the instruction index of a program if any.
+type Location struct {
+	File    string `json:"file"`
+	Range   *Range `json:"range,omitempty"`
+	Address *int   `json:"address,omitempty"`
+}
+
+// RelatedLocation is a location related to a diagnostic.
+type RelatedLocation struct {
+	Location
+	Message string `json:"message"`
+}
+
+// Edit replaces the text in the range with NewText.
+type Edit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// Fix is a hint to fix a diagnostic.
+// Edits is empty if the fix can not be applied automatically.
+type Fix struct {
+	Description string `json:"description"`
+	Edits       []Edit `json:"edits,omitempty"`
+}
+
+// Diagnostic is an error or a warning.
+type Diagnostic struct {
+	Severity Severity          `json:"severity"`
+	Code     string            `json:"code"`
+	Message  string            `json:"message"`
+	Location Location          `json:"location"`
+	Related  []RelatedLocation `json:"related,omitempty"`
+	Fixes    []Fix             `json:"fixes,omitempty"`
+}
+
+// CodeFatal is the code of errors other than diagnostics,
+// such as I/O errors.
+const CodeFatal = "fatal"
+
+// Fatal returns an error other than diagnostics as a diagnostic of the file.
+func Fatal(file string, err error) Diagnostic {
+	return Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeFatal,
+		Message:  err.Error(),
+		Location: Location{File: file},
+	}
+}
+
+// Format is an output format of diagnostics.
+type Format string
+
+const (
+	// FormatText is the human readable format of each command.
+	FormatText Format = "text"
+	// FormatJSON is JSON.
+	FormatJSON Format = "json"
+	// FormatSARIF is SARIF 2.1.0.
+	FormatSARIF Format = "sarif"
+)
+
+// ParseFormat parses the format name.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(name); format {
+	case FormatText, FormatJSON, FormatSARIF:
+		return format, nil
+	}
+	return "", fmt.Errorf("Unknown format: %s (text, json or sarif)", name)
+}
+
+// Write writes diagnostics of the tool in JSON or SARIF.
+// It is an error to write in FormatText, which each command formats.
+func Write(w io.Writer, format Format, tool string, diags []Diagnostic) error {
+	switch format {
+	case FormatJSON:
+		return WriteJSON(w, diags)
+	case FormatSARIF:
+		return WriteSARIF(w, tool, diags)
+	}
+	return fmt.Errorf("Unsupported format: %s", format)
+}
+
+// WriteJSON writes diagnostics as a JSON object {"diagnostics": [...]}.
+func WriteJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}{diags})
+}
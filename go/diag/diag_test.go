@@ -0,0 +1,103 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var testDiags = []Diagnostic{
+	{
+		Severity: SeverityError,
+		Code:     "undefined-symbol",
+		Message:  "Undefined symbol: sise",
+		Location: Location{File: "test.pl0", Range: &Range{Position{2, 8}, Position{2, 12}}},
+		Related: []RelatedLocation{{
+			Location: Location{File: "test.pl0", Range: &Range{Position{1, 7}, Position{1, 11}}},
+			Message:  "const size is declared here",
+		}},
+		Fixes: []Fix{
+			{Description: "did you mean 'size'?", Edits: []Edit{{Range{Position{2, 8}, Position{2, 12}}, "size"}}},
+			{Description: "no edits"},
+		},
+	},
+	{
+		Severity: SeverityError,
+		Code:     "division-by-zero",
+		Message:  "Division by zero",
+		Location: Location{File: "test.pl0vm", Address: new(int)},
+	},
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, name := range []string{"text", "json", "sarif"} {
+		if format, err := ParseFormat(name); err != nil || string(format) != name {
+			t.Errorf("%s: Got: %s, %v", name, format, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("xml: No error")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, testDiags); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Diagnostics) != 2 || got.Diagnostics[0].Fixes[0].Edits[0].NewText != "size" ||
+		*got.Diagnostics[1].Location.Address != 0 {
+		t.Errorf("Got: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	} else if got, want := buf.String(), "{\n  \"diagnostics\": []\n}\n"; got != want {
+		t.Errorf("Got: %q\nWant: %q", got, want)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, "pl0c", testDiags); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Got: %s", buf.String())
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "pl0c" || len(run.Tool.Driver.Rules) != 2 ||
+		run.Tool.Driver.Rules[1].ID != "division-by-zero" {
+		t.Errorf("Tool: Got: %+v", run.Tool)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("Results: Got: %+v", run.Results)
+	}
+	r := run.Results[0]
+	if r.RuleIndex != 0 || r.Level != "error" ||
+		*r.Locations[0].PhysicalLocation.Region != (sarifRegion{2, 8, 2, 12}) ||
+		*r.RelatedLocations[0].ID != 1 {
+		t.Errorf("Results[0]: Got: %+v", r)
+	}
+	// a fix without edits is omitted
+	if len(r.Fixes) != 1 ||
+		r.Fixes[0].ArtifactChanges[0].Replacements[0].InsertedContent.Text != "size" {
+		t.Errorf("Fixes: Got: %+v", r.Fixes)
+	}
+	r = run.Results[1]
+	if r.RuleIndex != 1 || r.Locations[0].PhysicalLocation.Region != nil ||
+		r.Locations[0].PhysicalLocation.Address.AbsoluteAddress != 0 {
+		t.Errorf("Results[1]: Got: %+v", r)
+	}
+}
@@ -0,0 +1,173 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+)
+
+// SARIF 2.1.0 log, which contains only the properties used here.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	RuleIndex        int             `json:"ruleIndex"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               *int                  `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+	Address          *sarifAddress         `json:"address,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+type sarifAddress struct {
+	AbsoluteAddress int    `json:"absoluteAddress"`
+	Kind            string `json:"kind"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+func sarifRegionOf(r Range) sarifRegion {
+	return sarifRegion{
+		StartLine:   r.Start.Line,
+		StartColumn: r.Start.Column,
+		EndLine:     r.End.Line,
+		EndColumn:   r.End.Column,
+	}
+}
+
+func sarifURI(file string) sarifArtifactLocation {
+	return sarifArtifactLocation{URI: filepath.ToSlash(file)}
+}
+
+func sarifLocationOf(loc Location) sarifLocation {
+	physical := sarifPhysicalLocation{ArtifactLocation: sarifURI(loc.File)}
+	if loc.Range != nil {
+		region := sarifRegionOf(*loc.Range)
+		physical.Region = &region
+	}
+	if loc.Address != nil {
+		physical.Address = &sarifAddress{AbsoluteAddress: *loc.Address, Kind: "instruction"}
+	}
+	return sarifLocation{PhysicalLocation: physical}
+}
+
+// WriteSARIF writes diagnostics of the tool as a SARIF log.
+// Fixes without edits are omitted since SARIF requires changes of fixes.
+func WriteSARIF(w io.Writer, tool string, diags []Diagnostic) error {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: tool, Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+	ruleIndex := make(map[string]int)
+	for _, d := range diags {
+		index, ok := ruleIndex[d.Code]
+		if !ok {
+			index = len(run.Tool.Driver.Rules)
+			ruleIndex[d.Code] = index
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Code})
+		}
+		result := sarifResult{
+			RuleID:    d.Code,
+			RuleIndex: index,
+			Level:     string(d.Severity),
+			Message:   sarifMessage{d.Message},
+			Locations: []sarifLocation{sarifLocationOf(d.Location)},
+		}
+		for i, related := range d.Related {
+			loc := sarifLocationOf(related.Location)
+			id := i + 1
+			loc.ID = &id
+			loc.Message = &sarifMessage{related.Message}
+			result.RelatedLocations = append(result.RelatedLocations, loc)
+		}
+		for _, fix := range d.Fixes {
+			if len(fix.Edits) == 0 {
+				continue
+			}
+			change := sarifArtifactChange{ArtifactLocation: sarifURI(d.Location.File)}
+			for _, edit := range fix.Edits {
+				change.Replacements = append(change.Replacements, sarifReplacement{
+					DeletedRegion:   sarifRegionOf(edit.Range),
+					InsertedContent: sarifMessage{edit.NewText},
+				})
+			}
+			result.Fixes = append(result.Fixes, sarifFix{
+				Description:     sarifMessage{fix.Description},
+				ArtifactChanges: []sarifArtifactChange{change},
+			})
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
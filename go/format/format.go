@@ -0,0 +1,561 @@
+// Package format formats PL/0 programs in the canonical style.
+//
+// The canonical style is:
+//
+//   - two spaces for each level of indentation
+//   - declarations of a function are indented,
+//     and its compound body starts at the level of 'function'
+//   - 'begin' of a compound statement in if, while and else
+//     starts a new line at the level of the statement
+//   - other statements in if, while, else and repeat are indented
+//   - single spaces around binary operators and ':='
+//   - no empty statement, so no ';' before 'end'
+//   - at most one blank line between declarations and statements,
+//     where the source has blank lines
+//
+// Comments are kept. A comment at the end of a line stays there,
+// a comment on its own line is indented at the level of the next line,
+// and a comment between the tokens of a statement stays before the token
+// following it, such as an operator.
+package format
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"kkpl0/ast"
+	"kkpl0/pl0compiler"
+)
+
+const indentUnit = "  "
+
+// Source formats a PL/0 source.
+// A source with syntax errors is not formatted, and the errors are returned.
+func Source(sourceName string, src []byte) ([]byte, error) {
+	return SourceDialect(sourceName, src, pl0compiler.DialectKK)
+}
+
+// SourceDialect is like Source, but formats the source in the dialect.
+func SourceDialect(sourceName string, src []byte, dialect pl0compiler.Dialect) ([]byte, error) {
+	prog, err := pl0compiler.ParseDialect(sourceName, src, dialect)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = FprintDialect(&buf, prog, dialect); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fprint prints the program in the canonical style.
+func Fprint(w io.Writer, prog *ast.Program) error {
+	return FprintDialect(w, prog, pl0compiler.DialectKK)
+}
+
+// FprintDialect is like Fprint, but prints the program in the dialect:
+// procedures of Wirth's PL/0 have no parentheses, and '<>' is '#'.
+func FprintDialect(w io.Writer, prog *ast.Program, dialect pl0compiler.Dialect) error {
+	p := &printer{dialect: dialect, comments: prog.Comments}
+	p.block(prog.Block, false)
+	p.flush(prog.Period)
+	p.print(".")
+	p.flush(ast.Pos{Offset: math.MaxInt32})
+	p.buf.WriteString("\n")
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+type printer struct {
+	buf      bytes.Buffer
+	indent   int
+	newlines int // number of newlines written before the next text
+	dialect  pl0compiler.Dialect
+
+	comments []*ast.Comment
+	next     int // index of the next comment to print
+	lastLine int // source line of the last node or comment printed
+}
+
+func (p *printer) print(texts ...string) {
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		if p.newlines > 0 {
+			p.buf.WriteString(strings.Repeat("\n", p.newlines))
+			p.buf.WriteString(strings.Repeat(indentUnit, p.indent))
+			p.newlines = 0
+		}
+		p.buf.WriteString(text)
+	}
+}
+
+// newline starts a new line.
+// It is written lazily, so that comments can be written at the end of the line.
+func (p *printer) newline() {
+	if p.newlines == 0 {
+		p.newlines = 1
+	}
+}
+
+// separate starts a new line for the node after prev,
+// with a blank line if the source has blank lines between them.
+func (p *printer) separate(prev ast.Node, node ast.Node) {
+	p.newline()
+	if prev != nil && node.Pos().Line-prev.End().Line > 1 {
+		p.newlines = 2
+	}
+}
+
+// flush prints the comments before pos.
+// A comment on the line of the last node is printed at the end of the line,
+// and other comments are printed on their own lines.
+func (p *printer) flush(pos ast.Pos) {
+	for ; p.next < len(p.comments) && p.comments[p.next].Pos().Before(pos); p.next++ {
+		c := p.comments[p.next]
+		if c.Pos().Line == p.lastLine {
+			p.trailingComment(c, pos)
+		} else {
+			if p.buf.Len() > 0 {
+				p.newlines = lineGap(p.lastLine, c.Pos().Line)
+			}
+			p.print(c.Text)
+			p.newlines = lineGap(c.End().Line, pos.Line)
+		}
+		p.lastLine = c.End().Line
+	}
+	p.lastLine = pos.Line
+}
+
+// trailingComment prints the comment at the end of the line,
+// or between tokens if the node at pos follows it on the same line.
+func (p *printer) trailingComment(c *ast.Comment, pos ast.Pos) {
+	if p.newlines == 0 && !c.IsLine() && noSpaceAfter(p.buf.Bytes()) {
+		p.buf.WriteString(c.Text)
+	} else {
+		p.buf.WriteString(" " + c.Text)
+	}
+	if c.IsLine() || c.End().Line < pos.Line {
+		p.newline()
+	} else if p.newlines == 0 {
+		p.buf.WriteString(" ")
+	}
+}
+
+// flushLine prints the comments on the line before the next node
+// at the end of the line, after the node ending at the line.
+func (p *printer) flushLine(line int, next ast.Pos) {
+	p.lastLine = line
+	for p.next < len(p.comments) && p.comments[p.next].Pos().Line == line &&
+		p.comments[p.next].Pos().Before(next) {
+		c := p.comments[p.next]
+		p.buf.WriteString(" " + c.Text)
+		p.next++
+		p.lastLine = c.End().Line
+		if c.End().Line != line {
+			return
+		}
+	}
+}
+
+// space prints a space before a token,
+// unless the token starts a line or follows a space.
+func (p *printer) space() {
+	if p.newlines == 0 && !noSpaceAfter(p.buf.Bytes()) {
+		p.buf.WriteString(" ")
+	}
+}
+
+// lineGap returns the number of newlines from line to line, 1 or 2.
+func lineGap(from int, to int) int {
+	if to-from > 1 {
+		return 2
+	}
+	return 1
+}
+
+// noSpaceAfter reports whether the printed text needs no space after it.
+func noSpaceAfter(text []byte) bool {
+	if len(text) == 0 {
+		return true
+	}
+	ch := text[len(text)-1]
+	return ch == ' ' || ch == '(' || ch == '['
+}
+
+func (p *printer) block(block *ast.Block, isFunc bool) {
+	if isFunc {
+		p.indent++
+	}
+	var prev ast.Node
+	for i, decl := range block.Decls {
+		if prev != nil {
+			p.separate(prev, decl)
+		} else if isFunc {
+			p.newline()
+		}
+		p.decl(decl)
+		next := block.Body.Pos()
+		if i < len(block.Decls)-1 {
+			next = block.Decls[i+1].Pos()
+		}
+		p.flushLine(decl.End().Line, next)
+		prev = decl
+	}
+	if isFunc {
+		p.indent--
+	}
+
+	if _, ok := block.Body.(*ast.EmptyStmt); ok {
+		if prev != nil {
+			p.newline()
+		}
+		return
+	}
+	if prev != nil || isFunc {
+		p.separate(prev, block.Body)
+	}
+	if _, ok := block.Body.(*ast.CompoundStmt); !ok && isFunc {
+		p.indent++
+		p.stmt(block.Body)
+		p.indent--
+		return
+	}
+	p.stmt(block.Body)
+}
+
+func (p *printer) decl(decl ast.Decl) {
+	p.flush(decl.Pos())
+	switch d := decl.(type) {
+	case *ast.ConstDecl:
+		p.print("const ")
+		for i, spec := range d.Specs {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.ident(spec.Name)
+			p.print(" = ")
+			p.expr(spec.Value)
+		}
+		p.print(";")
+	case *ast.VarDecl:
+		p.print("var ")
+		for i, spec := range d.Specs {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.ident(spec.Name)
+			if spec.Sizes != nil {
+				p.print("[")
+				p.exprList(spec.Sizes)
+				p.flush(spec.Rbrack)
+				p.print("]")
+			}
+		}
+		p.print(";")
+	case *ast.FuncDecl:
+		if d.Proc {
+			p.print("procedure ")
+		} else {
+			p.print("function ")
+		}
+		p.ident(d.Name)
+		if p.dialect == pl0compiler.DialectWirth {
+			p.print(";")
+			p.block(d.Body, true)
+			p.print(";")
+			return
+		}
+		p.print("(")
+		for i, param := range d.Params {
+			if i > 0 {
+				p.print(", ")
+			}
+			if param.Var.IsValid() {
+				p.print("var ")
+			}
+			p.ident(param.Name)
+			if param.Ref {
+				p.print("[")
+				for i := 1; i < param.Rank; i++ {
+					p.print(",")
+				}
+				p.print("]")
+			}
+		}
+		p.print(")")
+		p.block(d.Body, true)
+		p.print(";")
+	}
+}
+
+// body prints the statement in if, while and else.
+func (p *printer) body(stmt ast.Stmt) {
+	switch stmt.(type) {
+	case *ast.EmptyStmt:
+		return
+	case *ast.CompoundStmt:
+		p.newline()
+		p.stmt(stmt)
+		return
+	}
+	p.indent++
+	p.newline()
+	p.stmt(stmt)
+	p.indent--
+}
+
+// statements returns the statements of the compound statement
+// without empty statements.
+func statements(s *ast.CompoundStmt) []ast.Stmt {
+	var list []ast.Stmt
+	for _, stmt := range s.List {
+		if _, ok := stmt.(*ast.EmptyStmt); !ok {
+			list = append(list, stmt)
+		}
+	}
+	return list
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	p.flush(stmt.Pos())
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		p.ident(s.Name)
+		if s.Indices != nil {
+			p.print("[")
+			p.exprList(s.Indices)
+			p.print("]")
+		}
+		p.flush(s.Assign)
+		p.space()
+		p.print(":= ")
+		p.expr(s.Value)
+	case *ast.CompoundStmt:
+		p.print("begin")
+		p.indent++
+		list := statements(s)
+		var prev ast.Node = s
+		for i, child := range list {
+			if i == 0 {
+				p.newline()
+			} else {
+				p.separate(prev, child)
+			}
+			p.stmt(child)
+			if i < len(list)-1 {
+				p.print(";")
+			}
+			next := s.EndPos
+			if i < len(list)-1 {
+				next = list[i+1].Pos()
+			}
+			p.flushLine(child.End().Line, next)
+			prev = child
+		}
+		p.newline()
+		p.flush(s.EndPos)
+		p.indent--
+		p.print("end")
+	case *ast.IfStmt:
+		p.print("if ")
+		p.expr(s.Cond)
+		p.print(" then")
+		p.body(s.Then)
+		if s.Else != nil {
+			p.newline()
+			p.print("else")
+			if elseIf, ok := s.Else.(*ast.IfStmt); ok {
+				p.print(" ")
+				p.stmt(elseIf)
+			} else {
+				p.body(s.Else)
+			}
+		}
+	case *ast.WhileStmt:
+		p.print("while ")
+		p.expr(s.Cond)
+		p.print(" do")
+		p.body(s.Body)
+	case *ast.ForStmt:
+		p.print("for ")
+		p.ident(s.Var)
+		p.print(" := ")
+		p.expr(s.From)
+		if s.Down {
+			p.print(" downto ")
+		} else {
+			p.print(" to ")
+		}
+		p.expr(s.To)
+		if s.Step != nil {
+			p.print(" step ")
+			p.expr(s.Step)
+		}
+		p.print(" do")
+		p.body(s.Body)
+	case *ast.RepeatStmt:
+		if _, ok := s.Body.(*ast.CompoundStmt); ok {
+			p.print("repeat ")
+			p.stmt(s.Body)
+			p.print(" ")
+		} else {
+			p.print("repeat")
+			p.indent++
+			p.newline()
+			p.stmt(s.Body)
+			p.indent--
+			p.newline()
+		}
+		p.print("until ")
+		p.expr(s.Cond)
+	case *ast.CaseStmt:
+		p.print("case ")
+		p.expr(s.X)
+		p.print(" of")
+		p.indent++
+		var prev ast.Node = s
+		for i, clause := range s.Clauses {
+			p.separate(prev, clause)
+			p.exprList(clause.Labels)
+			p.flush(clause.Colon)
+			p.print(":")
+			if _, ok := clause.Body.(*ast.EmptyStmt); !ok {
+				p.print(" ")
+				p.stmt(clause.Body)
+			}
+			if i < len(s.Clauses)-1 {
+				p.print(";")
+			}
+			next := s.EndPos
+			if i < len(s.Clauses)-1 {
+				next = s.Clauses[i+1].Pos()
+			} else if s.Else != nil {
+				next = s.Else.Pos()
+			}
+			p.flushLine(clause.End().Line, next)
+			prev = clause
+		}
+		p.indent--
+		if s.Else != nil {
+			p.newline()
+			p.print("else")
+			p.body(s.Else)
+		}
+		p.newline()
+		p.flush(s.EndPos)
+		p.print("end")
+	case *ast.BranchStmt:
+		if s.Continue {
+			p.print("continue")
+		} else {
+			p.print("break")
+		}
+	case *ast.ReturnStmt:
+		p.print("return")
+		if s.Result != nil {
+			p.print(" ")
+			p.expr(s.Result)
+		}
+	case *ast.WriteStmt:
+		p.print("write ")
+		p.exprList(s.Items)
+	case *ast.WritelnStmt:
+		p.print("writeln")
+		if len(s.Items) > 0 {
+			p.print(" ")
+			p.exprList(s.Items)
+		}
+	case *ast.CallStmt:
+		if s.Call.IsValid() {
+			p.print("call ")
+		}
+		p.expr(s.X)
+	case *ast.InputStmt:
+		p.print("?")
+		p.ident(s.Name)
+	case *ast.OutputStmt:
+		p.print("!")
+		p.expr(s.X)
+	}
+}
+
+func (p *printer) ident(id *ast.Ident) {
+	p.flush(id.Pos())
+	p.print(id.Name)
+}
+
+func (p *printer) exprList(list []ast.Expr) {
+	for i, x := range list {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) expr(expr ast.Expr) {
+	p.flush(expr.Pos())
+	switch x := expr.(type) {
+	case *ast.Ident:
+		p.print(x.Name)
+	case *ast.NumberLit:
+		p.print(x.Text)
+	case *ast.StringLit:
+		if x.Text == "" {
+			p.print(strconv.Quote(x.Value))
+		} else {
+			p.print(x.Text)
+		}
+	case *ast.WidthExpr:
+		p.expr(x.X)
+		p.flush(x.Colon)
+		p.print(":")
+		p.expr(x.Width)
+	case *ast.ParenExpr:
+		p.print("(")
+		p.expr(x.X)
+		p.flush(x.Rparen)
+		p.print(")")
+	case *ast.UnaryExpr:
+		if x.Op == ast.OpOdd || x.Op == ast.OpNot {
+			p.print(string(x.Op), " ")
+		} else {
+			p.print(string(x.Op))
+		}
+		p.expr(x.X)
+	case *ast.BinaryExpr:
+		p.expr(x.X)
+		p.flush(x.OpPos)
+		p.space()
+		if x.Op == ast.OpNeq && p.dialect == pl0compiler.DialectWirth {
+			p.print("# ")
+		} else {
+			p.print(string(x.Op), " ")
+		}
+		p.expr(x.Y)
+	case *ast.IndexExpr:
+		p.print(x.Name.Name, "[")
+		p.exprList(x.Indices)
+		p.flush(x.Rbrack)
+		p.print("]")
+	case *ast.CallExpr:
+		if p.dialect == pl0compiler.DialectWirth {
+			p.print(x.Func.Name)
+			break
+		}
+		p.print(x.Func.Name, "(")
+		for i, arg := range x.Args {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.expr(arg)
+		}
+		p.flush(x.Rparen)
+		p.print(")")
+	}
+}
@@ -0,0 +1,217 @@
+package format
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"kkpl0/pl0compiler"
+	"kkpl0/pl0core"
+)
+
+var formatTargets = []struct {
+	source string
+	want   string
+}{
+	{
+		"var x;begin x:=1+2*(3-x);write -x end.",
+		"var x;\nbegin\n  x := 1 + 2 * (3 - x);\n  write -x\nend.\n",
+	},
+	{
+		"const a=1,b=2;var i,ary[b];\n" +
+			"function f(x,ap[]) var y; begin y:=x;return ap[y] ; end;\n" +
+			"begin i:=f(a,ary);writeln; end.",
+		"const a = 1, b = 2;\nvar i, ary[b];\n" +
+			"function f(x, ap[])\n  var y;\nbegin\n  y := x;\n  return ap[y]\nend;\n" +
+			"begin\n  i := f(a, ary);\n  writeln\nend.\n",
+	},
+	{
+		"function f(x) return x;\n\n\n" +
+			"var i;\nbegin\n" +
+			"  if odd i then i:=1 else if i>1 then begin i:=2 end else i:=3;\n\n\n" +
+			"  while i<10 do i:=i+1;\n" +
+			"  repeat i:=i-1 until i=0;\n" +
+			"  repeat begin i:=i+1; i:=i*2 end until i>=10;\n" +
+			"  if i<>0 then write i;\n" +
+			"end.",
+		"function f(x)\n  return x;\n\n" +
+			"var i;\nbegin\n" +
+			"  if odd i then\n    i := 1\n  else if i > 1 then\n  begin\n    i := 2\n  end\n  else\n    i := 3;\n\n" +
+			"  while i < 10 do\n    i := i + 1;\n" +
+			"  repeat\n    i := i - 1\n  until i = 0;\n" +
+			"  repeat begin\n    i := i + 1;\n    i := i * 2\n  end until i >= 10;\n" +
+			"  if i <> 0 then\n    write i\nend.\n",
+	},
+	{
+		// comments
+		"{ Fibonacci numbers }\n" +
+			"// by recursion\n" +
+			"\n" +
+			"function fib(n) // n >= 1\n" +
+			"begin\n" +
+			"  { small cases }\n" +
+			"  if n <= 2 then return 1; // base\n" +
+			"  return fib(n-1) + (* left *) fib(n-2) { right }\n" +
+			"end;\n" +
+			"\n" +
+			"var n; (* counter *)\n" +
+			"\n" +
+			"begin\n" +
+			"  n := 1;\n" +
+			"\n" +
+			"  while n <= 10 do\n" +
+			"    begin\n" +
+			"      write fib( // across\n" +
+			"        n);\n" +
+			"      writeln;\n" +
+			"      n := n + 1; // next\n" +
+			"      // trailing own line\n" +
+			"    end;\n" +
+			"end. // done\n" +
+			"{ after }\n",
+		"{ Fibonacci numbers }\n" +
+			"// by recursion\n" +
+			"\n" +
+			"function fib(n) // n >= 1\n" +
+			"begin\n" +
+			"  { small cases }\n" +
+			"  if n <= 2 then\n" +
+			"    return 1; // base\n" +
+			"  return fib(n - 1) + (* left *) fib(n - 2) { right }\n" +
+			"end;\n" +
+			"\n" +
+			"var n; (* counter *)\n" +
+			"\n" +
+			"begin\n" +
+			"  n := 1;\n" +
+			"\n" +
+			"  while n <= 10 do\n" +
+			"  begin\n" +
+			"    write fib( // across\n" +
+			"    n);\n" +
+			"    writeln;\n" +
+			"    n := n + 1 // next\n" +
+			"    // trailing own line\n" +
+			"  end\n" +
+			"end. // done\n" +
+			"{ after }\n",
+	},
+	{
+		"procedure p(x) begin if x<0 then return;write x end;\n" +
+			"begin call p(1);p(2) end.",
+		"procedure p(x)\nbegin\n  if x < 0 then\n    return;\n  write x\nend;\n" +
+			"begin\n  call p(1);\n  p(2)\nend.\n",
+	},
+	{
+		"var i,j;begin for i:=1 to 10 step 2 do for j:=i downto 1 do write j;\n" +
+			"for i:=0 to 1 do begin write i end end.",
+		"var i, j;\nbegin\n  for i := 1 to 10 step 2 do\n    for j := i downto 1 do\n      write j;\n" +
+			"  for i := 0 to 1 do\n  begin\n    write i\n  end\nend.\n",
+	},
+	{
+		"var x;begin if not(x>0)and odd x or(x+1)*2=4 then write x end.",
+		"var x;\nbegin\n  if not (x > 0) and odd x or (x + 1) * 2 = 4 then\n    write x\nend.\n",
+	},
+	{
+		"var x;begin x:=abs(x mod 3)+max(x,sqr(2))end.",
+		"var x;\nbegin\n  x := abs(x mod 3) + max(x, sqr(2))\nend.\n",
+	},
+	{
+		"var m[2,3];function f(p[ , ]) return p[1,2];begin m[1 ,2]:=f(m) end.",
+		"var m[2, 3];\nfunction f(p[,])\n  return p[1, 2];\nbegin\n  m[1, 2] := f(m)\nend.\n",
+	},
+	{
+		"var x;begin write \"x = \\\"\",x:3,\"\\\"\";writeln x,\"\\n\" end.",
+		"var x;\nbegin\n  write \"x = \\\"\", x:3, \"\\\"\";\n  writeln x, \"\\n\"\nend.\n",
+	},
+	{
+		"var i;begin while i>0 do begin i:=i-1;if odd i then continue;if i<3 then break end end.",
+		"var i;\nbegin\n  while i > 0 do\n  begin\n    i := i - 1;\n    if odd i then\n      continue;\n" +
+			"    if i < 3 then\n      break\n  end\nend.\n",
+	},
+	{
+		"procedure swap( var x,var y )var t;begin t:=x;x:=y;y:=t end;.",
+		"procedure swap(var x, var y)\n  var t;\nbegin\n  t := x;\n  x := y;\n  y := t\nend;\n.\n",
+	},
+	{
+		"var i;begin case i of 1,2:write 1;\n{three}\n3:begin i:=0 end else writeln end;case i of 4: end end.",
+		"var i;\nbegin\n  case i of\n    1, 2: write 1;\n    {three}\n    3: begin\n      i := 0\n    end\n" +
+			"  else\n    writeln\n  end;\n  case i of\n    4:\n  end\nend.\n",
+	},
+	{
+		// comments inside expressions stay before the next token
+		"var a;begin a:=a { c } +1;a(*x*):=a*2 // y\n-3;if a{z}>0 then write a+{w}1 end.",
+		"var a;\nbegin\n  a := a { c } + 1;\n  a (*x*) := a * 2 // y\n  - 3;\n" +
+			"  if a {z} > 0 then\n    write a + {w} 1\nend.\n",
+	},
+}
+
+func TestFormat(t *testing.T) {
+	for nth, target := range formatTargets {
+		got, err := Source("test", []byte(target.source))
+		if err != nil {
+			t.Errorf("#%d: Error: %s", nth, err)
+		} else if string(got) != target.want {
+			t.Errorf("#%d: Got:\n%s\nWant:\n%s", nth, got, target.want)
+		} else if again, _ := Source("test", got); string(again) != target.want {
+			t.Errorf("#%d: not idempotent:\n%s", nth, again)
+		}
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	if _, err := Source("test", []byte("begin x := end.")); err == nil {
+		t.Errorf("No error")
+	}
+}
+
+func TestFormatExamples(t *testing.T) {
+	testFormatExamples(t, filepath.Join("..", "..", "examples", "*.pl0"), pl0compiler.DialectKK)
+	testFormatExamples(t, filepath.Join("..", "..", "examples", "wirth", "*.pl0"), pl0compiler.DialectWirth)
+}
+
+func testFormatExamples(t *testing.T, pattern string, dialect pl0compiler.Dialect) {
+	files, err := filepath.Glob(pattern)
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No examples: %v", err)
+	}
+	compile := func(file string, src []byte) ([]pl0core.Instruction, error) {
+		c := pl0compiler.NewCompiler(file)
+		c.Dialect = dialect
+		return c.CompileSource(src)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := SourceDialect(file, src, dialect)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+
+		// formatting is idempotent
+		res2, err := SourceDialect(file, res, dialect)
+		if err != nil {
+			t.Errorf("%s: formatted: %s", file, err)
+			continue
+		}
+		if string(res2) != string(res) {
+			t.Errorf("%s: not idempotent:\nGot:\n%s\nWant:\n%s", file, res2, res)
+		}
+
+		// formatting doesn't change the program
+		want, err := compile(file, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := compile(file, res)
+		if err != nil {
+			t.Errorf("%s: formatted: %s", file, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: instructions differ after formatting", file)
+		}
+	}
+}
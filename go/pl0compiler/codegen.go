@@ -0,0 +1,188 @@
+package pl0compiler
+
+import "kkpl0/pl0core"
+
+// CodeGenerator generates PL/0 VM instructions.
+type CodeGenerator struct {
+	// StaticLinks converts the instructions with the display to the ones
+	// with static links in Instructions.
+	StaticLinks bool
+
+	symbols      *SymbolManager
+	instructions []pl0core.Instruction
+	levels       []int // levels of the code generating the instructions
+	strings      []string
+	stringIndex  map[string]int
+	targets      map[int]bool // indices where JMP and JPC land
+}
+
+// staticCodes are the instructions with static links
+// for the ones with the display.
+var staticCodes = map[byte]byte{
+	pl0core.InstructLOD: pl0core.InstructLODS,
+	pl0core.InstructLDA: pl0core.InstructLDAS,
+	pl0core.InstructSTO: pl0core.InstructSTOS,
+	pl0core.InstructCAL: pl0core.InstructCALS,
+	pl0core.InstructRET: pl0core.InstructRETS,
+	pl0core.InstructRTN: pl0core.InstructRTNS,
+	pl0core.InstructTCL: pl0core.InstructTCLS,
+}
+
+// NewCodeGenerator creates a CodeGenerator instance.
+func NewCodeGenerator(symbols *SymbolManager) *CodeGenerator {
+	return &CodeGenerator{symbols: symbols, targets: make(map[int]bool)}
+}
+
+// Instructions returns the generated instructions followed by the string pool.
+func (g *CodeGenerator) Instructions() []pl0core.Instruction {
+	code := g.instructions
+	if g.StaticLinks {
+		code = g.withStaticLinks()
+	}
+	if len(g.strings) == 0 {
+		return code
+	}
+	instructions := make([]pl0core.Instruction, 0, len(code)+len(g.strings))
+	instructions = append(instructions, code...)
+	return append(instructions, pl0core.StringPool(g.strings)...)
+}
+
+// withStaticLinks returns the instructions with static links. The number
+// of links of an address is the level of the code generating it minus the
+// level of the address, which is the level enclosing the callee of calls.
+func (g *CodeGenerator) withStaticLinks() []pl0core.Instruction {
+	code := make([]pl0core.Instruction, len(g.instructions))
+	for i, inst := range g.instructions {
+		code[i] = inst
+		switch inst := inst.(type) {
+		case *pl0core.AddrInstruction:
+			if static, ok := staticCodes[inst.Code]; ok {
+				links := g.levels[i] - inst.Level
+				if inst.Code == pl0core.InstructRET || inst.Code == pl0core.InstructRTN {
+					links = 0
+				}
+				code[i] = &pl0core.AddrInstruction{Code: static, Address: pl0core.Address{Level: links, Offset: inst.Offset}}
+			}
+		case *pl0core.TailCallInstruction:
+			static := *inst
+			static.Code = pl0core.InstructTCLS
+			static.Address.Level = g.levels[i] - inst.Address.Level
+			static.Level = 0
+			code[i] = &static
+		}
+	}
+	return code
+}
+
+func (g *CodeGenerator) gen(inst pl0core.Instruction) int {
+	g.instructions = append(g.instructions, inst)
+	g.levels = append(g.levels, g.symbols.Level())
+	return len(g.instructions) - 1
+}
+
+// GenValue generates a value instruction and returns its index.
+func (g *CodeGenerator) GenValue(code byte, value int) int {
+	if code == pl0core.InstructJMP || code == pl0core.InstructJPC {
+		g.targets[value] = true
+	}
+	return g.gen(&pl0core.ValueInstruction{Code: code, Value: value})
+}
+
+// GenString generates an instruction which pushes the pool index of a string
+// and returns its index.
+func (g *CodeGenerator) GenString(text string) int {
+	index, ok := g.stringIndex[text]
+	if !ok {
+		if g.stringIndex == nil {
+			g.stringIndex = make(map[string]int)
+		}
+		index = len(g.strings)
+		g.strings = append(g.strings, text)
+		g.stringIndex[text] = index
+	}
+	return g.GenValue(pl0core.InstructLIT, index)
+}
+
+// GenAddr generates an address instruction and returns its index.
+func (g *CodeGenerator) GenAddr(code byte, addr pl0core.Address) int {
+	return g.gen(&pl0core.AddrInstruction{Code: code, Address: addr})
+}
+
+// GenOpr generates an operation instruction and returns its index.
+func (g *CodeGenerator) GenOpr(opType byte) int {
+	return g.gen(&pl0core.OperationInstruction{Code: pl0core.InstructOPR, OpType: opType})
+}
+
+// BackPatch sets the next instruction index to the value instruction at index.
+func (g *CodeGenerator) BackPatch(index int) {
+	g.Patch(index, len(g.instructions))
+}
+
+// BackPatchAll sets the next instruction index to the instructions.
+func (g *CodeGenerator) BackPatchAll(indices []int) {
+	for _, index := range indices {
+		g.BackPatch(index)
+	}
+}
+
+// GenTailCall generates TCL from the function to the callee at addr.
+func (g *CodeGenerator) GenTailCall(addr pl0core.Address, funcSym *Symbol, args int) int {
+	return g.gen(&pl0core.TailCallInstruction{
+		Code:    pl0core.InstructTCL,
+		Address: addr,
+		Level:   g.symbols.Level(),
+		Params:  funcSym.ParamSlots(),
+		Args:    args,
+	})
+}
+
+// Patch sets the value of the value instruction at index.
+func (g *CodeGenerator) Patch(index int, value int) {
+	inst := g.instructions[index].(*pl0core.ValueInstruction)
+	if inst.Code == pl0core.InstructJMP || inst.Code == pl0core.InstructJPC {
+		g.targets[value] = true
+	}
+	inst.Value = value
+}
+
+// FixCallAddr replaces the address of CAL and TCL instructions from old to addr.
+// Calls in nested functions are generated before the address of the caller
+// function is fixed.
+func (g *CodeGenerator) FixCallAddr(old pl0core.Address, addr pl0core.Address) {
+	for i := old.Offset; i < len(g.instructions); i++ {
+		switch inst := g.instructions[i].(type) {
+		case *pl0core.AddrInstruction:
+			if inst.Code == pl0core.InstructCAL && inst.Address == old {
+				inst.Address = addr
+			}
+		case *pl0core.TailCallInstruction:
+			if inst.Address == old {
+				inst.Address = addr
+			}
+		}
+	}
+}
+
+// GenRet generates RET, or RTN for a procedure,
+// unless the last instruction is the same and no jump lands after it.
+func (g *CodeGenerator) GenRet(funcSym *Symbol) int {
+	code := byte(pl0core.InstructRET)
+	if funcSym != nil && funcSym.Kind == SymbolProc {
+		code = pl0core.InstructRTN
+	}
+	last := len(g.instructions) - 1
+	if last < 0 || g.instructions[last].GetCode() != code || g.targets[last+1] {
+		offset := 0
+		if funcSym != nil {
+			offset = funcSym.ParamSlots()
+		}
+		addr := pl0core.Address{Level: g.symbols.Level(), Offset: offset}
+		return g.GenAddr(code, addr)
+	}
+	return last
+}
+
+// NextInstIndex returns the index of the next instruction.
+func (g *CodeGenerator) NextInstIndex() int {
+	return len(g.instructions)
+}
@@ -0,0 +1,1323 @@
+// Package pl0compiler implements the PL/0 compiler,
+// which is ported from pl0c.rb.
+//
+// BNF
+//
+//	<program> ::= <block> '.'
+//	<block> ::= [<var_decl> | <const_decl> | <func_decl> | <proc_decl>]* <statement>
+//	<const_decl> ::= 'const' <ident> '=' <number> [',' <ident> '=' <number>]* ';'
+//	<var_decl> ::= 'var' <var_decl_elem> [',' <var_decl_elem>]* ';'
+//	<var_decl_elem> ::= <ident> ['[' <size> [',' <size>]* ']']
+//	<size> ::= <number> | <ident>
+//	<func_decl> ::= 'function' <ident> '(' [<param> [',' <param>]*] ')' <block> ';'
+//	<proc_decl> ::= 'procedure' <ident> '(' [<param> [',' <param>]*] ')' <block> ';'
+//	<param> ::= ['var'] <ident> | <ident> '[' [',']* ']'
+//	<statement> ::= #empty
+//	              | <ident> ['[' <expr> [',' <expr>]* ']'] ':=' <expr>
+//	              | 'begin' <statement> [';' <statement>]* 'end'
+//	              | 'if' <condition> 'then' <statement> ['else' <statement>]
+//	              | 'while' <condition> 'do' <statement>
+//	              | 'repeat' <statement> 'until' <condition>
+//	              | 'for' <ident> ':=' <expr> ('to' | 'downto') <expr> ['step' <expr>] 'do' <statement>
+//	              | 'break' | 'continue'
+//	              | 'case' <expr> 'of' <case_clause> [';' <case_clause>]* [';'] ['else' <statement>] 'end'
+//	              | ['call'] <ident> '(' [<expr> [',' <expr>]*] ')'
+//	              | 'return' [<expr>]
+//	              | 'writeln' [<write_item> [',' <write_item>]*]
+//	              | 'write' <write_item> [',' <write_item>]*
+//	<case_clause> ::= <expr> [',' <expr>]* ':' <statement>
+//	<write_item> ::= <string> | <expr> [':' <expr>]
+//	<condition> ::= <cond_term> ['or' <cond_term>]*
+//	<cond_term> ::= <cond_factor> ['and' <cond_factor>]*
+//	<cond_factor> ::= 'not' <cond_factor>
+//	                | 'odd' <expr>
+//	                | <expr> <cond_op> <expr>
+//	                | '(' <condition> ')'
+//	<cond_op> ::= '=' | '<>' | '<' | '>' | '<=' | '>='
+//	<expr> ::= ['+' | '-'] <term> [['+' | '-'] <term>]*
+//	<term> ::= <factor> [['*' | '/' | 'mod'] <factor>]*
+//	<factor> ::= <ident>
+//	           | <number>
+//	           | <ident> '[' <expr> [',' <expr>]* ']'
+//	           | <ident> '(' [<expr> [',' <expr>]*] ')'
+//	           | '(' <expr> ')'
+//
+// The grammar above is of kk-PL/0 (DialectKK), which also has the builtin
+// functions abs, min, max and sqr.
+// A <string> is a double-quoted string literal with Go escape sequences.
+// The field width after ':' writes the value without the trailing space.
+// 'break' and 'continue' are of the innermost loop.
+// The labels of 'case' are constant expressions, which must be distinct.
+// A 'var' parameter refers to its argument, which must be a variable or
+// an array element, and arrays are always passed by reference.
+// PL/0' (DialectPL0Prime) has no 'else', 'repeat', 'for', 'break', 'continue', 'case', 'and', 'or',
+// 'not', 'mod', builtin functions, arrays, procedures, 'var' parameters, strings and field widths,
+// and 'write' and 'writeln' take a single <expr> and nothing respectively.
+// Wirth's PL/0 (DialectWirth) has neither functions, 'return', 'write',
+// 'writeln', 'else', 'repeat', 'for', 'break', 'continue', 'case', 'and', 'or', 'not', 'mod' nor arrays,
+// but has the following:
+//
+//	<proc_decl> ::= 'procedure' <ident> ';' <block> ';'
+//	<statement> ::= 'call' <ident>
+//	              | '?' <ident>
+//	              | '!' <expr>
+//	<cond_op> ::= '#'
+package pl0compiler
+
+import (
+	"fmt"
+	"math"
+
+	"kkpl0/ast"
+	"kkpl0/pl0core"
+)
+
+// Info holds the result of symbol resolution.
+type Info struct {
+	Defs map[*ast.Ident]*Symbol // identifiers in declarations
+	Uses map[*ast.Ident]*Symbol // identifiers referring to symbols
+	// Steps are the constant steps of for statements,
+	// which are negative for counting down.
+	Steps map[*ast.ForStmt]int
+	// Universe is the outermost scope, which holds the builtin functions.
+	// The main block scope is its only child.
+	Universe *Scope
+	// BoundsCheck is whether array indices are checked at runtime,
+	// and so array references are sized.
+	BoundsCheck bool
+}
+
+// ObjectOf returns the symbol denoted by the identifier, or nil.
+func (info *Info) ObjectOf(id *ast.Ident) *Symbol {
+	if sym, ok := info.Defs[id]; ok {
+		return sym
+	}
+	return info.Uses[id]
+}
+
+// DefaultMaxLevel is the default of Compiler.MaxLevel,
+// the deepest level supported by PL0VM.
+const DefaultMaxLevel = pl0core.PL0VMMaxLevel - 1
+
+// Compiler generates PL/0 VM instructions from a syntax tree.
+type Compiler struct {
+	// Fold enables constant folding of expressions (default true).
+	Fold bool
+	// TailCalls enables tail calls by TCL for "return f(...)" (default true).
+	TailCalls bool
+	// BoundsCheck enables checks of array indices at runtime by CHK,
+	// for which array references are passed with the sizes of the
+	// first dimensions (default true).
+	// Without Fold, TailCalls, inlining and BoundsCheck, the code is
+	// identical to that of pl0c.rb.
+	BoundsCheck bool
+	// InlineThreshold is the maximum size of functions inlined at calls,
+	// in nodes of the syntax tree (default DefaultInlineThreshold).
+	// Zero disables inlining.
+	InlineThreshold int
+	// MaxLevel is the maximum nesting level of functions, where the
+	// functions declared in the main block are at level 1
+	// (default DefaultMaxLevel).
+	MaxLevel int
+	// StaticLinks compiles calls and variables of enclosing functions with
+	// the static links of frames instead of the display, by the instructions
+	// LODS, LDAS, STOS, CALS, RETS, RTNS and TCLS (default false).
+	StaticLinks bool
+	// Dialect is the dialect of sources given to CompileSource
+	// (default DialectKK).
+	Dialect Dialect
+
+	sourceName string
+	symbols    *SymbolManager
+	generator  *CodeGenerator
+	info       *Info
+	errors     ErrorList
+
+	inlinable    map[*Symbol]*ast.FuncDecl
+	inline       *inlining        // function body being inlined
+	inlineOffset int              // offset of the next variable of inlined functions and for loops
+	frameSize    int              // size of the frame including inlined functions
+	loopVars     map[*Symbol]bool // variables of the enclosing for loops
+	loops        []*loop          // enclosing loops, innermost last
+}
+
+// loop is a loop being compiled, with the jumps of break and continue
+// to be back-patched.
+type loop struct {
+	breaks    []int
+	continues []int
+}
+
+// NewCompiler creates a Compiler instance.
+func NewCompiler(sourceName string) *Compiler {
+	symbols := NewSymbolManager()
+	return &Compiler{
+		Fold:            true,
+		TailCalls:       true,
+		BoundsCheck:     true,
+		InlineThreshold: DefaultInlineThreshold,
+		MaxLevel:        DefaultMaxLevel,
+		sourceName:      sourceName,
+		symbols:         symbols,
+		generator:       NewCodeGenerator(symbols),
+		info: &Info{
+			Defs:     make(map[*ast.Ident]*Symbol),
+			Uses:     make(map[*ast.Ident]*Symbol),
+			Steps:    make(map[*ast.ForStmt]int),
+			Universe: symbols.Universe(),
+		},
+		inlinable: make(map[*Symbol]*ast.FuncDecl),
+		loopVars:  make(map[*Symbol]bool),
+	}
+}
+
+// CompileSource parses and compiles a PL/0 source.
+// On errors, it returns no instructions and ErrorList
+// containing both syntax and semantic errors.
+func CompileSource(sourceName string, src []byte) ([]pl0core.Instruction, error) {
+	return NewCompiler(sourceName).CompileSource(src)
+}
+
+// CompileSource is like the function CompileSource,
+// but uses the settings of the compiler.
+func (c *Compiler) CompileSource(src []byte) ([]pl0core.Instruction, error) {
+	prog, err := ParseDialect(c.sourceName, src, c.Dialect)
+	var errors ErrorList
+	if err != nil {
+		errors = err.(ErrorList)
+	}
+	if err = c.Compile(prog); err != nil {
+		errors = append(errors, err.(ErrorList)...)
+	}
+	if len(errors) > 0 {
+		errors.Sort()
+		return nil, errors
+	}
+	return c.Instructions(), nil
+}
+
+// Compile compiles a program and returns ErrorList if any errors.
+// The program may contain ast.BadExpr and ast.BadStmt of syntax errors,
+// which are skipped.
+// Info is available even if errors occurred.
+func (c *Compiler) Compile(prog *ast.Program) error {
+	c.info.BoundsCheck = c.BoundsCheck
+	if c.StaticLinks {
+		c.symbols.firstVarOffset = FirstStaticVarOffset
+		c.generator.StaticLinks = true
+	}
+	if c.Dialect == DialectKK {
+		for _, builtin := range Builtins {
+			c.symbols.EnterBuiltin(builtin)
+		}
+	}
+	c.symbols.BlockBegin(nil, ast.Pos{Line: 1, Column: 1})
+	c.compileBlock(prog.Block, nil)
+	c.symbols.BlockEnd(prog.End())
+	c.errors.Sort()
+	return c.errors.Err()
+}
+
+// Instructions returns the generated instructions.
+func (c *Compiler) Instructions() []pl0core.Instruction {
+	return c.generator.Instructions()
+}
+
+// Info returns the result of symbol resolution.
+func (c *Compiler) Info() *Info {
+	return c.info
+}
+
+// error reports an error at the node.
+func (c *Compiler) error(node ast.Node, code string, format string, args ...interface{}) *Error {
+	e := &Error{
+		SourceName: c.sourceName,
+		Code:       code,
+		Pos:        node.Pos(),
+		End:        node.End(),
+		Msg:        fmt.Sprintf(format, args...),
+	}
+	c.errors.Add(e)
+	return e
+}
+
+// symbolError reports an error at the identifier
+// with the declaration of its symbol, if it is not builtin.
+func (c *Compiler) symbolError(id *ast.Ident, sym *Symbol, code string, format string, args ...interface{}) {
+	e := c.error(id, code, format, args...)
+	if sym.Kind == SymbolBuiltin {
+		return
+	}
+	e.Related = append(e.Related, Related{
+		Pos: sym.Decl.Pos(),
+		End: sym.Decl.End(),
+		Msg: fmt.Sprintf("%s %s is declared here", sym.Kind, sym.Name),
+	})
+}
+
+// resolve returns the symbol of the identifier,
+// or nil if it is not defined or missing by a syntax error.
+func (c *Compiler) resolve(id *ast.Ident) *Symbol {
+	if c.inline != nil {
+		// resolved when the function was compiled
+		return c.lookup(id)
+	}
+	if id.Name == "" {
+		return nil
+	}
+	sym := c.symbols.Get(id.Name)
+	if sym == nil {
+		e := c.error(id, CodeUndefined, "Undefined symbol: %s", id.Name)
+		if similar := c.similarName(id); similar != "" {
+			e.Suggestion = fmt.Sprintf("did you mean '%s'?", similar)
+			e.Edits = []Edit{{Pos: id.Pos(), End: id.End(), NewText: similar}}
+		}
+		return nil
+	}
+	c.info.Uses[id] = sym
+	return sym
+}
+
+// lookup returns the symbol of the identifier without recording it.
+// In an inlined function, the symbols are remapped.
+func (c *Compiler) lookup(id *ast.Ident) *Symbol {
+	if c.inline != nil {
+		sym := c.info.Uses[id]
+		if remapped, ok := c.inline.symbols[sym]; ok {
+			return remapped
+		}
+		return sym
+	}
+	return c.symbols.Get(id.Name)
+}
+
+// similarName returns the visible symbol name most similar to
+// the undefined identifier, or "" if there is no similar name.
+func (c *Compiler) similarName(id *ast.Ident) string {
+	best, bestDist := "", 3
+	for _, sym := range c.symbols.Scope().Visible(id.Pos()) {
+		if d := editDistance(id.Name, sym.Name); d < bestDist {
+			best, bestDist = sym.Name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func (c *Compiler) define(id *ast.Ident, sym *Symbol) {
+	c.info.Defs[id] = sym
+}
+
+func (c *Compiler) compileBlock(block *ast.Block, funcSym *Symbol) {
+	g := c.generator
+	backpIndex := g.GenValue(pl0core.InstructJMP, 0)
+
+	for _, decl := range block.Decls {
+		switch d := decl.(type) {
+		case *ast.VarDecl:
+			c.compileVarDecl(d)
+		case *ast.ConstDecl:
+			c.compileConstDecl(d)
+		case *ast.FuncDecl:
+			c.compileFuncDecl(d)
+		}
+	}
+
+	g.BackPatch(backpIndex)
+	if funcSym != nil {
+		old := funcSym.Address
+		c.symbols.FixFuncAddr(funcSym, g.NextInstIndex())
+		g.FixCallAddr(old, funcSym.Address)
+	}
+	// the frame is extended for inlined functions
+	inlineOffset, frameSize := c.inlineOffset, c.frameSize
+	c.inlineOffset, c.frameSize = c.symbols.Offset(), c.symbols.Offset()
+	ictIndex := g.GenValue(pl0core.InstructICT, c.symbols.Offset())
+	c.compileStatement(block.Body, funcSym)
+	g.GenRet(funcSym)
+	g.Patch(ictIndex, c.frameSize)
+	c.inlineOffset, c.frameSize = inlineOffset, frameSize
+}
+
+func (c *Compiler) compileConstDecl(decl *ast.ConstDecl) {
+	for _, spec := range decl.Specs {
+		c.define(spec.Name, c.symbols.EnterConst(spec.Name, spec.Value.Value))
+	}
+}
+
+func (c *Compiler) compileVarDecl(decl *ast.VarDecl) {
+	for _, spec := range decl.Specs {
+		if spec.Sizes == nil {
+			c.define(spec.Name, c.symbols.EnterVarScalar(spec.Name))
+			continue
+		}
+		// array variable
+		dims := make([]int, len(spec.Sizes))
+		total := 1
+		for i, x := range spec.Sizes {
+			dims[i] = c.arraySize(spec, x)
+			total *= dims[i]
+			if total > math.MaxInt32 {
+				c.error(spec, CodeArraySize, "size of array '%s' is too large.", spec.Name.Name)
+				dims[i], total = 1, 1
+			}
+		}
+		c.define(spec.Name, c.symbols.EnterArray(spec.Name, dims))
+	}
+}
+
+// arraySize returns the size of a dimension of the array,
+// which is 1 if the size is invalid.
+func (c *Compiler) arraySize(spec *ast.VarSpec, x ast.Expr) int {
+	var size int
+	switch x := x.(type) {
+	case *ast.NumberLit:
+		size = x.Value
+	case *ast.Ident:
+		sym := c.resolve(x)
+		if sym == nil {
+			return 1
+		} else if sym.Kind != SymbolConst {
+			c.symbolError(x, sym, CodeArraySize, "size '%s' of array '%s' is not constant",
+				sym.Name, spec.Name.Name)
+			return 1
+		}
+		size = sym.Value
+	}
+	if size <= 0 {
+		c.error(x, CodeArraySize, "size %d of array '%s' is invalid.",
+			size, spec.Name.Name)
+		return 1
+	}
+	return size
+}
+
+func (c *Compiler) compileFuncDecl(decl *ast.FuncDecl) {
+	var funcSym *Symbol
+	if decl.Proc {
+		funcSym = c.symbols.EnterProc(decl.Name, c.generator.NextInstIndex())
+	} else {
+		funcSym = c.symbols.EnterFunc(decl.Name, c.generator.NextInstIndex())
+	}
+	c.define(decl.Name, funcSym)
+	c.symbols.BlockBegin(funcSym, decl.Name.End())
+	if c.symbols.Level() == c.MaxLevel+1 {
+		// only the outermost function too deep, not the ones nested in it
+		c.error(decl.Name, CodeNestingLevel, "Nesting level %d of %s exceeds the maximum %d.", c.symbols.Level(), decl.Name.Name, c.MaxLevel)
+	}
+	for _, param := range decl.Params {
+		kind, rank := SymbolVarScalar, 0
+		if param.Ref {
+			kind, rank = SymbolVarRef, param.Rank
+			if rank < 1 {
+				rank = 1
+			}
+			if param.Var.IsValid() {
+				c.error(param, CodeArrayUsage, "Array reference %s cannot be a var parameter.", param.Name.Name)
+			}
+		} else if param.Var.IsValid() {
+			kind = SymbolVarParam
+		}
+		c.define(param.Name, c.symbols.EnterFuncParam(funcSym, param.Name, kind, rank, param.Ref && c.BoundsCheck))
+	}
+	c.symbols.FixFuncParamOffsets(funcSym)
+	c.compileBlock(decl.Body, funcSym)
+	c.symbols.BlockEnd(decl.End())
+	c.checkInlinable(decl, funcSym)
+}
+
+// genVarAddr generates the code which pushes the address of an array
+// or a variable. References hold the addresses.
+func (c *Compiler) genVarAddr(sym *Symbol) {
+	if sym.Kind == SymbolVarRef || sym.Kind == SymbolVarParam {
+		c.generator.GenAddr(pl0core.InstructLOD, sym.Address)
+	} else {
+		c.generator.GenAddr(pl0core.InstructLDA, sym.Address)
+	}
+}
+
+// genLoad generates the code which pushes the value of a scalar variable
+// or the variable referred to by a var parameter.
+func (c *Compiler) genLoad(sym *Symbol) {
+	c.generator.GenAddr(pl0core.InstructLOD, sym.Address)
+	if sym.Kind == SymbolVarParam {
+		c.generator.GenOpr(pl0core.OpTypeLID)
+	}
+}
+
+func (c *Compiler) compileStatement(stmt ast.Stmt, funcSym *Symbol) {
+	g := c.generator
+
+	switch s := stmt.(type) {
+	case *ast.EmptyStmt, *ast.BadStmt:
+		// pass through
+	case *ast.AssignStmt:
+		sym := c.resolve(s.Name)
+		if sym == nil {
+			// check the expressions only
+			c.compileExprs(s.Indices)
+			c.compileExpr(s.Value)
+			return
+		}
+		if !sym.IsVariable() {
+			c.symbolError(s.Name, sym, CodeNotAssignable, "Symbol %s is not assignable.", sym.Name)
+			c.compileExpr(s.Value)
+			return
+		}
+		if s.Indices == nil && c.loopVars[sym] {
+			c.symbolError(s.Name, sym, CodeLoopVariable, "Loop variable %s cannot be assigned.", sym.Name)
+		}
+		if s.Indices != nil {
+			c.genElementAddr(s.Name, sym, s.Indices)
+		} else {
+			if sym.IsArrayOrRef() {
+				c.symbolError(s.Name, sym, CodeArrayUsage, "Symbol %s is an array.", sym.Name)
+			}
+			c.genVarAddr(sym)
+		}
+		c.compileExpr(s.Value)
+		g.GenOpr(pl0core.OpTypeSID)
+	case *ast.CompoundStmt:
+		for _, child := range s.List {
+			c.compileStatement(child, funcSym)
+		}
+	case *ast.IfStmt:
+		jumps := c.compileCondition(s.Cond)
+		c.compileStatement(s.Then, funcSym)
+		if s.Else == nil {
+			g.BackPatchAll(jumps)
+		} else {
+			jmpIndex := g.GenValue(pl0core.InstructJMP, 0)
+			g.BackPatchAll(jumps)
+			c.compileStatement(s.Else, funcSym)
+			g.BackPatch(jmpIndex)
+		}
+	case *ast.WhileStmt:
+		condIndex := g.NextInstIndex()
+		jumps := c.compileCondition(s.Cond)
+		l := c.compileLoopBody(s.Body, funcSym)
+		for _, index := range l.continues {
+			g.Patch(index, condIndex)
+		}
+		g.GenValue(pl0core.InstructJMP, condIndex)
+		g.BackPatchAll(jumps)
+		g.BackPatchAll(l.breaks)
+	case *ast.RepeatStmt:
+		stmtIndex := g.NextInstIndex()
+		l := c.compileLoopBody(s.Body, funcSym)
+		g.BackPatchAll(l.continues)
+		for _, index := range c.compileCondition(s.Cond) {
+			g.Patch(index, stmtIndex)
+		}
+		g.BackPatchAll(l.breaks)
+	case *ast.ForStmt:
+		c.compileFor(s, funcSym)
+	case *ast.CaseStmt:
+		c.compileCase(s, funcSym)
+	case *ast.BranchStmt:
+		if len(c.loops) == 0 {
+			name := "break"
+			if s.Continue {
+				name = "continue"
+			}
+			c.error(s, CodeBranchUsage, "Statement %s is not in a loop.", name)
+			break
+		}
+		l := c.loops[len(c.loops)-1]
+		index := g.GenValue(pl0core.InstructJMP, 0)
+		if s.Continue {
+			l.continues = append(l.continues, index)
+		} else {
+			l.breaks = append(l.breaks, index)
+		}
+	case *ast.ReturnStmt:
+		if funcSym != nil && funcSym.Kind == SymbolProc {
+			if s.Result != nil {
+				c.error(s.Result, CodeReturnValue, "Procedure %s cannot return a value.", funcSym.Name)
+			}
+			g.GenRet(funcSym)
+			break
+		}
+		if s.Result == nil {
+			c.error(s, CodeReturnValue, "Return value required.")
+			break
+		}
+		if c.inline != nil {
+			c.compileInlineReturn(s)
+			break
+		}
+		if call, ok := s.Result.(*ast.CallExpr); ok && c.TailCalls && c.compileTailCall(call, funcSym) {
+			break
+		}
+		c.compileExpr(s.Result)
+		g.GenRet(funcSym)
+	case *ast.WriteStmt:
+		c.compileWriteItems(s.Items)
+	case *ast.WritelnStmt:
+		c.compileWriteItems(s.Items)
+		g.GenOpr(pl0core.OpTypeWRL)
+	case *ast.CallStmt:
+		c.compileProcCall(s.X)
+	case *ast.InputStmt:
+		sym := c.resolve(s.Name)
+		if sym == nil {
+			return
+		}
+		if !sym.IsScalar() {
+			c.symbolError(s.Name, sym, CodeNotAssignable, "Symbol %s is not assignable.", sym.Name)
+			return
+		}
+		c.genVarAddr(sym)
+		g.GenOpr(pl0core.OpTypeRED)
+		g.GenOpr(pl0core.OpTypeSID)
+	case *ast.OutputStmt:
+		c.compileExpr(s.X)
+		g.GenOpr(pl0core.OpTypeWRT)
+		g.GenOpr(pl0core.OpTypeWRL)
+	default:
+		c.error(stmt, CodeSyntax, "Unexpected statement: %T", stmt)
+	}
+}
+
+// compileFor compiles the for statement into a while loop:
+//
+//	i := From; t := To;
+//	while i <= t do begin Body; i := i + Step end
+//
+// where t is a variable of the frame hidden from the source, or To itself
+// if it is constant. The condition is i >= t if the step is negative.
+// The step of 'downto' must be positive, and is negated.
+func (c *Compiler) compileFor(s *ast.ForStmt, funcSym *Symbol) {
+	g := c.generator
+	step := 1
+	if s.Step != nil {
+		if v, ok := numberValue(c.foldExpr(s.Step)); ok && v != 0 {
+			step = v
+		} else {
+			c.resolveAll(s.Step)
+			c.error(s.Step, CodeLoopStep, "Step of for must be a nonzero constant.")
+		}
+	}
+	if s.Down {
+		if step < 0 {
+			c.error(s.Step, CodeLoopStep, "Step of downto must be positive.")
+		}
+		step = -step
+	}
+
+	sym := c.resolve(s.Var)
+	if sym != nil && !sym.IsScalar() {
+		c.symbolError(s.Var, sym, CodeLoopVariable, "Loop variable %s must be a scalar variable.", sym.Name)
+		sym = nil
+	} else if sym != nil && c.loopVars[sym] {
+		c.symbolError(s.Var, sym, CodeLoopVariable, "Loop variable %s cannot be assigned.", sym.Name)
+	}
+	if sym == nil {
+		// check the expressions and the body only
+		c.compileExpr(s.From)
+		c.compileExpr(s.To)
+		c.compileLoopBody(s.Body, funcSym)
+		return
+	}
+
+	c.info.Steps[s] = step
+
+	// the bound is evaluated once, after From
+	base := c.inlineOffset
+	c.genVarAddr(sym)
+	c.compileExpr(s.From)
+	bound := s.To
+	if c.Fold {
+		bound = c.foldExpr(bound)
+	}
+	limit, constant := numberValue(bound)
+	var boundAddr pl0core.Address
+	if !constant {
+		boundAddr = c.allocTemp()
+		g.GenAddr(pl0core.InstructLDA, boundAddr)
+		c.genExpr(bound)
+		g.GenOpr(pl0core.OpTypeSID)
+	}
+	g.GenOpr(pl0core.OpTypeSID)
+
+	condIndex := g.NextInstIndex()
+	c.genLoad(sym)
+	if constant {
+		g.GenValue(pl0core.InstructLIT, limit)
+	} else {
+		g.GenAddr(pl0core.InstructLOD, boundAddr)
+	}
+	if step > 0 {
+		g.GenOpr(pl0core.OpTypeLSEQ)
+	} else {
+		g.GenOpr(pl0core.OpTypeGREQ)
+	}
+	jpcIndex := g.GenValue(pl0core.InstructJPC, 0)
+
+	c.loopVars[sym] = true
+	l := c.compileLoopBody(s.Body, funcSym)
+	delete(c.loopVars, sym)
+
+	g.BackPatchAll(l.continues)
+	c.genVarAddr(sym)
+	c.genLoad(sym)
+	g.GenValue(pl0core.InstructLIT, step)
+	g.GenOpr(pl0core.OpTypeADD)
+	g.GenOpr(pl0core.OpTypeSID)
+	g.GenValue(pl0core.InstructJMP, condIndex)
+	g.BackPatch(jpcIndex)
+	g.BackPatchAll(l.breaks)
+	c.inlineOffset = base
+}
+
+// minJumpTable is the minimum number of labels of a case statement
+// compiled into a jump table.
+const minJumpTable = 4
+
+// compileCase compiles the case statement. If the labels are dense, that is,
+// the range of the labels is at most twice the number of them, X indexes
+// a jump table:
+//
+//	X; LIT min; SUB; JTB n; JMP ...; Else; JMP end; Clauses
+//
+// where the n entries of the table jump to the clauses, or to Else if no
+// clause has the label, and each clause but the last is followed by
+// JMP end. Otherwise X is saved in a variable t of the frame hidden from
+// the source, and compared with the labels in order:
+//
+//	LOD t; LIT label; EQ; JPC next; Body; JMP end; next: ...
+func (c *Compiler) compileCase(s *ast.CaseStmt, funcSym *Symbol) {
+	g := c.generator
+	labels := c.caseLabels(s)
+	min, max := math.MaxInt32, math.MinInt32
+	count := 0
+	for _, values := range labels {
+		for _, v := range values {
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+			count++
+		}
+	}
+
+	var ends []int
+	if count >= minJumpTable && max-min < 2*count && max-min < math.MaxInt16 {
+		span := max - min + 1
+		c.compileExpr(s.X)
+		if min != 0 {
+			g.GenValue(pl0core.InstructLIT, min)
+			g.GenOpr(pl0core.OpTypeSUB)
+		}
+		g.GenValue(pl0core.InstructJTB, span)
+		table := g.NextInstIndex()
+		for i := 0; i < span; i++ {
+			g.GenValue(pl0core.InstructJMP, table+span)
+		}
+		if s.Else != nil {
+			c.compileStatement(s.Else, funcSym)
+		}
+		ends = append(ends, g.GenValue(pl0core.InstructJMP, 0))
+		for i, clause := range s.Clauses {
+			for _, v := range labels[i] {
+				g.Patch(table+v-min, g.NextInstIndex())
+			}
+			c.compileStatement(clause.Body, funcSym)
+			if i < len(s.Clauses)-1 {
+				ends = append(ends, g.GenValue(pl0core.InstructJMP, 0))
+			}
+		}
+		g.BackPatchAll(ends)
+		return
+	}
+
+	base := c.inlineOffset
+	t := c.allocTemp()
+	g.GenAddr(pl0core.InstructLDA, t)
+	c.compileExpr(s.X)
+	g.GenOpr(pl0core.OpTypeSID)
+	for i, clause := range s.Clauses {
+		values := labels[i]
+		var matches []int
+		next := -1
+		for j, v := range values {
+			g.GenAddr(pl0core.InstructLOD, t)
+			g.GenValue(pl0core.InstructLIT, v)
+			if j < len(values)-1 {
+				g.GenOpr(pl0core.OpTypeNEQ)
+				matches = append(matches, g.GenValue(pl0core.InstructJPC, 0))
+			} else {
+				g.GenOpr(pl0core.OpTypeEQ)
+				next = g.GenValue(pl0core.InstructJPC, 0)
+			}
+		}
+		g.BackPatchAll(matches)
+		c.compileStatement(clause.Body, funcSym)
+		if i < len(s.Clauses)-1 || s.Else != nil {
+			ends = append(ends, g.GenValue(pl0core.InstructJMP, 0))
+		}
+		if next >= 0 {
+			g.BackPatch(next)
+		}
+	}
+	if s.Else != nil {
+		c.compileStatement(s.Else, funcSym)
+	}
+	g.BackPatchAll(ends)
+	c.inlineOffset = base
+}
+
+// caseLabels returns the values of the labels of each clause,
+// and reports labels which are not constants or duplicate.
+func (c *Compiler) caseLabels(s *ast.CaseStmt) [][]int {
+	labels := make([][]int, len(s.Clauses))
+	seen := make(map[int]bool)
+	for i, clause := range s.Clauses {
+		for _, label := range clause.Labels {
+			v, ok := numberValue(c.foldExpr(label))
+			if !ok {
+				c.resolveAll(label)
+				c.error(label, CodeCaseLabel, "Label of case must be a constant.")
+				continue
+			}
+			if seen[v] {
+				c.error(label, CodeCaseLabel, "Duplicate label %d of case.", v)
+				continue
+			}
+			seen[v] = true
+			labels[i] = append(labels[i], v)
+		}
+	}
+	return labels
+}
+
+// compileLoopBody compiles the body of a loop, and returns the loop
+// with the jumps of break and continue in the body.
+func (c *Compiler) compileLoopBody(body ast.Stmt, funcSym *Symbol) *loop {
+	l := &loop{}
+	c.loops = append(c.loops, l)
+	c.compileStatement(body, funcSym)
+	c.loops = c.loops[:len(c.loops)-1]
+	return l
+}
+
+// allocTemp allocates a variable of the frame hidden from the source,
+// which is freed by restoring c.inlineOffset.
+func (c *Compiler) allocTemp() pl0core.Address {
+	addr := pl0core.Address{Level: c.symbols.Level(), Offset: c.inlineOffset}
+	c.inlineOffset++
+	if c.inlineOffset > c.frameSize {
+		c.frameSize = c.inlineOffset
+	}
+	return addr
+}
+
+var operationTypes = map[ast.Operator]byte{
+	ast.OpAdd:  pl0core.OpTypeADD,
+	ast.OpSub:  pl0core.OpTypeSUB,
+	ast.OpMul:  pl0core.OpTypeMUL,
+	ast.OpDiv:  pl0core.OpTypeDIV,
+	ast.OpMod:  pl0core.OpTypeMOD,
+	ast.OpOdd:  pl0core.OpTypeODD,
+	ast.OpEq:   pl0core.OpTypeEQ,
+	ast.OpNeq:  pl0core.OpTypeNEQ,
+	ast.OpLs:   pl0core.OpTypeLS,
+	ast.OpGr:   pl0core.OpTypeGR,
+	ast.OpLsEq: pl0core.OpTypeLSEQ,
+	ast.OpGrEq: pl0core.OpTypeGREQ,
+}
+
+// compileCondition generates the code which jumps if the condition is
+// false, and returns the indices of the jumps to be patched.
+func (c *Compiler) compileCondition(cond ast.Expr) []int {
+	return c.compileJump(cond, false)
+}
+
+// compileJump generates the code which jumps if the condition is jumpIf,
+// and returns the indices of the jumps. 'and' and 'or' are short-circuit:
+// the right operand is skipped if the left one decides the condition.
+func (c *Compiler) compileJump(cond ast.Expr, jumpIf bool) []int {
+	g := c.generator
+	switch x := cond.(type) {
+	case *ast.ParenExpr:
+		return c.compileJump(x.X, jumpIf)
+	case *ast.UnaryExpr:
+		if x.Op == ast.OpNot {
+			return c.compileJump(x.X, !jumpIf)
+		}
+	case *ast.BinaryExpr:
+		if x.Op == ast.OpAnd || x.Op == ast.OpOr {
+			// the left operand decides 'and' if false, and 'or' if true
+			decisive := x.Op == ast.OpOr
+			if jumpIf == decisive {
+				return append(c.compileJump(x.X, jumpIf), c.compileJump(x.Y, jumpIf)...)
+			}
+			skips := c.compileJump(x.X, decisive)
+			jumps := c.compileJump(x.Y, jumpIf)
+			g.BackPatchAll(skips)
+			return jumps
+		}
+	}
+	c.compileTest(cond)
+	if jumpIf {
+		g.GenOpr(pl0core.OpTypeNOT)
+	}
+	return []int{g.GenValue(pl0core.InstructJPC, 0)}
+}
+
+// compileTest generates a relational condition or odd,
+// which leaves 1 if true or 0 if false.
+func (c *Compiler) compileTest(cond ast.Expr) {
+	if c.Fold {
+		cond = c.foldExpr(cond)
+	}
+	switch x := cond.(type) {
+	case *ast.BinaryExpr:
+		if x.Op.IsRelational() {
+			c.genExpr(x.X)
+			c.genExpr(x.Y)
+			c.generator.GenOpr(operationTypes[x.Op])
+			return
+		}
+	case *ast.UnaryExpr:
+		if x.Op == ast.OpOdd {
+			c.genExpr(x.X)
+			c.generator.GenOpr(pl0core.OpTypeODD)
+			return
+		}
+	}
+	c.genExpr(cond)
+}
+
+func (c *Compiler) compileExpr(expr ast.Expr) {
+	if c.Fold {
+		expr = c.foldExpr(expr)
+	}
+	c.genExpr(expr)
+}
+
+func (c *Compiler) genExpr(expr ast.Expr) {
+	g := c.generator
+
+	switch x := expr.(type) {
+	case *ast.BadExpr:
+		// pass through
+	case *ast.NumberLit:
+		g.GenValue(pl0core.InstructLIT, x.Value)
+	case *ast.Ident:
+		c.compileIdent(x, false)
+	case *ast.ParenExpr:
+		c.genExpr(x.X)
+	case *ast.UnaryExpr:
+		if x.Op == ast.OpOdd || x.Op == ast.OpNot {
+			c.error(x, CodeConditionUsage, "Condition is not allowed in an expression.")
+			return
+		}
+		c.genExpr(x.X)
+		if x.Op == ast.OpSub {
+			g.GenOpr(pl0core.OpTypeNEG)
+		}
+	case *ast.BinaryExpr:
+		if x.Op.IsRelational() || x.Op.IsLogical() {
+			c.error(x, CodeConditionUsage, "Condition is not allowed in an expression.")
+			return
+		}
+		c.genExpr(x.X)
+		c.genExpr(x.Y)
+		g.GenOpr(operationTypes[x.Op])
+	case *ast.IndexExpr:
+		sym := c.resolve(x.Name)
+		if sym == nil {
+			for _, index := range x.Indices {
+				c.genExpr(index)
+			}
+			return
+		}
+		// array element
+		c.genElementAddr(x.Name, sym, x.Indices)
+		g.GenOpr(pl0core.OpTypeLID)
+	case *ast.CallExpr:
+		c.compileFuncCall(x)
+	default:
+		c.error(expr, CodeSyntax, "Unexpected expression: %T", expr)
+	}
+}
+
+func (c *Compiler) compileIdent(id *ast.Ident, allowRef bool) {
+	g := c.generator
+
+	sym := c.resolve(id)
+	if sym == nil {
+		return
+	}
+	switch sym.Kind {
+	case SymbolVarScalar, SymbolVarParam:
+		c.genLoad(sym)
+	case SymbolConst:
+		g.GenValue(pl0core.InstructLIT, sym.Value)
+	case SymbolFunc, SymbolBuiltin:
+		c.symbolError(id, sym, CodeFuncUsage, "Function %s requires '('.", sym.Name)
+	case SymbolProc:
+		c.symbolError(id, sym, CodeProcUsage, "Procedure %s has no value.", sym.Name)
+	case SymbolVarArray, SymbolVarRef:
+		// array reference
+		if !allowRef {
+			c.symbolError(id, sym, CodeArrayUsage, "Reference of array %s is not allowed here.", sym.Name)
+		}
+		c.genVarAddr(sym)
+	}
+}
+
+// genElementAddr generates the code which pushes the address of the
+// element of the array at the indices. The elements are in row-major order:
+//
+//	a[i, j, k]  =>  a + (i * dim1 + j) * dim2 + k
+//
+// The sizes of the dimensions of an array reference are parameters.
+// With BoundsCheck, each index is followed by its check
+//
+//	i; dim0; OPR CHK
+//
+// unless it is a constant in the range of the array.
+func (c *Compiler) genElementAddr(name *ast.Ident, sym *Symbol, indices []ast.Expr) {
+	g := c.generator
+	valid := sym.IsArrayOrRef() && len(indices) == sym.Rank
+	gen := func(d int) {
+		index := indices[d]
+		if c.Fold {
+			index = c.foldExpr(index)
+		}
+		c.genExpr(index)
+		if c.BoundsCheck && valid && !inBounds(sym, d, index) {
+			c.genDimSize(sym, d)
+			g.GenOpr(pl0core.OpTypeCHK)
+		}
+	}
+
+	if !sym.IsArrayOrRef() {
+		c.symbolError(name, sym, CodeArrayUsage, "Symbol %s is not an array.", sym.Name)
+	} else if len(indices) != sym.Rank {
+		c.symbolError(name, sym, CodeArrayUsage, "Array %s has %d dimension(s), but %d indices are given.",
+			sym.Name, sym.Rank, len(indices))
+	} else {
+		c.genVarAddr(sym)
+		gen(0)
+		for d := 1; d < len(indices); d++ {
+			c.genDimSize(sym, d)
+			g.GenOpr(pl0core.OpTypeMUL)
+			gen(d)
+			g.GenOpr(pl0core.OpTypeADD)
+		}
+		g.GenOpr(pl0core.OpTypeADD)
+		return
+	}
+	// check the indices only
+	for d := range indices {
+		gen(d)
+	}
+}
+
+// inBounds reports whether the folded index of the dimension d
+// is a constant in the range of the array.
+func inBounds(sym *Symbol, d int, index ast.Expr) bool {
+	v, ok := numberValue(index)
+	return ok && sym.Kind == SymbolVarArray && 0 <= v && v < sym.Dims[d]
+}
+
+// genDimSize generates the code which pushes the size of the dimension d
+// of the array, which follows the address of an array reference
+// in the order of SizeDims.
+func (c *Compiler) genDimSize(sym *Symbol, d int) {
+	if sym.Kind == SymbolVarRef {
+		addr := sym.Address
+		if d == 0 {
+			addr.Offset += sym.Rank
+		} else {
+			addr.Offset += d
+		}
+		c.generator.GenAddr(pl0core.InstructLOD, addr)
+	} else {
+		c.generator.GenValue(pl0core.InstructLIT, sym.Dims[d])
+	}
+}
+
+// compileExprs compiles the expressions in order.
+func (c *Compiler) compileExprs(list []ast.Expr) {
+	for _, x := range list {
+		c.compileExpr(x)
+	}
+}
+
+// compileTailCall compiles the call in "return f(...)" of the function
+// as a tail call, which reuses the frame of the function.
+// It reports false without generating code if the call is not a tail call:
+// the callee is nested in the function, and so needs its frame,
+// or an array or a variable of the function is passed by reference.
+func (c *Compiler) compileTailCall(call *ast.CallExpr, funcSym *Symbol) bool {
+	level := c.symbols.Level()
+	callee := c.lookup(call.Func)
+	if funcSym == nil || callee == nil || callee.Kind != SymbolFunc || c.inlinable[callee] != nil ||
+		callee.Address.Level+1 > level || len(call.Args) != len(callee.Params) || c.argsMismatch(call, callee) {
+		return false
+	}
+	for i, arg := range call.Args {
+		byRef := callee.Params[i].TakesAddress()
+		var sym *Symbol
+		switch x := arg.(type) {
+		case *ast.Ident:
+			sym = c.lookup(x)
+		case *ast.IndexExpr:
+			if byRef {
+				sym = c.lookup(x.Name)
+			}
+		}
+		if sym != nil && sym.Address.Level == level &&
+			(sym.Kind == SymbolVarArray || byRef && sym.Kind == SymbolVarScalar) {
+			return false
+		}
+	}
+
+	if c.Fold {
+		call = c.foldExpr(call).(*ast.CallExpr)
+	}
+	c.resolve(call.Func)
+	c.genArgs(call, callee)
+	c.generator.GenTailCall(callee.Address, funcSym, callee.ParamSlots())
+	return true
+}
+
+// genArgs generates the arguments of the call of the callee, which may be nil.
+// Arrays are passed by reference, followed by the sizes of the dimensions
+// in the SizeDims of the parameter. Arguments for var parameters are
+// passed by their addresses, and so are the variables for sized array
+// references, as arrays of one element.
+func (c *Compiler) genArgs(call *ast.CallExpr, callee *Symbol) {
+	for i, arg := range call.Args {
+		var param *Symbol
+		if callee != nil && i < len(callee.Params) {
+			param = callee.Params[i]
+		}
+		if param != nil && param.Kind == SymbolVarParam {
+			c.genVarArg(arg, param)
+			continue
+		}
+		id, ok := arg.(*ast.Ident)
+		var sym *Symbol
+		if ok {
+			sym = c.lookup(id)
+		}
+		if param != nil && param.TakesAddress() && (sym == nil || !sym.IsArrayOrRef()) {
+			c.genElementArg(arg, param)
+			continue
+		}
+		if ok {
+			c.compileIdent(id, true)
+		} else {
+			c.genExpr(arg)
+		}
+		if param == nil || param.Kind != SymbolVarRef {
+			continue
+		}
+		if param.Rank == 1 && (sym == nil || !sym.IsArrayOrRef() || sym.Rank == 1) {
+			// passed as before multi-dimensional arrays
+			if param.Sized {
+				c.genDimSize(sym, 0)
+			}
+			continue
+		}
+		if sym == nil || !sym.IsArrayOrRef() || sym.Rank != param.Rank {
+			if !ok || sym != nil {
+				c.error(arg, CodeArrayUsage, "Argument for %s must be an array of %d dimension(s).",
+					param.Name, param.Rank)
+			}
+			continue
+		}
+		for _, d := range param.SizeDims() {
+			c.genDimSize(sym, d)
+		}
+	}
+}
+
+// genVarArg generates the address of the argument for the var parameter,
+// which must be a scalar variable or an array element.
+func (c *Compiler) genVarArg(arg ast.Expr, param *Symbol) {
+	switch x := arg.(type) {
+	case *ast.Ident:
+		sym := c.resolve(x)
+		if sym == nil {
+			return
+		}
+		if sym.IsScalar() {
+			if c.loopVars[sym] {
+				c.symbolError(x, sym, CodeLoopVariable, "Loop variable %s cannot be assigned.", sym.Name)
+			}
+			c.genVarAddr(sym)
+			return
+		}
+	case *ast.IndexExpr:
+		if sym := c.resolve(x.Name); sym != nil {
+			c.genElementAddr(x.Name, sym, x.Indices)
+		} else {
+			c.compileExprs(x.Indices)
+		}
+		return
+	default:
+		c.compileExpr(arg)
+	}
+	c.error(arg, CodeNotAssignable, "Argument for var parameter %s must be a variable.", param.Name)
+}
+
+// genElementArg generates the address of the argument for the sized array
+// reference, which is not an array, followed by the size 1. The argument
+// must be a scalar variable or an array element, whose size is unknown
+// otherwise, and so the bounds of the reference could not be checked.
+func (c *Compiler) genElementArg(arg ast.Expr, param *Symbol) {
+	if c.isVarArg(arg) {
+		c.genVarArg(arg, param)
+	} else {
+		c.compileExpr(arg)
+		if id, ok := arg.(*ast.Ident); !ok || c.lookup(id) != nil {
+			c.error(arg, CodeArrayUsage, "Argument for %s must be an array or a variable.", param.Name)
+		}
+	}
+	c.generator.GenValue(pl0core.InstructLIT, 1)
+}
+
+// isVarArg reports whether the argument can be passed to a var parameter.
+func (c *Compiler) isVarArg(arg ast.Expr) bool {
+	switch x := arg.(type) {
+	case *ast.Ident:
+		sym := c.lookup(x)
+		return sym != nil && sym.IsScalar()
+	case *ast.IndexExpr:
+		sym := c.lookup(x.Name)
+		return sym != nil && sym.IsArrayOrRef() && len(x.Indices) == sym.Rank
+	}
+	return false
+}
+
+// argsMismatch reports whether an array argument of the call does not
+// match the multi-dimensional array reference parameter, or the reverse.
+func (c *Compiler) argsMismatch(call *ast.CallExpr, callee *Symbol) bool {
+	for i, arg := range call.Args {
+		rank := 0
+		if id, ok := arg.(*ast.Ident); ok {
+			if sym := c.lookup(id); sym != nil && sym.IsArrayOrRef() {
+				rank = sym.Rank
+			}
+		}
+		param := callee.Params[i]
+		if (rank > 1 || param.Rank > 1) && (param.Kind != SymbolVarRef || rank != param.Rank) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compiler) compileFuncCall(call *ast.CallExpr) {
+	funcSym := c.resolve(call.Func)
+	if funcSym != nil && funcSym.Kind == SymbolBuiltin {
+		c.compileBuiltinCall(call, funcSym.Builtin)
+		return
+	}
+	if funcSym != nil && funcSym.Kind == SymbolProc {
+		c.symbolError(call.Func, funcSym, CodeProcUsage, "Procedure %s has no value.", funcSym.Name)
+		funcSym = nil
+	} else if funcSym != nil && funcSym.Kind != SymbolFunc {
+		c.symbolError(call.Func, funcSym, CodeFuncUsage, "Symbol %s is not a function.", funcSym.Name)
+		funcSym = nil
+	}
+	if funcSym != nil && c.canInline(call, funcSym) {
+		c.compileInlineCall(call, funcSym)
+		return
+	}
+	c.genArgs(call, funcSym)
+	if funcSym == nil {
+		return
+	}
+	if len(call.Args) != len(funcSym.Params) {
+		c.symbolError(call.Func, funcSym, CodeArgumentCount, "%s: number of parameters mismatch.", funcSym.Name)
+	}
+	c.generator.GenAddr(pl0core.InstructCAL, funcSym.Address)
+}
+
+// compileBuiltinCall compiles the call of a builtin function
+// to its operation on the arguments.
+func (c *Compiler) compileBuiltinCall(call *ast.CallExpr, builtin *Builtin) {
+	for _, arg := range call.Args {
+		c.genExpr(arg)
+	}
+	if len(call.Args) != builtin.Params {
+		c.error(call.Func, CodeArgumentCount, "%s: number of parameters mismatch.", builtin.Name)
+		return
+	}
+	c.generator.GenOpr(builtin.OpType)
+}
+
+func (c *Compiler) compileWriteItems(items []ast.Expr) {
+	g := c.generator
+
+	for _, item := range items {
+		switch x := item.(type) {
+		case *ast.StringLit:
+			g.GenString(x.Value)
+			g.GenOpr(pl0core.OpTypeWRS)
+		case *ast.WidthExpr:
+			c.compileExpr(x.X)
+			c.compileExpr(x.Width)
+			g.GenOpr(pl0core.OpTypeWRF)
+		default:
+			c.compileExpr(x)
+			g.GenOpr(pl0core.OpTypeWRT)
+		}
+	}
+}
+
+// compileProcCall compiles the call of a procedure, which leaves no value.
+func (c *Compiler) compileProcCall(call *ast.CallExpr) {
+	procSym := c.resolve(call.Func)
+	if procSym != nil && procSym.Kind != SymbolProc {
+		c.symbolError(call.Func, procSym, CodeProcUsage, "Symbol %s is not a procedure.", procSym.Name)
+		procSym = nil
+	}
+	c.genArgs(call, procSym)
+	if procSym == nil {
+		return
+	}
+	if len(call.Args) != len(procSym.Params) {
+		c.symbolError(call.Func, procSym, CodeArgumentCount, "%s: number of parameters mismatch.", procSym.Name)
+	}
+	c.generator.GenAddr(pl0core.InstructCAL, procSym.Address)
+}
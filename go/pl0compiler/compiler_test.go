@@ -0,0 +1,1520 @@
+package pl0compiler
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"kkpl0/ast"
+	"kkpl0/pl0core"
+)
+
+func compileAndRun(source string) (string, error) {
+	instructions, err := CompileSource("test", []byte(source))
+	if err != nil {
+		return "", err
+	}
+	outBuf := bytes.NewBufferString("")
+	vm := pl0core.NewPL0VM()
+	vm.Output = outBuf
+	err = vm.Run(instructions)
+	return outBuf.String(), err
+}
+
+// Porting from test-pl0c.rb
+var compileTargets = []struct {
+	source string
+	want   string
+}{
+	{source: `.`, want: ""},
+	{source: `begin end.`, want: ""},
+	{source: `begin begin begin end end end.`, want: ""},
+	{source: `begin writeln; writeln
+	end.`, want: "\n\n"},
+	{source: `begin write 128; writeln end.`, want: "128 \n"},
+	{source: `const a = 1, b = 2; begin write a; write b end.`, want: "1 2 "},
+	{source: `const a = 32; var b; begin b := 64; write a; write b end.`, want: "32 64 "},
+	{source: `var a; function f() write 22; begin a := f() end.`, want: "22 "},
+	{source: `begin write -10 + 5 - 3 end.`, want: "-8 "},
+	{source: `begin if odd 2 then write 1 else write 2 end.`, want: "2 "},
+	{source: `var i; begin i:=0; repeat begin write i; i:=i+1 end until i > 3 end.`,
+		want: "0 1 2 3 "},
+	{
+		// nested function calls the enclosing function
+		source: `
+		  var n;
+		  function f(x)
+			function g(y)
+			begin
+			  if y > 0 then return f(y - 1);
+			  return 0
+			end;
+		  begin
+			write x;
+			return g(x)
+		  end;
+		  begin n := f(3) end.
+		`,
+		want: "3 2 1 0 ",
+	},
+	{
+		// array reference parameters through nested functions
+		source: `
+		  var void;
+		  function fill(ap[], len)
+			function set(i, v)
+			begin
+			  ap[i] := v;
+			  return 0
+			end;
+			var i;
+		  begin
+			i := 0;
+			while i < len do
+			begin
+			  void := set(i, i * i);
+			  i := i + 1
+			end
+		  end;
+		  const size = 4;
+		  var a[size], i;
+		  begin
+			void := fill(a, size);
+			i := 0;
+			while i < size do
+			begin
+			  write a[i];
+			  i := i + 1
+			end
+		  end.
+		`,
+		want: "0 1 4 9 ",
+	},
+	{
+		// comments inside expressions and across lines
+		source: `
+		  { multiplies { nested } by two }
+		  function f(x) return x (* argument *) * 2; // no begin
+		  var a;
+		  begin
+			a := 1 + {one} 2 (* two;
+			  (* nested *) still comment *) - 3;
+			write f( // across
+			  a // lines
+			) // end of the call
+		  end.`,
+		want: "0 ",
+	},
+	{
+		// procedures leave no values on the stack
+		source: `
+		  var n;
+		  procedure count(x)
+		  begin
+			if x < 0 then return;
+			n := n + x;
+			count(x - 1)
+		  end;
+		  procedure swap(a[], i, j)
+			var t;
+		  begin t := a[i]; a[i] := a[j]; a[j] := t end;
+		  var a[2], i;
+		  begin
+			n := 0;
+			i := 0;
+			while i < 1000 do begin call count(3); i := i + 1 end;
+			write n;
+			a[0] := 1; a[1] := 2;
+			swap(a, 0, 1);
+			write a[0] * 10 + a[1]
+		  end.
+		`,
+		want: "6000 21 ",
+	},
+	// a jump to the end of a procedure ending in return reaches the last RTN
+	{
+		source: `var v; procedure p(x) begin if x > 0 then begin write 5; return end end; begin p(0); writeln 7 end.`,
+		want:   "7 \n",
+	},
+	{
+		source: `
+		  procedure q2(x) begin case x of 1: write 1; 2: write 2; 3: write 3; 4: return end end;
+		  begin q2(5); q2(2); q2(4); writeln 9 end.
+		`,
+		want: "2 9 \n",
+	},
+	{
+		// for loops
+		source: `
+		  const two = 2;
+		  var i, j, n, calls;
+		  function bound() begin calls := calls + 1; return n end;
+		  procedure count(k)
+		  begin
+			for k := k downto 1 step two do write k;
+			writeln
+		  end;
+		  begin
+			for i := 1 to 3 do write i; writeln;
+			for i := 3 downto 1 do write i; writeln;
+			for i := 0 to 10 step 3 do write i; writeln;
+			for i := 10 to 0 step -4 do write i; writeln;
+			for i := 1 to 0 do write i;
+			for i := 0 downto 1 do write i;
+			write i; writeln;
+			n := 3; calls := 0;
+			for i := 1 to bound() do begin n := n + 1; write i end;
+			write calls; writeln;
+			for i := 1 to 3 do for j := i to 3 do write i * 10 + j; writeln;
+			count(5)
+		  end.
+		`,
+		want: "1 2 3 \n3 2 1 \n0 3 6 9 \n10 6 2 \n0 \n1 2 3 1 \n11 12 13 22 23 33 \n5 3 1 \n",
+	},
+	{
+		// short-circuit and, or, not
+		source: `
+		  var calls, i, a[3];
+		  function t(x) begin calls := calls + 1; return x end;
+		  begin
+			calls := 0;
+			if 1 = 0 and t(1) = 1 then write 1;
+			if 1 = 1 or t(1) = 1 then write 2;
+			if 1 = 1 and t(1) = 1 then write 3;
+			if 1 = 0 or t(0) = 1 then write 4;
+			write calls; writeln;
+			{ precedence: not > and > or }
+			if 1 = 1 or 1 = 0 and 1 = 0 then write 5;
+			if (1 = 1 or 1 = 0) and 1 = 0 then write 6;
+			if not 1 = 0 and not odd 2 then write 7;
+			if not (1 = 1 and 1 = 0) then write 8;
+			if ((1 + 2) * 2 = 6) then write 9;
+			writeln;
+			{ bounds checks guard the array elements }
+			a[0] := 5; a[1] := 3; a[2] := 7;
+			i := 0;
+			while i < 3 and a[i] <> 7 do i := i + 1;
+			write i;
+			i := 0;
+			repeat i := i + 1 until i >= 3 or a[i] > a[i - 1];
+			write i;
+			while not (i = 0 or odd i) do i := i - 1;
+			write i
+		  end.
+		`,
+		want: "2 3 2 \n5 7 8 9 \n2 2 1 ",
+	},
+	{
+		// mod and builtin functions
+		source: `
+		  var x, y;
+		  function gcd(a, b) begin if b = 0 then return a; return gcd(b, a mod b) end;
+		  function sum(min, max) return min + max; { parameters hide the builtins }
+		  begin
+			x := -7; y := 3;
+			write x mod y; write 7 mod (-3); write x - x / y * y; writeln;
+			write abs(x); write sqr(x); write min(x, y); write max(x, abs(y + x)); writeln;
+			write gcd(84, 36); write sum(1, 2); write max(min(x, 0), 1 mod 1) + sqr(2) mod 3
+		  end.
+		`,
+		want: "-1 1 -1 \n7 49 -7 4 \n12 3 1 ",
+	},
+	{
+		// strings and field widths
+		source: `
+		  var n, i;
+		  begin
+			n := 42;
+			write "n = ", n; writeln;
+			writeln "\"quoted\"\tтекст";
+			for i := 1 to 3 do write i * 10:4, "|";
+			writeln n:0, "-", n:1 - 5;
+			write "n = "
+		  end.
+		`,
+		want: "n = 42 \n\"quoted\"\tтекст\n  10|  20|  30|42-42\nn = ",
+	},
+	{
+		// multi-dimensional arrays
+		source: `
+		  const n = 3;
+		  var a[n, n], b[n, n], c[n, n], i, j, t[2, 3, 4];
+		  procedure mul(x[,], y[,], z[,], size)
+		    var i, j, k;
+		  begin
+			for i := 0 to size - 1 do
+			  for j := 0 to size - 1 do begin
+				z[i, j] := 0;
+				for k := 0 to size - 1 do z[i, j] := z[i, j] + x[i, k] * y[k, j]
+			  end
+		  end;
+		  function trace(m[,], size)
+		    var i, s;
+		    function diag(k) return m[k, k];
+		  begin
+			s := 0;
+			for i := 0 to size - 1 do s := s + diag(i);
+			return s
+		  end;
+		  function sum3(v[,,], x, y)
+		    var i, j, s;
+		  begin
+			s := 0;
+			for i := 0 to x - 1 do for j := 0 to y - 1 do s := s + v[i, j, i + j];
+			return s
+		  end;
+		  function pass(m[,]) return trace(m, n);
+		  begin
+			for i := 0 to n - 1 do
+			  for j := 0 to n - 1 do begin a[i, j] := i * n + j; b[i, j] := i - j end;
+			call mul(a, b, c, n);
+			for i := 0 to n - 1 do begin
+			  for j := 0 to n - 1 do write c[i, j]:4;
+			  writeln
+			end;
+			write trace(c, n), pass(a);
+			for i := 0 to 1 do for j := 0 to 2 do t[i, j, i + j] := i * 10 + j;
+			write sum3(t, 2, 3), t[1, 2, 3]
+		  end.
+		`,
+		want: "   5   2  -1\n  14   2 -10\n  23   2 -19\n-12 12 36 12 ",
+	},
+	{
+		// break and continue of the innermost loops
+		source: `
+		  var i, j, n;
+		  begin
+			i := 0;
+			while 1 = 1 do begin
+			  i := i + 1;
+			  if odd i then continue;
+			  if i > 8 then break;
+			  write i
+			end;
+			n := 0;
+			repeat begin
+			  n := n + 1;
+			  if n = 2 then continue;
+			  if n = 4 then break;
+			  write n
+			end until n >= 10;
+			for i := 1 to 3 do
+			  for j := 1 to 3 do begin
+				if j = i then continue;
+				if j > i then break;
+				write i * 10 + j
+			  end;
+			writeln
+		  end.
+		`,
+		want: "2 4 6 8 1 3 21 31 32 \n",
+	},
+	{
+		// case by a jump table and by comparisons
+		source: `
+		  const two = 2;
+		  var i;
+		  function name(n)
+		  begin
+			case n of
+			  0: return 100;
+			  1, 3: return 101
+			else return 0
+			end
+		  end;
+		  begin
+			for i := -1 to 6 do
+			  case i of
+				1: write 10;
+				two, 3: write 20;
+				4: begin if i = 4 then continue; write 40 end;
+				5: break
+			  else write 99
+			  end;
+			writeln;
+			for i := 0 to 4 do
+			  case i * 100 of 100: write 1; 300, 400: write name(i - 3) end;
+			writeln
+		  end.
+		`,
+		want: "99 99 10 20 20 \n1 100 101 \n",
+	},
+	{
+		// var parameters through multiple nesting levels
+		source: `
+		  var a, b, q, r, m[2, 3], i;
+		  procedure swap(var x, var y)
+			var t;
+		  begin t := x; x := y; y := t end;
+		  procedure divmod(n, d, var quo, var rem)
+		  begin quo := n / d; rem := n - quo * d end;
+		  procedure outer(var x)
+			procedure inner(var y)
+			  procedure innermost() begin y := y + 100; x := x + 1000 end;
+			begin y := y + 10; innermost() end;
+		  begin x := x + 1; inner(x) end;
+		  function count(var n) begin for n := 1 to 3 do write n; return n end;
+		  begin
+			a := 1; b := 2;
+			swap(a, b);
+			write a, b;
+			divmod(17, 5, q, r);
+			write q, r;
+			outer(a);
+			write a;
+			m[1, 2] := 5;
+			swap(m[1, 2], b);
+			write m[1, 2], b;
+			write count(i), i;
+			writeln
+		  end.
+		`,
+		want: "2 1 3 2 1113 1 5 1 2 3 4 4 \n",
+	},
+}
+
+func TestCompileTargets(t *testing.T) {
+	// without inlining, all functions are compiled as called
+	noInline := func(source string) (string, error) {
+		c := NewCompiler("test")
+		c.InlineThreshold = 0
+		instructions, err := c.CompileSource([]byte(source))
+		if err != nil {
+			return "", err
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		err = vm.Run(instructions)
+		return outBuf.String(), err
+	}
+
+	for nth, target := range compileTargets {
+		for _, run := range []func(string) (string, error){compileAndRun, noInline} {
+			got, err := run(target.source)
+			if err != nil {
+				t.Errorf("#%d: Error: %s\nSource: %s", nth, err, target.source)
+			} else if got != target.want {
+				t.Errorf("#%d: Got: %s\nWant: %s\nSource: %s",
+					nth, got, target.want, target.source)
+			}
+		}
+	}
+}
+
+func TestOptimizeCompiled(t *testing.T) {
+	for nth, target := range compileTargets {
+		instructions, err := CompileSource("test", []byte(target.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		optimized, stats := pl0core.Optimize(instructions)
+		if err := pl0core.Verify(optimized); err != nil {
+			t.Errorf("#%d: Verify: %v", nth, err)
+			continue
+		}
+		if stats.After > stats.Before {
+			t.Errorf("#%d: Got stats %+v", nth, stats)
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		if err := vm.Run(optimized); err != nil {
+			t.Errorf("#%d: Error: %s", nth, err)
+		} else if got := outBuf.String(); got != target.want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.want)
+		}
+	}
+}
+
+func TestCompileExamplesFib(t *testing.T) {
+	// examples/fib.pl0 compiled by pl0c.rb
+	want :=
+		"\x08\x00\x13\x08\x00\x02\x07\x00\x02\x03\x00\x01\xff\xff\x01\x00" +
+			"\x00\x00\x02\x02\x0b\x09\x00\x09\x01\x00\x00\x00\x01\x06\x00\x01" +
+			"\x00\x01\x03\x00\x01\xff\xff\x01\x00\x00\x00\x01\x02\x03\x05\x00" +
+			"\x00\x00\x02\x03\x00\x01\xff\xff\x01\x00\x00\x00\x02\x02\x03\x05" +
+			"\x00\x00\x00\x02\x02\x02\x06\x00\x01\x00\x01\x07\x00\x03\x0a\x00" +
+			"\x00\x00\x02\x01\x00\x00\x00\x01\x02\x10\x03\x00\x00\x00\x02\x01" +
+			"\x00\x00\x00\x0a\x02\x0b\x09\x00\x25\x03\x00\x00\x00\x02\x05\x00" +
+			"\x00\x00\x02\x02\x0d\x02\x0e\x0a\x00\x00\x00\x02\x03\x00\x00\x00" +
+			"\x02\x01\x00\x00\x00\x01\x02\x02\x02\x10\x08\x00\x17\x06\x00\x00" +
+			"\x00\x00"
+	source := `
+		function fib(n)
+		begin
+		  if n <= 2 then return 1;
+		  return fib(n-1) + fib(n-2)
+		end;
+
+		var n;
+
+		begin
+		  n := 1;
+
+		  while n <= 10 do
+			begin
+			  write fib(n);
+			  writeln;
+			  n := n + 1
+			end;
+		end.
+	`
+
+	instructions, err := CompileSource("fib", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outBuf := bytes.NewBufferString("")
+	err = pl0core.WriteInstructions(outBuf, instructions)
+	if err != nil {
+		t.Fatal(err)
+	} else if got := outBuf.String(); got != want {
+		t.Errorf("Got: %q\nWant: %q", got, want)
+	}
+}
+
+var compileErrorTargets = []struct {
+	source  string
+	wantMsg string
+}{
+	{"var a; begin a := 1; write undef end.", "test:1:28: Undefined symbol: undef"},
+	{"begin write 1 end", "test:1:18: '.' required."},
+	{"begin write 1\nwrite 2 end.", "test:1:14: Expected ';' or 'end' but was 'write'"},
+	{"const c = 1; begin c := 2 end.", "test:1:20: Symbol c is not assignable."},
+	{"var a[3]; begin a := 2 end.", "test:1:17: Symbol a is an array."},
+	{"var a; begin a[0] := 2 end.", "test:1:14: Symbol a is not an array."},
+	{"var a[3]; begin write a end.", "test:1:23: Reference of array a is not allowed here."},
+	{"var n; var a[n]; .", "test:1:14: size 'n' of array 'a' is not constant"},
+	{"var a[0]; .", "test:1:7: size 0 of array 'a' is invalid."},
+	{"function f(x) return x; begin write f(1, 2) end.",
+		"test:1:37: f: number of parameters mismatch."},
+	{"var a; begin a := 1a end.", "test:1:19: Illegal number '1a'"},
+	{"begin write 1 # 2 end.", "test:1:15: Unexpected character '#'"},
+	{"begin then end.", "test:1:7: Unexpected token: then"},
+	{"begin write 1\n{ comment { nested }\nend.", "test:2:1: Unterminated comment starting at line 2"},
+	{"begin write 1 (* comment end.", "test:1:15: Unterminated comment starting at line 1"},
+	{"begin write 1 } end.", "test:1:15: Unexpected character '}'"},
+	{"procedure p() write 1; begin write p() end.", "test:1:36: Procedure p has no value."},
+	{"procedure p() write 1; var x; begin x := p end.", "test:1:42: Procedure p has no value."},
+	{"function f() return 1; begin call f() end.", "test:1:35: Symbol f is not a procedure."},
+	{"var x; begin x(1) end.", "test:1:14: Symbol x is not a procedure."},
+	{"procedure p() return 1; begin p() end.", "test:1:22: Procedure p cannot return a value."},
+	{"function f() return; begin write f() end.", "test:1:14: Return value required."},
+	{"procedure p(x) write x; begin p() end.", "test:1:31: p: number of parameters mismatch."},
+	{"var i; begin for i := 1 to 10 do i := i + 1 end.", "test:1:34: Loop variable i cannot be assigned."},
+	{"var i; begin for i := 1 to 2 do for i := 1 to 2 do write i end.", "test:1:37: Loop variable i cannot be assigned."},
+	{"var a[2]; begin for a := 1 to 2 do write 1 end.", "test:1:21: Loop variable a must be a scalar variable."},
+	{"var i; begin for i := 1 to 2 step 0 do write i end.", "test:1:35: Step of for must be a nonzero constant."},
+	{"var i, s; begin for i := 1 to 2 step s do write i end.", "test:1:38: Step of for must be a nonzero constant."},
+	{"var i; begin for i := 2 downto 1 step -1 do write i end.", "test:1:39: Step of downto must be positive."},
+	{"var i; begin for i := 1 downto do write i end.", "test:1:32: Unexpected token 'do'"},
+	{"begin write (1 < 2) end.", "test:1:14: Condition is not allowed in an expression."},
+	{"var x; begin x := (not 1 = 0) + 1 end.", "test:1:20: Condition is not allowed in an expression."},
+	{"var x; begin if (x + 1) and x > 0 then write x end.", "test:1:25: Expected '=', '<>', '>', '>=', '<' or '<=' but was 'and'"},
+	{"var x; begin if x > 0 and x then write x end.", "test:1:29: Expected '=', '<>', '>', '>=', '<' or '<=' but was 'then'"},
+	{"begin write abs(1, 2) end.", "test:1:13: abs: number of parameters mismatch."},
+	{"var x; begin x := abs end.", "test:1:19: Function abs requires '('."},
+	{"begin abs(1) end.", "test:1:7: Symbol abs is not a procedure."},
+	{"begin max := 1 end.", "test:1:7: Symbol max is not assignable."},
+	{"var m[2, 3]; begin m[1] := 0 end.", "test:1:20: Array m has 2 dimension(s), but 1 indices are given."},
+	{"var a[2]; begin write a[0, 1] end.", "test:1:23: Array a has 1 dimension(s), but 2 indices are given."},
+	{"var m[2, 0]; .", "test:1:10: size 0 of array 'm' is invalid."},
+	{"var m[65536, 65536]; .", "test:1:5: size of array 'm' is too large."},
+	{"var a[2]; function f(m[,]) return m[0, 0]; begin write f(a) end.", "test:1:58: Argument for m must be an array of 2 dimension(s)."},
+	{"var m[2, 2]; function f(a[]) return a[0]; begin write f(m) end.", "test:1:57: Argument for a must be an array of 1 dimension(s)."},
+	{"var x; function f(m[,]) return 0; begin write f(x + 1) end.", "test:1:49: Argument for m must be an array of 2 dimension(s)."},
+	{"var x; function f(a[]) return 0; begin write f(x + 1) end.", "test:1:48: Argument for a must be an array or a variable."},
+	{"begin write \"abc end.", "test:1:13: Unterminated string"},
+	{"begin write \"\\q\" end.", "test:1:13: Illegal string \"\\q\""},
+	{"var x; begin x := \"a\" end.", "test:1:19: Unexpected token '\"a\"'"},
+	{"begin write 1, end.", "test:1:16: Unexpected token 'end'"},
+	{"begin write 1:\"a\" end.", "test:1:15: Unexpected token '\"a\"'"},
+	{"begin break end.", "test:1:7: Statement break is not in a loop."},
+	{"var i; function f() begin continue; return 0 end; begin while 1 = 1 do write f() end.",
+		"test:1:27: Statement continue is not in a loop."},
+	{"var i; begin for i := 1 to 2 do write i; continue end.", "test:1:42: Statement continue is not in a loop."},
+	{"var x; begin case x of x: write 1 end end.", "test:1:24: Label of case must be a constant."},
+	{"const c = 2; begin case 1 of 1, 2: write 1; c: write 2 end end.", "test:1:45: Duplicate label 2 of case."},
+	{"begin case 1 of 1: write 1 else writeln; end.", "test:1:40: Expected 'end' but was ';'"},
+	{"begin case 1 of else writeln end.", "test:1:17: Expected a label of case but was 'else'"},
+	{"var a; procedure p(var x) x := 1; begin p(a + 1) end.",
+		"test:1:43: Argument for var parameter x must be a variable."},
+	{"const c = 1; procedure p(var x) x := 1; begin p(c) end.",
+		"test:1:49: Argument for var parameter x must be a variable."},
+	{"var a[2]; function f(var x) return x; begin write f(a) end.",
+		"test:1:53: Argument for var parameter x must be a variable."},
+	{"var i; procedure p(var x) x := 1; begin for i := 1 to 2 do p(i) end.",
+		"test:1:62: Loop variable i cannot be assigned."},
+	{"procedure p(var a[]) a[0] := 1; begin end.", "test:1:13: Array reference a cannot be a var parameter."},
+}
+
+func TestCompileErrors(t *testing.T) {
+	for nth, target := range compileErrorTargets {
+		_, err := CompileSource("test", []byte(target.source))
+		if err == nil {
+			t.Errorf("#%d: No error\nSource: %s", nth, target.source)
+		} else if got := err.(ErrorList)[0].Error(); got != target.wantMsg {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.wantMsg)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	source := "// head\nvar a; { a {b} }\nbegin a := (* x\n*) 1 end. // tail"
+	prog, err := Parse("test", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1:1-1:8 // head",
+		"2:8-2:17 { a {b} }",
+		"3:12-4:3 (* x\n*)",
+		"4:11-4:18 // tail",
+	}
+	var got []string
+	for _, c := range prog.Comments {
+		got = append(got, fmt.Sprintf("%s-%s %s", c.Pos(), c.End(), c.Text))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nWant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// comments are kept as trivia of the following token
+	s := NewScanner("test", []byte(source), nil)
+	var texts []string
+	for {
+		token := s.NextToken()
+		if len(token.Comments) > 0 {
+			texts = append(texts, token.Text+":"+token.Comments[0].Text)
+		}
+		if token.Kind == TokenEOF {
+			break
+		}
+	}
+	if got := strings.Join(texts, " "); got != "var:// head begin:{ a {b} } 1:(* x\n*) :// tail" {
+		t.Errorf("Got trivia: %q", got)
+	}
+}
+
+func TestErrorDiagnostics(t *testing.T) {
+	source := "const size = 3;\nvar a;\nbegin a := sise; size := 1\nwrite a end."
+	_, err := CompileSource("test", []byte(source))
+	errors, ok := err.(ErrorList)
+	if !ok || len(errors) != 3 {
+		t.Fatalf("Got: %v", err)
+	}
+	diags := errors.Diagnostics()
+	var got []string
+	for _, d := range diags {
+		s := fmt.Sprintf("%s %d:%d-%d:%d", d.Code,
+			d.Location.Range.Start.Line, d.Location.Range.Start.Column,
+			d.Location.Range.End.Line, d.Location.Range.End.Column)
+		for _, r := range d.Related {
+			s += fmt.Sprintf(" related %d:%d %s", r.Range.Start.Line, r.Range.Start.Column, r.Message)
+		}
+		for _, fix := range d.Fixes {
+			for _, edit := range fix.Edits {
+				s += fmt.Sprintf(" edit %d:%d-%d:%d %q", edit.Range.Start.Line, edit.Range.Start.Column,
+					edit.Range.End.Line, edit.Range.End.Column, edit.NewText)
+			}
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		`undefined-symbol 3:12-3:16 edit 3:12-3:16 "size"`,
+		`not-assignable 3:18-3:22 related 1:7 const size is declared here`,
+		`syntax-error 3:27-3:27 edit 3:27-3:27 ";"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nWant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestCompileErrorsGolden compiles broken programs in testdata/errors
+// and compares all error messages with the golden files.
+// Run "go test -update" to update the golden files.
+func TestCompileErrorsGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "errors", "*.pl0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Base(file)
+		instructions, err := CompileSource(name, src)
+		errors, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("%s: Got: %v", name, err)
+			continue
+		}
+		if instructions != nil {
+			t.Errorf("%s: instructions generated", name)
+		}
+		got := errors.Format(src)
+
+		golden := strings.TrimSuffix(file, ".pl0") + ".golden"
+		if *update {
+			if err = ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s:\nGot:\n%s\nWant:\n%s", name, got, want)
+		}
+	}
+}
+
+func TestInfoScopes(t *testing.T) {
+	source := "var a;\n" +
+		"function f(x, ap[])\n" +
+		"  var a;\n" +
+		"  return a + x;\n" +
+		"const c = 3;\n" +
+		"begin a := f(c, a) end."
+	prog, err := Parse("test", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompiler("test")
+	if err = c.Compile(prog); err != nil {
+		t.Fatal(err)
+	}
+	info := c.Info()
+
+	main := info.Universe.Children[0]
+	if len(main.Children) != 1 {
+		t.Fatalf("Got %d function scopes, Want 1", len(main.Children))
+	}
+	fscope := main.Children[0]
+
+	// "a + x" in f refers to the local a at level 1.
+	inner := ast.Pos{Offset: strings.Index(source, "a + x"), Line: 4, Column: 10}
+	if got := info.Universe.Innermost(inner); got != fscope {
+		t.Errorf("Innermost: Got level %d, Want level 1", got.Level)
+	}
+	sym := fscope.Lookup("a", inner)
+	if sym == nil || sym.Address != (pl0core.Address{Level: 1, Offset: 2}) {
+		t.Errorf("Lookup a: Got %v", sym)
+	}
+	var names []string
+	for _, sym := range fscope.Visible(inner) {
+		names = append(names, sym.Name)
+	}
+	// c is declared after f. The builtin functions are in the universe.
+	if got, want := strings.Join(names, ","), "a,ap,x,f,sqr,max,min,abs"; got != want {
+		t.Errorf("Visible: Got %s, Want %s", got, want)
+	}
+
+	params := info.Defs[prog.Block.Decls[1].(*ast.FuncDecl).Name].Params
+	// ap is passed with its size for bounds checks.
+	if len(params) != 2 || params[0].Address.Offset != -3 ||
+		params[1].Address.Offset != -2 || params[1].Kind != SymbolVarRef {
+		t.Errorf("Params: Got %v", params)
+	}
+}
+
+var foldingTargets = []struct {
+	source string
+	saved  int // number of instructions saved by folding
+	want   string
+}{
+	{`
+	  const size = 10;
+	  var i, a[size], n;
+	  begin
+	    i := 1;
+	    while i <= size do
+	    begin
+	      a[i - 1] := i * 10 / 2;
+	      a[i - 1] := a[i - 1] * 2;
+	      i := i + 1;
+	    end;
+	    n := 0;
+	    while n < size do
+	    begin
+	      write a[n + (n - n)];
+	      n := n + 1;
+	    end;
+	  end.`, 4, "10 20 30 40 50 60 70 80 90 100 "},
+	{"const c = 6; begin write c * (c + 1) / 2; write -(c - 7) end.", 9, "21 1 "},
+	{"var x; begin x := 5; write x + 0; write 0 + x * 1; write 1 * x - 0; write x / 1 end.", 12, "5 5 5 5 "},
+	{"var x; begin x := 5; write x * 0; write 0 * (x + 1); write (x + 2) - (x + 2) end.", 12, "0 0 0 "},
+	{"const c = 3; begin if odd c then write 1; if odd (c + 1) then write 2; if c < 2 * 2 then write 3 end.",
+		8, "1 3 "},
+	{"var x; begin x := 3; write +x; write -(-x) end.", 0, "3 3 "},
+	// calls, array elements and divisions by variables have side effects or runtime errors
+	{`var n;
+	  function f(x) begin n := n + x; return 1 end;
+	  begin n := 0; write f(2) * 0; write f(3) - f(3); write n end.`, 0, "0 0 8 "},
+	{"var a[2], x; begin x := 1; a[1] := 7; write a[x] * 0; write (x / x) - (x / x) end.", 0, "0 0 "},
+	{"var x; begin x := 1; write x * (2 - 2); write (1 - 1) * x + 4 / 2 end.", 12, "0 2 "},
+	// arguments of calls and indices are folded, and constant indices are not checked
+	{`const k = 2;
+	  var a[3];
+	  function g(y, ap[]) return ap[y] + y;
+	  begin a[k] := 5; write g(k * 1, a); write a[k + 0 * k] end.`, 10, "7 5 "},
+	// division by zero is left to the VM
+	{"begin write 1 / (1 - 1) end.", 2, ""},
+	{"begin write 1 mod 0 end.", 0, ""},
+	// mod and builtin functions
+	{"begin write 7 mod 3; write abs(-2) + max(1, sqr(3)) end.", 9, "1 11 "},
+	{"var x; begin x := 2; write abs(x) * 0; write x mod x * 0 end.", 3, "0 0 "},
+	// literals of the VM are 32-bit
+	{"const c = 2147483647; begin write c + 1; write -c - 1 end.", 3, "2147483648 -2147483648 "},
+}
+
+func TestFolding(t *testing.T) {
+	compile := func(source string, fold bool) []pl0core.Instruction {
+		prog, err := Parse("test", []byte(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewCompiler("test")
+		c.Fold = fold
+		if err = c.Compile(prog); err != nil {
+			t.Fatal(err)
+		}
+		return c.Instructions()
+	}
+	run := func(instructions []pl0core.Instruction) (string, error) {
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		err := vm.Run(instructions)
+		return outBuf.String(), err
+	}
+
+	for nth, target := range foldingTargets {
+		folded, plain := compile(target.source, true), compile(target.source, false)
+		if saved := len(plain) - len(folded); saved != target.saved {
+			t.Errorf("#%d: Saved %d instructions, Want %d", nth, saved, target.saved)
+		}
+		got, err := run(folded)
+		want, wantErr := run(plain)
+		if got != want || (err == nil) != (wantErr == nil) {
+			t.Errorf("#%d: Got %q (%v), Want %q (%v)", nth, got, err, want, wantErr)
+		}
+		if got != target.want {
+			t.Errorf("#%d: Got %q, Want %q", nth, got, target.want)
+		}
+	}
+}
+
+func TestFoldingUses(t *testing.T) {
+	source := "const c = 2; var x; begin x := c; write x * 0; write x - x end."
+	prog, err := Parse("test", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompiler("test")
+	if err = c.Compile(prog); err != nil {
+		t.Fatal(err)
+	}
+	uses := 0
+	ast.Inspect(prog, func(node ast.Node) bool {
+		if id, ok := node.(*ast.Ident); ok && c.Info().Uses[id] != nil {
+			uses++
+		}
+		return true
+	})
+	// x, c, x in "x * 0" and both x in "x - x"
+	if uses != 5 {
+		t.Errorf("Got %d uses, Want 5", uses)
+	}
+}
+
+var tailCallTargets = []struct {
+	source    string
+	tailCalls int
+	want      string
+}{
+	{ // far beyond the stack size
+		`function sum(n, acc)
+		 begin
+		   if n = 0 then return acc;
+		   return sum(n - 1, acc + n)
+		 end;
+		 begin write sum(100000, 0) end.`,
+		1, "5000050000 "},
+	{ // nested functions use the display, and call the outer function
+		`function outer(n, base)
+		   var count;
+		   function inner(k)
+		   begin
+		     count := count + 1;
+		     if k = 0 then return outer(n - 1, base + count);
+		     return inner(k - 1)
+		   end;
+		 begin
+		   if n = 0 then return base;
+		   count := 0;
+		   return inner(n)
+		 end;
+		 begin write outer(100, 0) end.`,
+		2, "5150 "},
+	{ // array references are passed through
+		`var a[10], i;
+		 function total(ap[], n, acc)
+		 begin
+		   if n = 0 then return acc;
+		   return total(ap, n - 1, acc + ap[n - 1])
+		 end;
+		 begin
+		   i := 0;
+		   while i < 10 do begin a[i] := i; i := i + 1 end;
+		   write total(a, 10, 0)
+		 end.`,
+		1, "45 "},
+	{ // sizes of dimensions are passed through
+		`var m[3, 4], i, j;
+		 function total(p[,], k, acc)
+		 begin
+		   if k = 0 then return acc;
+		   return total(p, k - 1, acc + p[(k - 1) / 4, (k - 1) mod 4])
+		 end;
+		 begin
+		   for i := 0 to 2 do for j := 0 to 3 do m[i, j] := i * 4 + j;
+		   write total(m, 12, 0)
+		 end.`,
+		1, "66 "},
+	{ // not a tail call: a local array is passed, or the callee is nested
+		`function first(ap[]) return ap[0];
+		 function f(x)
+		   var b[2];
+		   function g(y) return x + y;
+		 begin
+		   if x = 0 then return g(1);
+		   b[0] := x;
+		   return first(b)
+		 end;
+		 begin write f(0); write f(7) end.`,
+		0, "1 7 "},
+	{ // the argument is evaluated before the frame is reused
+		`function f(n, m)
+		 begin
+		   if n = 0 then return m;
+		   return f(n - 1, f(0, n) * 2 + m)
+		 end;
+		 begin write f(3, 0) end.`,
+		1, "12 "},
+	{ // var parameters are passed through, but not variables of the frame
+		`function addto(var acc, n)
+		 begin
+		   if n = 0 then return acc;
+		   acc := acc + n;
+		   return addto(acc, n - 1)
+		 end;
+		 function local(n)
+		   var s;
+		 begin
+		   s := 0;
+		   return addto(s, n)
+		 end;
+		 var total;
+		 begin total := 0; write addto(total, 100000), total, local(10) end.`,
+		1, "5000050000 5000050000 55 "},
+}
+
+func TestTailCalls(t *testing.T) {
+	for nth, target := range tailCallTargets {
+		prog, err := Parse("test", []byte(target.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewCompiler("test")
+		if err = c.Compile(prog); err != nil {
+			t.Fatal(err)
+		}
+		tailCalls := 0
+		for _, inst := range c.Instructions() {
+			if inst.GetCode() == pl0core.InstructTCL {
+				tailCalls++
+			}
+		}
+		if tailCalls != target.tailCalls {
+			t.Errorf("#%d: Got %d tail calls, Want %d", nth, tailCalls, target.tailCalls)
+		}
+		if err := pl0core.Verify(c.Instructions()); err != nil {
+			t.Errorf("#%d: Verify: %v", nth, err)
+		}
+		got, err := compileAndRun(target.source)
+		if err != nil {
+			t.Errorf("#%d: Error: %s", nth, err)
+		} else if got != target.want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.want)
+		}
+	}
+
+	// without tail calls, the stack overflows
+	prog, _ := Parse("test", []byte(tailCallTargets[0].source))
+	c := NewCompiler("test")
+	c.TailCalls = false
+	if err := c.Compile(prog); err != nil {
+		t.Fatal(err)
+	}
+	vm := pl0core.NewPL0VM()
+	vm.Output = ioutil.Discard
+	if e, ok := vm.Run(c.Instructions()).(*pl0core.Error); !ok || e.Code != pl0core.CodeStackOverflow {
+		t.Errorf("Got: %v, Want stack overflow", e)
+	}
+}
+
+var inlineTargets = []struct {
+	source  string
+	inlined int // number of calls inlined
+	want    string
+}{
+	{`function max(a, b) begin if a > b then return a; return b end;
+	  begin write max(3, 5); write max(7, 2) end.`, 2, "5 7 "},
+	// arguments with side effects are evaluated once in order
+	{`var n;
+	  function next() begin n := n + 1; return n end;
+	  function sub(a, b) return a - b;
+	  begin n := 0; write sub(next(), next()); write n end.`, 3, "-1 2 "},
+	// return in the middle of a body, and local variables
+	{`function sign(x)
+	    var s;
+	  begin
+	    s := 1;
+	    if x = 0 then return 0;
+	    if x < 0 then s := -1;
+	    return s
+	  end;
+	  var i;
+	  begin i := -2; while i <= 2 do begin write sign(i); i := i + 1 end end.`, 1, "-1 -1 0 1 1 "},
+	// local arrays, array references and variables of the outer blocks
+	{`var g, a[3];
+	  function sum3(ap[])
+	    var t[2];
+	  begin
+	    t[0] := ap[0] + ap[1];
+	    t[1] := t[0] + ap[2] + g;
+	    return t[1]
+	  end;
+	  function outer(x)
+	    var b[3];
+	    function inner(y) return sum3(b) + y;
+	  begin
+	    b[0] := x; b[1] := x; b[2] := x;
+	    return inner(1) + sum3(a)
+	  end;
+	  begin
+	    g := 100; a[0] := 1; a[1] := 2; a[2] := 3;
+	    write sum3(a); write outer(10)
+	  end.`, 3, "106 237 "},
+	// inlined calls in the arguments of inlined calls
+	{`function add(x, y) return x + y;
+	  function twice(x) var r; begin r := x * 2; return r end;
+	  begin write twice(add(twice(1), add(2, twice(3)))) end.`, 5, "20 "},
+	// multi-dimensional array references with the sizes of the dimensions
+	{`var m[2, 3];
+	  function trace(x[,]) return x[0, 0] + x[1, 1];
+	  begin m[0, 0] := 3; m[1, 1] := 4; write trace(m) end.`, 1, "7 "},
+	// break in the body, and of the loop of the caller
+	{`function find(x)
+	    var i;
+	  begin
+	    i := 0;
+	    repeat begin if i * i >= x then break; i := i + 1 end until 0 = 1;
+	    return i
+	  end;
+	  var k;
+	  begin for k := 1 to 3 do begin write find(k * 4); if k = 2 then break end end.`, 1, "2 3 "},
+	// var parameters refer to the arguments of the caller
+	{`var a, m[3];
+	  function inc(var x) begin x := x + 1; return x end;
+	  begin a := 1; m[1] := 5; write inc(a) + inc(a), inc(m[1]), a, m[1] end.`, 3, "5 6 3 6 "},
+	// builtin functions are not calls
+	{`function dist(a, b) return abs(a - b);
+	  begin write dist(3, 5) + dist(sqr(2), 1) end.`, 2, "5 "},
+	// recursive, calling and large functions are not inlined
+	{`function fact(n) begin if n <= 1 then return 1; return n * fact(n - 1) end;
+	  function f(x) return fact(x);
+	  function noreturn(x) begin if x > 0 then return x end;
+	  function leave(x) begin repeat begin if x > 0 then break; return 1 end until x = 0 end;
+	  begin write fact(5); write f(3); write noreturn(1); write leave(0) end.`, 0, "120 6 1 1 "},
+}
+
+func TestInline(t *testing.T) {
+	compile := func(source string, threshold int) []pl0core.Instruction {
+		prog, err := Parse("test", []byte(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewCompiler("test")
+		c.InlineThreshold = threshold
+		if err = c.Compile(prog); err != nil {
+			t.Fatal(err)
+		}
+		return c.Instructions()
+	}
+	run := func(instructions []pl0core.Instruction) (string, error) {
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		err := vm.Run(instructions)
+		return outBuf.String(), err
+	}
+	calls := func(instructions []pl0core.Instruction) int {
+		n := 0
+		for _, inst := range instructions {
+			if code := inst.GetCode(); code == pl0core.InstructCAL || code == pl0core.InstructTCL {
+				n++
+			}
+		}
+		return n
+	}
+
+	for nth, target := range inlineTargets {
+		inlined, plain := compile(target.source, DefaultInlineThreshold), compile(target.source, 0)
+		if n := calls(plain) - calls(inlined); n != target.inlined {
+			t.Errorf("#%d: Inlined %d calls, Want %d", nth, n, target.inlined)
+		}
+		if err := pl0core.Verify(inlined); err != nil {
+			t.Errorf("#%d: Verify: %v", nth, err)
+		}
+		got, err := run(inlined)
+		if err != nil {
+			t.Errorf("#%d: Error: %s", nth, err)
+		} else if got != target.want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.want)
+		}
+	}
+
+	// differential testing with all functions inlined if possible
+	sources := []string{}
+	for _, target := range compileTargets {
+		sources = append(sources, target.source)
+	}
+	for _, target := range inlineTargets {
+		sources = append(sources, target.source)
+	}
+	files, _ := filepath.Glob(filepath.Join("..", "..", "examples", "*.pl0"))
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, string(src))
+	}
+	for nth, source := range sources {
+		plain, inlined := compile(source, 0), compile(source, 1000)
+		if reflect.DeepEqual(plain, inlined) {
+			continue
+		}
+		want, wantErr := run(plain)
+		got, err := run(inlined)
+		if got != want || (err == nil) != (wantErr == nil) {
+			t.Errorf("#%d: Got %q (%v), Want %q (%v)\nSource: %s", nth, got, err, want, wantErr, source)
+		}
+	}
+}
+
+var boundsCheckTargets = []struct {
+	source string
+	checks int    // number of CHK operations
+	want   string // output before the error
+	fail   bool   // whether an index is out of range
+}{
+	{"var a[3], i; begin i := 2; a[i] := 1; write a[i] end.", 2, "1 ", false},
+	{"var a[3], i; begin i := 3; a[i] := 1 end.", 1, "", true},
+	{"var a[3], i; begin i := -1; write a[i] end.", 1, "", true},
+	// each index is checked, not the offset in the array
+	{"var m[2, 3], i, j; begin i := 0; j := 3; m[i, j] := 1 end.", 2, "", true},
+	// constant indices in the range are not checked
+	{"const k = 2; var a[3]; begin a[k] := 1; write a[0] + a[k - 1] end.", 0, "0 ", false},
+	{"var a[3]; begin a[3] := 1 end.", 1, "", true},
+	// array references are passed with their sizes
+	{`var a[3];
+	  function f(ap[], i) begin if i < 0 then return 0; return ap[i] end;
+	  begin a[2] := 5; write f(a, 2); write f(a, 3) end.`, 1, "5 ", true},
+	{`var m[2, 3];
+	  procedure p(x[,], i) x[i, 0] := i;
+	  procedure q(y[,], i) call p(y, i);
+	  begin call q(m, 1); write m[1, 0]; call q(m, 2) end.`, 2, "1 ", true},
+	// a variable passed by reference is an array of one element
+	{`var x;
+	  function f(ap[]) begin if x < 0 then return ap[0]; return 0 end;
+	  begin x := 0; write f(x) end.`, 1, "0 ", false},
+	{`var x, i, a[3];
+	  function g(ap[]) begin ap[0] := 7; return 0 end;
+	  begin x := g(i); x := g(a[1]); write i; write a[1] end.`, 1, "7 7 ", false},
+	{`var x, i;
+	  function g(ap[]) begin ap[5] := 7; return 0 end;
+	  begin x := g(i); writeln i end.`, 1, "", true},
+}
+
+func TestCaseStatement(t *testing.T) {
+	targets := []struct {
+		source    string
+		jumpTable bool
+		want      string
+	}{
+		// dense labels
+		{"var i; begin for i := 0 to 5 do case i of 1: write 1; 2: write 2; 3, 4: write 3 else write 0 end end.",
+			true, "0 1 2 3 3 0 "},
+		{"var i; begin for i := 0 to 9 do case i - 5 of -3: write 1; 0: write 2; 1: write 3; 4: write 4 end end.",
+			true, "1 2 3 4 "},
+		// sparse or few labels
+		{"var i; begin for i := 0 to 5 do case i of 1: write 1; 2: write 2; 3: write 3 else write 0 end end.",
+			false, "0 1 2 3 0 0 "},
+		{"var i; begin for i := 0 to 99 do case i of 0, 10, 50: write i; 99: write 1 end end.",
+			false, "0 10 50 1 "},
+	}
+	for nth, target := range targets {
+		instructions, err := CompileSource("test", []byte(target.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		jumpTable := false
+		for _, inst := range instructions {
+			jumpTable = jumpTable || inst.GetCode() == pl0core.InstructJTB
+		}
+		if jumpTable != target.jumpTable {
+			t.Errorf("#%d: Got jump table %t", nth, jumpTable)
+		}
+		if err := pl0core.Verify(instructions); err != nil {
+			t.Errorf("#%d: Verify: %v", nth, err)
+		}
+		got, err := compileAndRun(target.source)
+		if err != nil {
+			t.Errorf("#%d: Error: %s", nth, err)
+		} else if got != target.want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.want)
+		}
+	}
+}
+
+func TestBoundsCheck(t *testing.T) {
+	compile := func(source string, boundsCheck bool) []pl0core.Instruction {
+		prog, err := Parse("test", []byte(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewCompiler("test")
+		c.BoundsCheck = boundsCheck
+		c.InlineThreshold = 0
+		if err = c.Compile(prog); err != nil {
+			t.Fatal(err)
+		}
+		return c.Instructions()
+	}
+	checks := func(instructions []pl0core.Instruction) int {
+		n := 0
+		for _, inst := range instructions {
+			if op, ok := inst.(*pl0core.OperationInstruction); ok && op.OpType == pl0core.OpTypeCHK {
+				n++
+			}
+		}
+		return n
+	}
+
+	for nth, target := range boundsCheckTargets {
+		instructions := compile(target.source, true)
+		if n := checks(instructions); n != target.checks {
+			t.Errorf("#%d: Got %d checks, Want %d", nth, n, target.checks)
+		}
+		if err := pl0core.Verify(instructions); err != nil {
+			t.Errorf("#%d: Verify: %v", nth, err)
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		err := vm.Run(instructions)
+		if got := outBuf.String(); got != target.want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.want)
+		}
+		if e, ok := err.(*pl0core.Error); target.fail && (!ok || e.Code != pl0core.CodeIndexOutOfRange) ||
+			!target.fail && err != nil {
+			t.Errorf("#%d: Error: %v", nth, err)
+		}
+
+		// without checks, the indices are not checked
+		if n := checks(compile(target.source, false)); n != 0 {
+			t.Errorf("#%d: Got %d checks without BoundsCheck", nth, n)
+		}
+	}
+}
+
+func TestNestingLevel(t *testing.T) {
+	// procedure p1 declares p2, ..., and p7 writes the variable of main
+	var head, tail string
+	for k := 1; k <= 7; k++ {
+		head += fmt.Sprintf("procedure p%d()\n", k)
+		if k < 7 {
+			tail = fmt.Sprintf("begin write %d; call p%d() end;\n", k, k+1) + tail
+		}
+	}
+	source := "var x;\n" + head + "begin write 7; write x end;\n" + tail + "begin x := 42; call p1() end."
+	got, err := compileAndRun(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1 2 3 4 5 6 7 42 "; got != want {
+		t.Errorf("Got: %s\nWant: %s", got, want)
+	}
+
+	// only the outermost procedure too deep is reported
+	c := NewCompiler("test")
+	c.MaxLevel = 4
+	_, err = c.CompileSource([]byte(source))
+	errors, _ := err.(ErrorList)
+	if len(errors) != 1 {
+		t.Fatalf("Got: %v\nWant an error", err)
+	}
+	want := "test:6:11: Nesting level 5 of p5 exceeds the maximum 4."
+	if got := errors[0].Error(); got != want || errors[0].Code != CodeNestingLevel {
+		t.Errorf("Got: %s (%s)\nWant: %s", got, errors[0].Code, want)
+	}
+}
+
+func TestStaticLinks(t *testing.T) {
+	run := func(source string) (string, []pl0core.Instruction, error) {
+		c := NewCompiler("test")
+		c.StaticLinks = true
+		instructions, err := c.CompileSource([]byte(source))
+		if err != nil {
+			return "", nil, err
+		}
+		if err := pl0core.Verify(instructions); err != nil {
+			return "", nil, err
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		err = vm.Run(instructions)
+		return outBuf.String(), instructions, err
+	}
+
+	// the same output as with the display, without its instructions
+	var sources, wants []string
+	for _, target := range compileTargets {
+		sources, wants = append(sources, target.source), append(wants, target.want)
+	}
+	for _, target := range tailCallTargets {
+		sources, wants = append(sources, target.source), append(wants, target.want)
+	}
+	for nth, source := range sources {
+		got, instructions, err := run(source)
+		if err != nil {
+			t.Errorf("#%d: Error: %s\nSource: %s", nth, err, source)
+			continue
+		} else if got != wants[nth] {
+			t.Errorf("#%d: Got: %s\nWant: %s\nSource: %s", nth, got, wants[nth], source)
+		}
+		for pc, inst := range instructions {
+			switch inst.GetCode() {
+			case pl0core.InstructLOD, pl0core.InstructLDA, pl0core.InstructSTO, pl0core.InstructCAL,
+				pl0core.InstructRET, pl0core.InstructRTN, pl0core.InstructTCL:
+				t.Errorf("#%d: Got %s at %d", nth, inst, pc)
+			}
+		}
+		optimized, _ := pl0core.Optimize(instructions)
+		if err := pl0core.Verify(optimized); err != nil {
+			t.Errorf("#%d: Verify optimized: %v", nth, err)
+			continue
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		if err := vm.Run(optimized); err != nil || outBuf.String() != wants[nth] {
+			t.Errorf("#%d: Got optimized: %s (%v)\nWant: %s", nth, outBuf.String(), err, wants[nth])
+		}
+	}
+
+	// numbers of static links
+	source := `
+	  var x;
+	  function f(n)
+	    var y;
+	    function g(m) begin if m = 0 then return x + y; return g(m - 1) end;
+	  begin y := n; if n = 0 then return g(2); return g(1) + f(n - 1) end;
+	  begin x := 10; write f(1) end.`
+	got, instructions, err := run(source)
+	if err != nil {
+		t.Fatal(err)
+	} else if got != "21 " {
+		t.Errorf("Got: %s\nWant: 21 ", got)
+	}
+	names := map[byte]string{pl0core.InstructLODS: "LODS", pl0core.InstructCALS: "CALS", pl0core.InstructTCLS: "TCLS"}
+	var links []string
+	for _, inst := range instructions {
+		switch inst := inst.(type) {
+		case *pl0core.AddrInstruction:
+			if name, ok := names[inst.Code]; ok {
+				links = append(links, fmt.Sprintf("%s %d,%d", name, inst.Level, inst.Offset))
+			}
+		case *pl0core.TailCallInstruction:
+			links = append(links, fmt.Sprintf("%s %d,%d", names[inst.Code], inst.Address.Level, inst.Offset))
+		}
+	}
+	want := []string{
+		// g: m, x, y, and g(m - 1)
+		"LODS 0,-1", "LODS 2,3", "LODS 1,3", "LODS 0,-1", "TCLS 1,3",
+		// f: n, g(2), g(1), and f(n - 1)
+		"LODS 0,-1", "LODS 0,-1", "CALS 0,3", "CALS 0,3", "LODS 0,-1", "CALS 1,17",
+		// main: f(1)
+		"CALS 0,17",
+	}
+	if strings.Join(links, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nWant:\n%s", strings.Join(links, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// conformance suites of the dialects
+var dialectTargets = []struct {
+	dialect Dialect
+	source  string
+	input   string
+	want    string
+}{
+	// kk-PL/0
+	{DialectKK, `var a[2]; begin a[0] := 1; repeat a[0] := a[0] * 2 until a[0] > 10;
+	  if a[0] = 16 then write 1 else write 0 end.`, "", "1 "},
+	// PL/0'
+	{DialectPL0Prime, `function f(x) begin if x > 1 then return x * f(x - 1); return 1 end;
+	  begin write f(5); writeln end.`, "", "120 \n"},
+	{DialectPL0Prime, `var else, repeat; begin else := 1; repeat := 2; write else * 10 + repeat end.`, "", "12 "},
+	{DialectPL0Prime, `var call, procedure; begin call := 1; procedure := 2; write call + procedure end.`, "", "3 "},
+	{DialectPL0Prime, `var mod, and; function abs(x) return x; begin mod := 1; and := 2; write abs(mod + and) end.`, "", "3 "},
+	// Wirth's PL/0
+	{DialectWirth, `var x; begin ?x; !x * x end.`, "12", "144 \n"},
+	{DialectWirth, `var x, y; begin ?x; ?y; if x # y then !x - y; if x <> y then !y - x end.`, "5\n3\n", "2 \n-2 \n"},
+	{DialectWirth, `var n, f;
+	  procedure fact;
+	  begin if n > 1 then begin f := f * n; n := n - 1; call fact end end;
+	  begin n := 5; f := 1; call fact; !f end.`, "", "120 \n"},
+	// nested procedures refer to the variables of the outer procedures
+	{DialectWirth, `var r;
+	  procedure outer;
+	    var k;
+	    procedure inner; r := r + k;
+	  begin k := 10; call inner; call inner end;
+	  begin r := 1; call outer; !r end.`, "", "21 \n"},
+	// procedures leave no values on the stack
+	{DialectWirth, `var i; procedure p; i := i + 1; begin i := 0; while i < 5000 do call p; !i end.`, "", "5000 \n"},
+	{DialectWirth, `var write, return; begin write := 3; return := 4; if write < return then !write end.`, "", "3 \n"},
+}
+
+func TestDialects(t *testing.T) {
+	for nth, target := range dialectTargets {
+		c := NewCompiler("test")
+		c.Dialect = target.dialect
+		instructions, err := c.CompileSource([]byte(target.source))
+		if err != nil {
+			t.Errorf("#%d (%s): Error: %s", nth, target.dialect, err)
+			continue
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := pl0core.NewPL0VM()
+		vm.Output = outBuf
+		vm.Input = strings.NewReader(target.input)
+		if err := vm.Run(instructions); err != nil {
+			t.Errorf("#%d (%s): Error: %s", nth, target.dialect, err)
+		} else if got := outBuf.String(); got != target.want {
+			t.Errorf("#%d (%s): Got: %q\nWant: %q", nth, target.dialect, got, target.want)
+		}
+	}
+}
+
+var dialectErrorTargets = []struct {
+	dialect Dialect
+	source  string
+	wantMsg string
+}{
+	{DialectKK, "var x; begin ?x end.", "test:1:14: Unexpected character '?'"},
+	{DialectKK, "var x; begin x := 1 # 2 end.", "test:1:21: Unexpected character '#'"},
+	{DialectPL0Prime, "var a[3]; .", "test:1:6: Unexpected character '['"},
+	{DialectPL0Prime, "begin write abs(1) end.", "test:1:13: Undefined symbol: abs"},
+	{DialectPL0Prime, `begin write "a" end.`, "test:1:13: Unexpected character '\"'"},
+	{DialectPL0Prime, "begin writeln 1 end.", "test:1:15: Expected ';' or 'end' but was '1'"},
+	{DialectPL0Prime, "function f(var x) return x; begin end.", "test:1:12: Expected ')' but was 'var'"},
+	{DialectPL0Prime, "begin if 1 = 1 then write 1 else write 2 end.",
+		"test:1:28: Expected ';' or 'end' but was 'else'"},
+	{DialectWirth, "var x; begin write x end.", "test:1:14: Undefined symbol: write"},
+	{DialectWirth, "var x; procedure p; x := 1; begin x := p end.", "test:1:40: Procedure p has no value."},
+	{DialectWirth, "var x; begin x := x(1) end.", "test:1:20: Expected ';' or 'end' but was '('"},
+	{DialectWirth, "var x; begin call x end.", "test:1:19: Symbol x is not a procedure."},
+	{DialectWirth, "const c = 1; begin ?c end.", "test:1:21: Symbol c is not assignable."},
+	{DialectWirth, "var a[3]; .", "test:1:6: Unexpected character '['"},
+}
+
+func TestDialectErrors(t *testing.T) {
+	for nth, target := range dialectErrorTargets {
+		c := NewCompiler("test")
+		c.Dialect = target.dialect
+		_, err := c.CompileSource([]byte(target.source))
+		if err == nil {
+			t.Errorf("#%d (%s): No error\nSource: %s", nth, target.dialect, target.source)
+		} else if got := err.(ErrorList)[0].Error(); got != target.wantMsg {
+			t.Errorf("#%d (%s): Got: %s\nWant: %s", nth, target.dialect, got, target.wantMsg)
+		}
+	}
+}
+
+func TestDialectExamples(t *testing.T) {
+	// programs of PL/0' are compiled in the same way as kk-PL/0
+	file := filepath.Join("..", "..", "examples", "fig3.8.pl0")
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := CompileSource(file, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompiler(file)
+	c.Dialect = DialectPL0Prime
+	if got, err := c.CompileSource(src); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: instructions differ", file)
+	}
+
+	file = filepath.Join("..", "..", "examples", "wirth", "arith.pl0")
+	if src, err = ioutil.ReadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	c = NewCompiler(file)
+	c.Dialect = DialectWirth
+	instructions, err := c.CompileSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outBuf := bytes.NewBufferString("")
+	vm := pl0core.NewPL0VM()
+	vm.Output = outBuf
+	vm.Input = strings.NewReader("500 7\n")
+	if err := vm.Run(instructions); err != nil {
+		t.Error(err)
+	} else if got := outBuf.String(); got != "595 \n71 \n3 \n1 \n" {
+		t.Errorf("Got: %q", got)
+	}
+}
+
+func TestLookupDialect(t *testing.T) {
+	for _, d := range []Dialect{DialectKK, DialectPL0Prime, DialectWirth} {
+		if got, err := LookupDialect(d.String()); err != nil || got != d {
+			t.Errorf("%s: Got %v, %v", d, got, err)
+		}
+	}
+	if _, err := LookupDialect("pascal"); err == nil {
+		t.Error("No error for an unknown dialect")
+	}
+}
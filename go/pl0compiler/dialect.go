@@ -0,0 +1,66 @@
+package pl0compiler
+
+import "fmt"
+
+// Dialect is a dialect of PL/0.
+// All dialects are compiled into the same PL/0 VM instructions.
+type Dialect int
+
+const (
+	// DialectKK is kk-PL/0, which is PL/0' with else, repeat-until, for,
+	// break-continue, case, and-or-not, mod, builtin functions, arrays and procedures.
+	DialectKK Dialect = iota
+	// DialectPL0Prime is PL/0' of the book, which has functions with
+	// parameters, return, write and writeln.
+	DialectPL0Prime
+	// DialectWirth is the original PL/0 of Wirth, which has procedures
+	// without parameters, call, '?' for input and '!' for output.
+	DialectWirth
+)
+
+var dialectNames = [...]string{
+	DialectKK:       "kk",
+	DialectPL0Prime: "pl0prime",
+	DialectWirth:    "wirth",
+}
+
+func (d Dialect) String() string {
+	if d < 0 || int(d) >= len(dialectNames) {
+		return "Unknown"
+	}
+	return dialectNames[d]
+}
+
+// LookupDialect returns the dialect named name: kk, pl0prime or wirth.
+func LookupDialect(name string) (Dialect, error) {
+	for d, dn := range dialectNames {
+		if dn == name {
+			return Dialect(d), nil
+		}
+	}
+	return DialectKK, fmt.Errorf("unknown dialect: %s", name)
+}
+
+// excludedTokens are the reserved words and symbols not in the dialects,
+// which are read as identifiers and illegal characters.
+var excludedTokens = [...]tokenSet{
+	DialectKK: newTokenSet(TokenQuestion, TokenExclamation),
+	DialectPL0Prime: newTokenSet(TokenProcedure, TokenCall, TokenQuestion, TokenExclamation,
+		TokenElse, TokenRepeat, TokenUntil, TokenLBracket, TokenRBracket,
+		TokenFor, TokenTo, TokenDownto, TokenStep, TokenBreak, TokenContinue,
+		TokenCase, TokenOf,
+		TokenAnd, TokenOr, TokenNot, TokenMod, TokenString, TokenColon),
+	DialectWirth: newTokenSet(TokenFunc, TokenElse, TokenRepeat, TokenUntil,
+		TokenWrite, TokenWriteln, TokenReturn, TokenLBracket, TokenRBracket,
+		TokenFor, TokenTo, TokenDownto, TokenStep, TokenBreak, TokenContinue,
+		TokenCase, TokenOf,
+		TokenAnd, TokenOr, TokenNot, TokenMod, TokenString, TokenColon),
+}
+
+// hasToken reports whether the token of the text is in the dialect.
+func (d Dialect) hasToken(kind TokenKind, text string) bool {
+	if text == "#" {
+		return d == DialectWirth
+	}
+	return !excludedTokens[d].has(kind)
+}
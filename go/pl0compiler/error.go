@@ -0,0 +1,202 @@
+package pl0compiler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"kkpl0/ast"
+	"kkpl0/diag"
+)
+
+// Error codes, which are the rule IDs of diagnostics.
+const (
+	CodeSyntax           = "syntax-error"
+	CodeIllegalCharacter = "illegal-character"
+	CodeIllegalNumber    = "illegal-number"
+	CodeIllegalString    = "illegal-string"
+	CodeUnterminated     = "unterminated-comment"
+	CodeUndefined        = "undefined-symbol"
+	CodeNotAssignable    = "not-assignable"
+	CodeArrayUsage       = "array-usage"
+	CodeArraySize        = "array-size"
+	CodeFuncUsage        = "function-usage"
+	CodeProcUsage        = "procedure-usage"
+	CodeArgumentCount    = "argument-count"
+	CodeReturnValue      = "return-value"
+	CodeLoopVariable     = "loop-variable"
+	CodeLoopStep         = "loop-step"
+	CodeConditionUsage   = "condition-usage"
+	CodeBranchUsage      = "branch-usage"
+	CodeCaseLabel        = "case-label"
+	CodeNestingLevel     = "nesting-level"
+)
+
+// Error is a compile error.
+type Error struct {
+	SourceName string
+	Code       string
+	Pos        ast.Pos
+	End        ast.Pos // may be invalid
+	Msg        string
+	Suggestion string    // hint to fix the error, may be empty
+	Edits      []Edit    // edits of the suggestion, may be empty
+	Related    []Related // related locations, may be empty
+}
+
+// Edit replaces the source from Pos to End with NewText.
+type Edit struct {
+	Pos     ast.Pos
+	End     ast.Pos
+	NewText string
+}
+
+// Related is a source location related to an error.
+type Related struct {
+	Pos ast.Pos
+	End ast.Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.SourceName, e.Pos.Line, e.Pos.Column, e.Msg)
+}
+
+// Excerpt returns the source line of the error and a caret
+// pointing the error column.
+func (e *Error) Excerpt(src []byte) string {
+	if !e.Pos.IsValid() || e.Pos.Offset > len(src) {
+		return ""
+	}
+	start := bytes.LastIndexByte(src[:e.Pos.Offset], '\n') + 1
+	end := bytes.IndexByte(src[start:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += start
+	}
+	line := strings.TrimRight(string(src[start:end]), "\r")
+
+	var caret strings.Builder
+	for _, r := range string(src[start:e.Pos.Offset]) {
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return line + "\n" + caret.String()
+}
+
+// Format returns the error message with the source excerpt
+// and the suggestion.
+func (e *Error) Format(src []byte) string {
+	var buf strings.Builder
+	buf.WriteString(e.Error())
+	buf.WriteString("\n")
+	if excerpt := e.Excerpt(src); excerpt != "" {
+		for _, line := range strings.Split(excerpt, "\n") {
+			buf.WriteString("    " + line + "\n")
+		}
+	}
+	if e.Suggestion != "" {
+		buf.WriteString("    suggestion: " + e.Suggestion + "\n")
+	}
+	return buf.String()
+}
+
+func toDiagRange(pos ast.Pos, end ast.Pos) *diag.Range {
+	if !pos.IsValid() {
+		return nil
+	}
+	if !end.IsValid() {
+		end = pos
+	}
+	return &diag.Range{
+		Start: diag.Position{Line: pos.Line, Column: pos.Column},
+		End:   diag.Position{Line: end.Line, Column: end.Column},
+	}
+}
+
+// Diagnostic returns the error as a diagnostic.
+func (e *Error) Diagnostic() diag.Diagnostic {
+	d := diag.Diagnostic{
+		Severity: diag.SeverityError,
+		Code:     e.Code,
+		Message:  e.Msg,
+		Location: diag.Location{File: e.SourceName, Range: toDiagRange(e.Pos, e.End)},
+	}
+	for _, r := range e.Related {
+		d.Related = append(d.Related, diag.RelatedLocation{
+			Location: diag.Location{File: e.SourceName, Range: toDiagRange(r.Pos, r.End)},
+			Message:  r.Msg,
+		})
+	}
+	if e.Suggestion != "" {
+		fix := diag.Fix{Description: e.Suggestion}
+		for _, edit := range e.Edits {
+			fix.Edits = append(fix.Edits, diag.Edit{
+				Range:   *toDiagRange(edit.Pos, edit.End),
+				NewText: edit.NewText,
+			})
+		}
+		d.Fixes = []diag.Fix{fix}
+	}
+	return d
+}
+
+// ErrorList is a list of compile errors.
+type ErrorList []*Error
+
+// Add adds an error.
+func (list *ErrorList) Add(e *Error) {
+	*list = append(*list, e)
+}
+
+// Sort sorts the errors by position.
+func (list ErrorList) Sort() {
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Pos.Before(list[j].Pos)
+	})
+}
+
+// Err returns the list as an error, or nil if the list is empty.
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
+
+// Diagnostics returns the errors as diagnostics.
+func (list ErrorList) Diagnostics() []diag.Diagnostic {
+	diags := make([]diag.Diagnostic, len(list))
+	for i, e := range list {
+		diags[i] = e.Diagnostic()
+	}
+	return diags
+}
+
+// Format returns all error messages with source excerpts.
+func (list ErrorList) Format(src []byte) string {
+	var buf strings.Builder
+	for _, e := range list {
+		buf.WriteString(e.Format(src))
+	}
+	return buf.String()
+}
@@ -0,0 +1,266 @@
+package pl0compiler
+
+import (
+	"math"
+	"strconv"
+
+	"kkpl0/ast"
+	"kkpl0/pl0core"
+)
+
+// foldExpr returns the expression with constant subexpressions folded
+// and algebraic identities simplified:
+//
+//	x + 0, 0 + x, x - 0, x * 1, 1 * x, x / 1  =>  x
+//	x * 0, 0 * x, x - x                       =>  0 (if x has no side effects)
+//	odd c, -c, builtin(c, ...)                =>  constant
+//
+// The syntax tree is not modified; folded nodes are new nodes at the
+// positions of the original ones. Identifiers removed by folding are
+// resolved, so that Info records their uses.
+func (c *Compiler) foldExpr(expr ast.Expr) ast.Expr {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if sym := c.lookup(x); sym != nil && sym.Kind == SymbolConst {
+			c.resolve(x)
+			return newNumber(x, sym.Value)
+		}
+	case *ast.ParenExpr:
+		if isCondition(x) {
+			// not a value, which is reported by genExpr
+			return expr
+		}
+		// parentheses generate no code
+		return c.foldExpr(x.X)
+	case *ast.UnaryExpr:
+		operand := c.foldExpr(x.X)
+		if v, ok := numberValue(operand); ok {
+			switch x.Op {
+			case ast.OpSub:
+				return c.foldedNumber(x, -v, expr)
+			case ast.OpOdd:
+				return newNumber(x, v&1)
+			}
+		}
+		if x.Op == ast.OpAdd {
+			return operand
+		}
+		if operand != x.X {
+			return &ast.UnaryExpr{OpPos: x.OpPos, Op: x.Op, X: operand}
+		}
+	case *ast.BinaryExpr:
+		return c.foldBinary(x)
+	case *ast.IndexExpr:
+		if indices, changed := c.foldList(x.Indices); changed {
+			return &ast.IndexExpr{Name: x.Name, Lbrack: x.Lbrack, Indices: indices, Rbrack: x.Rbrack}
+		}
+	case *ast.CallExpr:
+		var args []ast.Expr
+		var values []int
+		changed := false
+		for _, arg := range x.Args {
+			folded := c.foldExpr(arg)
+			changed = changed || folded != arg
+			args = append(args, folded)
+			if v, ok := numberValue(folded); ok {
+				values = append(values, v)
+			}
+		}
+		if sym := c.lookup(x.Func); sym != nil && sym.Kind == SymbolBuiltin &&
+			len(values) == len(args) && len(args) == sym.Builtin.Params {
+			c.resolve(x.Func)
+			return c.foldedNumber(x, sym.Builtin.Eval(values), expr)
+		}
+		if changed {
+			return &ast.CallExpr{Func: x.Func, Lparen: x.Lparen, Args: args, Rparen: x.Rparen}
+		}
+	}
+	return expr
+}
+
+// foldList folds the expressions, and reports whether any of them changed.
+func (c *Compiler) foldList(list []ast.Expr) ([]ast.Expr, bool) {
+	folded := make([]ast.Expr, len(list))
+	changed := false
+	for i, x := range list {
+		folded[i] = c.foldExpr(x)
+		changed = changed || folded[i] != x
+	}
+	return folded, changed
+}
+
+func (c *Compiler) foldBinary(x *ast.BinaryExpr) ast.Expr {
+	left, right := c.foldExpr(x.X), c.foldExpr(x.Y)
+	l, lok := numberValue(left)
+	r, rok := numberValue(right)
+	if lok && rok {
+		if v, ok := Eval(x.Op, l, r); ok {
+			return c.foldedNumber(x, v, x)
+		}
+	}
+
+	switch {
+	case x.Op == ast.OpAdd && rok && r == 0,
+		x.Op == ast.OpSub && rok && r == 0,
+		x.Op == ast.OpMul && rok && r == 1,
+		x.Op == ast.OpDiv && rok && r == 1:
+		return left
+	case x.Op == ast.OpAdd && lok && l == 0,
+		x.Op == ast.OpMul && lok && l == 1:
+		return right
+	case x.Op == ast.OpMul && rok && r == 0 && c.isPure(left),
+		x.Op == ast.OpMul && lok && l == 0 && c.isPure(right),
+		x.Op == ast.OpSub && c.isPure(left) && sameExpr(left, right):
+		c.resolveAll(left)
+		c.resolveAll(right)
+		return newNumber(x, 0)
+	}
+	if left != x.X || right != x.Y {
+		return &ast.BinaryExpr{X: left, OpPos: x.OpPos, Op: x.Op, Y: right}
+	}
+	return x
+}
+
+// foldedNumber returns the number folded from the node,
+// or orig if the number cannot be a literal of the VM.
+func (c *Compiler) foldedNumber(node ast.Node, v int, orig ast.Expr) ast.Expr {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return orig
+	}
+	return newNumber(node, v)
+}
+
+func newNumber(node ast.Node, v int) *ast.NumberLit {
+	return &ast.NumberLit{ValuePos: node.Pos(), Value: v, Text: strconv.Itoa(v)}
+}
+
+func numberValue(expr ast.Expr) (int, bool) {
+	if n, ok := expr.(*ast.NumberLit); ok {
+		return n.Value, true
+	}
+	return 0, false
+}
+
+// isPure reports whether the folded expression has no side effects and
+// no runtime errors, so that it can be removed.
+func (c *Compiler) isPure(expr ast.Expr) bool {
+	switch x := expr.(type) {
+	case *ast.NumberLit:
+		return true
+	case *ast.Ident:
+		sym := c.lookup(x)
+		return sym != nil && sym.IsScalar()
+	case *ast.UnaryExpr:
+		return c.isPure(x.X)
+	case *ast.BinaryExpr:
+		if d, ok := numberValue(x.Y); (x.Op == ast.OpDiv || x.Op == ast.OpMod) && (!ok || d == 0) {
+			return false
+		}
+		return c.isPure(x.X) && c.isPure(x.Y)
+	case *ast.CallExpr:
+		sym := c.lookup(x.Func)
+		if sym == nil || sym.Kind != SymbolBuiltin || len(x.Args) != sym.Builtin.Params {
+			return false
+		}
+		for _, arg := range x.Args {
+			if !c.isPure(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sameExpr reports whether the folded expressions are the same.
+func sameExpr(a ast.Expr, b ast.Expr) bool {
+	switch x := a.(type) {
+	case *ast.NumberLit:
+		y, ok := b.(*ast.NumberLit)
+		return ok && x.Value == y.Value
+	case *ast.Ident:
+		y, ok := b.(*ast.Ident)
+		return ok && x.Name == y.Name
+	case *ast.UnaryExpr:
+		y, ok := b.(*ast.UnaryExpr)
+		return ok && x.Op == y.Op && sameExpr(x.X, y.X)
+	case *ast.BinaryExpr:
+		y, ok := b.(*ast.BinaryExpr)
+		return ok && x.Op == y.Op && sameExpr(x.X, y.X) && sameExpr(x.Y, y.Y)
+	}
+	return false
+}
+
+// resolveAll resolves the identifiers in the expression removed by folding.
+func (c *Compiler) resolveAll(expr ast.Expr) {
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if id, ok := node.(*ast.Ident); ok {
+			c.resolve(id)
+		}
+		return true
+	})
+}
+
+// Eval returns the result of the binary operator as the VM computes it.
+// Relational operators result in 1 (true) or 0 (false).
+// It returns false for division and modulo by zero.
+func Eval(op ast.Operator, a int, b int) (int, bool) {
+	boolValue := func(cond bool) (int, bool) {
+		if cond {
+			return 1, true
+		}
+		return 0, true
+	}
+	switch op {
+	case ast.OpAdd:
+		return a + b, true
+	case ast.OpSub:
+		return a - b, true
+	case ast.OpMul:
+		return a * b, true
+	case ast.OpDiv:
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	case ast.OpMod:
+		if b == 0 {
+			return 0, false
+		}
+		return a % b, true
+	case ast.OpEq:
+		return boolValue(a == b)
+	case ast.OpNeq:
+		return boolValue(a != b)
+	case ast.OpLs:
+		return boolValue(a < b)
+	case ast.OpGr:
+		return boolValue(a > b)
+	case ast.OpLsEq:
+		return boolValue(a <= b)
+	case ast.OpGrEq:
+		return boolValue(a >= b)
+	}
+	return 0, false
+}
+
+// Eval returns the result of the builtin function as the VM computes it.
+func (b *Builtin) Eval(args []int) int {
+	switch b.OpType {
+	case pl0core.OpTypeABS:
+		if args[0] < 0 {
+			return -args[0]
+		}
+	case pl0core.OpTypeMIN:
+		if args[1] < args[0] {
+			return args[1]
+		}
+	case pl0core.OpTypeMAX:
+		if args[1] > args[0] {
+			return args[1]
+		}
+	case pl0core.OpTypeSQR:
+		return args[0] * args[0]
+	}
+	return args[0]
+}
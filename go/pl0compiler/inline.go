@@ -0,0 +1,165 @@
+package pl0compiler
+
+import (
+	"kkpl0/ast"
+	"kkpl0/pl0core"
+)
+
+// DefaultInlineThreshold is the default of Compiler.InlineThreshold.
+const DefaultInlineThreshold = 50
+
+// inlining is the state of a function body being inlined.
+// Parameters and local variables of the function are remapped into
+// the frame of the caller.
+type inlining struct {
+	symbols map[*Symbol]*Symbol
+	result  pl0core.Address // variable of the result
+	exits   []int           // JMP from return to the end
+}
+
+// checkInlinable records the function as inlinable if it is small,
+// calls no functions but builtin ones, has no nested functions,
+// and returns on all paths.
+// Calling no functions, it is not recursive.
+func (c *Compiler) checkInlinable(decl *ast.FuncDecl, funcSym *Symbol) {
+	if c.InlineThreshold <= 0 || len(c.errors) > 0 || decl.Proc || !Returns(decl.Body.Body) {
+		return
+	}
+	size := 0
+	leaf := true
+	ast.Inspect(decl.Body, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.CallExpr:
+			if sym := c.info.Uses[x.Func]; sym == nil || sym.Kind != SymbolBuiltin {
+				leaf = false
+			}
+		case *ast.FuncDecl:
+			leaf = false
+		case nil:
+			return false
+		}
+		size++
+		return leaf
+	})
+	if leaf && size <= c.InlineThreshold {
+		c.inlinable[funcSym] = decl
+	}
+}
+
+// canInline reports whether the call can be inlined:
+// the callee is inlinable, and the arguments match the parameters.
+func (c *Compiler) canInline(call *ast.CallExpr, funcSym *Symbol) bool {
+	if c.inline != nil || c.inlinable[funcSym] == nil || len(call.Args) != len(funcSym.Params) {
+		return false
+	}
+	for i, arg := range call.Args {
+		if funcSym.Params[i].Kind == SymbolVarParam {
+			if !c.isVarArg(arg) {
+				return false
+			}
+			continue
+		}
+		var argSym *Symbol
+		if id, ok := arg.(*ast.Ident); ok {
+			argSym = c.lookup(id)
+		}
+		isArray := argSym != nil && argSym.IsArrayOrRef()
+		if isArray != (funcSym.Params[i].Kind == SymbolVarRef) {
+			return false
+		}
+		if isArray && argSym.Rank != funcSym.Params[i].Rank {
+			return false
+		}
+	}
+	return true
+}
+
+// compileInlineCall generates the body of the callee in place of the call.
+// The arguments are evaluated in order into the parameters, which are
+// variables in the frame of the caller.
+func (c *Compiler) compileInlineCall(call *ast.CallExpr, funcSym *Symbol) {
+	g := c.generator
+	decl := c.inlinable[funcSym]
+	level := c.symbols.Level()
+	base := c.inlineOffset
+	inline := &inlining{symbols: make(map[*Symbol]*Symbol)}
+	alloc := func(sym *Symbol, size int) *Symbol {
+		remapped := *sym
+		remapped.Address = pl0core.Address{Level: level, Offset: c.inlineOffset}
+		c.inlineOffset += size
+		inline.symbols[sym] = &remapped
+		return &remapped
+	}
+
+	var params []*Symbol
+	for _, param := range funcSym.Params {
+		params = append(params, alloc(param, param.Slots()))
+	}
+	for _, d := range decl.Body.Decls {
+		if vd, ok := d.(*ast.VarDecl); ok {
+			for _, spec := range vd.Specs {
+				sym := c.info.Defs[spec.Name]
+				size := 1
+				if sym.Kind == SymbolVarArray {
+					size = sym.Size
+				}
+				alloc(sym, size)
+			}
+		}
+	}
+	if c.inlineOffset > c.frameSize {
+		c.frameSize = c.inlineOffset
+	}
+
+	for i, arg := range call.Args {
+		g.GenAddr(pl0core.InstructLDA, params[i].Address)
+		id, ok := arg.(*ast.Ident)
+		if params[i].Kind == SymbolVarParam {
+			c.genVarArg(arg, params[i])
+		} else if ok {
+			c.compileIdent(id, true)
+		} else {
+			c.genExpr(arg)
+		}
+		g.GenOpr(pl0core.OpTypeSID)
+		// the sizes of the dimensions of an array reference
+		for k, d := range params[i].SizeDims() {
+			addr := params[i].Address
+			addr.Offset += 1 + k
+			g.GenAddr(pl0core.InstructLDA, addr)
+			c.genDimSize(c.lookup(id), d)
+			g.GenOpr(pl0core.OpTypeSID)
+		}
+	}
+
+	// break and continue are of the loops in the body
+	loops := c.loops
+	c.inline, c.loops = inline, nil
+	if ret, ok := decl.Body.Body.(*ast.ReturnStmt); ok {
+		// the result is left on the stack
+		c.compileExpr(ret.Result)
+	} else {
+		inline.result = pl0core.Address{Level: level, Offset: c.inlineOffset}
+		c.inlineOffset++
+		if c.inlineOffset > c.frameSize {
+			c.frameSize = c.inlineOffset
+		}
+		c.compileStatement(decl.Body.Body, funcSym)
+		for _, index := range inline.exits {
+			g.BackPatch(index)
+		}
+		g.GenAddr(pl0core.InstructLOD, inline.result)
+	}
+	c.inline, c.loops = nil, loops
+	c.inlineOffset = base
+}
+
+// compileInlineReturn generates return in an inlined body,
+// which stores the result and jumps to the end of the body.
+func (c *Compiler) compileInlineReturn(ret *ast.ReturnStmt) {
+	g := c.generator
+	g.GenAddr(pl0core.InstructLDA, c.inline.result)
+	c.compileExpr(ret.Result)
+	g.GenOpr(pl0core.OpTypeSID)
+	c.inline.exits = append(c.inline.exits, g.GenValue(pl0core.InstructJMP, 0))
+}
@@ -0,0 +1,813 @@
+package pl0compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"kkpl0/ast"
+)
+
+// Parser is PL/0 parser which builds a syntax tree.
+//
+// On syntax errors, the parser recovers in panic mode as described in
+// chapter 7 of the book: a missing token is assumed to be inserted,
+// a wrong symbol is assumed to be replaced, and otherwise tokens are skipped
+// until a token in the synchronizing set of the construct.
+type Parser struct {
+	scanner *Scanner
+	dialect Dialect
+	token   *Token
+	prev    *Token // previous token
+	errors  ErrorList
+
+	comments []*ast.Comment // comments read
+
+	numTokens    int // number of tokens read
+	errorAtToken int // numTokens at the last error
+}
+
+// Parse parses a kk-PL/0 source and returns its syntax tree.
+// If the source has syntax errors, the syntax tree containing
+// ast.BadExpr and ast.BadStmt is returned with ErrorList.
+func Parse(sourceName string, src []byte) (*ast.Program, error) {
+	return ParseDialect(sourceName, src, DialectKK)
+}
+
+// ParseDialect is like Parse, but parses the source in the dialect.
+func ParseDialect(sourceName string, src []byte, dialect Dialect) (*ast.Program, error) {
+	p := &Parser{dialect: dialect}
+	p.scanner = NewDialectScanner(sourceName, src, func(e *Error) {
+		p.errors.Add(e)
+		// suppress a syntax error at the token being read
+		p.errorAtToken = p.numTokens + 1
+	}, dialect)
+	return p.Parse()
+}
+
+// Parse parses a program.
+func (p *Parser) Parse() (*ast.Program, error) {
+	p.nextToken()
+	prog := &ast.Program{Block: p.parseBlock(), Period: p.token.Pos}
+	if p.token.Kind != TokenPeriod {
+		if p.token.Kind == TokenEOF {
+			pos := p.prevEnd()
+			p.report(&Error{
+				Pos:        pos,
+				End:        pos,
+				Msg:        "'.' required.",
+				Suggestion: "insert '.' at the end",
+				Edits:      []Edit{{Pos: pos, End: pos, NewText: "."}},
+			})
+			prog.Period = pos
+		} else {
+			p.error("'.' required.")
+		}
+	} else {
+		// comments after the program
+		p.comments = append(p.comments, p.scanner.ScanComments()...)
+	}
+	prog.Comments = p.comments
+	p.errors.Sort()
+	return prog, p.errors.Err()
+}
+
+// tokenSet is a set of token kinds.
+type tokenSet uint64
+
+func newTokenSet(kinds ...TokenKind) tokenSet {
+	var set tokenSet
+	for _, kind := range kinds {
+		set |= 1 << uint(kind)
+	}
+	return set
+}
+
+func (set tokenSet) has(kind TokenKind) bool {
+	return set&(1<<uint(kind)) != 0
+}
+
+// synchronizing sets
+var (
+	statementBegin = newTokenSet(TokenIdent, TokenBegin, TokenIf, TokenWhile,
+		TokenRepeat, TokenFor, TokenBreak, TokenContinue, TokenCase, TokenReturn, TokenWrite, TokenWriteln, TokenCall,
+		TokenQuestion, TokenExclamation)
+	statementFollow = newTokenSet(TokenSemicolon, TokenEnd, TokenPeriod,
+		TokenElse, TokenUntil, TokenEOF)
+	declBegin   = newTokenSet(TokenConst, TokenVar, TokenFunc, TokenProcedure)
+	factorBegin = newTokenSet(TokenIdent, TokenNumber, TokenLParen)
+	relOps      = newTokenSet(TokenEqual, TokenNotEqual, TokenGt, TokenGtEq,
+		TokenLt, TokenLtEq)
+	exprFollow = statementFollow | relOps | newTokenSet(TokenRParen,
+		TokenRBracket, TokenComma, TokenThen, TokenDo, TokenTo, TokenDownto, TokenStep,
+		TokenAnd, TokenOr, TokenColon, TokenOf)
+	// tokens which are never replaced by expected tokens
+	keyTokens = statementBegin | statementFollow | declBegin |
+		newTokenSet(TokenThen, TokenDo, TokenTo, TokenDownto, TokenStep, TokenOf,
+			TokenAnd, TokenOr, TokenRParen, TokenRBracket)
+)
+
+// report reports a syntax error.
+// It reports at most one error until the next token is read
+// to avoid cascaded errors.
+func (p *Parser) report(e *Error) {
+	if len(p.errors) > 0 && p.errorAtToken == p.numTokens {
+		return
+	}
+	p.errorAtToken = p.numTokens
+	e.SourceName = p.scanner.SourceName()
+	e.Code = CodeSyntax
+	p.errors.Add(e)
+}
+
+// error reports an error at the current token.
+func (p *Parser) error(format string, args ...interface{}) {
+	p.report(&Error{Pos: p.token.Pos, End: p.token.End(), Msg: fmt.Sprintf(format, args...)})
+}
+
+// errorReplace reports an error at the current token,
+// which should be replaced with text.
+func (p *Parser) errorReplace(text string, format string, args ...interface{}) {
+	p.report(&Error{
+		Pos:        p.token.Pos,
+		End:        p.token.End(),
+		Msg:        fmt.Sprintf(format, args...),
+		Suggestion: fmt.Sprintf("replace '%s' with '%s'", p.token, text),
+		Edits:      []Edit{{Pos: p.token.Pos, End: p.token.End(), NewText: text}},
+	})
+}
+
+// errorInsert reports an error that text is missing before the current token.
+func (p *Parser) errorInsert(text string, format string, args ...interface{}) {
+	pos := p.prevEnd()
+	newText := text
+	if isLetter(text[0]) {
+		newText = " " + text
+	}
+	p.report(&Error{
+		Pos:        pos,
+		End:        pos,
+		Msg:        fmt.Sprintf(format, args...),
+		Suggestion: fmt.Sprintf("insert '%s' before '%s'", text, p.token),
+		Edits:      []Edit{{Pos: pos, End: pos, NewText: newText}},
+	})
+}
+
+// errorElse reports an error at 'else' of the current token.
+// The previous ';' is suggested to be removed.
+func (p *Parser) errorElse(format string, args ...interface{}) {
+	e := &Error{Pos: p.token.Pos, End: p.token.End(), Msg: fmt.Sprintf(format, args...)}
+	if p.prev != nil && p.prev.Kind == TokenSemicolon {
+		e.Suggestion = "remove ';' before 'else'"
+		e.Edits = []Edit{{Pos: p.prev.Pos, End: p.prev.End(), NewText: ""}}
+	}
+	p.report(e)
+}
+
+func (p *Parser) nextToken() *Token {
+	p.prev = p.token
+	p.token = p.scanner.NextToken()
+	p.comments = append(p.comments, p.token.Comments...)
+	p.numTokens++
+	return p.token
+}
+
+// prevEnd returns the end position of the previous token.
+func (p *Parser) prevEnd() ast.Pos {
+	if p.prev == nil {
+		return p.token.Pos
+	}
+	return p.prev.End()
+}
+
+// skipTo skips tokens until a token in the set or EOF.
+func (p *Parser) skipTo(set tokenSet) {
+	for !set.has(p.token.Kind) && p.token.Kind != TokenEOF {
+		p.nextToken()
+	}
+}
+
+func isReplaceable(expected TokenKind, actual TokenKind) bool {
+	if keyTokens.has(actual) {
+		return false
+	}
+	isSymbol := func(kind TokenKind) bool { return kind >= TokenPeriod }
+	return isSymbol(expected) && isSymbol(actual) ||
+		expected.IsReservedWord() && actual.IsReservedWord()
+}
+
+// expect checks the current token and reads the next token.
+// It returns the checked token, or nil if the token is missing.
+func (p *Parser) expect(expected TokenKind) *Token {
+	token := p.token
+	if token.Kind == expected {
+		p.nextToken()
+		return token
+	}
+	if isReplaceable(expected, token.Kind) {
+		p.errorReplace(expected.String(), "Expected '%s' but was '%s'", expected, token)
+		p.nextToken()
+		return nil
+	}
+	p.errorInsert(expected.String(), "Expected '%s' but was '%s'", expected, token)
+	return nil
+}
+
+// expectPos is the same as expect, but returns the position of the token.
+func (p *Parser) expectPos(expected TokenKind) ast.Pos {
+	if token := p.expect(expected); token != nil {
+		return token.Pos
+	}
+	return p.prevEnd()
+}
+
+func expectedList(expected ...TokenKind) string {
+	cand := make([]string, len(expected)-1)
+	for i, kind := range expected[:len(expected)-1] {
+		cand[i] = kind.String()
+	}
+	return fmt.Sprintf("'%s' or '%s'",
+		strings.Join(cand, "', '"), expected[len(expected)-1])
+}
+
+// parseIdent parses an identifier.
+// A missing identifier has an empty name.
+func (p *Parser) parseIdent() *ast.Ident {
+	if token := p.expect(TokenIdent); token != nil {
+		return &ast.Ident{NamePos: token.Pos, Name: token.Text}
+	}
+	return &ast.Ident{NamePos: p.prevEnd()}
+}
+
+// parseNumber parses a number.
+// A missing number is 0 with empty text.
+func (p *Parser) parseNumber() *ast.NumberLit {
+	if p.token.Kind == TokenIdent {
+		// replace the identifier
+		token := p.token
+		p.error("Expected '%s' but was '%s'", TokenNumber, token)
+		p.nextToken()
+		return &ast.NumberLit{ValuePos: token.Pos, Text: token.Text}
+	}
+	if token := p.expect(TokenNumber); token != nil {
+		return &ast.NumberLit{ValuePos: token.Pos, Value: token.Number, Text: token.Text}
+	}
+	return &ast.NumberLit{ValuePos: p.prevEnd()}
+}
+
+// continueList reports whether the list continues after a ','.
+// A missing ',' between identifiers is assumed to be inserted.
+func (p *Parser) continueList() bool {
+	switch p.token.Kind {
+	case TokenComma:
+		p.nextToken()
+		return true
+	case TokenIdent:
+		p.errorInsert(",", "Expected ',' or ';' but was '%s'", p.token)
+		return true
+	}
+	return false
+}
+
+func (p *Parser) parseBlock() *ast.Block {
+	block := new(ast.Block)
+	for declBegin.has(p.token.Kind) {
+		var decl ast.Decl
+		switch p.token.Kind {
+		case TokenVar:
+			decl = p.parseVarDecl()
+		case TokenConst:
+			decl = p.parseConstDecl()
+		case TokenFunc:
+			decl = p.parseFuncDecl()
+		case TokenProcedure:
+			decl = p.parseProcDecl()
+		}
+		block.Decls = append(block.Decls, decl)
+	}
+	block.Body = p.parseStatement()
+	return block
+}
+
+func (p *Parser) parseConstDecl() *ast.ConstDecl {
+	decl := &ast.ConstDecl{Const: p.token.Pos}
+	p.nextToken()
+	for {
+		spec := &ast.ConstSpec{Name: p.parseIdent()}
+		p.expect(TokenEqual)
+		spec.Value = p.parseNumber()
+		decl.Specs = append(decl.Specs, spec)
+		if !p.continueList() {
+			break
+		}
+	}
+	decl.Semicolon = p.expectPos(TokenSemicolon)
+	return decl
+}
+
+func (p *Parser) parseVarDecl() *ast.VarDecl {
+	decl := &ast.VarDecl{Var: p.token.Pos}
+	p.nextToken()
+	for {
+		spec := &ast.VarSpec{Name: p.parseIdent()}
+		if p.token.Kind == TokenLBracket {
+			// array variable
+			p.nextToken()
+			for {
+				if p.token.Kind == TokenIdent {
+					spec.Sizes = append(spec.Sizes, p.parseIdent())
+				} else {
+					spec.Sizes = append(spec.Sizes, p.parseNumber())
+				}
+				if p.token.Kind != TokenComma {
+					break
+				}
+				p.nextToken()
+			}
+			spec.Rbrack = p.expectPos(TokenRBracket)
+		}
+		decl.Specs = append(decl.Specs, spec)
+		if !p.continueList() {
+			break
+		}
+	}
+	decl.Semicolon = p.expectPos(TokenSemicolon)
+	return decl
+}
+
+func (p *Parser) parseFuncDecl() *ast.FuncDecl {
+	decl := &ast.FuncDecl{Func: p.token.Pos}
+	p.nextToken()
+	decl.Name = p.parseIdent()
+	decl.Params = p.parseParams()
+	decl.Body = p.parseBlock()
+	decl.Semicolon = p.expectPos(TokenSemicolon)
+	return decl
+}
+
+// parseProcDecl parses 'procedure' Name '(' Params ')' Body ';',
+// or 'procedure' Name ';' Body ';' of Wirth's PL/0.
+func (p *Parser) parseProcDecl() *ast.FuncDecl {
+	decl := &ast.FuncDecl{Func: p.token.Pos, Proc: true}
+	p.nextToken()
+	decl.Name = p.parseIdent()
+	if p.dialect == DialectWirth {
+		p.expect(TokenSemicolon)
+	} else {
+		decl.Params = p.parseParams()
+	}
+	decl.Body = p.parseBlock()
+	decl.Semicolon = p.expectPos(TokenSemicolon)
+	return decl
+}
+
+func (p *Parser) parseParams() []*ast.Param {
+	var params []*ast.Param
+	p.expect(TokenLParen)
+	if p.token.Kind == TokenIdent || p.token.Kind == TokenVar && p.dialect == DialectKK {
+		for {
+			param := new(ast.Param)
+			if p.token.Kind == TokenVar && p.dialect == DialectKK {
+				param.Var = p.token.Pos
+				p.nextToken()
+			}
+			param.Name = p.parseIdent()
+			if p.token.Kind == TokenLBracket {
+				p.nextToken()
+				param.Ref = true
+				param.Rank = 1
+				for p.token.Kind == TokenComma {
+					p.nextToken()
+					param.Rank++
+				}
+				param.Rbrack = p.expectPos(TokenRBracket)
+			}
+			params = append(params, param)
+			if !p.continueList() {
+				break
+			}
+		}
+	}
+	p.expect(TokenRParen)
+	return params
+}
+
+func (p *Parser) parseStatement() ast.Stmt {
+	switch p.token.Kind {
+	case TokenEnd, TokenPeriod:
+		return &ast.EmptyStmt{At: p.token.Pos}
+	case TokenIdent:
+		name := p.parseIdent()
+		if p.token.Kind == TokenLParen && p.dialect != DialectWirth {
+			// procedure call without 'call'
+			return &ast.CallStmt{X: p.parseFuncCall(name)}
+		}
+		stmt := &ast.AssignStmt{Name: name}
+		if p.token.Kind == TokenLBracket {
+			p.nextToken()
+			stmt.Indices = p.parseIndices()
+			p.expect(TokenRBracket)
+		}
+		stmt.Assign = p.expectPos(TokenAssign)
+		stmt.Value = p.parseExpr()
+		return stmt
+	case TokenBegin:
+		return p.parseCompoundStatement()
+	case TokenIf:
+		stmt := &ast.IfStmt{If: p.token.Pos}
+		p.nextToken()
+		stmt.Cond = p.parseCondition()
+		p.expect(TokenThen)
+		stmt.Then = p.parseStatement()
+		if p.token.Kind == TokenElse {
+			p.nextToken()
+			stmt.Else = p.parseStatement()
+		}
+		return stmt
+	case TokenWhile:
+		stmt := &ast.WhileStmt{While: p.token.Pos}
+		p.nextToken()
+		stmt.Cond = p.parseCondition()
+		p.expect(TokenDo)
+		stmt.Body = p.parseStatement()
+		return stmt
+	case TokenRepeat:
+		stmt := &ast.RepeatStmt{Repeat: p.token.Pos}
+		p.nextToken()
+		stmt.Body = p.parseStatement()
+		p.expect(TokenUntil)
+		stmt.Cond = p.parseCondition()
+		return stmt
+	case TokenFor:
+		stmt := &ast.ForStmt{For: p.token.Pos}
+		p.nextToken()
+		stmt.Var = p.parseIdent()
+		p.expect(TokenAssign)
+		stmt.From = p.parseExpr()
+		if p.token.Kind == TokenDownto {
+			stmt.Down = true
+			p.nextToken()
+		} else {
+			p.expect(TokenTo)
+		}
+		stmt.To = p.parseExpr()
+		if p.token.Kind == TokenStep {
+			p.nextToken()
+			stmt.Step = p.parseExpr()
+		}
+		p.expect(TokenDo)
+		stmt.Body = p.parseStatement()
+		return stmt
+	case TokenBreak, TokenContinue:
+		stmt := &ast.BranchStmt{Branch: p.token.Pos, Continue: p.token.Kind == TokenContinue}
+		p.nextToken()
+		return stmt
+	case TokenCase:
+		return p.parseCaseStatement()
+	case TokenReturn:
+		stmt := &ast.ReturnStmt{Return: p.token.Pos}
+		p.nextToken()
+		if !statementFollow.has(p.token.Kind) {
+			stmt.Result = p.parseExpr()
+		}
+		return stmt
+	case TokenWrite:
+		stmt := &ast.WriteStmt{Write: p.token.Pos}
+		p.nextToken()
+		if p.dialect == DialectKK {
+			stmt.Items = p.parseWriteItems()
+		} else {
+			stmt.Items = []ast.Expr{p.parseExpr()}
+		}
+		return stmt
+	case TokenWriteln:
+		stmt := &ast.WritelnStmt{Writeln: p.token.Pos}
+		p.nextToken()
+		if p.dialect == DialectKK && !statementFollow.has(p.token.Kind) {
+			stmt.Items = p.parseWriteItems()
+		}
+		return stmt
+	case TokenCall:
+		stmt := &ast.CallStmt{Call: p.token.Pos}
+		p.nextToken()
+		name := p.parseIdent()
+		if p.dialect == DialectWirth {
+			stmt.X = &ast.CallExpr{Func: name}
+		} else {
+			stmt.X = p.parseFuncCall(name)
+		}
+		return stmt
+	case TokenQuestion:
+		stmt := &ast.InputStmt{Question: p.token.Pos}
+		p.nextToken()
+		stmt.Name = p.parseIdent()
+		return stmt
+	case TokenExclamation:
+		stmt := &ast.OutputStmt{Exclamation: p.token.Pos}
+		p.nextToken()
+		stmt.X = p.parseExpr()
+		return stmt
+	}
+
+	from := p.token.Pos
+	if p.token.Kind == TokenElse {
+		p.errorElse("Unexpected token: %s", p.token)
+	} else {
+		p.error("Unexpected token: %s", p.token)
+	}
+	if !statementFollow.has(p.token.Kind) {
+		p.skipTo(statementBegin | statementFollow)
+		if statementBegin.has(p.token.Kind) {
+			return p.parseStatement()
+		}
+	}
+	return &ast.BadStmt{From: from, To: p.token.Pos}
+}
+
+func (p *Parser) parseCompoundStatement() *ast.CompoundStmt {
+	stmt := &ast.CompoundStmt{Begin: p.token.Pos}
+	p.nextToken()
+	stmt.List = append(stmt.List, p.parseStatement())
+	for {
+		switch {
+		case p.token.Kind == TokenSemicolon:
+			p.nextToken()
+		case statementBegin.has(p.token.Kind):
+			p.errorInsert(";", "Expected ';' or 'end' but was '%s'", p.token)
+		case p.token.Kind == TokenEnd || p.token.Kind == TokenPeriod ||
+			p.token.Kind == TokenEOF:
+			stmt.EndPos = p.expectPos(TokenEnd)
+			return stmt
+		default:
+			if p.token.Kind == TokenElse {
+				p.errorElse("Expected ';' or 'end' but was '%s'", p.token)
+			} else {
+				p.error("Expected ';' or 'end' but was '%s'", p.token)
+			}
+			p.nextToken()
+			p.skipTo(statementBegin | statementFollow)
+			if !statementBegin.has(p.token.Kind) {
+				continue
+			}
+		}
+		stmt.List = append(stmt.List, p.parseStatement())
+	}
+}
+
+// parseCaseStatement parses 'case' X 'of' Clauses ['else' Else] 'end'.
+// The ';' after the last clause is optional.
+func (p *Parser) parseCaseStatement() *ast.CaseStmt {
+	stmt := &ast.CaseStmt{Case: p.token.Pos}
+	p.nextToken()
+	stmt.X = p.parseExpr()
+	p.expect(TokenOf)
+	for p.token.Kind != TokenElse && p.token.Kind != TokenEnd &&
+		p.token.Kind != TokenPeriod && p.token.Kind != TokenEOF {
+		clause := new(ast.CaseClause)
+		for {
+			clause.Labels = append(clause.Labels, p.parseExpr())
+			if p.token.Kind != TokenComma {
+				break
+			}
+			p.nextToken()
+		}
+		clause.Colon = p.expectPos(TokenColon)
+		clause.Body = p.parseStatement()
+		stmt.Clauses = append(stmt.Clauses, clause)
+		if p.token.Kind != TokenSemicolon {
+			break
+		}
+		p.nextToken()
+	}
+	if len(stmt.Clauses) == 0 {
+		p.error("Expected a label of case but was '%s'", p.token)
+	}
+	if p.token.Kind == TokenElse {
+		p.nextToken()
+		stmt.Else = p.parseStatement()
+	}
+	stmt.EndPos = p.expectPos(TokenEnd)
+	return stmt
+}
+
+var relationalOperators = map[TokenKind]ast.Operator{
+	TokenEqual:    ast.OpEq,
+	TokenNotEqual: ast.OpNeq,
+	TokenGt:       ast.OpGr,
+	TokenGtEq:     ast.OpGrEq,
+	TokenLt:       ast.OpLs,
+	TokenLtEq:     ast.OpLsEq,
+}
+
+// parseCondition parses a condition:
+//
+//	<condition> ::= <cond_term> ['or' <cond_term>]*
+//	<cond_term> ::= <cond_factor> ['and' <cond_factor>]*
+//	<cond_factor> ::= 'not' <cond_factor>
+//	                | 'odd' <expr>
+//	                | <expr> <cond_op> <expr>
+//	                | '(' <condition> ')'
+func (p *Parser) parseCondition() ast.Expr {
+	return p.parseOr(false)
+}
+
+// parseOr parses a condition. In parentheses, which may enclose either
+// a condition or an expression, an expression is returned as it is.
+func (p *Parser) parseOr(inParen bool) ast.Expr {
+	x := p.parseAnd(inParen)
+	for p.token.Kind == TokenOr {
+		binary := &ast.BinaryExpr{X: x, OpPos: p.token.Pos, Op: ast.OpOr}
+		p.nextToken()
+		binary.Y = p.parseAnd(false)
+		x = binary
+	}
+	return x
+}
+
+func (p *Parser) parseAnd(inParen bool) ast.Expr {
+	x := p.parseNot(inParen)
+	for p.token.Kind == TokenAnd {
+		binary := &ast.BinaryExpr{X: x, OpPos: p.token.Pos, Op: ast.OpAnd}
+		p.nextToken()
+		binary.Y = p.parseNot(false)
+		x = binary
+	}
+	return x
+}
+
+func (p *Parser) parseNot(inParen bool) ast.Expr {
+	switch p.token.Kind {
+	case TokenNot:
+		cond := &ast.UnaryExpr{OpPos: p.token.Pos, Op: ast.OpNot}
+		p.nextToken()
+		cond.X = p.parseNot(false)
+		return cond
+	case TokenOdd:
+		cond := &ast.UnaryExpr{OpPos: p.token.Pos, Op: ast.OpOdd}
+		p.nextToken()
+		cond.X = p.parseExpr()
+		return cond
+	}
+	x := p.parseExpr()
+	if !relOps.has(p.token.Kind) {
+		if isCondition(x) ||
+			inParen && p.token.Kind != TokenAnd && p.token.Kind != TokenOr {
+			return x
+		}
+		expected := expectedList(TokenEqual, TokenNotEqual, TokenGt, TokenGtEq,
+			TokenLt, TokenLtEq)
+		if p.token.Kind != TokenAssign {
+			p.error("Expected %s but was '%s'", expected, p.token)
+			return x
+		}
+		// replace ':=' with '='
+		p.errorReplace("=", "Expected %s but was '%s'", expected, p.token)
+		p.token.Kind = TokenEqual
+	}
+	cond := &ast.BinaryExpr{X: x, OpPos: p.token.Pos, Op: relationalOperators[p.token.Kind]}
+	p.nextToken()
+	cond.Y = p.parseExpr()
+	return cond
+}
+
+// isCondition reports whether the expression is a condition
+// in parentheses.
+func isCondition(x ast.Expr) bool {
+	paren, ok := x.(*ast.ParenExpr)
+	if !ok {
+		return false
+	}
+	switch x := paren.X.(type) {
+	case *ast.ParenExpr:
+		return isCondition(x)
+	case *ast.BinaryExpr:
+		return x.Op.IsRelational() || x.Op.IsLogical()
+	case *ast.UnaryExpr:
+		return x.Op == ast.OpOdd || x.Op == ast.OpNot
+	}
+	return false
+}
+
+func (p *Parser) parseExpr() ast.Expr {
+	var x ast.Expr
+	if p.token.Kind == TokenPlus || p.token.Kind == TokenMinus {
+		unary := &ast.UnaryExpr{OpPos: p.token.Pos, Op: ast.Operator(p.token.Text)}
+		p.nextToken()
+		unary.X = p.parseTerm()
+		x = unary
+	} else {
+		x = p.parseTerm()
+	}
+
+	for p.token.Kind == TokenPlus || p.token.Kind == TokenMinus {
+		binary := &ast.BinaryExpr{X: x, OpPos: p.token.Pos, Op: ast.Operator(p.token.Text)}
+		p.nextToken()
+		binary.Y = p.parseTerm()
+		x = binary
+	}
+	return x
+}
+
+func (p *Parser) parseTerm() ast.Expr {
+	x := p.parseFactor()
+	for p.token.Kind == TokenMul || p.token.Kind == TokenDiv || p.token.Kind == TokenMod {
+		binary := &ast.BinaryExpr{X: x, OpPos: p.token.Pos, Op: ast.Operator(p.token.Text)}
+		p.nextToken()
+		binary.Y = p.parseFactor()
+		x = binary
+	}
+	return x
+}
+
+func (p *Parser) parseFactor() ast.Expr {
+	switch p.token.Kind {
+	case TokenIdent:
+		return p.parseFactorIdent()
+	case TokenNumber:
+		return p.parseNumber()
+	case TokenLParen:
+		x := &ast.ParenExpr{Lparen: p.token.Pos}
+		p.nextToken()
+		if p.dialect == DialectKK {
+			// a condition, or an expression
+			x.X = p.parseOr(true)
+		} else {
+			x.X = p.parseExpr()
+		}
+		x.Rparen = p.expectPos(TokenRParen)
+		return x
+	}
+
+	from := p.token.Pos
+	p.error("Unexpected token '%s'", p.token)
+	if !(exprFollow | statementBegin).has(p.token.Kind) {
+		p.skipTo(exprFollow | statementBegin | factorBegin)
+		if factorBegin.has(p.token.Kind) {
+			return p.parseFactor()
+		}
+	}
+	return &ast.BadExpr{From: from, To: p.token.Pos}
+}
+
+func (p *Parser) parseFactorIdent() ast.Expr {
+	name := p.parseIdent()
+	switch p.token.Kind {
+	case TokenLBracket:
+		// array element
+		x := &ast.IndexExpr{Name: name, Lbrack: p.token.Pos}
+		p.nextToken()
+		x.Indices = p.parseIndices()
+		x.Rbrack = p.expectPos(TokenRBracket)
+		return x
+	case TokenLParen:
+		if p.dialect != DialectWirth {
+			return p.parseFuncCall(name)
+		}
+	}
+	return name
+}
+
+func (p *Parser) parseIndices() []ast.Expr {
+	indices := []ast.Expr{p.parseExpr()}
+	for p.token.Kind == TokenComma {
+		p.nextToken()
+		indices = append(indices, p.parseExpr())
+	}
+	return indices
+}
+
+func (p *Parser) parseWriteItems() []ast.Expr {
+	var items []ast.Expr
+	for {
+		if p.token.Kind == TokenString {
+			items = append(items, &ast.StringLit{ValuePos: p.token.Pos, Value: p.token.Value, Text: p.token.Text})
+			p.nextToken()
+		} else {
+			x := p.parseExpr()
+			if p.token.Kind == TokenColon {
+				colon := p.token.Pos
+				p.nextToken()
+				x = &ast.WidthExpr{X: x, Colon: colon, Width: p.parseExpr()}
+			}
+			items = append(items, x)
+		}
+		if p.token.Kind != TokenComma {
+			return items
+		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseFuncCall(name *ast.Ident) *ast.CallExpr {
+	x := &ast.CallExpr{Func: name, Lparen: p.expectPos(TokenLParen)}
+	if p.token.Kind != TokenRParen {
+		for {
+			x.Args = append(x.Args, p.parseExpr())
+			if p.token.Kind != TokenComma {
+				break
+			}
+			p.nextToken()
+		}
+	}
+	x.Rparen = p.expectPos(TokenRParen)
+	return x
+}
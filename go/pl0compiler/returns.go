@@ -0,0 +1,59 @@
+package pl0compiler
+
+import "kkpl0/ast"
+
+// Returns reports whether the statement returns on all paths.
+// A repeat loop returns if its body does and has no break or continue;
+// other loops may not run their bodies.
+func Returns(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.CompoundStmt:
+		for _, child := range s.List {
+			if Returns(child) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		return s.Else != nil && Returns(s.Then) && Returns(s.Else)
+	case *ast.CaseStmt:
+		if s.Else == nil || !Returns(s.Else) {
+			return false
+		}
+		for _, clause := range s.Clauses {
+			if !Returns(clause.Body) {
+				return false
+			}
+		}
+		return true
+	case *ast.RepeatStmt:
+		return Returns(s.Body) && !branches(s.Body)
+	}
+	return false
+}
+
+// branches reports whether the statement has break or continue of the
+// enclosing loop, which may leave the loop without return.
+func branches(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.BranchStmt:
+		return true
+	case *ast.CompoundStmt:
+		for _, child := range s.List {
+			if branches(child) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		return branches(s.Then) || s.Else != nil && branches(s.Else)
+	case *ast.CaseStmt:
+		for _, clause := range s.Clauses {
+			if branches(clause.Body) {
+				return true
+			}
+		}
+		return s.Else != nil && branches(s.Else)
+	}
+	return false
+}
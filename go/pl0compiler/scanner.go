@@ -0,0 +1,264 @@
+package pl0compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"kkpl0/ast"
+)
+
+// ErrorHandler is called for each error.
+type ErrorHandler func(e *Error)
+
+// Scanner is PL/0 lexical scanner.
+type Scanner struct {
+	sourceName string
+	src        []byte
+	errh       ErrorHandler
+	dialect    Dialect
+	offset     int // offset of the next character
+	line       int
+	column     int
+}
+
+// NewScanner creates a Scanner instance of kk-PL/0.
+// Errors are reported to errh, and scanning continues after them.
+func NewScanner(sourceName string, src []byte, errh ErrorHandler) *Scanner {
+	return NewDialectScanner(sourceName, src, errh, DialectKK)
+}
+
+// NewDialectScanner creates a Scanner instance of the dialect.
+// Reserved words of other dialects are read as identifiers.
+func NewDialectScanner(sourceName string, src []byte, errh ErrorHandler, dialect Dialect) *Scanner {
+	return &Scanner{sourceName: sourceName, src: src, errh: errh, dialect: dialect, line: 1, column: 1}
+}
+
+// SourceName returns the source name.
+func (s *Scanner) SourceName() string {
+	return s.sourceName
+}
+
+// NextToken reads the next token.
+// Comments before the token are returned in Token.Comments.
+func (s *Scanner) NextToken() *Token {
+	for {
+		comments := s.ScanComments()
+		pos := s.pos()
+		if s.offset >= len(s.src) {
+			return &Token{Kind: TokenEOF, Pos: pos, Comments: comments}
+		}
+
+		var token *Token
+		ch := s.src[s.offset]
+		switch {
+		case isLetter(ch):
+			token = s.readIdent(pos)
+		case isDigit(ch):
+			token = s.readNumber(pos)
+		case ch == '"' && s.dialect.hasToken(TokenString, ""):
+			token = s.readString(pos)
+		default:
+			token = s.readMeta(pos)
+		}
+		if token != nil {
+			token.Comments = comments
+			return token
+		}
+	}
+}
+
+// ScanComments skips white spaces and comments, and returns the comments.
+// Block comments '{' ... '}' and '(*' ... '*)' may be nested.
+func (s *Scanner) ScanComments() []*ast.Comment {
+	var comments []*ast.Comment
+	for s.offset < len(s.src) {
+		if isSpace(s.src[s.offset]) {
+			s.nextChar()
+			continue
+		}
+		pos := s.pos()
+		switch {
+		case s.lookingAt("//"):
+			for s.offset < len(s.src) && s.src[s.offset] != '\n' {
+				s.nextChar()
+			}
+		case s.lookingAt("{"):
+			s.skipBlockComment(pos, "{", "}")
+		case s.lookingAt("(*"):
+			s.skipBlockComment(pos, "(*", "*)")
+		default:
+			return comments
+		}
+		comments = append(comments, &ast.Comment{
+			From: pos,
+			To:   s.pos(),
+			Text: string(s.src[pos.Offset:s.offset]),
+		})
+	}
+	return comments
+}
+
+// skipBlockComment skips a block comment which may contain nested comments.
+func (s *Scanner) skipBlockComment(pos ast.Pos, open string, close string) {
+	depth := 0
+	for s.offset < len(s.src) {
+		switch {
+		case s.lookingAt(open):
+			depth++
+			s.skip(open)
+		case s.lookingAt(close):
+			depth--
+			s.skip(close)
+			if depth == 0 {
+				return
+			}
+		default:
+			s.nextChar()
+		}
+	}
+	s.error(CodeUnterminated, pos, "Unterminated comment starting at line %d", pos.Line)
+}
+
+// lookingAt reports whether the source at the current position starts with text.
+func (s *Scanner) lookingAt(text string) bool {
+	return bytes.HasPrefix(s.src[s.offset:], []byte(text))
+}
+
+// skip skips text at the current position.
+func (s *Scanner) skip(text string) {
+	for i := 0; i < len(text); i++ {
+		s.nextChar()
+	}
+}
+
+// error reports an error from pos to the current position.
+func (s *Scanner) error(code string, pos ast.Pos, format string, args ...interface{}) {
+	if s.errh != nil {
+		s.errh(&Error{
+			SourceName: s.sourceName,
+			Code:       code,
+			Pos:        pos,
+			End:        s.pos(),
+			Msg:        fmt.Sprintf(format, args...),
+		})
+	}
+}
+
+func (s *Scanner) pos() ast.Pos {
+	return ast.Pos{Offset: s.offset, Line: s.line, Column: s.column}
+}
+
+func (s *Scanner) nextChar() {
+	if s.src[s.offset] == '\n' {
+		s.line++
+		s.column = 1
+		s.offset++
+		return
+	}
+	_, size := utf8.DecodeRune(s.src[s.offset:])
+	s.offset += size
+	s.column++
+}
+
+func (s *Scanner) readWord() string {
+	start := s.offset
+	for s.offset < len(s.src) && isWordChar(s.src[s.offset]) {
+		s.nextChar()
+	}
+	return string(s.src[start:s.offset])
+}
+
+func (s *Scanner) readIdent(pos ast.Pos) *Token {
+	word := s.readWord()
+	kind, ok := reservedWords[word]
+	if !ok || !s.dialect.hasToken(kind, word) {
+		kind = TokenIdent
+	}
+	return &Token{Kind: kind, Text: word, Pos: pos}
+}
+
+// readNumber reads a number. An illegal number is read as 0.
+func (s *Scanner) readNumber(pos ast.Pos) *Token {
+	word := s.readWord()
+	token := &Token{Kind: TokenNumber, Text: word, Pos: pos}
+	for i := 0; i < len(word); i++ {
+		if !isDigit(word[i]) {
+			s.error(CodeIllegalNumber, pos, "Illegal number '%s'", word)
+			return token
+		}
+	}
+	val, err := strconv.ParseInt(word, 10, 32)
+	if err != nil {
+		s.error(CodeIllegalNumber, pos, "Number '%s' is out of range", word)
+		return token
+	}
+	token.Number = int(val)
+	return token
+}
+
+// readString reads a string literal in double quotes, which may contain
+// the escape sequences of Go such as \" and \n, but no newlines.
+func (s *Scanner) readString(pos ast.Pos) *Token {
+	s.nextChar()
+	for s.offset < len(s.src) && s.src[s.offset] != '"' && s.src[s.offset] != '\n' {
+		if s.src[s.offset] == '\\' && s.offset+1 < len(s.src) && s.src[s.offset+1] != '\n' {
+			s.nextChar()
+		}
+		s.nextChar()
+	}
+	token := &Token{Kind: TokenString, Pos: pos}
+	if s.offset >= len(s.src) || s.src[s.offset] != '"' {
+		token.Text = string(s.src[pos.Offset:s.offset])
+		s.error(CodeIllegalString, pos, "Unterminated string")
+		return token
+	}
+	s.nextChar()
+	token.Text = string(s.src[pos.Offset:s.offset])
+	value, err := strconv.Unquote(token.Text)
+	if err != nil {
+		s.error(CodeIllegalString, pos, "Illegal string %s", token.Text)
+		return token
+	}
+	token.Value = value
+	return token
+}
+
+// readMeta reads a symbol. It skips an unexpected character and returns nil.
+func (s *Scanner) readMeta(pos ast.Pos) *Token {
+	if s.offset+1 < len(s.src) {
+		text := string(s.src[s.offset : s.offset+2])
+		if kind, ok := metaTokens[text]; ok && s.dialect.hasToken(kind, text) {
+			s.nextChar()
+			s.nextChar()
+			return &Token{Kind: kind, Text: text, Pos: pos}
+		}
+	}
+	text := string(s.src[s.offset : s.offset+1])
+	if kind, ok := metaTokens[text]; ok && s.dialect.hasToken(kind, text) {
+		s.nextChar()
+		return &Token{Kind: kind, Text: text, Pos: pos}
+	}
+	r, _ := utf8.DecodeRune(s.src[s.offset:])
+	s.nextChar()
+	s.error(CodeIllegalCharacter, pos, "Unexpected character '%c'", r)
+	return nil
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' ||
+		ch == '\f' || ch == '\v'
+}
+
+func isLetter(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isWordChar(ch byte) bool {
+	return isLetter(ch) || isDigit(ch)
+}
@@ -0,0 +1,399 @@
+package pl0compiler
+
+import (
+	"kkpl0/ast"
+	"kkpl0/pl0core"
+)
+
+// SymbolKind is kind of symbols.
+type SymbolKind int
+
+const (
+	// SymbolVarScalar is scalar variable.
+	SymbolVarScalar SymbolKind = iota
+	// SymbolConst is constant.
+	SymbolConst
+	// SymbolFunc is function.
+	SymbolFunc
+	// SymbolVarArray is array variable.
+	SymbolVarArray
+	// SymbolVarRef is array reference parameter.
+	SymbolVarRef
+	// SymbolProc is procedure, which returns no value.
+	SymbolProc
+	// SymbolBuiltin is builtin function, which is compiled to an operation.
+	SymbolBuiltin
+	// SymbolVarParam is scalar var parameter, which holds the address
+	// of a variable or an array element.
+	SymbolVarParam
+)
+
+var symbolKindStrings = [...]string{
+	SymbolVarScalar: "var",
+	SymbolConst:     "const",
+	SymbolFunc:      "function",
+	SymbolVarArray:  "array",
+	SymbolVarRef:    "array reference",
+	SymbolProc:      "procedure",
+	SymbolBuiltin:   "builtin function",
+	SymbolVarParam:  "var parameter",
+}
+
+func (kind SymbolKind) String() string {
+	return symbolKindStrings[kind]
+}
+
+// Symbol is a defined symbol.
+type Symbol struct {
+	Kind    SymbolKind
+	Name    string
+	Decl    *ast.Ident // identifier in the declaration
+	Address pl0core.Address
+	Size    int       // size of array
+	Dims    []int     // sizes of the dimensions of array, nil for array reference
+	Rank    int       // number of dimensions of array or array reference
+	Sized   bool      // whether array reference is passed with the size of the first dimension
+	Value   int       // value of constant
+	Params  []*Symbol // parameters of function or procedure
+	Param   bool      // whether the symbol is a function parameter
+	Scope   *Scope    // scope in which the symbol is declared
+	Builtin *Builtin  // builtin function
+}
+
+// Builtin is a builtin function, which is compiled to an operation of
+// the VM instead of a call.
+type Builtin struct {
+	Name   string
+	Params int  // number of parameters
+	OpType byte // operation type of the VM
+}
+
+// Builtins are the builtin functions of kk-PL/0 in the universe scope.
+// Declarations of the same names hide them.
+var Builtins = []*Builtin{
+	{Name: "abs", Params: 1, OpType: pl0core.OpTypeABS},
+	{Name: "min", Params: 2, OpType: pl0core.OpTypeMIN},
+	{Name: "max", Params: 2, OpType: pl0core.OpTypeMAX},
+	{Name: "sqr", Params: 1, OpType: pl0core.OpTypeSQR},
+}
+
+// IsVariable reports whether the symbol is a variable.
+func (sym *Symbol) IsVariable() bool {
+	return sym.Kind == SymbolVarScalar || sym.Kind == SymbolVarArray ||
+		sym.Kind == SymbolVarRef || sym.Kind == SymbolVarParam
+}
+
+// IsScalar reports whether the symbol is a scalar variable or a scalar
+// var parameter.
+func (sym *Symbol) IsScalar() bool {
+	return sym.Kind == SymbolVarScalar || sym.Kind == SymbolVarParam
+}
+
+// IsArrayOrRef reports whether the symbol is an array or an array reference.
+func (sym *Symbol) IsArrayOrRef() bool {
+	return sym.Kind == SymbolVarArray || sym.Kind == SymbolVarRef
+}
+
+// TakesAddress reports whether the parameter takes the address of a scalar
+// variable or an array element passed to it: a var parameter, or a sized
+// array reference of one dimension, which takes it as an array of one element.
+func (sym *Symbol) TakesAddress() bool {
+	return sym.Kind == SymbolVarParam || sym.Kind == SymbolVarRef && sym.Sized && sym.Rank == 1
+}
+
+// Slots returns the number of stack slots of the parameter.
+// An array reference is passed as its address followed by the sizes
+// of the dimensions in SizeDims.
+func (sym *Symbol) Slots() int {
+	return 1 + len(sym.SizeDims())
+}
+
+// SizeDims returns the dimensions whose sizes follow the address of the
+// array reference parameter in order: the dimensions but the first,
+// and the first one if the reference is sized for bounds checks.
+func (sym *Symbol) SizeDims() []int {
+	if sym.Kind != SymbolVarRef {
+		return nil
+	}
+	var dims []int
+	for d := 1; d < sym.Rank; d++ {
+		dims = append(dims, d)
+	}
+	if sym.Sized {
+		dims = append(dims, 0)
+	}
+	return dims
+}
+
+// ParamSlots returns the number of stack slots of the parameters
+// of the function or procedure.
+func (sym *Symbol) ParamSlots() int {
+	slots := 0
+	for _, param := range sym.Params {
+		slots += param.Slots()
+	}
+	return slots
+}
+
+// Scope is a block scope.
+// Symbols are visible from their declarations to the end of the scope.
+type Scope struct {
+	Parent   *Scope
+	Children []*Scope
+	Level    int
+	Func     *Symbol   // function of the block, nil for the main block
+	Symbols  []*Symbol // in order of declaration
+	Start    ast.Pos
+	End      ast.Pos // invalid while the scope is not closed
+}
+
+// Contains reports whether pos is in the scope.
+func (s *Scope) Contains(pos ast.Pos) bool {
+	if pos.Before(s.Start) {
+		return false
+	}
+	return !s.End.IsValid() || pos.Before(s.End)
+}
+
+// Innermost returns the innermost scope containing pos.
+func (s *Scope) Innermost(pos ast.Pos) *Scope {
+	for _, child := range s.Children {
+		if child.Contains(pos) {
+			return child.Innermost(pos)
+		}
+	}
+	return s
+}
+
+// Lookup returns the symbol named name which is visible at pos.
+func (s *Scope) Lookup(name string, pos ast.Pos) *Symbol {
+	for scope := s; scope != nil; scope = scope.Parent {
+		for i := len(scope.Symbols) - 1; i >= 0; i-- {
+			sym := scope.Symbols[i]
+			if sym.Name == name && sym.Decl.Pos().Before(pos) {
+				return sym
+			}
+		}
+	}
+	return nil
+}
+
+// Visible returns the symbols which are visible at pos.
+// Inner declarations hide outer ones.
+func (s *Scope) Visible(pos ast.Pos) []*Symbol {
+	var syms []*Symbol
+	seen := make(map[string]bool)
+	for scope := s; scope != nil; scope = scope.Parent {
+		for i := len(scope.Symbols) - 1; i >= 0; i-- {
+			sym := scope.Symbols[i]
+			if !seen[sym.Name] && sym.Decl.Pos().Before(pos) {
+				seen[sym.Name] = true
+				syms = append(syms, sym)
+			}
+		}
+	}
+	return syms
+}
+
+// SymbolManager manages symbols and scopes while compiling.
+//
+// FirstVarOffset/offset: see PL0VM InstructCAL, InstructRET, InstructICT
+// ex. call func(p1, p2); var v1,v2;
+//
+//	Stack             Offset
+//	  p1               -2
+//	  p2               -1 (or sizes of dimensions after array reference)
+//	  display[level]    0 (top-of-stack)
+//	  pc                1 return address
+//	  v1                2 FirstVarOffset
+//	  v2                3
+//	                    4 offset (top-of-stack after ict)
+//
+// With static links, the frame has the static link at offset 0, and the
+// dynamic link at offset 2 before the first local variable
+// (see PL0VM InstructCALS).
+type SymbolManager struct {
+	level          int
+	offset         int
+	offsetStack    []int
+	universe       *Scope
+	scope          *Scope
+	firstVarOffset int
+}
+
+// FirstVarOffset is the offset of the first local variable.
+const FirstVarOffset = 2
+
+// FirstStaticVarOffset is the offset of the first local variable
+// with static links.
+const FirstStaticVarOffset = 3
+
+// NewSymbolManager creates a SymbolManager instance.
+func NewSymbolManager() *SymbolManager {
+	universe := &Scope{Level: -1}
+	return &SymbolManager{
+		level:          -1,
+		offset:         FirstVarOffset,
+		universe:       universe,
+		scope:          universe,
+		firstVarOffset: FirstVarOffset,
+	}
+}
+
+// Level returns the current level.
+func (sm *SymbolManager) Level() int {
+	return sm.level
+}
+
+// Offset returns the offset of the next variable.
+func (sm *SymbolManager) Offset() int {
+	return sm.offset
+}
+
+// Universe returns the outermost scope.
+// The main block scope is its only child.
+func (sm *SymbolManager) Universe() *Scope {
+	return sm.universe
+}
+
+// Scope returns the current scope.
+func (sm *SymbolManager) Scope() *Scope {
+	return sm.scope
+}
+
+// BlockBegin begins a block scope.
+func (sm *SymbolManager) BlockBegin(funcSym *Symbol, start ast.Pos) {
+	sm.offsetStack = append(sm.offsetStack, sm.offset)
+	sm.offset = sm.firstVarOffset
+	sm.level++
+	scope := &Scope{Parent: sm.scope, Level: sm.level, Func: funcSym, Start: start}
+	sm.scope.Children = append(sm.scope.Children, scope)
+	sm.scope = scope
+}
+
+// BlockEnd ends the current block scope.
+func (sm *SymbolManager) BlockEnd(end ast.Pos) {
+	sm.scope.End = end
+	sm.scope = sm.scope.Parent
+	sm.level--
+	sm.offset = sm.offsetStack[len(sm.offsetStack)-1]
+	sm.offsetStack = sm.offsetStack[:len(sm.offsetStack)-1]
+}
+
+// Get returns the symbol named name, or nil if it is not defined.
+func (sm *SymbolManager) Get(name string) *Symbol {
+	for scope := sm.scope; scope != nil; scope = scope.Parent {
+		for i := len(scope.Symbols) - 1; i >= 0; i-- {
+			if scope.Symbols[i].Name == name {
+				return scope.Symbols[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (sm *SymbolManager) enter(sym *Symbol) *Symbol {
+	sym.Scope = sm.scope
+	sm.scope.Symbols = append(sm.scope.Symbols, sym)
+	return sym
+}
+
+// EnterBuiltin enters a builtin function, whose declaration has no position.
+func (sm *SymbolManager) EnterBuiltin(builtin *Builtin) *Symbol {
+	return sm.enter(&Symbol{
+		Kind:    SymbolBuiltin,
+		Name:    builtin.Name,
+		Decl:    &ast.Ident{Name: builtin.Name},
+		Builtin: builtin,
+	})
+}
+
+// EnterVarScalar enters a scalar variable.
+func (sm *SymbolManager) EnterVarScalar(name *ast.Ident) *Symbol {
+	sym := sm.enter(&Symbol{
+		Kind:    SymbolVarScalar,
+		Name:    name.Name,
+		Decl:    name,
+		Address: pl0core.Address{Level: sm.level, Offset: sm.offset},
+	})
+	sm.offset++
+	return sym
+}
+
+// EnterArray enters an array variable with the sizes of its dimensions,
+// whose elements are in row-major order.
+func (sm *SymbolManager) EnterArray(name *ast.Ident, dims []int) *Symbol {
+	size := 1
+	for _, dim := range dims {
+		size *= dim
+	}
+	sym := sm.enter(&Symbol{
+		Kind:    SymbolVarArray,
+		Name:    name.Name,
+		Decl:    name,
+		Address: pl0core.Address{Level: sm.level, Offset: sm.offset},
+		Size:    size,
+		Dims:    dims,
+		Rank:    len(dims),
+	})
+	sm.offset += size
+	return sym
+}
+
+// EnterConst enters a constant.
+func (sm *SymbolManager) EnterConst(name *ast.Ident, value int) *Symbol {
+	return sm.enter(&Symbol{
+		Kind:  SymbolConst,
+		Name:  name.Name,
+		Decl:  name,
+		Value: value,
+	})
+}
+
+// EnterFunc enters a function.
+func (sm *SymbolManager) EnterFunc(name *ast.Ident, instIndex int) *Symbol {
+	return sm.enter(&Symbol{
+		Kind:    SymbolFunc,
+		Name:    name.Name,
+		Decl:    name,
+		Address: pl0core.Address{Level: sm.level, Offset: instIndex},
+	})
+}
+
+// EnterProc enters a procedure.
+func (sm *SymbolManager) EnterProc(name *ast.Ident, instIndex int) *Symbol {
+	sym := sm.EnterFunc(name, instIndex)
+	sym.Kind = SymbolProc
+	return sym
+}
+
+// FixFuncAddr fixes the code address of a function.
+func (sm *SymbolManager) FixFuncAddr(funcSym *Symbol, instIndex int) {
+	funcSym.Address.Offset = instIndex
+}
+
+// EnterFuncParam enters a function parameter.
+// The rank is the number of dimensions of an array reference, which is
+// sized if it is passed with the size of the first dimension.
+func (sm *SymbolManager) EnterFuncParam(funcSym *Symbol, name *ast.Ident, kind SymbolKind, rank int, sized bool) *Symbol {
+	sym := sm.enter(&Symbol{
+		Kind:    kind,
+		Name:    name.Name,
+		Decl:    name,
+		Address: pl0core.Address{Level: sm.level, Offset: 0},
+		Rank:    rank,
+		Sized:   sized,
+		Param:   true,
+	})
+	funcSym.Params = append(funcSym.Params, sym)
+	return sym
+}
+
+// FixFuncParamOffsets fixes the offsets of function parameters.
+func (sm *SymbolManager) FixFuncParamOffsets(funcSym *Symbol) {
+	offset := 0
+	for i := len(funcSym.Params) - 1; i >= 0; i-- {
+		offset -= funcSym.Params[i].Slots()
+		funcSym.Params[i].Address.Offset = offset
+	}
+}
@@ -0,0 +1,189 @@
+package pl0compiler
+
+import (
+	"unicode/utf8"
+
+	"kkpl0/ast"
+)
+
+// TokenKind is kind of tokens.
+type TokenKind int
+
+const (
+	// TokenIdent is identifier.
+	TokenIdent TokenKind = iota
+	// TokenNumber is number.
+	TokenNumber
+	// TokenString is string literal.
+	TokenString
+	// TokenEOF is end of file.
+	TokenEOF
+
+	// reserved words
+	TokenBegin
+	TokenEnd
+	TokenConst
+	TokenVar
+	TokenFunc
+	TokenIf
+	TokenElse
+	TokenThen
+	TokenWhile
+	TokenDo
+	TokenRepeat
+	TokenUntil
+	TokenWrite
+	TokenWriteln
+	TokenReturn
+	TokenProcedure
+	TokenCall
+	TokenFor
+	TokenTo
+	TokenDownto
+	TokenStep
+	TokenBreak
+	TokenContinue
+	TokenCase
+	TokenOf
+	TokenAnd
+	TokenOr
+	TokenNot
+	TokenMod
+	TokenOdd
+
+	// symbols
+	TokenPeriod
+	TokenComma
+	TokenSemicolon
+	TokenEqual
+	TokenNotEqual
+	TokenGt
+	TokenGtEq
+	TokenLt
+	TokenLtEq
+	TokenAssign
+	TokenPlus
+	TokenMinus
+	TokenMul
+	TokenDiv
+	TokenLParen
+	TokenRParen
+	TokenLBracket
+	TokenRBracket
+	TokenColon
+	TokenQuestion
+	TokenExclamation
+)
+
+var tokenKindStrings = [...]string{
+	TokenIdent:       "Identifier",
+	TokenNumber:      "Number",
+	TokenString:      "String",
+	TokenEOF:         "EOF",
+	TokenBegin:       "begin",
+	TokenEnd:         "end",
+	TokenConst:       "const",
+	TokenVar:         "var",
+	TokenFunc:        "function",
+	TokenIf:          "if",
+	TokenElse:        "else",
+	TokenThen:        "then",
+	TokenWhile:       "while",
+	TokenDo:          "do",
+	TokenRepeat:      "repeat",
+	TokenUntil:       "until",
+	TokenWrite:       "write",
+	TokenWriteln:     "writeln",
+	TokenReturn:      "return",
+	TokenProcedure:   "procedure",
+	TokenCall:        "call",
+	TokenFor:         "for",
+	TokenTo:          "to",
+	TokenDownto:      "downto",
+	TokenStep:        "step",
+	TokenBreak:       "break",
+	TokenContinue:    "continue",
+	TokenCase:        "case",
+	TokenOf:          "of",
+	TokenAnd:         "and",
+	TokenOr:          "or",
+	TokenNot:         "not",
+	TokenMod:         "mod",
+	TokenOdd:         "odd",
+	TokenPeriod:      ".",
+	TokenComma:       ",",
+	TokenSemicolon:   ";",
+	TokenEqual:       "=",
+	TokenNotEqual:    "<>",
+	TokenGt:          ">",
+	TokenGtEq:        ">=",
+	TokenLt:          "<",
+	TokenLtEq:        "<=",
+	TokenAssign:      ":=",
+	TokenPlus:        "+",
+	TokenMinus:       "-",
+	TokenMul:         "*",
+	TokenDiv:         "/",
+	TokenLParen:      "(",
+	TokenRParen:      ")",
+	TokenLBracket:    "[",
+	TokenRBracket:    "]",
+	TokenColon:       ":",
+	TokenQuestion:    "?",
+	TokenExclamation: "!",
+}
+
+var reservedWords = map[string]TokenKind{}
+
+var metaTokens = map[string]TokenKind{}
+
+func init() {
+	for kind := TokenBegin; kind <= TokenOdd; kind++ {
+		reservedWords[kind.String()] = kind
+	}
+	for kind := TokenPeriod; kind <= TokenExclamation; kind++ {
+		metaTokens[kind.String()] = kind
+	}
+	// not equal in Wirth's PL/0
+	metaTokens["#"] = TokenNotEqual
+}
+
+func (kind TokenKind) String() string {
+	if kind < 0 || int(kind) >= len(tokenKindStrings) {
+		return "Unknown"
+	}
+	return tokenKindStrings[kind]
+}
+
+// IsReservedWord reports whether the kind is a reserved word.
+func (kind TokenKind) IsReservedWord() bool {
+	return TokenBegin <= kind && kind <= TokenOdd
+}
+
+// Token is a lexical token.
+type Token struct {
+	Kind   TokenKind
+	Text   string
+	Number int    // value of TokenNumber
+	Value  string // value of TokenString
+	Pos    ast.Pos
+
+	// Comments are the comments between the previous token and the token.
+	Comments []*ast.Comment
+}
+
+// End returns the position immediately after the token.
+func (t *Token) End() ast.Pos {
+	return ast.Pos{
+		Offset: t.Pos.Offset + len(t.Text),
+		Line:   t.Pos.Line,
+		Column: t.Pos.Column + utf8.RuneCountInString(t.Text),
+	}
+}
+
+func (t *Token) String() string {
+	if t.Kind == TokenEOF {
+		return t.Kind.String()
+	}
+	return t.Text
+}
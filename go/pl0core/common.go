@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 )
 
 const (
@@ -27,6 +28,40 @@ const (
 	InstructJPC = 9
 	// InstructLDA is instruction code LDA.
 	InstructLDA = 10
+	// InstructTCL is instruction code TCL.
+	InstructTCL = 11
+	// InstructRTN is instruction code RTN, which is RET without a value.
+	InstructRTN = 12
+	// InstructSTR is instruction code STR, which is a string constant of
+	// the pool following the code. It is not executed.
+	InstructSTR = 13
+	// InstructJTB is instruction code JTB, which pops an index i and jumps
+	// through the table of the Value JMP instructions following it:
+	// to the i-th one if 0 <= i < Value, or past the table otherwise.
+	InstructJTB = 14
+
+	// The instructions with static links are LOD, LDA, STO, CAL, RET, RTN
+	// and TCL for frames linked to the frames of their enclosing functions,
+	// instead of the display. Their levels are the numbers of static links
+	// followed from the current frame, and the levels of RETS, RTNS and the
+	// caller of TCLS are not used. A frame consists of the static link,
+	// the return address and the dynamic link, followed by the variables.
+
+	// InstructLODS is instruction code LODS, LOD with static links.
+	InstructLODS = 15
+	// InstructLDAS is instruction code LDAS, LDA with static links.
+	InstructLDAS = 16
+	// InstructSTOS is instruction code STOS, STO with static links.
+	InstructSTOS = 17
+	// InstructCALS is instruction code CALS, which calls the function
+	// enclosed in the frame of its level.
+	InstructCALS = 18
+	// InstructRETS is instruction code RETS, RET with static links.
+	InstructRETS = 19
+	// InstructRTNS is instruction code RTNS, RTN with static links.
+	InstructRTNS = 20
+	// InstructTCLS is instruction code TCLS, TCL with static links.
+	InstructTCLS = 21
 
 	// OpTypeNEG is operation type NEG.
 	OpTypeNEG = 1
@@ -60,6 +95,30 @@ const (
 	OpTypeLID = 15
 	// OpTypeSID is operation type SID.
 	OpTypeSID = 16
+	// OpTypeRED is operation type RED.
+	OpTypeRED = 17
+	// OpTypeNOT is operation type NOT, which makes 0 into 1 and others into 0.
+	OpTypeNOT = 18
+	// OpTypeMOD is operation type MOD, whose result has the sign of the dividend.
+	OpTypeMOD = 19
+	// OpTypeABS is operation type ABS.
+	OpTypeABS = 20
+	// OpTypeMIN is operation type MIN.
+	OpTypeMIN = 21
+	// OpTypeMAX is operation type MAX.
+	OpTypeMAX = 22
+	// OpTypeSQR is operation type SQR, which squares the value.
+	OpTypeSQR = 23
+	// OpTypeWRS is operation type WRS, which writes the string constant
+	// of the index.
+	OpTypeWRS = 24
+	// OpTypeWRF is operation type WRF, which writes the value right-aligned
+	// in the field of the width, without the trailing space of WRT.
+	// A width above PL0VMMaxWidth is an error.
+	OpTypeWRF = 25
+	// OpTypeCHK is operation type CHK, which pops the length of an array,
+	// and checks that the index on the stack is in the range [0, length).
+	OpTypeCHK = 26
 )
 
 // Address is code address.
@@ -91,6 +150,25 @@ type OperationInstruction struct {
 	OpType byte
 }
 
+// TailCallInstruction is tail call instruction.
+// It returns from the caller as RET, and calls the callee as CAL
+// with the arguments on the stack, reusing the frame of the caller.
+type TailCallInstruction struct {
+	Code byte
+	Address
+	Level  int // level of the caller
+	Params int // number of parameters of the caller
+	Args   int // number of arguments to the callee
+}
+
+// StringInstruction is a string constant of the pool.
+// The pool follows the code, and its strings are referred to by
+// their indices from 0.
+type StringInstruction struct {
+	Code byte
+	Text string
+}
+
 // GetCode returns instruction code
 func (ai *AddrInstruction) GetCode() byte {
 	return ai.Code
@@ -118,6 +196,48 @@ func (oi *OperationInstruction) String() string {
 	return fmt.Sprintf("code:%d operation:%d ", oi.Code, oi.OpType)
 }
 
+// GetCode returns instruction code
+func (ti *TailCallInstruction) GetCode() byte {
+	return ti.Code
+}
+
+func (ti *TailCallInstruction) String() string {
+	return fmt.Sprintf("code:%d address:%d,%d caller:%d,%d args:%d",
+		ti.Code, ti.Address.Level, ti.Offset, ti.Level, ti.Params, ti.Args)
+}
+
+// GetCode returns instruction code
+func (si *StringInstruction) GetCode() byte {
+	return si.Code
+}
+
+func (si *StringInstruction) String() string {
+	return fmt.Sprintf("code:%d string:%q", si.Code, si.Text)
+}
+
+// SplitStrings splits the instructions into the code and the strings
+// of the pool following it.
+func SplitStrings(instructions []Instruction) ([]Instruction, []string) {
+	n := len(instructions)
+	for n > 0 && instructions[n-1].GetCode() == InstructSTR {
+		n--
+	}
+	var strings []string
+	for _, inst := range instructions[n:] {
+		strings = append(strings, inst.(*StringInstruction).Text)
+	}
+	return instructions[:n], strings
+}
+
+// StringPool returns the instructions of the pool of the strings.
+func StringPool(strings []string) []Instruction {
+	var pool []Instruction
+	for _, s := range strings {
+		pool = append(pool, &StringInstruction{Code: InstructSTR, Text: s})
+	}
+	return pool
+}
+
 // ReadInstructions reads instructions.
 func ReadInstructions(reader io.Reader) ([]Instruction, error) {
 	var instructions []Instruction
@@ -147,7 +267,7 @@ func ReadInstructions(reader io.Reader) ([]Instruction, error) {
 			inst := &ValueInstruction{code, int(valInt32)}
 			instructions = append(instructions, inst)
 
-		case InstructICT, InstructJMP, InstructJPC:
+		case InstructICT, InstructJMP, InstructJPC, InstructJTB:
 			// read int16
 			err = binary.Read(reader, byteOrder, &valInt16)
 			if err != nil {
@@ -156,7 +276,8 @@ func ReadInstructions(reader io.Reader) ([]Instruction, error) {
 			inst := &ValueInstruction{code, int(valInt16)}
 			instructions = append(instructions, inst)
 
-		case InstructLOD, InstructLDA, InstructSTO, InstructCAL, InstructRET:
+		case InstructLOD, InstructLDA, InstructSTO, InstructCAL, InstructRET, InstructRTN,
+			InstructLODS, InstructLDAS, InstructSTOS, InstructCALS, InstructRETS, InstructRTNS:
 			// read int16, int16
 			addr := new(Address)
 			err = binary.Read(reader, byteOrder, &valInt16)
@@ -172,6 +293,17 @@ func ReadInstructions(reader io.Reader) ([]Instruction, error) {
 			inst := &AddrInstruction{code, *addr}
 			instructions = append(instructions, inst)
 
+		case InstructTCL, InstructTCLS:
+			// read int16 * 5
+			var vals [5]int16
+			err = binary.Read(reader, byteOrder, &vals)
+			if err != nil {
+				return nil, err
+			}
+			inst := &TailCallInstruction{code, Address{int(vals[0]), int(vals[1])},
+				int(vals[2]), int(vals[3]), int(vals[4])}
+			instructions = append(instructions, inst)
+
 		case InstructOPR:
 			// read byte
 			err = binary.Read(reader, byteOrder, &valByte)
@@ -181,9 +313,63 @@ func ReadInstructions(reader io.Reader) ([]Instruction, error) {
 			inst := &OperationInstruction{code, valByte}
 			instructions = append(instructions, inst)
 
+		case InstructSTR:
+			// read uint16 length and bytes
+			var length uint16
+			err = binary.Read(reader, byteOrder, &length)
+			if err != nil {
+				return nil, err
+			}
+			text := make([]byte, length)
+			if _, err = io.ReadFull(reader, text); err != nil {
+				return nil, err
+			}
+			inst := &StringInstruction{code, string(text)}
+			instructions = append(instructions, inst)
+
 		default:
 			return nil, fmt.Errorf("Unknown instruction code: %d", code)
 		}
 	}
 	return instructions, nil
 }
+
+// WriteInstructions writes instructions in binary format.
+func WriteInstructions(writer io.Writer, instructions []Instruction) error {
+	byteOrder := binary.BigEndian
+
+	for _, inst := range instructions {
+		var data []interface{}
+
+		switch i := inst.(type) {
+		case *ValueInstruction:
+			if i.Code == InstructLIT {
+				data = []interface{}{i.Code, int32(i.Value)}
+			} else {
+				data = []interface{}{i.Code, int16(i.Value)}
+			}
+		case *AddrInstruction:
+			data = []interface{}{i.Code, int16(i.Level), int16(i.Offset)}
+		case *OperationInstruction:
+			data = []interface{}{i.Code, i.OpType}
+		case *TailCallInstruction:
+			data = []interface{}{i.Code, int16(i.Address.Level), int16(i.Offset),
+				int16(i.Level), int16(i.Params), int16(i.Args)}
+		case *StringInstruction:
+			if len(i.Text) > math.MaxUint16 {
+				return fmt.Errorf("String constant is too long: %d bytes", len(i.Text))
+			}
+			data = []interface{}{i.Code, uint16(len(i.Text)), []byte(i.Text)}
+		default:
+			return fmt.Errorf("Unknown instruction code: %d", inst.GetCode())
+		}
+
+		for _, v := range data {
+			err := binary.Write(writer, byteOrder, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package pl0core
+
+import (
+	"fmt"
+
+	"kkpl0/diag"
+)
+
+// Error codes of runtime errors and verification errors.
+const (
+	CodeUnknownInstruction = "unknown-instruction"
+	CodeUnknownOperation   = "unknown-operation"
+	CodeInvalidAddress     = "invalid-address"
+	CodeInvalidLevel       = "invalid-level"
+	CodeInvalidValue       = "invalid-value"
+	CodeStackOverflow      = "stack-overflow"
+	CodeDivisionByZero     = "division-by-zero"
+	CodeInvalidInput       = "invalid-input"
+	CodeIndexOutOfRange    = "index-out-of-range"
+	CodeMixedConvention    = "mixed-convention"
+)
+
+// Error is an error of the instruction at PC.
+type Error struct {
+	PC   int
+	Code string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Diagnostic returns the error of the program file as a diagnostic.
+func (e *Error) Diagnostic(file string) diag.Diagnostic {
+	pc := e.PC
+	return diag.Diagnostic{
+		Severity: diag.SeverityError,
+		Code:     e.Code,
+		Message:  e.Msg,
+		Location: diag.Location{File: file, Address: &pc},
+	}
+}
+
+// ErrorList is a list of errors.
+type ErrorList []*Error
+
+// Err returns the list as an error, or nil if the list is empty.
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
@@ -0,0 +1,339 @@
+package pl0core
+
+// OptimizeStats is statistics of Optimize.
+type OptimizeStats struct {
+	Before int // number of instructions before optimization
+	After  int // number of instructions after optimization
+
+	JumpsThreaded      int // jumps and calls retargeted past JMP
+	JumpsRemoved       int // JMP to the next instruction
+	Unreachable        int // unreachable instructions
+	LoadsCombined      int // LDA, LIT, OPR ADD, OPR LID combined into LOD
+	BranchesSimplified int // comparisons with 0 and constants before JPC
+}
+
+// Optimize returns optimized instructions with the same behavior.
+// It works on instructions from any compiler or assembler:
+//
+//   - threads jumps and calls to JMP
+//   - removes JMP to the next instruction
+//   - removes unreachable instructions after JMP, RET and TCL
+//   - replaces LDA l,o; LIT k; OPR ADD; OPR LID with LOD l,o+k
+//     (and the same with static links)
+//   - replaces OPR NEQ or OPR EQ with 0, and constants before JPC
+//     with direct branches
+//
+// Jump and call addresses are remapped after removing instructions.
+// The tables of JTB are kept as they are, except for their targets.
+// Address 0 is kept, since jumping to it ends the program.
+// The pool of strings is kept after the code.
+// The instructions must pass Verify; they are not modified.
+func Optimize(instructions []Instruction) ([]Instruction, *OptimizeStats) {
+	instructions, strings := SplitStrings(instructions)
+	stats := &OptimizeStats{Before: len(instructions)}
+	code := make([]Instruction, len(instructions))
+	for i, inst := range instructions {
+		code[i] = copyInstruction(inst)
+	}
+	if len(code) > 0 {
+		for {
+			o := &optimizer{code: code, stats: stats}
+			if !o.pass() {
+				break
+			}
+			code = o.compact()
+		}
+	}
+	stats.After = len(code)
+	return append(code, StringPool(strings)...), stats
+}
+
+func copyInstruction(inst Instruction) Instruction {
+	switch i := inst.(type) {
+	case *AddrInstruction:
+		c := *i
+		return &c
+	case *ValueInstruction:
+		c := *i
+		return &c
+	case *OperationInstruction:
+		c := *i
+		return &c
+	case *TailCallInstruction:
+		c := *i
+		return &c
+	}
+	return inst
+}
+
+// optimizer is a pass of Optimize.
+// Rewritten instructions keep their addresses during a pass;
+// removed ones are marked and compacted at the end of the pass.
+type optimizer struct {
+	code    []Instruction
+	removed []bool
+	targets []bool // addresses of jumps and calls
+	tables  []bool // entries of jump tables
+	stats   *OptimizeStats
+}
+
+// target returns the jump or call address of the instruction.
+func target(inst Instruction) (int, bool) {
+	switch i := inst.(type) {
+	case *ValueInstruction:
+		if i.Code == InstructJMP || i.Code == InstructJPC {
+			return i.Value, true
+		}
+	case *AddrInstruction:
+		if i.Code == InstructCAL || i.Code == InstructCALS {
+			return i.Offset, true
+		}
+	case *TailCallInstruction:
+		return i.Offset, true
+	}
+	return 0, false
+}
+
+func setTarget(inst Instruction, addr int) {
+	switch i := inst.(type) {
+	case *ValueInstruction:
+		i.Value = addr
+	case *AddrInstruction:
+		i.Offset = addr
+	case *TailCallInstruction:
+		i.Offset = addr
+	}
+}
+
+func (o *optimizer) valid(addr int) bool {
+	return addr >= 0 && addr < len(o.code)
+}
+
+func (o *optimizer) isCode(addr int, code byte) bool {
+	return o.valid(addr) && o.code[addr].GetCode() == code
+}
+
+func (o *optimizer) isOpr(addr int, opType byte) bool {
+	oi, ok := o.code[addr].(*OperationInstruction)
+	return ok && oi.Code == InstructOPR && oi.OpType == opType
+}
+
+// isLiteral returns the value of LIT at addr.
+func (o *optimizer) isLiteral(addr int) (int, bool) {
+	if vi, ok := o.code[addr].(*ValueInstruction); ok && vi.Code == InstructLIT {
+		return vi.Value, true
+	}
+	return 0, false
+}
+
+// interior reports whether the n instructions following addr exist and
+// are not jumped to, so that they can be rewritten with the one at addr.
+func (o *optimizer) interior(addr int, n int) bool {
+	if addr+n >= len(o.code) {
+		return false
+	}
+	for i := addr + 1; i <= addr+n; i++ {
+		if o.targets[i] || o.removed[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// remove marks the instruction removed. Address 0 is never removed.
+func (o *optimizer) remove(addr int) bool {
+	if addr == 0 {
+		return false
+	}
+	o.removed[addr] = true
+	return true
+}
+
+// pass rewrites the code once and reports whether anything changed.
+func (o *optimizer) pass() bool {
+	n := len(o.code)
+	o.removed = make([]bool, n)
+	changed := false
+
+	// thread jumps and calls to JMP
+	for _, inst := range o.code {
+		addr, ok := target(inst)
+		if !ok {
+			continue
+		}
+		next := addr
+		for hops := 0; hops < n && next != 0 && o.isCode(next, InstructJMP); hops++ {
+			next = o.code[next].(*ValueInstruction).Value
+		}
+		if next != addr && (next == 0 || !o.isCode(next, InstructJMP)) {
+			setTarget(inst, next)
+			o.stats.JumpsThreaded++
+			changed = true
+		}
+	}
+
+	o.targets = make([]bool, n)
+	o.tables = make([]bool, n)
+	for i, inst := range o.code {
+		if addr, ok := target(inst); ok && o.valid(addr) {
+			o.targets[addr] = true
+		}
+		if inst.GetCode() == InstructJTB {
+			for k := 1; k <= inst.(*ValueInstruction).Value+1 && o.valid(i+k); k++ {
+				o.targets[i+k] = true
+				o.tables[i+k] = k <= inst.(*ValueInstruction).Value
+			}
+		}
+	}
+
+	// unreachable instructions
+	reachable := o.reachable()
+	for i := range o.code {
+		if !reachable[i] && o.remove(i) {
+			o.stats.Unreachable++
+			changed = true
+		}
+	}
+
+	for i, inst := range o.code {
+		if o.removed[i] {
+			continue
+		}
+		switch inst.GetCode() {
+		case InstructJMP:
+			if inst.(*ValueInstruction).Value == i+1 && !o.tables[i] && o.remove(i) {
+				o.stats.JumpsRemoved++
+				changed = true
+			}
+		case InstructLDA, InstructLDAS:
+			changed = o.combineLoad(i) || changed
+		case InstructLIT:
+			changed = o.simplifyBranch(i) || changed
+		}
+	}
+	return changed
+}
+
+// reachable returns the instructions reachable from address 0.
+func (o *optimizer) reachable() []bool {
+	reachable := make([]bool, len(o.code))
+	work := []int{0}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		for o.valid(pc) && !reachable[pc] {
+			reachable[pc] = true
+			inst := o.code[pc]
+			if addr, ok := target(inst); ok {
+				work = append(work, addr)
+			}
+			if inst.GetCode() == InstructJTB {
+				// the entries and the instruction after the table
+				for k := 1; k <= inst.(*ValueInstruction).Value+1; k++ {
+					work = append(work, pc+k)
+				}
+				break
+			}
+			if ends(inst.GetCode()) {
+				break
+			}
+			pc++
+		}
+	}
+	return reachable
+}
+
+// ends reports whether the instruction of the code never continues to
+// the next one: JMP, and the returns and tail calls.
+func ends(code byte) bool {
+	switch code {
+	case InstructJMP, InstructRET, InstructRTN, InstructTCL, InstructRETS, InstructRTNS, InstructTCLS:
+		return true
+	}
+	return false
+}
+
+// combineLoad replaces LDA l,o; LIT k; OPR ADD; OPR LID with LOD l,o+k,
+// or LDAS with LODS.
+func (o *optimizer) combineLoad(addr int) bool {
+	if !o.interior(addr, 3) {
+		return false
+	}
+	k, ok := o.isLiteral(addr + 1)
+	if !ok || !o.isOpr(addr+2, OpTypeADD) || !o.isOpr(addr+3, OpTypeLID) {
+		return false
+	}
+	lda := o.code[addr].(*AddrInstruction)
+	offset := lda.Offset + k
+	if offset < -1<<15 || offset >= 1<<15 {
+		return false
+	}
+	code := byte(InstructLOD)
+	if lda.Code == InstructLDAS {
+		code = InstructLODS
+	}
+	o.code[addr] = &AddrInstruction{code, Address{lda.Level, offset}}
+	o.remove(addr + 1)
+	o.remove(addr + 2)
+	o.remove(addr + 3)
+	o.stats.LoadsCombined++
+	return true
+}
+
+// simplifyBranch replaces the following with direct branches:
+//
+//	LIT 0; OPR NEQ; JPC t  =>  JPC t
+//	LIT 0; OPR EQ; JPC t   =>  JPC next; JMP t
+//	LIT 0; JPC t           =>  JMP t
+//	LIT c; JPC t           =>  (nothing) if c is not 0
+//
+// The LIT may be jumped to, since the rewritten code behaves the same
+// from that address.
+func (o *optimizer) simplifyBranch(addr int) bool {
+	if addr == 0 {
+		return false
+	}
+	c, _ := o.isLiteral(addr)
+	switch {
+	case o.interior(addr, 1) && o.isCode(addr+1, InstructJPC):
+		jpc := o.code[addr+1].(*ValueInstruction)
+		o.remove(addr)
+		if c == 0 {
+			o.code[addr+1] = &ValueInstruction{InstructJMP, jpc.Value}
+		} else {
+			o.remove(addr + 1)
+		}
+	case c == 0 && o.interior(addr, 2) && o.isCode(addr+2, InstructJPC) && o.isOpr(addr+1, OpTypeNEQ):
+		o.remove(addr)
+		o.remove(addr + 1)
+	case c == 0 && o.interior(addr, 2) && o.isCode(addr+2, InstructJPC) && o.isOpr(addr+1, OpTypeEQ):
+		jpc := o.code[addr+2].(*ValueInstruction)
+		o.remove(addr)
+		o.code[addr+1] = &ValueInstruction{InstructJPC, addr + 3}
+		o.code[addr+2] = &ValueInstruction{InstructJMP, jpc.Value}
+	default:
+		return false
+	}
+	o.stats.BranchesSimplified++
+	return true
+}
+
+// compact removes the marked instructions and remaps addresses.
+// An address of a removed instruction is mapped to the next instruction.
+func (o *optimizer) compact() []Instruction {
+	newAddr := make([]int, len(o.code)+1)
+	var code []Instruction
+	for i, inst := range o.code {
+		newAddr[i] = len(code)
+		if !o.removed[i] {
+			code = append(code, inst)
+		}
+	}
+	newAddr[len(o.code)] = len(code)
+	for _, inst := range code {
+		if addr, ok := target(inst); ok && addr >= 0 && addr <= len(o.code) {
+			setTarget(inst, newAddr[addr])
+		}
+	}
+	return code
+}
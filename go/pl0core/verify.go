@@ -0,0 +1,131 @@
+package pl0core
+
+import (
+	"fmt"
+)
+
+// Verify checks instructions statically before running them,
+// and returns ErrorList of all errors found.
+// It checks instruction codes, operation types, jump and call addresses,
+// jump tables, display levels, numbers of static links and sizes of ICT,
+// that the display and static links are not mixed, and that the pool of
+// strings follows the code.
+func Verify(instructions []Instruction) error {
+	var errors ErrorList
+	report := func(pc int, code string, format string, args ...interface{}) {
+		errors = append(errors, &Error{PC: pc, Code: code, Msg: fmt.Sprintf(format, args...)})
+	}
+	code, _ := SplitStrings(instructions)
+	checkAddress := func(pc int, addr int) {
+		if addr < 0 || addr >= len(code) {
+			report(pc, CodeInvalidAddress, "Address %d is out of range", addr)
+		}
+	}
+	checkLevel := func(pc int, level int) {
+		if level < 0 || level >= PL0VMMaxLevel {
+			report(pc, CodeInvalidLevel, "Level %d is out of range", level)
+		}
+	}
+
+	checkLinks := func(pc int, links int, min int) {
+		if links < min {
+			report(pc, CodeInvalidLevel, "Number of static links %d is invalid", links)
+		}
+	}
+	// the convention of the first call, return or variable access
+	conventionFound, staticLinks, mixed := false, false, false
+	checkConvention := func(pc int, static bool) {
+		if !conventionFound {
+			conventionFound, staticLinks = true, static
+		} else if static != staticLinks && !mixed {
+			report(pc, CodeMixedConvention, "Instructions with the display and static links are mixed")
+			mixed = true
+		}
+	}
+
+	if len(code) == 0 {
+		report(0, CodeInvalidAddress, "No instructions")
+	}
+	for pc, inst := range code {
+		for _, level := range displayLevels(inst) {
+			checkLevel(pc, level)
+		}
+		switch inst.GetCode() {
+		case InstructLOD, InstructLDA, InstructSTO, InstructCAL, InstructRET, InstructRTN, InstructTCL:
+			checkConvention(pc, false)
+		case InstructLODS, InstructLDAS, InstructSTOS, InstructCALS, InstructRETS, InstructRTNS, InstructTCLS:
+			checkConvention(pc, true)
+		}
+		switch inst := inst.(type) {
+		case *AddrInstruction:
+			switch inst.Code {
+			case InstructLOD, InstructLDA, InstructSTO:
+			case InstructLODS, InstructLDAS, InstructSTOS:
+				checkLinks(pc, inst.Level, 0)
+			case InstructCAL:
+				checkAddress(pc, inst.Offset)
+			case InstructCALS:
+				checkLinks(pc, inst.Level, 0)
+				checkAddress(pc, inst.Offset)
+			case InstructRET, InstructRTN, InstructRETS, InstructRTNS:
+				if inst.Offset < 0 {
+					report(pc, CodeInvalidValue, "Number of parameters %d is invalid", inst.Offset)
+				}
+			default:
+				report(pc, CodeUnknownInstruction, "Unknown instruction code: %d", inst.Code)
+			}
+		case *ValueInstruction:
+			switch inst.Code {
+			case InstructLIT:
+			case InstructICT:
+				if inst.Value < 0 || inst.Value >= PL0VMStackSize {
+					report(pc, CodeInvalidValue, "Size %d of ICT is invalid", inst.Value)
+				}
+			case InstructJMP, InstructJPC:
+				checkAddress(pc, inst.Value)
+			case InstructJTB:
+				// the table and the instruction following it
+				if inst.Value < 0 {
+					report(pc, CodeInvalidValue, "Size %d of jump table is invalid", inst.Value)
+					break
+				}
+				checkAddress(pc, pc+inst.Value+1)
+				for i := pc + 1; i <= pc+inst.Value && i < len(code); i++ {
+					if code[i].GetCode() != InstructJMP {
+						report(pc, CodeInvalidValue, "Entry %d of jump table is not JMP", i-pc-1)
+					}
+				}
+			default:
+				report(pc, CodeUnknownInstruction, "Unknown instruction code: %d", inst.Code)
+			}
+		case *TailCallInstruction:
+			switch inst.Code {
+			case InstructTCL:
+			case InstructTCLS:
+				// the callee cannot be enclosed in the frame of the caller
+				checkLinks(pc, inst.Address.Level, 1)
+			default:
+				report(pc, CodeUnknownInstruction, "Unknown instruction code: %d", inst.Code)
+				continue
+			}
+			checkAddress(pc, inst.Offset)
+			if inst.Params < 0 {
+				report(pc, CodeInvalidValue, "Number of parameters %d is invalid", inst.Params)
+			}
+			if inst.Args < 0 {
+				report(pc, CodeInvalidValue, "Number of arguments %d is invalid", inst.Args)
+			}
+		case *OperationInstruction:
+			if inst.Code != InstructOPR {
+				report(pc, CodeUnknownInstruction, "Unknown instruction code: %d", inst.Code)
+			} else if inst.OpType < OpTypeNEG || inst.OpType > OpTypeCHK {
+				report(pc, CodeUnknownOperation, "Unknown operation type: %d", inst.OpType)
+			}
+		case *StringInstruction:
+			report(pc, CodeInvalidAddress, "String constant is not in the pool following the code")
+		default:
+			report(pc, CodeUnknownInstruction, "Unknown instruction code: %d", inst.GetCode())
+		}
+	}
+	return errors.Err()
+}
@@ -1,27 +1,39 @@
 package pl0core
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 )
 
 const (
 	// PL0VMStackSize is PL0VM stack size.
 	PL0VMStackSize = 2048
 
-	// PL0VMMaxLevel is PL0VM max level.
-	PL0VMMaxLevel = 5
+	// PL0VMMaxLevel is the number of levels supported by PL0VM.
+	// The display is sized for the levels of a program up to it.
+	// Deeper functions could not be called, since the frames of all the
+	// enclosing functions, of at least 2 words each, are on the stack.
+	PL0VMMaxLevel = PL0VMStackSize / 2
+
+	// PL0VMMaxWidth is the maximum field width of WRF.
+	PL0VMMaxWidth = 1000
 )
 
 // PL0VM is PL/0 VM
 type PL0VM struct {
 	Debug   bool
 	Output  io.Writer
+	Input   io.Reader // read by OPR RED
+	input   io.Reader // Input which can unread characters after numbers
 	stack   [PL0VMStackSize]int
-	display [PL0VMMaxLevel]int
+	display []int
+	strings []string // pool of string constants
 	top     int
 	pc      int
+	bp      int // base of the current frame with static links
 }
 
 // NewPL0VM creates a PL0VM instance.
@@ -29,20 +41,55 @@ func NewPL0VM() *PL0VM {
 	vm := new(PL0VM)
 	vm.Debug = false
 	vm.Output = os.Stdout
+	vm.Input = os.Stdin
 	vm.top = 0
 	vm.pc = 0
 	return vm
 }
 
-// Run executes instructions.
-func (vm *PL0VM) Run(instructions []Instruction) error {
+// Run executes instructions, which may be followed by a pool of strings.
+// Runtime errors are returned as *Error, also for malformed instructions
+// which Verify doesn't reject, such as popping the empty stack.
+func (vm *PL0VM) Run(instructions []Instruction) (err error) {
+	pc := 0 // pc of the current instruction
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case *Error:
+				err = r
+			case runtime.Error:
+				err = &Error{PC: pc, Code: CodeInvalidAddress, Msg: fmt.Sprintf("Invalid access: %v", r)}
+			default:
+				panic(r)
+			}
+		}
+	}()
+
+	instructions, vm.strings = SplitStrings(instructions)
+	size, err := displaySize(instructions)
+	if err != nil {
+		return err
+	}
+	vm.display = make([]int, size)
 	vm.top = 0
 	vm.pc = 0
+	if _, ok := vm.Input.(io.RuneScanner); ok || vm.Input == nil {
+		vm.input = vm.Input
+	} else {
+		vm.input = bufio.NewReader(vm.Input)
+	}
 	vm.display[0] = 0
 	vm.stack[vm.top] = vm.display[0]
 	vm.stack[vm.top+1] = vm.pc
+	// the frame of the main block with static links links to itself
+	vm.bp = 0
+	vm.stack[vm.top+2] = vm.bp
 
 	for {
+		if vm.pc < 0 || vm.pc >= len(instructions) {
+			return &Error{PC: pc, Code: CodeInvalidAddress, Msg: fmt.Sprintf("Address %d is out of range", vm.pc)}
+		}
+		pc = vm.pc
 		inst := instructions[vm.pc]
 		vm.pc++
 
@@ -61,6 +108,48 @@ func (vm *PL0VM) Run(instructions []Instruction) error {
 	return nil
 }
 
+// displaySize returns the size of the display for the levels which the
+// instructions refer to, or an error if a level is not supported.
+func displaySize(code []Instruction) (int, error) {
+	size := 1
+	for pc, inst := range code {
+		for _, level := range displayLevels(inst) {
+			if level < 0 || level >= PL0VMMaxLevel {
+				return 0, &Error{PC: pc, Code: CodeInvalidLevel, Msg: fmt.Sprintf("Level %d is out of range", level)}
+			}
+			if level >= size {
+				size = level + 1
+			}
+		}
+	}
+	return size, nil
+}
+
+// displayLevels returns the levels of the display which the instruction
+// refers to: the level of the variable or the returning frame, or the
+// level of the callee and of the caller of TCL.
+func displayLevels(inst Instruction) []int {
+	switch inst := inst.(type) {
+	case *AddrInstruction:
+		switch inst.Code {
+		case InstructLOD, InstructLDA, InstructSTO, InstructRET, InstructRTN:
+			return []int{inst.Level}
+		case InstructCAL:
+			return []int{inst.Level + 1}
+		}
+	case *TailCallInstruction:
+		if inst.Code == InstructTCL {
+			return []int{inst.Address.Level + 1, inst.Level}
+		}
+	}
+	return nil
+}
+
+// error returns a runtime error of the current instruction.
+func (vm *PL0VM) error(code string, format string, args ...interface{}) *Error {
+	return &Error{PC: vm.pc - 1, Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
 func (vm *PL0VM) printState(inst Instruction) {
 	fmt.Fprintf(vm.Output, "%s\n", inst)
 	fmt.Fprintf(vm.Output, "  pc=%d\n", vm.pc)
@@ -84,6 +173,9 @@ func (vm *PL0VM) executeInstruction(inst Instruction) error {
 	case InstructCAL:
 		ai := inst.(*AddrInstruction)
 		calleeLevel := ai.Level + 1
+		if vm.top+1 >= PL0VMStackSize {
+			panic(vm.error(CodeStackOverflow, "Stack overflow"))
+		}
 		vm.stack[vm.top] = vm.display[calleeLevel]
 		vm.stack[vm.top+1] = vm.pc
 		vm.display[calleeLevel] = vm.top
@@ -98,10 +190,63 @@ func (vm *PL0VM) executeInstruction(inst Instruction) error {
 		vm.pc = vm.stack[vm.top+1]
 		vm.top -= numFuncParams
 		vm.push(retValue)
+	case InstructRTN:
+		ai := inst.(*AddrInstruction)
+		vm.top = vm.display[ai.Level]
+		vm.display[ai.Level] = vm.stack[vm.top]
+		vm.pc = vm.stack[vm.top+1]
+		vm.top -= ai.Offset
+	case InstructTCL:
+		// RET of the caller, keeping the arguments
+		ti := inst.(*TailCallInstruction)
+		base := vm.display[ti.Level]
+		retPC := vm.stack[base+1]
+		vm.display[ti.Level] = vm.stack[base]
+		args := vm.stack[vm.top-ti.Args : vm.top]
+		vm.top = base - ti.Params
+		copy(vm.stack[vm.top:], args)
+		vm.top += ti.Args
+		// CAL of the callee, returning to the caller of the caller
+		calleeLevel := ti.Address.Level + 1
+		vm.stack[vm.top] = vm.display[calleeLevel]
+		vm.stack[vm.top+1] = retPC
+		vm.display[calleeLevel] = vm.top
+		vm.pc = ti.Offset
+	case InstructLODS:
+		ai := inst.(*AddrInstruction)
+		vm.push(vm.stack[vm.base(ai.Level)+ai.Offset])
+	case InstructLDAS:
+		ai := inst.(*AddrInstruction)
+		vm.push(vm.base(ai.Level) + ai.Offset)
+	case InstructSTOS:
+		ai := inst.(*AddrInstruction)
+		vm.stack[vm.base(ai.Level)+ai.Offset] = vm.pop()
+	case InstructCALS:
+		ai := inst.(*AddrInstruction)
+		vm.callStatic(vm.base(ai.Level), vm.pc, vm.bp)
+		vm.pc = ai.Offset
+	case InstructRETS:
+		retValue := vm.pop()
+		vm.returnStatic(inst.(*AddrInstruction).Offset)
+		vm.push(retValue)
+	case InstructRTNS:
+		vm.returnStatic(inst.(*AddrInstruction).Offset)
+	case InstructTCLS:
+		// RETS of the caller, keeping the arguments and the links
+		ti := inst.(*TailCallInstruction)
+		link := vm.base(ti.Address.Level)
+		retPC, dynamicLink := vm.stack[vm.bp+1], vm.stack[vm.bp+2]
+		args := vm.stack[vm.top-ti.Args : vm.top]
+		vm.top = vm.bp - ti.Params
+		copy(vm.stack[vm.top:], args)
+		vm.top += ti.Args
+		// CALS of the callee, returning to the caller of the caller
+		vm.callStatic(link, retPC, dynamicLink)
+		vm.pc = ti.Offset
 	case InstructICT:
 		vi := inst.(*ValueInstruction)
 		if vm.top+vi.Value >= PL0VMStackSize {
-			panic("stack overflow")
+			panic(vm.error(CodeStackOverflow, "Stack overflow"))
 		}
 		vm.top += vi.Value
 	case InstructJMP:
@@ -112,21 +257,57 @@ func (vm *PL0VM) executeInstruction(inst Instruction) error {
 			vi := inst.(*ValueInstruction)
 			vm.pc = vi.Value
 		}
+	case InstructJTB:
+		vi := inst.(*ValueInstruction)
+		if i := vm.pop(); i >= 0 && i < vi.Value {
+			vm.pc += i
+		} else {
+			vm.pc += vi.Value
+		}
 	case InstructOPR:
 		err := vm.doOpration(inst.(*OperationInstruction))
 		if err != nil {
 			return err
 		}
 	default:
-		return fmt.Errorf("Unknown instruction code: %d", inst.GetCode())
+		return vm.error(CodeUnknownInstruction, "Unknown instruction code: %d", inst.GetCode())
 	}
 
 	return nil
 }
 
+// base returns the base of the frame which is the given number of
+// static links away from the current frame.
+func (vm *PL0VM) base(links int) int {
+	b := vm.bp
+	for ; links > 0; links-- {
+		b = vm.stack[b]
+	}
+	return b
+}
+
+// callStatic pushes the frame of a call with static links.
+func (vm *PL0VM) callStatic(link int, retPC int, dynamicLink int) {
+	if vm.top+2 >= PL0VMStackSize {
+		panic(vm.error(CodeStackOverflow, "Stack overflow"))
+	}
+	vm.stack[vm.top] = link
+	vm.stack[vm.top+1] = retPC
+	vm.stack[vm.top+2] = dynamicLink
+	vm.bp = vm.top
+}
+
+// returnStatic pops the current frame with static links and the parameters.
+func (vm *PL0VM) returnStatic(numFuncParams int) {
+	vm.top = vm.bp
+	vm.pc = vm.stack[vm.top+1]
+	vm.bp = vm.stack[vm.top+2]
+	vm.top -= numFuncParams
+}
+
 func (vm *PL0VM) push(value int) {
 	if vm.top+1 >= PL0VMStackSize {
-		panic("stack overflow")
+		panic(vm.error(CodeStackOverflow, "Stack overflow"))
 	}
 	vm.stack[vm.top] = value
 	vm.top++
@@ -150,6 +331,9 @@ func (vm *PL0VM) doOpration(oi *OperationInstruction) error {
 	case OpTypeMUL:
 		vm.operateBinaryInt(func(a int, b int) int { return a * b })
 	case OpTypeDIV:
+		if vm.stack[vm.top-1] == 0 {
+			return vm.error(CodeDivisionByZero, "Division by zero")
+		}
 		vm.operateBinaryInt(func(a int, b int) int { return a / b })
 	case OpTypeEQ:
 		vm.operateBinaryBool(func(a int, b int) bool { return a == b })
@@ -172,8 +356,69 @@ func (vm *PL0VM) doOpration(oi *OperationInstruction) error {
 	case OpTypeSID:
 		vm.stack[vm.stack[vm.top-2]] = vm.stack[vm.top-1]
 		vm.top -= 2
+	case OpTypeRED:
+		if vm.input == nil {
+			return vm.error(CodeInvalidInput, "No input")
+		}
+		var value int
+		if _, err := fmt.Fscan(vm.input, &value); err != nil {
+			return vm.error(CodeInvalidInput, "Invalid input: %s", err)
+		}
+		vm.push(value)
+	case OpTypeNOT:
+		if vm.stack[vm.top-1] == 0 {
+			vm.stack[vm.top-1] = 1
+		} else {
+			vm.stack[vm.top-1] = 0
+		}
+	case OpTypeMOD:
+		if vm.stack[vm.top-1] == 0 {
+			return vm.error(CodeDivisionByZero, "Division by zero")
+		}
+		// a = (a / b) * b + a mod b, as DIV truncates toward zero
+		vm.operateBinaryInt(func(a int, b int) int { return a % b })
+	case OpTypeABS:
+		if vm.stack[vm.top-1] < 0 {
+			vm.stack[vm.top-1] = -vm.stack[vm.top-1]
+		}
+	case OpTypeMIN:
+		vm.operateBinaryInt(func(a int, b int) int {
+			if b < a {
+				return b
+			}
+			return a
+		})
+	case OpTypeMAX:
+		vm.operateBinaryInt(func(a int, b int) int {
+			if b > a {
+				return b
+			}
+			return a
+		})
+	case OpTypeSQR:
+		vm.stack[vm.top-1] *= vm.stack[vm.top-1]
+	case OpTypeWRS:
+		index := vm.pop()
+		if index < 0 || index >= len(vm.strings) {
+			return vm.error(CodeInvalidValue, "String %d is out of range", index)
+		}
+		fmt.Fprint(vm.Output, vm.strings[index])
+	case OpTypeWRF:
+		width := vm.pop()
+		if width > PL0VMMaxWidth {
+			return vm.error(CodeInvalidValue, "Width %d exceeds the maximum %d", width, PL0VMMaxWidth)
+		}
+		if width < 0 {
+			width = 0
+		}
+		fmt.Fprintf(vm.Output, "%*d", width, vm.pop())
+	case OpTypeCHK:
+		length := vm.pop()
+		if index := vm.stack[vm.top-1]; index < 0 || index >= length {
+			return vm.error(CodeIndexOutOfRange, "Index %d is out of range of length %d", index, length)
+		}
 	default:
-		return fmt.Errorf("Unknown operation type: %d", oi.OpType)
+		return vm.error(CodeUnknownOperation, "Unknown operation type: %d", oi.OpType)
 	}
 	return nil
 }
@@ -2,6 +2,8 @@ package pl0core
 
 import (
 	"bytes"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -519,3 +521,719 @@ func TestUnknownOperationType(t *testing.T) {
 		t.Errorf("Got: %s\nWant: %s", err.Error(), wantMsg)
 	}
 }
+
+func TestRuntimeErrors(t *testing.T) {
+	targets := []struct {
+		instructions []Instruction
+		wantPC       int
+		wantCode     string
+	}{
+		{
+			// write 1 / 0
+			[]Instruction{
+				&ValueInstruction{InstructLIT, 1},
+				&ValueInstruction{InstructLIT, 0},
+				&OperationInstruction{InstructOPR, OpTypeDIV},
+				&OperationInstruction{InstructOPR, OpTypeWRT},
+			},
+			2, CodeDivisionByZero,
+		},
+		{
+			// write 1 mod 0
+			[]Instruction{
+				&ValueInstruction{InstructLIT, 1},
+				&ValueInstruction{InstructLIT, 0},
+				&OperationInstruction{InstructOPR, OpTypeMOD},
+				&OperationInstruction{InstructOPR, OpTypeWRT},
+			},
+			2, CodeDivisionByZero,
+		},
+		{
+			// infinite recursion
+			[]Instruction{
+				&ValueInstruction{InstructJMP, 1},
+				&ValueInstruction{InstructICT, 2},
+				&AddrInstruction{InstructCAL, Address{0, 1}},
+			},
+			1, CodeStackOverflow,
+		},
+		{
+			// var a; function f(n) begin return f(n) + 1 end; begin write f(1) end.
+			// without tail calls, overflowing at CAL
+			[]Instruction{
+				&ValueInstruction{InstructJMP, 8},
+				&ValueInstruction{InstructJMP, 2},
+				&ValueInstruction{InstructICT, 2},
+				&AddrInstruction{InstructLOD, Address{1, -1}},
+				&AddrInstruction{InstructCAL, Address{0, 2}},
+				&ValueInstruction{InstructLIT, 1},
+				&OperationInstruction{InstructOPR, OpTypeADD},
+				&AddrInstruction{InstructRET, Address{1, 1}},
+				&ValueInstruction{InstructICT, 3},
+				&ValueInstruction{InstructLIT, 1},
+				&AddrInstruction{InstructCAL, Address{0, 2}},
+				&OperationInstruction{InstructOPR, OpTypeWRT},
+				&AddrInstruction{InstructRET, Address{0, 0}},
+			},
+			4, CodeStackOverflow,
+		},
+		{
+			// write 1:1000001
+			[]Instruction{
+				&ValueInstruction{InstructLIT, 1},
+				&ValueInstruction{InstructLIT, 1000001},
+				&OperationInstruction{InstructOPR, OpTypeWRF},
+			},
+			2, CodeInvalidValue,
+		},
+		{
+			// adding on the empty stack
+			[]Instruction{
+				&OperationInstruction{InstructOPR, OpTypeADD},
+			},
+			0, CodeInvalidAddress,
+		},
+		{
+			// returning to an address out of the code
+			[]Instruction{
+				&ValueInstruction{InstructICT, 3},
+				&ValueInstruction{InstructLIT, 9},
+				&AddrInstruction{InstructSTO, Address{0, 1}},
+				&ValueInstruction{InstructLIT, 0},
+				&AddrInstruction{InstructRET, Address{0, 0}},
+			},
+			4, CodeInvalidAddress,
+		},
+	}
+	for nth, target := range targets {
+		vm := NewPL0VM()
+		err := vm.Run(target.instructions)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("#%d: Got: %v", nth, err)
+		} else if e.PC != target.wantPC || e.Code != target.wantCode {
+			t.Errorf("#%d: Got: %+v\nWant: pc %d, %s", nth, e, target.wantPC, target.wantCode)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for nth, target := range inspectionTargets {
+		instructions, err := ReadInstructions(strings.NewReader(target.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(instructions); err != nil {
+			t.Errorf("#%d: Got: %v", nth, err)
+		}
+	}
+
+	instructions := []Instruction{
+		&ValueInstruction{InstructJMP, 7},
+		&AddrInstruction{InstructLOD, Address{PL0VMMaxLevel, 2}},
+		&AddrInstruction{InstructCAL, Address{0, -1}},
+		&ValueInstruction{InstructICT, -1},
+		&OperationInstruction{InstructOPR, 0},
+		&StringInstruction{InstructSTR, "not in the pool"},
+		&ValueInstruction{0, 0},
+		&StringInstruction{InstructSTR, "pool"},
+	}
+	want := []string{
+		"0 invalid-address Address 7 is out of range",
+		"1 invalid-level Level 1024 is out of range",
+		"2 invalid-address Address -1 is out of range",
+		"3 invalid-value Size -1 of ICT is invalid",
+		"4 unknown-operation Unknown operation type: 0",
+		"5 invalid-address String constant is not in the pool following the code",
+		"6 unknown-instruction Unknown instruction code: 0",
+	}
+	errors, _ := Verify(instructions).(ErrorList)
+	var got []string
+	for _, e := range errors {
+		got = append(got, fmt.Sprintf("%d %s %s", e.PC, e.Code, e.Msg))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nWant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	before, after := 0, 0
+	for nth, target := range inspectionTargets {
+		instructions, err := ReadInstructions(strings.NewReader(target.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		optimized, stats := Optimize(instructions)
+		if err := Verify(optimized); err != nil {
+			t.Errorf("#%d: Verify: %v", nth, err)
+			continue
+		}
+		if stats.Before != len(instructions) || stats.After != len(optimized) || stats.After > stats.Before {
+			t.Errorf("#%d: Got stats %+v for %d instructions", nth, stats, len(optimized))
+		}
+		before += stats.Before
+		after += stats.After
+		outBuf := bytes.NewBufferString("")
+		vm := NewPL0VM()
+		vm.Output = outBuf
+		if err := vm.Run(optimized); err != nil {
+			t.Errorf("#%d: Error: %s", nth, err)
+		} else if got := outBuf.String(); got != target.want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.want)
+		}
+
+		// the input is not modified
+		if again, _ := ReadInstructions(strings.NewReader(target.input)); !reflect.DeepEqual(again, instructions) {
+			t.Errorf("#%d: Input modified", nth)
+		}
+	}
+	if after >= before {
+		t.Errorf("Not optimized: %d instructions to %d", before, after)
+	}
+}
+
+func TestOptimizeRules(t *testing.T) {
+	jmp := func(addr int) Instruction { return &ValueInstruction{InstructJMP, addr} }
+	jpc := func(addr int) Instruction { return &ValueInstruction{InstructJPC, addr} }
+	lit := func(v int) Instruction { return &ValueInstruction{InstructLIT, v} }
+	opr := func(opType byte) Instruction { return &OperationInstruction{InstructOPR, opType} }
+	addr := func(code byte, level int, offset int) Instruction {
+		return &AddrInstruction{code, Address{level, offset}}
+	}
+	ret := addr(InstructRET, 0, 0)
+
+	targets := []struct {
+		instructions []Instruction
+		want         []Instruction
+		stats        OptimizeStats
+	}{
+		{ // JMP to the next instruction, except at address 0
+			[]Instruction{jmp(1), &ValueInstruction{InstructICT, 2}, jmp(3), lit(1), opr(OpTypeWRT), ret},
+			[]Instruction{jmp(1), &ValueInstruction{InstructICT, 2}, lit(1), opr(OpTypeWRT), ret},
+			OptimizeStats{JumpsRemoved: 1},
+		},
+		{ // jump chains and unreachable code
+			[]Instruction{jmp(3), lit(9), opr(OpTypeWRT), jmp(5), ret, jmp(7), lit(8), lit(1), opr(OpTypeWRT), ret},
+			[]Instruction{jmp(1), lit(1), opr(OpTypeWRT), ret},
+			OptimizeStats{JumpsThreaded: 2, Unreachable: 6},
+		},
+		{ // jumps to the end of the program
+			[]Instruction{jmp(1), lit(1), jpc(3), jmp(5), ret, jmp(0)},
+			[]Instruction{jmp(0)},
+			OptimizeStats{JumpsThreaded: 3, Unreachable: 3, BranchesSimplified: 1},
+		},
+		{ // calls are threaded and remapped
+			[]Instruction{jmp(4), lit(1), ret, jmp(1), addr(InstructCAL, 0, 3), opr(OpTypeWRT), ret},
+			[]Instruction{jmp(3), lit(1), ret, addr(InstructCAL, 0, 1), opr(OpTypeWRT), ret},
+			OptimizeStats{JumpsThreaded: 1, Unreachable: 1},
+		},
+		{ // LDA l,o; LIT k; OPR ADD; OPR LID
+			[]Instruction{jmp(1), addr(InstructLDA, 0, 2), lit(3), opr(OpTypeADD), opr(OpTypeLID), opr(OpTypeWRT), ret},
+			[]Instruction{jmp(1), addr(InstructLOD, 0, 5), opr(OpTypeWRT), ret},
+			OptimizeStats{LoadsCombined: 1},
+		},
+		{ // LDAS l,o; LIT k; OPR ADD; OPR LID
+			[]Instruction{jmp(1), addr(InstructLDAS, 1, 3), lit(3), opr(OpTypeADD), opr(OpTypeLID), opr(OpTypeWRT),
+				addr(InstructRETS, 0, 0)},
+			[]Instruction{jmp(1), addr(InstructLODS, 1, 6), opr(OpTypeWRT), addr(InstructRETS, 0, 0)},
+			OptimizeStats{LoadsCombined: 1},
+		},
+		{ // not combined if jumped into
+			[]Instruction{jmp(1), addr(InstructLDA, 0, 2), lit(3), opr(OpTypeADD), opr(OpTypeLID), jpc(3), ret},
+			[]Instruction{jmp(1), addr(InstructLDA, 0, 2), lit(3), opr(OpTypeADD), opr(OpTypeLID), jpc(3), ret},
+			OptimizeStats{},
+		},
+		{ // x <> 0 and x = 0 before JPC
+			[]Instruction{jmp(1), addr(InstructLOD, 0, 2), lit(0), opr(OpTypeNEQ), jpc(11),
+				addr(InstructLOD, 0, 2), lit(0), opr(OpTypeEQ), jpc(11), lit(7), opr(OpTypeWRT), ret},
+			[]Instruction{jmp(1), addr(InstructLOD, 0, 2), jpc(8),
+				addr(InstructLOD, 0, 2), jpc(6), jmp(8), lit(7), opr(OpTypeWRT), ret},
+			OptimizeStats{BranchesSimplified: 2},
+		},
+		{ // constant conditions
+			[]Instruction{jmp(1), lit(1), jpc(5), lit(2), opr(OpTypeWRT), lit(0), jpc(9), lit(3), opr(OpTypeWRT), ret},
+			[]Instruction{jmp(1), lit(2), opr(OpTypeWRT), ret},
+			OptimizeStats{BranchesSimplified: 2, Unreachable: 2, JumpsRemoved: 1},
+		},
+		{ // entries of jump tables are threaded, but kept in place
+			[]Instruction{jmp(1), lit(1), &ValueInstruction{InstructJTB, 2}, jmp(8), jmp(5),
+				lit(0), opr(OpTypeWRT), ret, jmp(9), lit(7), opr(OpTypeWRT), ret},
+			[]Instruction{jmp(1), lit(1), &ValueInstruction{InstructJTB, 2}, jmp(8), jmp(5),
+				lit(0), opr(OpTypeWRT), ret, lit(7), opr(OpTypeWRT), ret},
+			OptimizeStats{JumpsThreaded: 1, Unreachable: 1},
+		},
+	}
+	for nth, target := range targets {
+		got, stats := Optimize(target.instructions)
+		if !reflect.DeepEqual(got, target.want) {
+			var lines []string
+			for _, inst := range got {
+				lines = append(lines, fmt.Sprint(inst))
+			}
+			t.Errorf("#%d: Got:\n%s", nth, strings.Join(lines, "\n"))
+		}
+		target.stats.Before, target.stats.After = len(target.instructions), len(target.want)
+		if *stats != target.stats {
+			t.Errorf("#%d: Got stats %+v\nWant %+v", nth, *stats, target.stats)
+		}
+	}
+}
+
+func TestTailCallInstruction(t *testing.T) {
+	// function f(n) begin if n = 0 then return 7; return f(n - 1) end;
+	// begin write f(5000) end.
+	instructions := []Instruction{
+		&ValueInstruction{InstructJMP, 13},
+		&ValueInstruction{InstructICT, 2},
+		&AddrInstruction{InstructLOD, Address{1, -1}},
+		&ValueInstruction{InstructLIT, 0},
+		&OperationInstruction{InstructOPR, OpTypeEQ},
+		&ValueInstruction{InstructJPC, 8},
+		&ValueInstruction{InstructLIT, 7},
+		&AddrInstruction{InstructRET, Address{1, 1}},
+		&AddrInstruction{InstructLOD, Address{1, -1}},
+		&ValueInstruction{InstructLIT, 1},
+		&OperationInstruction{InstructOPR, OpTypeSUB},
+		&TailCallInstruction{InstructTCL, Address{0, 1}, 1, 1, 1},
+		&AddrInstruction{InstructRET, Address{1, 1}},
+		&ValueInstruction{InstructICT, 2},
+		&ValueInstruction{InstructLIT, 5000},
+		&AddrInstruction{InstructCAL, Address{0, 1}},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&AddrInstruction{InstructRET, Address{0, 0}},
+	}
+	buf := bytes.NewBufferString("")
+	if err := WriteInstructions(buf, instructions); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAndRun(buf.String())
+	if err != nil {
+		t.Error(err)
+	} else if got != "7 " {
+		t.Errorf("Got: %s\nWant: 7 ", got)
+	}
+
+	instructions[11] = &TailCallInstruction{InstructTCL, Address{PL0VMMaxLevel, 99}, 1, -1, -1}
+	errors, _ := Verify(instructions).(ErrorList)
+	if len(errors) != 4 {
+		t.Errorf("Got: %v", errors)
+	}
+}
+
+func TestProcedureAndInput(t *testing.T) {
+	// procedure p; begin ?x; !x * 2 end; begin call p; call p end.
+	instructions := []Instruction{
+		&ValueInstruction{InstructJMP, 10},
+		&ValueInstruction{InstructICT, 2},
+		&AddrInstruction{InstructLDA, Address{0, 2}},
+		&OperationInstruction{InstructOPR, OpTypeRED},
+		&OperationInstruction{InstructOPR, OpTypeSID},
+		&AddrInstruction{InstructLOD, Address{0, 2}},
+		&ValueInstruction{InstructLIT, 2},
+		&OperationInstruction{InstructOPR, OpTypeMUL},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&AddrInstruction{InstructRTN, Address{1, 0}},
+		&ValueInstruction{InstructICT, 3},
+		&AddrInstruction{InstructCAL, Address{0, 1}},
+		&AddrInstruction{InstructCAL, Address{0, 1}},
+		&ValueInstruction{InstructLIT, 7},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&AddrInstruction{InstructRET, Address{0, 0}},
+	}
+	if err := Verify(instructions); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBufferString("")
+	if err := WriteInstructions(buf, instructions); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadInstructions(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outBuf := bytes.NewBufferString("")
+	vm := NewPL0VM()
+	vm.Output = outBuf
+	vm.Input = strings.NewReader(" 21\n-4")
+	if err := vm.Run(read); err != nil {
+		t.Error(err)
+	} else if got := outBuf.String(); got != "42 -8 7 " {
+		t.Errorf("Got: %s\nWant: 42 -8 7 ", got)
+	}
+
+	for _, input := range []string{"", "1 x"} {
+		vm := NewPL0VM()
+		vm.Output = bytes.NewBufferString("")
+		vm.Input = strings.NewReader(input)
+		e, ok := vm.Run(read).(*Error)
+		if !ok || e.PC != 3 || e.Code != CodeInvalidInput {
+			t.Errorf("%q: Got: %v, Want invalid input at pc 3", input, e)
+		}
+	}
+}
+
+func TestNotOperation(t *testing.T) {
+	instructions := []Instruction{&ValueInstruction{InstructICT, 2}}
+	for _, value := range []int{0, 1, -3, 2} {
+		instructions = append(instructions,
+			&ValueInstruction{InstructLIT, value},
+			&OperationInstruction{InstructOPR, OpTypeNOT},
+			&OperationInstruction{InstructOPR, OpTypeWRT})
+	}
+	instructions = append(instructions, &AddrInstruction{InstructRET, Address{0, 0}})
+	if err := Verify(instructions); err != nil {
+		t.Fatal(err)
+	}
+	outBuf := bytes.NewBufferString("")
+	vm := NewPL0VM()
+	vm.Output = outBuf
+	if err := vm.Run(instructions); err != nil {
+		t.Error(err)
+	} else if got := outBuf.String(); got != "1 0 0 0 " {
+		t.Errorf("Got: %s\nWant: 1 0 0 0 ", got)
+	}
+}
+
+func TestMathOperations(t *testing.T) {
+	targets := []struct {
+		opType byte
+		args   []int
+		want   int
+	}{
+		{OpTypeMOD, []int{7, 3}, 1},
+		{OpTypeMOD, []int{-7, 3}, -1},
+		{OpTypeMOD, []int{7, -3}, 1},
+		{OpTypeMOD, []int{-7, -3}, -1},
+		{OpTypeABS, []int{-5}, 5},
+		{OpTypeABS, []int{5}, 5},
+		{OpTypeMIN, []int{-2, 3}, -2},
+		{OpTypeMIN, []int{4, 3}, 3},
+		{OpTypeMAX, []int{-2, 3}, 3},
+		{OpTypeMAX, []int{4, -3}, 4},
+		{OpTypeSQR, []int{-3}, 9},
+	}
+	for nth, target := range targets {
+		instructions := []Instruction{&ValueInstruction{InstructICT, 2}}
+		for _, arg := range target.args {
+			instructions = append(instructions, &ValueInstruction{InstructLIT, arg})
+		}
+		instructions = append(instructions,
+			&OperationInstruction{InstructOPR, target.opType},
+			&OperationInstruction{InstructOPR, OpTypeWRT},
+			&AddrInstruction{InstructRET, Address{0, 0}})
+		if err := Verify(instructions); err != nil {
+			t.Fatal(err)
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := NewPL0VM()
+		vm.Output = outBuf
+		want := fmt.Sprintf("%d ", target.want)
+		if err := vm.Run(instructions); err != nil {
+			t.Errorf("#%d: %s", nth, err)
+		} else if got := outBuf.String(); got != want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, want)
+		}
+	}
+}
+
+func TestStringOperations(t *testing.T) {
+	// write "n = ", 42:0; writeln; write -7:4, "|"
+	instructions := []Instruction{
+		&ValueInstruction{InstructJMP, 3},
+		&ValueInstruction{InstructICT, 2}, // unreachable
+		&AddrInstruction{InstructRET, Address{0, 0}},
+		&ValueInstruction{InstructICT, 2},
+		&ValueInstruction{InstructLIT, 0},
+		&OperationInstruction{InstructOPR, OpTypeWRS},
+		&ValueInstruction{InstructLIT, 42},
+		&ValueInstruction{InstructLIT, 0},
+		&OperationInstruction{InstructOPR, OpTypeWRF},
+		&OperationInstruction{InstructOPR, OpTypeWRL},
+		&ValueInstruction{InstructLIT, -7},
+		&ValueInstruction{InstructLIT, 4},
+		&OperationInstruction{InstructOPR, OpTypeWRF},
+		&ValueInstruction{InstructLIT, 1},
+		&OperationInstruction{InstructOPR, OpTypeWRS},
+		&AddrInstruction{InstructRET, Address{0, 0}},
+		&StringInstruction{InstructSTR, "n = "},
+		&StringInstruction{InstructSTR, "|"},
+	}
+	want := "n = 42\n  -7|"
+	if err := Verify(instructions); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBufferString("")
+	if err := WriteInstructions(buf, instructions); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAndRun(buf.String()); err != nil {
+		t.Error(err)
+	} else if got != want {
+		t.Errorf("Got: %q\nWant: %q", got, want)
+	}
+
+	// the pool is kept after the optimized code
+	optimized, stats := Optimize(instructions)
+	if stats.Unreachable != 2 {
+		t.Errorf("Got stats %+v", stats)
+	}
+	code, strings := SplitStrings(optimized)
+	if len(code) != stats.After || len(strings) != 2 {
+		t.Errorf("Got %d instructions and %d strings", len(code), len(strings))
+	}
+	outBuf := bytes.NewBufferString("")
+	vm := NewPL0VM()
+	vm.Output = outBuf
+	if err := vm.Run(optimized); err != nil {
+		t.Error(err)
+	} else if got := outBuf.String(); got != want {
+		t.Errorf("Got: %q\nWant: %q", got, want)
+	}
+
+	// the index out of the pool
+	instructions[13] = &ValueInstruction{InstructLIT, 2}
+	vm = NewPL0VM()
+	vm.Output = bytes.NewBufferString("")
+	err := vm.Run(instructions)
+	if e, ok := err.(*Error); !ok || e.PC != 14 || e.Code != CodeInvalidValue {
+		t.Errorf("Got: %v", err)
+	}
+}
+
+func TestCheckOperation(t *testing.T) {
+	targets := []struct {
+		index, length int
+		ok            bool
+	}{
+		{0, 3, true},
+		{2, 3, true},
+		{3, 3, false},
+		{-1, 3, false},
+		{0, 0, false},
+	}
+	for nth, target := range targets {
+		instructions := []Instruction{
+			&ValueInstruction{InstructICT, 2},
+			&ValueInstruction{InstructLIT, target.index},
+			&ValueInstruction{InstructLIT, target.length},
+			&OperationInstruction{InstructOPR, OpTypeCHK},
+			&OperationInstruction{InstructOPR, OpTypeWRT},
+			&AddrInstruction{InstructRET, Address{0, 0}},
+		}
+		if err := Verify(instructions); err != nil {
+			t.Fatal(err)
+		}
+		outBuf := bytes.NewBufferString("")
+		vm := NewPL0VM()
+		vm.Output = outBuf
+		err := vm.Run(instructions)
+		if target.ok {
+			if want := fmt.Sprintf("%d ", target.index); err != nil || outBuf.String() != want {
+				t.Errorf("#%d: Got: %q, %v\nWant: %q", nth, outBuf.String(), err, want)
+			}
+		} else if e, ok := err.(*Error); !ok || e.PC != 3 || e.Code != CodeIndexOutOfRange {
+			t.Errorf("#%d: Got: %v", nth, err)
+		}
+	}
+}
+
+func TestJumpTable(t *testing.T) {
+	// case i of 0: write 10; 1: write 11; 2: write 12 else write 99 end
+	program := func(i int) []Instruction {
+		return []Instruction{
+			&ValueInstruction{InstructICT, 2},
+			&ValueInstruction{InstructLIT, i},
+			&ValueInstruction{InstructJTB, 3},
+			&ValueInstruction{InstructJMP, 9},
+			&ValueInstruction{InstructJMP, 12},
+			&ValueInstruction{InstructJMP, 15},
+			&ValueInstruction{InstructLIT, 99},
+			&OperationInstruction{InstructOPR, OpTypeWRT},
+			&AddrInstruction{InstructRET, Address{0, 0}},
+			&ValueInstruction{InstructLIT, 10},
+			&OperationInstruction{InstructOPR, OpTypeWRT},
+			&AddrInstruction{InstructRET, Address{0, 0}},
+			&ValueInstruction{InstructLIT, 11},
+			&OperationInstruction{InstructOPR, OpTypeWRT},
+			&AddrInstruction{InstructRET, Address{0, 0}},
+			&ValueInstruction{InstructLIT, 12},
+			&OperationInstruction{InstructOPR, OpTypeWRT},
+			&AddrInstruction{InstructRET, Address{0, 0}},
+		}
+	}
+	targets := []struct {
+		index int
+		want  string
+	}{
+		{0, "10 "}, {1, "11 "}, {2, "12 "}, {3, "99 "}, {-1, "99 "},
+	}
+	for nth, target := range targets {
+		instructions := program(target.index)
+		if err := Verify(instructions); err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.NewBufferString("")
+		if err := WriteInstructions(buf, instructions); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := readAndRun(buf.String()); err != nil {
+			t.Errorf("#%d: %s", nth, err)
+		} else if got != target.want {
+			t.Errorf("#%d: Got: %s\nWant: %s", nth, got, target.want)
+		}
+	}
+
+	// the table must consist of JMP, and be followed by an instruction
+	instructions := []Instruction{
+		&ValueInstruction{InstructICT, 2},
+		&ValueInstruction{InstructLIT, 0},
+		&ValueInstruction{InstructJTB, 2},
+		&ValueInstruction{InstructJMP, 0},
+		&ValueInstruction{InstructLIT, 0},
+	}
+	want := []string{
+		"2 invalid-address Address 5 is out of range",
+		"2 invalid-value Entry 1 of jump table is not JMP",
+	}
+	errors, _ := Verify(instructions).(ErrorList)
+	var got []string
+	for _, e := range errors {
+		got = append(got, fmt.Sprintf("%d %s %s", e.PC, e.Code, e.Msg))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nWant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDisplayLevels(t *testing.T) {
+	// f1 calls f2 nested in it, ..., and f7 writes a variable of main
+	var instructions []Instruction
+	instructions = append(instructions, &ValueInstruction{InstructJMP, 37})
+	for k := 1; k <= 6; k++ {
+		instructions = append(instructions,
+			&ValueInstruction{InstructICT, 2},
+			&ValueInstruction{InstructLIT, k},
+			&OperationInstruction{InstructOPR, OpTypeWRT},
+			&AddrInstruction{InstructCAL, Address{k, 1 + 5*k}},
+			&AddrInstruction{InstructRTN, Address{k, 0}})
+	}
+	instructions = append(instructions,
+		&ValueInstruction{InstructICT, 2},
+		&ValueInstruction{InstructLIT, 7},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&AddrInstruction{InstructLOD, Address{0, 2}},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&AddrInstruction{InstructRTN, Address{7, 0}},
+		&ValueInstruction{InstructICT, 3},
+		&AddrInstruction{InstructLDA, Address{0, 2}},
+		&ValueInstruction{InstructLIT, 42},
+		&OperationInstruction{InstructOPR, OpTypeSID},
+		&AddrInstruction{InstructCAL, Address{0, 1}},
+		&AddrInstruction{InstructRET, Address{0, 0}})
+	if err := Verify(instructions); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBufferString("")
+	if err := WriteInstructions(buf, instructions); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAndRun(buf.String()); err != nil {
+		t.Error(err)
+	} else if want := "1 2 3 4 5 6 7 42 "; got != want {
+		t.Errorf("Got: %s\nWant: %s", got, want)
+	}
+
+	// a level beyond the VM is an error before running
+	instructions = []Instruction{
+		&ValueInstruction{InstructLIT, 1},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&AddrInstruction{InstructCAL, Address{PL0VMMaxLevel, 0}},
+		&AddrInstruction{InstructRET, Address{0, 0}},
+	}
+	out := bytes.NewBufferString("")
+	vm := NewPL0VM()
+	vm.Output = out
+	err := vm.Run(instructions)
+	if e, ok := err.(*Error); !ok || e.PC != 2 || e.Code != CodeInvalidLevel {
+		t.Errorf("Got: %v\nWant: invalid level at 2", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Got output: %s", out.String())
+	}
+}
+
+func TestStaticLinks(t *testing.T) {
+	// var x;
+	// function f(n)
+	//   var y;
+	//   function g(m) begin if m = 0 then return y + x; return g(m - 1) end;
+	// begin y := n * 10; return g(3000) end;
+	// begin x := 5; write f(2); write f(4) end.
+	instructions := []Instruction{
+		&ValueInstruction{InstructJMP, 23},
+		&ValueInstruction{InstructICT, 4},
+		&AddrInstruction{InstructLDAS, Address{0, 3}},
+		&AddrInstruction{InstructLODS, Address{0, -1}},
+		&ValueInstruction{InstructLIT, 10},
+		&OperationInstruction{InstructOPR, OpTypeMUL},
+		&OperationInstruction{InstructOPR, OpTypeSID},
+		&ValueInstruction{InstructLIT, 3000},
+		&AddrInstruction{InstructCALS, Address{0, 10}},
+		&AddrInstruction{InstructRETS, Address{0, 1}},
+		&ValueInstruction{InstructICT, 3},
+		&AddrInstruction{InstructLODS, Address{0, -1}},
+		&ValueInstruction{InstructLIT, 0},
+		&OperationInstruction{InstructOPR, OpTypeEQ},
+		&ValueInstruction{InstructJPC, 19},
+		&AddrInstruction{InstructLODS, Address{1, 3}},
+		&AddrInstruction{InstructLODS, Address{2, 3}},
+		&OperationInstruction{InstructOPR, OpTypeADD},
+		&AddrInstruction{InstructRETS, Address{0, 1}},
+		&AddrInstruction{InstructLODS, Address{0, -1}},
+		&ValueInstruction{InstructLIT, 1},
+		&OperationInstruction{InstructOPR, OpTypeSUB},
+		&TailCallInstruction{InstructTCLS, Address{1, 10}, 0, 1, 1},
+		&ValueInstruction{InstructICT, 4},
+		&ValueInstruction{InstructLIT, 5},
+		&AddrInstruction{InstructSTOS, Address{0, 3}},
+		&ValueInstruction{InstructLIT, 2},
+		&AddrInstruction{InstructCALS, Address{0, 1}},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&ValueInstruction{InstructLIT, 4},
+		&AddrInstruction{InstructCALS, Address{0, 1}},
+		&OperationInstruction{InstructOPR, OpTypeWRT},
+		&AddrInstruction{InstructRETS, Address{0, 0}},
+	}
+	if err := Verify(instructions); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBufferString("")
+	if err := WriteInstructions(buf, instructions); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAndRun(buf.String()); err != nil {
+		t.Error(err)
+	} else if want := "25 45 "; got != want {
+		t.Errorf("Got: %s\nWant: %s", got, want)
+	}
+
+	// the callee of TCLS cannot be enclosed in the frame of the caller,
+	// and the display and static links cannot be mixed
+	instructions[15] = &AddrInstruction{InstructLODS, Address{-1, 3}}
+	instructions[22] = &TailCallInstruction{InstructTCLS, Address{0, 10}, 0, 1, 1}
+	instructions[32] = &AddrInstruction{InstructRET, Address{0, 0}}
+	want := []string{
+		"15 invalid-level Number of static links -1 is invalid",
+		"22 invalid-level Number of static links 0 is invalid",
+		"32 mixed-convention Instructions with the display and static links are mixed",
+	}
+	errors, _ := Verify(instructions).(ErrorList)
+	var got []string
+	for _, e := range errors {
+		got = append(got, fmt.Sprintf("%d %s %s", e.PC, e.Code, e.Msg))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nWant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
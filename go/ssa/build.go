@@ -0,0 +1,733 @@
+package ssa
+
+import (
+	"fmt"
+	"math"
+
+	"kkpl0/ast"
+	"kkpl0/pl0compiler"
+	"kkpl0/pl0core"
+)
+
+// Build returns the program in SSA form.
+// Info must be the result of compiling the program without errors.
+func Build(prog *ast.Program, info *pl0compiler.Info) (*Program, error) {
+	b := &builder{info: info, p: &Program{}, funcs: make(map[*pl0compiler.Symbol]*Func)}
+	if err := b.buildFunc("main", nil, info.Universe.Children[0], prog.Block); err != nil {
+		return nil, err
+	}
+	return b.p, nil
+}
+
+// BuildSource parses, compiles and builds a PL/0 source.
+func BuildSource(sourceName string, src []byte) (*Program, error) {
+	prog, err := pl0compiler.Parse(sourceName, src)
+	if err != nil {
+		return nil, err
+	}
+	c := pl0compiler.NewCompiler(sourceName)
+	if err := c.Compile(prog); err != nil {
+		return nil, err
+	}
+	return Build(prog, c.Info())
+}
+
+type builder struct {
+	info  *pl0compiler.Info
+	p     *Program
+	funcs map[*pl0compiler.Symbol]*Func
+}
+
+// funcBuilder builds a function by the algorithm of Braun et al.,
+// "Simple and Efficient Construction of Static Single Assignment Form",
+// which reads variables through the predecessors of unsealed blocks.
+type funcBuilder struct {
+	*builder
+	f          *Func
+	scope      *pl0compiler.Scope
+	promoted   map[*pl0compiler.Symbol]bool
+	cur        *Block
+	defs       map[*Block]map[*pl0compiler.Symbol]*Value
+	sealed     map[*Block]bool
+	incomplete map[*Block][]incompletePhi
+	loops      []loopTargets // enclosing loops, innermost last
+}
+
+// loopTargets are the blocks to which break and continue of a loop jump.
+type loopTargets struct {
+	brk  *Block
+	cont *Block
+}
+
+type incompletePhi struct {
+	sym *pl0compiler.Symbol
+	phi *Value
+}
+
+func (b *builder) buildFunc(name string, funcSym *pl0compiler.Symbol, scope *pl0compiler.Scope, block *ast.Block) error {
+	f := &Func{Name: name, Level: scope.Level, FrameSize: pl0compiler.FirstVarOffset}
+	if funcSym != nil {
+		f.Params = funcSym.ParamSlots()
+		f.Proc = funcSym.Kind == pl0compiler.SymbolProc
+		b.funcs[funcSym] = f
+	}
+	for _, decl := range block.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok {
+			sym := b.info.Defs[d.Name]
+			if err := b.buildFunc(d.Name.Name, sym, funcScope(scope, sym), d.Body); err != nil {
+				return err
+			}
+		}
+	}
+
+	fb := &funcBuilder{
+		builder:    b,
+		f:          f,
+		scope:      scope,
+		promoted:   make(map[*pl0compiler.Symbol]bool),
+		defs:       make(map[*Block]map[*pl0compiler.Symbol]*Value),
+		sealed:     make(map[*Block]bool),
+		incomplete: make(map[*Block][]incompletePhi),
+	}
+	for _, sym := range scope.Symbols {
+		switch {
+		case sym.Kind == pl0compiler.SymbolVarScalar:
+			fb.promoted[sym] = true
+			if !sym.Param {
+				f.FrameSize = sym.Address.Offset + 1
+			}
+		case sym.Kind == pl0compiler.SymbolVarArray:
+			f.FrameSize = sym.Address.Offset + sym.Size
+		}
+	}
+	fb.unpromote(block)
+
+	f.Entry = f.newBlock()
+	fb.seal(f.Entry)
+	fb.cur = f.Entry
+	if funcSym != nil {
+		for _, param := range funcSym.Params {
+			if fb.promoted[param] {
+				v := f.Entry.newValue(OpParam)
+				v.Addr = param.Address
+				fb.write(param, f.Entry, v)
+			}
+		}
+	}
+	if err := fb.stmt(block.Body); err != nil {
+		return err
+	}
+	fb.cur.Kind = BlockReturn
+	removeUnreachable(f)
+	removeTrivialPhis(f)
+	mergeBlocks(f)
+	b.p.Funcs = append(b.p.Funcs, f)
+	if funcSym == nil {
+		b.p.Main = f
+	}
+	return nil
+}
+
+// funcScope returns the scope of the body of the function.
+func funcScope(parent *pl0compiler.Scope, funcSym *pl0compiler.Symbol) *pl0compiler.Scope {
+	for _, child := range parent.Children {
+		if child.Func == funcSym {
+			return child
+		}
+	}
+	return nil
+}
+
+// unpromote keeps the variables in memory which are referred to
+// by nested functions through the display, or passed by their addresses.
+func (fb *funcBuilder) unpromote(block *ast.Block) {
+	for _, decl := range block.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok {
+			ast.Inspect(d, func(node ast.Node) bool {
+				if id, ok := node.(*ast.Ident); ok {
+					if sym := fb.info.Uses[id]; sym != nil && sym.Scope == fb.scope {
+						delete(fb.promoted, sym)
+					}
+				}
+				return true
+			})
+		}
+	}
+	ast.Inspect(block.Body, func(node ast.Node) bool {
+		if call, ok := node.(*ast.CallExpr); ok {
+			params := fb.info.Uses[call.Func].Params
+			for i, arg := range call.Args {
+				if id, ok := arg.(*ast.Ident); ok && i < len(params) && params[i].TakesAddress() {
+					delete(fb.promoted, fb.info.Uses[id])
+				}
+			}
+		}
+		return true
+	})
+}
+
+// write records the value of the variable at the end of the block.
+func (fb *funcBuilder) write(sym *pl0compiler.Symbol, blk *Block, v *Value) {
+	defs := fb.defs[blk]
+	if defs == nil {
+		defs = make(map[*pl0compiler.Symbol]*Value)
+		fb.defs[blk] = defs
+	}
+	defs[sym] = v
+}
+
+// read returns the value of the variable at the end of the block.
+func (fb *funcBuilder) read(sym *pl0compiler.Symbol, blk *Block) *Value {
+	if v, ok := fb.defs[blk][sym]; ok {
+		return v
+	}
+	var v *Value
+	switch {
+	case !fb.sealed[blk]:
+		v = blk.insertValue(OpPhi)
+		fb.incomplete[blk] = append(fb.incomplete[blk], incompletePhi{sym, v})
+	case len(blk.Preds) == 0:
+		v = blk.insertValue(OpUndef)
+		v.Addr = sym.Address
+	case len(blk.Preds) == 1:
+		v = fb.read(sym, blk.Preds[0])
+	default:
+		v = blk.insertValue(OpPhi)
+		fb.write(sym, blk, v)
+		fb.addPhiArgs(sym, v)
+	}
+	fb.write(sym, blk, v)
+	return v
+}
+
+func (fb *funcBuilder) addPhiArgs(sym *pl0compiler.Symbol, phi *Value) {
+	for _, pred := range phi.Block.Preds {
+		phi.Args = append(phi.Args, fb.read(sym, pred))
+	}
+}
+
+// seal marks the block whose predecessors are all known.
+func (fb *funcBuilder) seal(blk *Block) {
+	for _, p := range fb.incomplete[blk] {
+		fb.addPhiArgs(p.sym, p.phi)
+	}
+	delete(fb.incomplete, blk)
+	fb.sealed[blk] = true
+}
+
+// jump ends the current block with a jump to the block.
+func (fb *funcBuilder) jump(to *Block) {
+	fb.cur.Kind = BlockPlain
+	fb.cur.addEdge(to)
+}
+
+// branch ends the current block with a branch on the condition.
+func (fb *funcBuilder) branch(cond *Value, then *Block, els *Block) {
+	fb.cur.Kind = BlockIf
+	fb.cur.Control = cond
+	fb.cur.addEdge(then)
+	fb.cur.addEdge(els)
+}
+
+func (fb *funcBuilder) stmt(stmt ast.Stmt) error {
+	switch s := stmt.(type) {
+	case *ast.EmptyStmt:
+		// pass through
+	case *ast.AssignStmt:
+		sym := fb.info.Uses[s.Name]
+		if s.Indices != nil {
+			addr, err := fb.index(sym, s.Indices)
+			if err != nil {
+				return err
+			}
+			v, err := fb.expr(s.Value)
+			if err != nil {
+				return err
+			}
+			fb.cur.newValue(OpStoreElem, addr, v)
+			break
+		}
+		v, err := fb.expr(s.Value)
+		if err != nil {
+			return err
+		}
+		fb.assign(sym, v)
+	case *ast.CompoundStmt:
+		for _, child := range s.List {
+			if err := fb.stmt(child); err != nil {
+				return err
+			}
+		}
+	case *ast.IfStmt:
+		then, join := fb.f.newBlock(), fb.f.newBlock()
+		els := join
+		if s.Else != nil {
+			els = fb.f.newBlock()
+		}
+		if err := fb.cond(s.Cond, then, els); err != nil {
+			return err
+		}
+		fb.seal(then)
+		fb.cur = then
+		if err := fb.stmt(s.Then); err != nil {
+			return err
+		}
+		fb.jump(join)
+		if s.Else != nil {
+			fb.seal(els)
+			fb.cur = els
+			if err := fb.stmt(s.Else); err != nil {
+				return err
+			}
+			fb.jump(join)
+		}
+		fb.seal(join)
+		fb.cur = join
+	case *ast.WhileStmt:
+		header, body, exit := fb.f.newBlock(), fb.f.newBlock(), fb.f.newBlock()
+		fb.jump(header)
+		fb.cur = header
+		if err := fb.cond(s.Cond, body, exit); err != nil {
+			return err
+		}
+		fb.seal(body)
+		fb.cur = body
+		if err := fb.loopBody(s.Body, exit, header); err != nil {
+			return err
+		}
+		fb.jump(header)
+		fb.seal(header)
+		fb.seal(exit)
+		fb.cur = exit
+	case *ast.RepeatStmt:
+		body, cond, exit := fb.f.newBlock(), fb.f.newBlock(), fb.f.newBlock()
+		fb.jump(body)
+		fb.cur = body
+		if err := fb.loopBody(s.Body, exit, cond); err != nil {
+			return err
+		}
+		fb.jump(cond)
+		fb.seal(cond)
+		fb.cur = cond
+		if err := fb.cond(s.Cond, exit, body); err != nil {
+			return err
+		}
+		fb.seal(body)
+		fb.seal(exit)
+		fb.cur = exit
+	case *ast.ForStmt:
+		sym := fb.info.Uses[s.Var]
+		from, err := fb.expr(s.From)
+		if err != nil {
+			return err
+		}
+		to, err := fb.expr(s.To)
+		if err != nil {
+			return err
+		}
+		fb.assign(sym, from)
+		header, body, exit := fb.f.newBlock(), fb.f.newBlock(), fb.f.newBlock()
+		fb.jump(header)
+		fb.cur = header
+		op, step := OpLsEq, fb.info.Steps[s]
+		if step < 0 {
+			op = OpGrEq
+		}
+		i, _ := fb.expr(s.Var)
+		fb.branch(fb.cur.newValue(op, i, to), body, exit)
+		next := fb.f.newBlock()
+		fb.seal(body)
+		fb.cur = body
+		if err := fb.loopBody(s.Body, exit, next); err != nil {
+			return err
+		}
+		fb.jump(next)
+		fb.seal(next)
+		fb.cur = next
+		i, _ = fb.expr(s.Var)
+		fb.assign(sym, fb.cur.newValue(OpAdd, i, fb.constant(step)))
+		fb.jump(header)
+		fb.seal(header)
+		fb.seal(exit)
+		fb.cur = exit
+	case *ast.CaseStmt:
+		// the labels are compared with X in order
+		x, err := fb.expr(s.X)
+		if err != nil {
+			return err
+		}
+		join := fb.f.newBlock()
+		for _, clause := range s.Clauses {
+			body := fb.f.newBlock()
+			for _, label := range clause.Labels {
+				v, err := fb.expr(label)
+				if err != nil {
+					return err
+				}
+				next := fb.f.newBlock()
+				fb.branch(fb.cur.newValue(OpEq, x, v), body, next)
+				fb.seal(next)
+				fb.cur = next
+			}
+			fb.seal(body)
+			next := fb.cur
+			fb.cur = body
+			if err := fb.stmt(clause.Body); err != nil {
+				return err
+			}
+			fb.jump(join)
+			fb.cur = next
+		}
+		if s.Else != nil {
+			if err := fb.stmt(s.Else); err != nil {
+				return err
+			}
+		}
+		fb.jump(join)
+		fb.seal(join)
+		fb.cur = join
+	case *ast.BranchStmt:
+		l := fb.loops[len(fb.loops)-1]
+		if s.Continue {
+			fb.jump(l.cont)
+		} else {
+			fb.jump(l.brk)
+		}
+		// the following statements are unreachable
+		fb.cur = fb.f.newBlock()
+		fb.seal(fb.cur)
+	case *ast.ReturnStmt:
+		var v *Value
+		if s.Result != nil {
+			var err error
+			if v, err = fb.expr(s.Result); err != nil {
+				return err
+			}
+		}
+		fb.cur.Kind = BlockReturn
+		fb.cur.Control = v
+		// the following statements are unreachable
+		fb.cur = fb.f.newBlock()
+		fb.seal(fb.cur)
+	case *ast.WriteStmt:
+		return fb.writeItems(s.Items)
+	case *ast.WritelnStmt:
+		if err := fb.writeItems(s.Items); err != nil {
+			return err
+		}
+		fb.cur.newValue(OpWriteln)
+	case *ast.CallStmt:
+		if _, err := fb.call(OpCallProc, s.X); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("ssa: unsupported statement %T", stmt)
+	}
+	return nil
+}
+
+// loopBody adds the body of a loop, whose break and continue jump to
+// brk and cont.
+func (fb *funcBuilder) loopBody(body ast.Stmt, brk *Block, cont *Block) error {
+	fb.loops = append(fb.loops, loopTargets{brk: brk, cont: cont})
+	err := fb.stmt(body)
+	fb.loops = fb.loops[:len(fb.loops)-1]
+	return err
+}
+
+var binaryOps = map[ast.Operator]Op{
+	ast.OpAdd:  OpAdd,
+	ast.OpSub:  OpSub,
+	ast.OpMul:  OpMul,
+	ast.OpDiv:  OpDiv,
+	ast.OpMod:  OpMod,
+	ast.OpEq:   OpEq,
+	ast.OpNeq:  OpNeq,
+	ast.OpLs:   OpLs,
+	ast.OpGr:   OpGr,
+	ast.OpLsEq: OpLsEq,
+	ast.OpGrEq: OpGrEq,
+}
+
+func (fb *funcBuilder) writeItems(items []ast.Expr) error {
+	for _, item := range items {
+		switch x := item.(type) {
+		case *ast.StringLit:
+			fb.cur.newValue(OpWriteStr, fb.constant(fb.stringIndex(x.Value)))
+		case *ast.WidthExpr:
+			v, err := fb.expr(x.X)
+			if err != nil {
+				return err
+			}
+			width, err := fb.expr(x.Width)
+			if err != nil {
+				return err
+			}
+			fb.cur.newValue(OpWriteWidth, v, width)
+		default:
+			v, err := fb.expr(x)
+			if err != nil {
+				return err
+			}
+			fb.cur.newValue(OpWrite, v)
+		}
+	}
+	return nil
+}
+
+// stringIndex returns the index of the string in the pool, adding it if needed.
+func (b *builder) stringIndex(s string) int {
+	for i, t := range b.p.Strings {
+		if t == s {
+			return i
+		}
+	}
+	b.p.Strings = append(b.p.Strings, s)
+	return len(b.p.Strings) - 1
+}
+
+func (fb *funcBuilder) expr(expr ast.Expr) (*Value, error) {
+	switch x := expr.(type) {
+	case *ast.NumberLit:
+		return fb.constant(x.Value), nil
+	case *ast.Ident:
+		sym := fb.info.Uses[x]
+		switch {
+		case sym.Kind == pl0compiler.SymbolConst:
+			return fb.constant(sym.Value), nil
+		case fb.promoted[sym]:
+			return fb.read(sym, fb.cur), nil
+		case sym.Kind == pl0compiler.SymbolVarScalar:
+			load := fb.cur.newValue(OpLoad)
+			load.Addr = sym.Address
+			return load, nil
+		case sym.Kind == pl0compiler.SymbolVarParam:
+			return fb.cur.newValue(OpLoadElem, fb.arrayAddr(sym)), nil
+		case sym.IsArrayOrRef():
+			// array passed by reference
+			return fb.arrayAddr(sym), nil
+		}
+	case *ast.ParenExpr:
+		return fb.expr(x.X)
+	case *ast.UnaryExpr:
+		v, err := fb.expr(x.X)
+		if err != nil {
+			return nil, err
+		}
+		switch x.Op {
+		case ast.OpSub:
+			return fb.cur.newValue(OpNeg, v), nil
+		case ast.OpOdd:
+			return fb.cur.newValue(OpOdd, v), nil
+		}
+		return v, nil
+	case *ast.BinaryExpr:
+		l, err := fb.expr(x.X)
+		if err != nil {
+			return nil, err
+		}
+		r, err := fb.expr(x.Y)
+		if err != nil {
+			return nil, err
+		}
+		return fb.cur.newValue(binaryOps[x.Op], l, r), nil
+	case *ast.IndexExpr:
+		addr, err := fb.index(fb.info.Uses[x.Name], x.Indices)
+		if err != nil {
+			return nil, err
+		}
+		return fb.cur.newValue(OpLoadElem, addr), nil
+	case *ast.CallExpr:
+		if sym := fb.info.Uses[x.Func]; sym.Kind == pl0compiler.SymbolBuiltin {
+			return fb.builtin(sym.Builtin, x)
+		}
+		return fb.call(OpCall, x)
+	}
+	return nil, fmt.Errorf("ssa: unsupported expression %T", expr)
+}
+
+// cond ends the current block with branches to then or els on the condition,
+// evaluating and, or and not by short-circuit.
+func (fb *funcBuilder) cond(cond ast.Expr, then *Block, els *Block) error {
+	switch x := cond.(type) {
+	case *ast.ParenExpr:
+		return fb.cond(x.X, then, els)
+	case *ast.UnaryExpr:
+		if x.Op == ast.OpNot {
+			return fb.cond(x.X, els, then)
+		}
+	case *ast.BinaryExpr:
+		if x.Op.IsLogical() {
+			next := fb.f.newBlock()
+			var err error
+			if x.Op == ast.OpAnd {
+				err = fb.cond(x.X, next, els)
+			} else {
+				err = fb.cond(x.X, then, next)
+			}
+			if err != nil {
+				return err
+			}
+			fb.seal(next)
+			fb.cur = next
+			return fb.cond(x.Y, then, els)
+		}
+	}
+	v, err := fb.expr(cond)
+	if err != nil {
+		return err
+	}
+	fb.branch(v, then, els)
+	return nil
+}
+
+// call adds the call of a function by OpCall or a procedure by OpCallProc.
+func (fb *funcBuilder) call(op Op, x *ast.CallExpr) (*Value, error) {
+	args, err := fb.args(x)
+	if err != nil {
+		return nil, err
+	}
+	call := fb.cur.newValue(op, args...)
+	call.Callee = fb.funcs[fb.info.Uses[x.Func]]
+	return call, nil
+}
+
+// builtinOps are the operations of the builtin functions by operation types.
+var builtinOps = map[byte]Op{
+	pl0core.OpTypeABS: OpAbs,
+	pl0core.OpTypeMIN: OpMin,
+	pl0core.OpTypeMAX: OpMax,
+	pl0core.OpTypeSQR: OpSqr,
+}
+
+// builtin adds the operation of the builtin function on the arguments.
+func (fb *funcBuilder) builtin(builtin *pl0compiler.Builtin, x *ast.CallExpr) (*Value, error) {
+	args, err := fb.args(x)
+	if err != nil {
+		return nil, err
+	}
+	return fb.cur.newValue(builtinOps[builtin.OpType], args...), nil
+}
+
+// args adds the arguments of the call in order.
+// Arrays are followed by the sizes of the dimensions in the SizeDims
+// of the parameters. Variables for sized array references are passed
+// by their addresses as arrays of one element.
+func (fb *funcBuilder) args(x *ast.CallExpr) ([]*Value, error) {
+	var args []*Value
+	params := fb.info.Uses[x.Func].Params
+	for i, arg := range x.Args {
+		var sym *pl0compiler.Symbol
+		if id, ok := arg.(*ast.Ident); ok {
+			sym = fb.info.Uses[id]
+		}
+		if i < len(params) && params[i].TakesAddress() && (sym == nil || !sym.IsArrayOrRef()) {
+			v, err := fb.varArg(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+			if params[i].Kind == pl0compiler.SymbolVarRef {
+				// an array of one element
+				args = append(args, fb.constant(1))
+			}
+			continue
+		}
+		v, err := fb.expr(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+		if i >= len(params) {
+			continue
+		}
+		for _, d := range params[i].SizeDims() {
+			if sym == nil || !sym.IsArrayOrRef() {
+				// the size of the value is unknown
+				args = append(args, fb.constant(math.MaxInt32))
+			} else {
+				args = append(args, fb.dimSize(sym, d))
+			}
+		}
+	}
+	return args, nil
+}
+
+// varArg returns the address of the argument for a var parameter,
+// which is a scalar variable or an array element.
+func (fb *funcBuilder) varArg(arg ast.Expr) (*Value, error) {
+	if x, ok := arg.(*ast.IndexExpr); ok {
+		return fb.index(fb.info.Uses[x.Name], x.Indices)
+	}
+	sym := fb.info.Uses[arg.(*ast.Ident)]
+	return fb.arrayAddr(sym), nil
+}
+
+// assign assigns the value to the scalar variable.
+func (fb *funcBuilder) assign(sym *pl0compiler.Symbol, v *Value) {
+	if fb.promoted[sym] {
+		fb.write(sym, fb.cur, v)
+	} else if sym.Kind == pl0compiler.SymbolVarParam {
+		fb.cur.newValue(OpStoreElem, fb.arrayAddr(sym), v)
+	} else {
+		fb.cur.newValue(OpStore, v).Addr = sym.Address
+	}
+}
+
+func (fb *funcBuilder) constant(c int) *Value {
+	v := fb.cur.newValue(OpConst)
+	v.Aux = c
+	return v
+}
+
+// arrayAddr returns the address of the array or the variable, or the
+// address held by the reference. References are parameters which are
+// not assigned.
+func (fb *funcBuilder) arrayAddr(sym *pl0compiler.Symbol) *Value {
+	op := OpAddr
+	if sym.Kind == pl0compiler.SymbolVarRef || sym.Kind == pl0compiler.SymbolVarParam {
+		op = OpParam
+	}
+	v := fb.cur.newValue(op)
+	v.Addr = sym.Address
+	return v
+}
+
+// dimSize returns the size of the dimension d of the array.
+// The sizes of an array reference are parameters following its address
+// in the order of SizeDims.
+func (fb *funcBuilder) dimSize(sym *pl0compiler.Symbol, d int) *Value {
+	if sym.Kind != pl0compiler.SymbolVarRef {
+		return fb.constant(sym.Dims[d])
+	}
+	v := fb.cur.newValue(OpParam)
+	v.Addr = sym.Address
+	if d == 0 {
+		v.Addr.Offset += sym.Rank
+	} else {
+		v.Addr.Offset += d
+	}
+	return v
+}
+
+// index returns the address of the array element in row-major order.
+// The indices are checked if Info.BoundsCheck.
+func (fb *funcBuilder) index(sym *pl0compiler.Symbol, indices []ast.Expr) (*Value, error) {
+	base := fb.arrayAddr(sym)
+	var offset *Value
+	for d, index := range indices {
+		i, err := fb.expr(index)
+		if err != nil {
+			return nil, err
+		}
+		if fb.info.BoundsCheck {
+			i = fb.cur.newValue(OpCheck, i, fb.dimSize(sym, d))
+		}
+		if d == 0 {
+			offset = i
+		} else {
+			offset = fb.cur.newValue(OpAdd, fb.cur.newValue(OpMul, offset, fb.dimSize(sym, d)), i)
+		}
+	}
+	return fb.cur.newValue(OpIndex, base, offset), nil
+}
@@ -0,0 +1,199 @@
+package ssa
+
+// postorder returns the blocks reachable from the entry in postorder.
+func postorder(f *Func) []*Block {
+	var order []*Block
+	seen := make(map[*Block]bool)
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+		// reversed, so that reverse postorder follows the first successors
+		for i := len(b.Succs) - 1; i >= 0; i-- {
+			if s := b.Succs[i]; !seen[s] {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(f.Entry)
+	return order
+}
+
+// DomTree is the dominator tree of a function.
+// A block a dominates b if every path from the entry to b passes a.
+type DomTree struct {
+	idom     map[*Block]*Block
+	children map[*Block][]*Block
+	order    map[*Block]int // index in reverse postorder
+}
+
+// Dominators returns the dominator tree of the function, computed by
+// the algorithm of Cooper, Harvey and Kennedy,
+// "A Simple, Fast Dominance Algorithm".
+func Dominators(f *Func) *DomTree {
+	po := postorder(f)
+	t := &DomTree{
+		idom:     make(map[*Block]*Block),
+		children: make(map[*Block][]*Block),
+		order:    make(map[*Block]int),
+	}
+	for i, b := range po {
+		t.order[b] = len(po) - 1 - i
+	}
+	t.idom[f.Entry] = f.Entry
+	intersect := func(a *Block, b *Block) *Block {
+		for a != b {
+			for t.order[a] > t.order[b] {
+				a = t.idom[a]
+			}
+			for t.order[b] > t.order[a] {
+				b = t.idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(po) - 2; i >= 0; i-- {
+			b := po[i]
+			var idom *Block
+			for _, p := range b.Preds {
+				if t.idom[p] == nil {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if t.idom[b] != idom {
+				t.idom[b] = idom
+				changed = true
+			}
+		}
+	}
+	for i := len(po) - 2; i >= 0; i-- {
+		b := po[i]
+		t.children[t.idom[b]] = append(t.children[t.idom[b]], b)
+	}
+	delete(t.idom, f.Entry)
+	return t
+}
+
+// Idom returns the immediate dominator of the block,
+// or nil for the entry and unreachable blocks.
+func (t *DomTree) Idom(b *Block) *Block {
+	return t.idom[b]
+}
+
+// Children returns the blocks immediately dominated by the block.
+func (t *DomTree) Children(b *Block) []*Block {
+	return t.children[b]
+}
+
+// Dominates reports whether a dominates b. A block dominates itself.
+func (t *DomTree) Dominates(a *Block, b *Block) bool {
+	for b != nil {
+		if a == b {
+			return true
+		}
+		b = t.idom[b]
+	}
+	return false
+}
+
+// removeUnreachable removes the blocks unreachable from the entry,
+// and orders the blocks in reverse postorder.
+func removeUnreachable(f *Func) {
+	po := postorder(f)
+	reachable := make(map[*Block]bool)
+	for _, b := range po {
+		reachable[b] = true
+	}
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			continue
+		}
+		for _, s := range b.Succs {
+			if reachable[s] {
+				s.removePred(s.predIndex(b))
+			}
+		}
+	}
+	f.Blocks = f.Blocks[:0]
+	for i := len(po) - 1; i >= 0; i-- {
+		f.Blocks = append(f.Blocks, po[i])
+	}
+}
+
+// removeTrivialPhis replaces phis whose arguments are the same value,
+// or the phi itself, with the value.
+func removeTrivialPhis(f *Func) {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			for _, v := range b.Values {
+				if v.Op != OpPhi {
+					continue
+				}
+				var same *Value
+				trivial := true
+				for _, arg := range v.Args {
+					if arg == v || arg == same {
+						continue
+					}
+					if same != nil {
+						trivial = false
+						break
+					}
+					same = arg
+				}
+				if !trivial || same == nil {
+					continue
+				}
+				f.replaceUses(v, same)
+				v.Op = OpInvalid
+				changed = true
+			}
+		}
+		f.removeValues(func(v *Value) bool { return v.Op == OpInvalid })
+	}
+}
+
+// mergeBlocks merges blocks into their predecessors jumping only to them.
+func mergeBlocks(f *Func) {
+	for _, b := range f.Blocks {
+		for b.Kind == BlockPlain {
+			s := b.Succs[0]
+			if s == b || len(s.Preds) != 1 || s == f.Entry {
+				break
+			}
+			// phis of s have single arguments
+			for _, v := range s.Values {
+				if v.Op == OpPhi {
+					f.replaceUses(v, v.Args[0])
+					v.Op = OpInvalid
+				}
+			}
+			for _, v := range s.Values {
+				if v.Op != OpInvalid {
+					v.Block = b
+					b.Values = append(b.Values, v)
+				}
+			}
+			b.Kind, b.Control, b.Succs = s.Kind, s.Control, s.Succs
+			for _, x := range s.Succs {
+				for i, p := range x.Preds {
+					if p == s {
+						x.Preds[i] = b
+					}
+				}
+			}
+			// s is unreachable
+			s.Values, s.Succs, s.Preds = nil, nil, nil
+			s.Kind = BlockReturn
+		}
+	}
+	removeUnreachable(f)
+}
@@ -0,0 +1,377 @@
+package ssa
+
+import "kkpl0/pl0core"
+
+var opTypes = map[Op]byte{
+	OpNeg:        pl0core.OpTypeNEG,
+	OpOdd:        pl0core.OpTypeODD,
+	OpAdd:        pl0core.OpTypeADD,
+	OpSub:        pl0core.OpTypeSUB,
+	OpMul:        pl0core.OpTypeMUL,
+	OpDiv:        pl0core.OpTypeDIV,
+	OpMod:        pl0core.OpTypeMOD,
+	OpEq:         pl0core.OpTypeEQ,
+	OpNeq:        pl0core.OpTypeNEQ,
+	OpLs:         pl0core.OpTypeLS,
+	OpGr:         pl0core.OpTypeGR,
+	OpLsEq:       pl0core.OpTypeLSEQ,
+	OpGrEq:       pl0core.OpTypeGREQ,
+	OpAbs:        pl0core.OpTypeABS,
+	OpMin:        pl0core.OpTypeMIN,
+	OpMax:        pl0core.OpTypeMAX,
+	OpSqr:        pl0core.OpTypeSQR,
+	OpIndex:      pl0core.OpTypeADD,
+	OpCheck:      pl0core.OpTypeCHK,
+	OpLoadElem:   pl0core.OpTypeLID,
+	OpStoreElem:  pl0core.OpTypeSID,
+	OpWrite:      pl0core.OpTypeWRT,
+	OpWriteStr:   pl0core.OpTypeWRS,
+	OpWriteWidth: pl0core.OpTypeWRF,
+	OpWriteln:    pl0core.OpTypeWRL,
+}
+
+// Lower returns the PL/0 VM instructions of the program.
+//
+// Values are computed in order. A value used once by a following value
+// of the same block is left on the stack if the values between them are
+// consumed in order, as in the code from expressions. The other values,
+// including phis, are stored into slots added to the frame.
+// Constants, parameters, addresses of arrays and undefined values are
+// computed at each use.
+//
+// Calls returned as soon as they are computed are tail calls by TCL.
+// The string pool follows the code.
+//
+// Lower splits critical edges to blocks with phis, which are copied at
+// the ends of the predecessors.
+func Lower(p *Program) []pl0core.Instruction {
+	l := &lowerer{
+		code:  []pl0core.Instruction{&pl0core.ValueInstruction{Code: pl0core.InstructJMP}},
+		entry: make(map[*Func]int),
+	}
+	for _, f := range p.Funcs {
+		l.lowerFunc(f)
+	}
+	l.code[0].(*pl0core.ValueInstruction).Value = l.entry[p.Main]
+	for _, c := range l.calls {
+		switch inst := l.code[c.index].(type) {
+		case *pl0core.AddrInstruction:
+			inst.Offset = l.entry[c.callee]
+		case *pl0core.TailCallInstruction:
+			inst.Offset = l.entry[c.callee]
+		}
+	}
+	return append(l.code, pl0core.StringPool(p.Strings)...)
+}
+
+type lowerer struct {
+	code  []pl0core.Instruction
+	entry map[*Func]int
+	calls []callFixup
+
+	// state of the function
+	f        *Func
+	onStack  map[*Value]bool
+	slots    map[*Value]int
+	blocks   map[*Block]int
+	jumps    []jumpFixup
+	nextSlot int
+}
+
+type callFixup struct {
+	index  int
+	callee *Func
+}
+
+type jumpFixup struct {
+	index int
+	to    *Block
+}
+
+// rematerialized reports whether the value is computed at each use.
+func rematerialized(v *Value) bool {
+	switch v.Op {
+	case OpConst, OpParam, OpAddr, OpUndef:
+		return true
+	}
+	return false
+}
+
+func (l *lowerer) lowerFunc(f *Func) {
+	splitCriticalEdges(f)
+	l.f = f
+	l.onStack = make(map[*Value]bool)
+	l.slots = make(map[*Value]int)
+	l.blocks = make(map[*Block]int)
+	l.jumps = nil
+	l.nextSlot = f.FrameSize
+
+	uses := make(map[*Value]int)
+	for v, users := range f.users() {
+		uses[v] = len(users)
+	}
+	for _, b := range f.Blocks {
+		l.schedule(b, uses)
+	}
+
+	l.entry[f] = len(l.code)
+	ict := l.genValue(pl0core.InstructICT, 0)
+	for i, b := range f.Blocks {
+		var next *Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
+		l.lowerBlock(b, next)
+	}
+	l.code[ict].(*pl0core.ValueInstruction).Value = l.nextSlot
+	for _, j := range l.jumps {
+		l.code[j.index].(*pl0core.ValueInstruction).Value = l.blocks[j.to]
+	}
+}
+
+// splitCriticalEdges inserts blocks on the edges from blocks with
+// multiple successors to blocks with phis and multiple predecessors.
+func splitCriticalEdges(f *Func) {
+	for _, b := range f.Blocks {
+		if len(b.Succs) < 2 {
+			continue
+		}
+		for i, s := range b.Succs {
+			if len(s.Preds) < 2 || len(s.Values) == 0 || s.Values[0].Op != OpPhi {
+				continue
+			}
+			mid := f.newBlock()
+			mid.Kind = BlockPlain
+			mid.Preds = []*Block{b}
+			mid.Succs = []*Block{s}
+			b.Succs[i] = mid
+			for j, p := range s.Preds {
+				if p == b {
+					s.Preds[j] = mid
+					break
+				}
+			}
+		}
+	}
+	removeUnreachable(f)
+}
+
+// schedule decides the values left on the stack in the block.
+func (l *lowerer) schedule(b *Block, uses map[*Value]int) {
+	users := make(map[*Value]int)
+	local := make(map[*Value]bool) // used once by the block
+	for _, v := range b.Values {
+		for _, arg := range v.Args {
+			users[arg]++
+			local[arg] = v.Op != OpPhi
+		}
+	}
+	if b.Control != nil {
+		users[b.Control]++
+		local[b.Control] = true
+	}
+	var stack []*Value
+	spill := func() {
+		for _, v := range stack {
+			delete(l.onStack, v)
+			l.slots[v] = l.allocSlot()
+		}
+		stack = nil
+	}
+	// consume pops the arguments on the stack, which must be the top of
+	// the stack in order, followed by the arguments computed at the use.
+	consume := func(args []*Value) {
+		m := 0
+		for m < len(args) && l.onStack[args[m]] {
+			m++
+		}
+		for _, arg := range args[m:] {
+			if l.onStack[arg] {
+				spill()
+				return
+			}
+		}
+		if m > len(stack) {
+			spill()
+			return
+		}
+		for i, arg := range args[:m] {
+			if stack[len(stack)-m+i] != arg {
+				spill()
+				return
+			}
+		}
+		stack = stack[:len(stack)-m]
+	}
+
+	for _, v := range b.Values {
+		if v.Op == OpPhi {
+			l.slots[v] = l.allocSlot()
+			continue
+		}
+		if rematerialized(v) {
+			continue
+		}
+		consume(v.Args)
+		if !v.Op.hasResult() {
+			continue
+		}
+		if uses[v] == 1 && users[v] == 1 && local[v] {
+			l.onStack[v] = true
+			stack = append(stack, v)
+		} else {
+			// unused results are stored too, to be removed from the stack
+			l.slots[v] = l.allocSlot()
+		}
+	}
+	if b.Control != nil {
+		consume([]*Value{b.Control})
+	}
+	spill()
+}
+
+func (l *lowerer) allocSlot() int {
+	l.nextSlot++
+	return l.nextSlot - 1
+}
+
+func (l *lowerer) gen(inst pl0core.Instruction) int {
+	l.code = append(l.code, inst)
+	return len(l.code) - 1
+}
+
+func (l *lowerer) genValue(code byte, value int) int {
+	return l.gen(&pl0core.ValueInstruction{Code: code, Value: value})
+}
+
+func (l *lowerer) genAddr(code byte, addr pl0core.Address) int {
+	return l.gen(&pl0core.AddrInstruction{Code: code, Address: addr})
+}
+
+func (l *lowerer) genOpr(opType byte) int {
+	return l.gen(&pl0core.OperationInstruction{Code: pl0core.InstructOPR, OpType: opType})
+}
+
+func (l *lowerer) genJump(code byte, to *Block) {
+	l.jumps = append(l.jumps, jumpFixup{l.genValue(code, 0), to})
+}
+
+func (l *lowerer) slotAddr(v *Value) pl0core.Address {
+	return pl0core.Address{Level: l.f.Level, Offset: l.slots[v]}
+}
+
+// push generates the code which pushes the value not on the stack.
+func (l *lowerer) push(v *Value) {
+	switch {
+	case l.onStack[v]:
+		// computed before
+	case v.Op == OpConst:
+		l.genValue(pl0core.InstructLIT, v.Aux)
+	case v.Op == OpAddr:
+		l.genAddr(pl0core.InstructLDA, v.Addr)
+	case v.Op == OpParam, v.Op == OpUndef:
+		l.genAddr(pl0core.InstructLOD, v.Addr)
+	default:
+		l.genAddr(pl0core.InstructLOD, l.slotAddr(v))
+	}
+}
+
+// isTailCall reports whether the call is returned by the block as soon as
+// it is computed, and can reuse the frame of the function by TCL:
+// the callee is not nested in the function, which needs the frame,
+// and no arrays of the function are passed.
+func (l *lowerer) isTailCall(b *Block, v *Value) bool {
+	if l.f.Level == 0 || b.Kind != BlockReturn || b.Control != v ||
+		b.Values[len(b.Values)-1] != v || !l.onStack[v] || v.Callee.Level > l.f.Level {
+		return false
+	}
+	for _, arg := range v.Args {
+		if arg.Op == OpAddr && arg.Addr.Level == l.f.Level {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *lowerer) lowerBlock(b *Block, next *Block) {
+	l.blocks[b] = len(l.code)
+	for _, v := range b.Values {
+		if v.Op == OpPhi || rematerialized(v) {
+			continue
+		}
+		for _, arg := range v.Args {
+			l.push(arg)
+		}
+		switch v.Op {
+		case OpLoad:
+			l.genAddr(pl0core.InstructLOD, v.Addr)
+		case OpStore:
+			l.genAddr(pl0core.InstructSTO, v.Addr)
+		case OpCall:
+			if l.isTailCall(b, v) {
+				l.calls = append(l.calls, callFixup{
+					l.gen(&pl0core.TailCallInstruction{
+						Code:    pl0core.InstructTCL,
+						Address: pl0core.Address{Level: v.Callee.Level - 1},
+						Level:   l.f.Level,
+						Params:  l.f.Params,
+						Args:    len(v.Args),
+					}),
+					v.Callee,
+				})
+				// TCL returns instead of RET
+				return
+			}
+			fallthrough
+		case OpCallProc:
+			l.calls = append(l.calls, callFixup{
+				l.genAddr(pl0core.InstructCAL, pl0core.Address{Level: v.Callee.Level - 1}),
+				v.Callee,
+			})
+		default:
+			l.genOpr(opTypes[v.Op])
+		}
+		if _, ok := l.slots[v]; ok {
+			l.genAddr(pl0core.InstructSTO, l.slotAddr(v))
+		}
+	}
+
+	switch b.Kind {
+	case BlockPlain:
+		s := b.Succs[0]
+		l.copyPhis(s, s.predIndex(b))
+		if s != next {
+			l.genJump(pl0core.InstructJMP, s)
+		}
+	case BlockIf:
+		l.push(b.Control)
+		l.genJump(pl0core.InstructJPC, b.Succs[1])
+		if b.Succs[0] != next {
+			l.genJump(pl0core.InstructJMP, b.Succs[0])
+		}
+	case BlockReturn:
+		if b.Control != nil {
+			l.push(b.Control)
+		}
+		code := byte(pl0core.InstructRET)
+		if l.f.Proc {
+			code = pl0core.InstructRTN
+		}
+		l.genAddr(code, pl0core.Address{Level: l.f.Level, Offset: l.f.Params})
+	}
+}
+
+// copyPhis stores the arguments for the i-th predecessor into the phis.
+// All arguments are pushed first, since they may be the phis.
+func (l *lowerer) copyPhis(b *Block, i int) {
+	var phis []*Value
+	for _, v := range b.Values {
+		if v.Op == OpPhi {
+			phis = append(phis, v)
+			l.push(v.Args[i])
+		}
+	}
+	for j := len(phis) - 1; j >= 0; j-- {
+		l.genAddr(pl0core.InstructSTO, l.slotAddr(phis[j]))
+	}
+}
@@ -0,0 +1,531 @@
+package ssa
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"kkpl0/ast"
+	"kkpl0/pl0compiler"
+)
+
+// Pass is an optimization pass.
+type Pass struct {
+	Name string
+	Run  func(f *Func)
+}
+
+// Passes are the optimization passes in the default order.
+var Passes = []Pass{
+	{"constprop", ConstProp},
+	{"dce", DeadCode},
+	{"cse", CSE},
+	{"licm", LICM},
+	{"dce", DeadCode},
+}
+
+// LookupPasses returns the passes named in the comma-separated list.
+func LookupPasses(names string) ([]Pass, error) {
+	var passes []Pass
+	for _, name := range strings.Split(names, ",") {
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, pass := range Passes {
+			if pass.Name == name {
+				passes = append(passes, pass)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("ssa: unknown pass %s", name)
+		}
+	}
+	return passes, nil
+}
+
+// Optimize runs the passes on each function of the program,
+// verifying the functions after each pass.
+func Optimize(p *Program, passes []Pass) error {
+	for _, f := range p.Funcs {
+		for _, pass := range passes {
+			pass.Run(f)
+			if err := f.Verify(); err != nil {
+				return fmt.Errorf("after %s: %v", pass.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+var opOperators = map[Op]ast.Operator{
+	OpAdd:  ast.OpAdd,
+	OpSub:  ast.OpSub,
+	OpMul:  ast.OpMul,
+	OpDiv:  ast.OpDiv,
+	OpMod:  ast.OpMod,
+	OpEq:   ast.OpEq,
+	OpNeq:  ast.OpNeq,
+	OpLs:   ast.OpLs,
+	OpGr:   ast.OpGr,
+	OpLsEq: ast.OpLsEq,
+	OpGrEq: ast.OpGrEq,
+}
+
+// eval returns the result of the operation on constants as the VM
+// computes it. It returns false if the result is not a literal of the VM.
+func eval(op Op, args []int) (int, bool) {
+	var v int
+	switch op {
+	case OpNeg:
+		v = -args[0]
+	case OpOdd:
+		v = args[0] & 1
+	case OpCheck:
+		// a constant out of the range is left to the VM
+		if args[0] < 0 || args[0] >= args[1] {
+			return 0, false
+		}
+		v = args[0]
+	case OpAbs, OpMin, OpMax, OpSqr:
+		for _, b := range pl0compiler.Builtins {
+			if b.OpType == opTypes[op] {
+				v = b.Eval(args)
+			}
+		}
+	default:
+		operator, ok := opOperators[op]
+		if !ok {
+			return 0, false
+		}
+		if v, ok = pl0compiler.Eval(operator, args[0], args[1]); !ok {
+			return 0, false
+		}
+	}
+	return v, v >= math.MinInt32 && v <= math.MaxInt32
+}
+
+// lattice is a value of the lattice of sparse conditional constant
+// propagation: unknown yet, a constant, or not a constant.
+type lattice struct {
+	kind  int // latticeTop, latticeConst or latticeBottom
+	value int
+}
+
+const (
+	latticeTop = iota
+	latticeConst
+	latticeBottom
+)
+
+type edge struct {
+	from *Block
+	to   *Block
+}
+
+// ConstProp propagates constants by the algorithm of Wegman and Zadeck,
+// "Constant Propagation with Conditional Branches".
+// Values computed to constants are replaced with constants,
+// branches on constants are replaced with jumps,
+// and unreachable blocks are removed.
+func ConstProp(f *Func) {
+	users := f.users()
+	values := make(map[*Value]lattice)
+	executable := make(map[*Block]bool)
+	edges := make(map[edge]bool)
+	var flowWork []edge
+	var ssaWork []*Value
+
+	update := func(v *Value, l lattice) {
+		if values[v] != l {
+			values[v] = l
+			ssaWork = append(ssaWork, v)
+		}
+	}
+	evaluate := func(v *Value) {
+		if values[v].kind == latticeBottom {
+			return
+		}
+		switch {
+		case v.Op == OpConst:
+			update(v, lattice{latticeConst, v.Aux})
+		case v.Op == OpPhi:
+			l := lattice{}
+			for i, arg := range v.Args {
+				if !edges[edge{v.Block.Preds[i], v.Block}] {
+					continue
+				}
+				a := values[arg]
+				switch {
+				case a.kind == latticeTop:
+				case l.kind == latticeTop:
+					l = a
+				case a != l:
+					l = lattice{kind: latticeBottom}
+				}
+			}
+			update(v, l)
+		case v.Op.isPure() && v.Op != OpParam && v.Op != OpAddr && v.Op != OpIndex:
+			var args []int
+			for _, arg := range v.Args {
+				a := values[arg]
+				if a.kind != latticeConst {
+					if a.kind == latticeBottom {
+						update(v, lattice{kind: latticeBottom})
+					}
+					return
+				}
+				args = append(args, a.value)
+			}
+			if c, ok := eval(v.Op, args); ok {
+				update(v, lattice{latticeConst, c})
+			} else {
+				update(v, lattice{kind: latticeBottom})
+			}
+		case v.Op.hasResult():
+			update(v, lattice{kind: latticeBottom})
+		}
+	}
+	// branch adds the executable successors of the block.
+	branch := func(b *Block) {
+		switch b.Kind {
+		case BlockPlain:
+			flowWork = append(flowWork, edge{b, b.Succs[0]})
+		case BlockIf:
+			c := values[b.Control]
+			if c.kind != latticeConst || c.value != 0 {
+				flowWork = append(flowWork, edge{b, b.Succs[0]})
+			}
+			if c.kind != latticeConst || c.value == 0 {
+				flowWork = append(flowWork, edge{b, b.Succs[1]})
+			}
+		}
+	}
+
+	executable[f.Entry] = true
+	for _, v := range f.Entry.Values {
+		evaluate(v)
+	}
+	branch(f.Entry)
+	for len(flowWork) > 0 || len(ssaWork) > 0 {
+		for len(flowWork) > 0 {
+			e := flowWork[len(flowWork)-1]
+			flowWork = flowWork[:len(flowWork)-1]
+			if edges[e] {
+				continue
+			}
+			edges[e] = true
+			for _, v := range e.to.Values {
+				if v.Op == OpPhi || !executable[e.to] {
+					evaluate(v)
+				}
+			}
+			if !executable[e.to] {
+				executable[e.to] = true
+				branch(e.to)
+			}
+		}
+		for len(ssaWork) > 0 {
+			v := ssaWork[len(ssaWork)-1]
+			ssaWork = ssaWork[:len(ssaWork)-1]
+			for _, u := range users[v] {
+				switch {
+				case u == nil:
+					// the control of a block
+					for _, b := range f.Blocks {
+						if b.Control == v && executable[b] {
+							branch(b)
+						}
+					}
+				case executable[u.Block]:
+					evaluate(u)
+				}
+			}
+		}
+	}
+
+	// rewrite
+	for _, b := range f.Blocks {
+		if !executable[b] {
+			continue
+		}
+		for _, v := range b.Values {
+			if l := values[v]; l.kind == latticeConst && v.Op != OpConst {
+				v.Op = OpConst
+				v.Aux = l.value
+				v.Args = nil
+			}
+		}
+		if b.Kind == BlockIf {
+			if c := values[b.Control]; c.kind == latticeConst {
+				taken, removed := b.Succs[0], b.Succs[1]
+				if c.value == 0 {
+					taken, removed = removed, taken
+				}
+				b.Succs = []*Block{taken}
+				removed.removePred(removed.predIndex(b))
+				b.Kind = BlockPlain
+				b.Control = nil
+			}
+		}
+	}
+	removeUnreachable(f)
+	removeTrivialPhis(f)
+	mergeBlocks(f)
+}
+
+// DeadCode removes values whose results are not used and which have no
+// side effects. A division which may fail is kept.
+func DeadCode(f *Func) {
+	live := make(map[*Value]bool)
+	var work []*Value
+	mark := func(v *Value) {
+		if v != nil && !live[v] {
+			live[v] = true
+			work = append(work, v)
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if !v.removable() {
+				mark(v)
+			}
+		}
+		mark(b.Control)
+	}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range v.Args {
+			mark(arg)
+		}
+	}
+	f.removeValues(func(v *Value) bool { return !live[v] })
+}
+
+// cseKey identifies pure values computing the same result.
+type cseKey struct {
+	op   Op
+	aux  int
+	addr [2]int
+	args [2]int
+}
+
+func keyOf(v *Value) cseKey {
+	k := cseKey{op: v.Op, aux: v.Aux, addr: [2]int{v.Addr.Level, v.Addr.Offset}}
+	for i, arg := range v.Args {
+		k.args[i] = arg.ID
+	}
+	switch v.Op {
+	case OpAdd, OpMul, OpEq, OpNeq, OpMin, OpMax:
+		// commutative
+		if k.args[0] > k.args[1] {
+			k.args[0], k.args[1] = k.args[1], k.args[0]
+		}
+	}
+	return k
+}
+
+// CSE eliminates common subexpressions: a pure value is replaced with
+// the same computation in a dominating block.
+// Loads are not eliminated, since memory may be modified in between.
+func CSE(f *Func) {
+	dom := Dominators(f)
+	available := make(map[cseKey]*Value)
+	var walk func(b *Block)
+	walk = func(b *Block) {
+		var added []cseKey
+		for _, v := range b.Values {
+			for i, arg := range v.Args {
+				if arg.Op == OpInvalid {
+					v.Args[i] = arg.Args[0]
+				}
+			}
+			if !v.Op.isPure() {
+				continue
+			}
+			k := keyOf(v)
+			if prev, ok := available[k]; ok {
+				// the value is replaced by the argument of OpInvalid
+				v.Op = OpInvalid
+				v.Args = []*Value{prev}
+				continue
+			}
+			available[k] = v
+			added = append(added, k)
+		}
+		if c := b.Control; c != nil && c.Op == OpInvalid {
+			b.Control = c.Args[0]
+		}
+		for _, child := range dom.Children(b) {
+			walk(child)
+		}
+		for _, k := range added {
+			delete(available, k)
+		}
+	}
+	walk(f.Entry)
+
+	// phi arguments may be visited before the replaced values
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			for i, arg := range v.Args {
+				if arg.Op == OpInvalid && v.Op != OpInvalid {
+					v.Args[i] = arg.Args[0]
+				}
+			}
+		}
+		if c := b.Control; c != nil && c.Op == OpInvalid {
+			b.Control = c.Args[0]
+		}
+	}
+	f.removeValues(func(v *Value) bool { return v.Op == OpInvalid })
+	removeTrivialPhis(f)
+}
+
+// loop is a natural loop.
+type loop struct {
+	header *Block
+	blocks map[*Block]bool
+}
+
+// findLoops returns the natural loops of the back edges,
+// merging the loops with the same header.
+func findLoops(f *Func, dom *DomTree) []*loop {
+	var loops []*loop
+	byHeader := make(map[*Block]*loop)
+	for _, b := range f.Blocks {
+		for _, h := range b.Succs {
+			if !dom.Dominates(h, b) {
+				continue
+			}
+			l := byHeader[h]
+			if l == nil {
+				l = &loop{header: h, blocks: map[*Block]bool{h: true}}
+				byHeader[h] = l
+				loops = append(loops, l)
+			}
+			work := []*Block{b}
+			for len(work) > 0 {
+				x := work[len(work)-1]
+				work = work[:len(work)-1]
+				if l.blocks[x] {
+					continue
+				}
+				l.blocks[x] = true
+				work = append(work, x.Preds...)
+			}
+		}
+	}
+	// inner loops first
+	sort.SliceStable(loops, func(i, j int) bool {
+		return len(loops[i].blocks) < len(loops[j].blocks)
+	})
+	return loops
+}
+
+// preheader returns the block through which the loop is entered,
+// inserting it if necessary.
+func preheader(f *Func, l *loop) *Block {
+	h := l.header
+	var outside []int
+	for i, p := range h.Preds {
+		if !l.blocks[p] {
+			outside = append(outside, i)
+		}
+	}
+	if len(outside) == 1 {
+		if p := h.Preds[outside[0]]; len(p.Succs) == 1 {
+			return p
+		}
+	}
+
+	pre := f.newBlock()
+	pre.Kind = BlockPlain
+	var preds []*Block
+	for _, i := range outside {
+		p := h.Preds[i]
+		preds = append(preds, p)
+		for j, s := range p.Succs {
+			if s == h {
+				p.Succs[j] = pre
+			}
+		}
+	}
+	pre.Preds = preds
+	// phis of the header take the values from outside through the preheader
+	for _, v := range h.Values {
+		if v.Op != OpPhi {
+			continue
+		}
+		var args []*Value
+		for _, i := range outside {
+			args = append(args, v.Args[i])
+		}
+		phi := pre.newValue(OpPhi, args...)
+		v.Args = append(v.Args, phi)
+	}
+	for i := len(outside) - 1; i >= 0; i-- {
+		h.removePred(outside[i])
+	}
+	pre.addEdge(h)
+	removeTrivialPhis(f)
+	return pre
+}
+
+// LICM moves loop-invariant pure values out of loops into the preheaders.
+// Divisions which may fail are not moved, since the loop body may not be
+// executed.
+func LICM(f *Func) {
+	dom := Dominators(f)
+	loops := findLoops(f, dom)
+	for _, l := range loops {
+		var pre *Block
+		for changed := true; changed; {
+			changed = false
+			for _, b := range f.Blocks {
+				if !l.blocks[b] {
+					continue
+				}
+				values := b.Values[:0]
+				for _, v := range b.Values {
+					if !invariant(v, l) {
+						values = append(values, v)
+						continue
+					}
+					if pre == nil {
+						pre = preheader(f, l)
+						// the preheader is in the loops enclosing the loop
+						for _, outer := range loops {
+							if outer != l && outer.blocks[l.header] {
+								outer.blocks[pre] = true
+							}
+						}
+					}
+					v.Block = pre
+					pre.Values = append(pre.Values, v)
+					changed = true
+				}
+				b.Values = values
+			}
+		}
+	}
+	removeUnreachable(f)
+}
+
+// invariant reports whether the value can be moved out of the loop.
+// Values computed at each use by Lower are not moved.
+func invariant(v *Value, l *loop) bool {
+	if !v.Op.isPure() || v.mayFail() || rematerialized(v) {
+		return false
+	}
+	for _, arg := range v.Args {
+		if l.blocks[arg.Block] {
+			return false
+		}
+	}
+	return true
+}
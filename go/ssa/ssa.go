@@ -0,0 +1,394 @@
+// Package ssa implements an intermediate representation of PL/0 programs
+// in SSA (static single assignment) form over basic blocks.
+//
+// Scalar local variables and parameters of each function are promoted to
+// SSA values, unless nested functions refer to them. Arrays and the other
+// variables are kept in memory, and accessed by loads and stores.
+//
+// A program is built from a syntax tree by Build, optimized by passes
+// such as ConstProp, DeadCode, CSE and LICM, and lowered back to PL/0 VM
+// instructions by Lower.
+package ssa
+
+import (
+	"fmt"
+	"strings"
+
+	"kkpl0/pl0core"
+)
+
+// Op is an operation of a value.
+type Op int
+
+// Operations of values.
+const (
+	OpInvalid   Op = iota
+	OpConst        // Aux
+	OpUndef        // value of a variable before assignment; Addr is the variable
+	OpPhi          // Args correspond to Preds of the block
+	OpParam        // value of the parameter at Addr, which is not assigned
+	OpLoad         // load of the variable at Addr
+	OpStore        // store of Args[0] into the variable at Addr
+	OpAddr         // address of the array at Addr
+	OpIndex        // address of the element Args[1] of the array at Args[0]
+	OpCheck        // Args[0] checked to be an index of the array of the length Args[1]
+	OpLoadElem     // load of the element at the address Args[0]
+	OpStoreElem    // store of Args[1] into the element at the address Args[0]
+	OpNeg
+	OpOdd
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEq
+	OpNeq
+	OpLs
+	OpGr
+	OpLsEq
+	OpGrEq
+	OpAbs
+	OpMin
+	OpMax
+	OpSqr
+	OpCall     // call of Callee with Args
+	OpCallProc // call of the procedure Callee with Args, without result
+	OpWrite
+	OpWriteStr   // write of the string at the index Args[0] of Program.Strings
+	OpWriteWidth // write of Args[0] in the field of Args[1] characters
+	OpWriteln
+)
+
+var opNames = [...]string{
+	OpInvalid:    "Invalid",
+	OpConst:      "Const",
+	OpUndef:      "Undef",
+	OpPhi:        "Phi",
+	OpParam:      "Param",
+	OpLoad:       "Load",
+	OpStore:      "Store",
+	OpAddr:       "Addr",
+	OpIndex:      "Index",
+	OpCheck:      "Check",
+	OpLoadElem:   "LoadElem",
+	OpStoreElem:  "StoreElem",
+	OpNeg:        "Neg",
+	OpOdd:        "Odd",
+	OpAdd:        "Add",
+	OpSub:        "Sub",
+	OpMul:        "Mul",
+	OpDiv:        "Div",
+	OpMod:        "Mod",
+	OpEq:         "Eq",
+	OpNeq:        "Neq",
+	OpLs:         "Ls",
+	OpGr:         "Gr",
+	OpLsEq:       "LsEq",
+	OpGrEq:       "GrEq",
+	OpAbs:        "Abs",
+	OpMin:        "Min",
+	OpMax:        "Max",
+	OpSqr:        "Sqr",
+	OpCall:       "Call",
+	OpCallProc:   "CallProc",
+	OpWrite:      "Write",
+	OpWriteStr:   "WriteStr",
+	OpWriteWidth: "WriteWidth",
+	OpWriteln:    "Writeln",
+}
+
+func (op Op) String() string {
+	if op < 0 || int(op) >= len(opNames) {
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+	return opNames[op]
+}
+
+// hasResult reports whether values of the operation have results.
+func (op Op) hasResult() bool {
+	switch op {
+	case OpStore, OpStoreElem, OpCallProc, OpWrite, OpWriteStr, OpWriteWidth, OpWriteln:
+		return false
+	}
+	return true
+}
+
+// isPure reports whether values of the operation depend only on their
+// arguments and have no side effects, except that OpDiv, OpMod and OpCheck
+// may fail.
+func (op Op) isPure() bool {
+	switch op {
+	case OpConst, OpParam, OpAddr, OpIndex, OpCheck, OpNeg, OpOdd, OpAdd, OpSub, OpMul, OpDiv, OpMod,
+		OpEq, OpNeq, OpLs, OpGr, OpLsEq, OpGrEq, OpAbs, OpMin, OpMax, OpSqr:
+		return true
+	}
+	return false
+}
+
+// Value is a value, or an operation without result, in a block.
+type Value struct {
+	ID     int
+	Op     Op
+	Args   []*Value
+	Aux    int             // value of OpConst
+	Addr   pl0core.Address // variable of OpUndef, OpParam, OpLoad, OpStore and OpAddr
+	Callee *Func           // function of OpCall and OpCallProc
+	Block  *Block
+}
+
+// mayFail reports whether the value may cause a runtime error,
+// that is, a division by a non-constant or zero, or a check of an index
+// which is not a constant in the range.
+func (v *Value) mayFail() bool {
+	switch v.Op {
+	case OpDiv, OpMod:
+		return v.Args[1].Op != OpConst || v.Args[1].Aux == 0
+	case OpCheck:
+		if v.Args[0].Op != OpConst || v.Args[1].Op != OpConst {
+			return true
+		}
+		_, ok := eval(OpCheck, []int{v.Args[0].Aux, v.Args[1].Aux})
+		return !ok
+	}
+	return false
+}
+
+// removable reports whether the value can be removed if unused.
+func (v *Value) removable() bool {
+	return v.Op.isPure() && !v.mayFail() ||
+		v.Op == OpPhi || v.Op == OpUndef || v.Op == OpLoad
+}
+
+func (v *Value) String() string {
+	return fmt.Sprintf("v%d", v.ID)
+}
+
+// LongString returns the value with its operation and arguments.
+func (v *Value) LongString() string {
+	var sb strings.Builder
+	if v.Op.hasResult() {
+		fmt.Fprintf(&sb, "%s = ", v)
+	}
+	sb.WriteString(v.Op.String())
+	switch v.Op {
+	case OpConst:
+		fmt.Fprintf(&sb, " %d", v.Aux)
+	case OpUndef, OpParam, OpLoad, OpStore, OpAddr:
+		fmt.Fprintf(&sb, " [%d,%d]", v.Addr.Level, v.Addr.Offset)
+	case OpCall, OpCallProc:
+		fmt.Fprintf(&sb, " %s", v.Callee.Name)
+	}
+	for _, arg := range v.Args {
+		fmt.Fprintf(&sb, " %s", arg)
+	}
+	return sb.String()
+}
+
+// BlockKind is a kind of blocks, which determines how the block ends.
+type BlockKind int
+
+const (
+	// BlockPlain jumps to Succs[0].
+	BlockPlain BlockKind = iota
+	// BlockIf jumps to Succs[0] if Control is not 0, otherwise to Succs[1].
+	BlockIf
+	// BlockReturn returns Control from the function.
+	// Control is nil at the end of the function without return.
+	BlockReturn
+)
+
+// Block is a basic block.
+type Block struct {
+	ID      int
+	Kind    BlockKind
+	Values  []*Value // phis come first
+	Control *Value
+	Preds   []*Block
+	Succs   []*Block
+	Func    *Func
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+// newValue appends a value to the block.
+func (b *Block) newValue(op Op, args ...*Value) *Value {
+	v := b.Func.newValue(op, args...)
+	v.Block = b
+	b.Values = append(b.Values, v)
+	return v
+}
+
+// insertValue inserts a value after the phis of the block.
+func (b *Block) insertValue(op Op, args ...*Value) *Value {
+	v := b.Func.newValue(op, args...)
+	v.Block = b
+	i := 0
+	for i < len(b.Values) && b.Values[i].Op == OpPhi {
+		i++
+	}
+	b.Values = append(b.Values, nil)
+	copy(b.Values[i+1:], b.Values[i:])
+	b.Values[i] = v
+	return v
+}
+
+// predIndex returns the index of pred in the predecessors of the block.
+func (b *Block) predIndex(pred *Block) int {
+	for i, p := range b.Preds {
+		if p == pred {
+			return i
+		}
+	}
+	return -1
+}
+
+// addEdge adds an edge from b to succ.
+func (b *Block) addEdge(succ *Block) {
+	b.Succs = append(b.Succs, succ)
+	succ.Preds = append(succ.Preds, b)
+}
+
+// removePred removes the i-th predecessor and the phi arguments for it.
+func (b *Block) removePred(i int) {
+	b.Preds = append(b.Preds[:i:i], b.Preds[i+1:]...)
+	for _, v := range b.Values {
+		if v.Op == OpPhi {
+			v.Args = append(v.Args[:i:i], v.Args[i+1:]...)
+		}
+	}
+}
+
+// Func is a function, or the main block of a program.
+type Func struct {
+	Name      string
+	Level     int  // level of the body
+	Params    int  // number of parameters
+	Proc      bool // procedure, which returns no value
+	FrameSize int  // size of the frame for the variables in memory
+	Blocks    []*Block
+	Entry     *Block
+
+	nextValueID int
+	nextBlockID int
+}
+
+func (f *Func) newValue(op Op, args ...*Value) *Value {
+	f.nextValueID++
+	return &Value{ID: f.nextValueID, Op: op, Args: args}
+}
+
+func (f *Func) newBlock() *Block {
+	b := &Block{ID: f.nextBlockID, Func: f}
+	f.nextBlockID++
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// Values returns the number of values in the function.
+func (f *Func) Values() int {
+	n := 0
+	for _, b := range f.Blocks {
+		n += len(b.Values)
+	}
+	return n
+}
+
+// users returns the values using each value, including blocks using
+// values as Control, which are represented by nil.
+func (f *Func) users() map[*Value][]*Value {
+	users := make(map[*Value][]*Value)
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			for _, arg := range v.Args {
+				users[arg] = append(users[arg], v)
+			}
+		}
+		if b.Control != nil {
+			users[b.Control] = append(users[b.Control], nil)
+		}
+	}
+	return users
+}
+
+// replaceUses replaces the uses of old with new.
+func (f *Func) replaceUses(old *Value, new *Value) {
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			for i, arg := range v.Args {
+				if arg == old {
+					v.Args[i] = new
+				}
+			}
+		}
+		if b.Control == old {
+			b.Control = new
+		}
+	}
+}
+
+// removeValues removes the values for which remove returns true.
+func (f *Func) removeValues(remove func(v *Value) bool) {
+	for _, b := range f.Blocks {
+		values := b.Values[:0]
+		for _, v := range b.Values {
+			if !remove(v) {
+				values = append(values, v)
+			}
+		}
+		b.Values = values
+	}
+}
+
+func (f *Func) String() string {
+	var sb strings.Builder
+	kind := "func"
+	if f.Proc {
+		kind = "proc"
+	}
+	fmt.Fprintf(&sb, "%s %s level %d params %d frame %d\n", kind, f.Name, f.Level, f.Params, f.FrameSize)
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&sb, "%s:", b)
+		if len(b.Preds) > 0 {
+			sb.WriteString(" <-")
+			for _, p := range b.Preds {
+				fmt.Fprintf(&sb, " %s", p)
+			}
+		}
+		sb.WriteString("\n")
+		for _, v := range b.Values {
+			fmt.Fprintf(&sb, "  %s\n", v.LongString())
+		}
+		switch b.Kind {
+		case BlockPlain:
+			if len(b.Succs) == 1 {
+				fmt.Fprintf(&sb, "  Jump %s\n", b.Succs[0])
+			}
+		case BlockIf:
+			fmt.Fprintf(&sb, "  If %s -> %s %s\n", b.Control, b.Succs[0], b.Succs[1])
+		case BlockReturn:
+			if b.Control != nil {
+				fmt.Fprintf(&sb, "  Return %s\n", b.Control)
+			} else {
+				sb.WriteString("  Return\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Program is a program of functions.
+type Program struct {
+	Funcs   []*Func // in order of declarations, followed by Main
+	Main    *Func
+	Strings []string // string pool
+}
+
+func (p *Program) String() string {
+	var list []string
+	for _, f := range p.Funcs {
+		list = append(list, f.String())
+	}
+	return strings.Join(list, "\n")
+}
@@ -0,0 +1,480 @@
+package ssa
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kkpl0/pl0compiler"
+	"kkpl0/pl0core"
+)
+
+func run(instructions []pl0core.Instruction) (string, error) {
+	outBuf := bytes.NewBufferString("")
+	vm := pl0core.NewPL0VM()
+	vm.Output = outBuf
+	err := vm.Run(instructions)
+	return outBuf.String(), err
+}
+
+// buildAndLower builds the source, runs the passes and lowers it.
+func buildAndLower(t *testing.T, source string, passes []Pass) []pl0core.Instruction {
+	t.Helper()
+	p, err := BuildSource("test", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("%v\n%s", err, p)
+	}
+	if err := Optimize(p, passes); err != nil {
+		t.Fatalf("%v\n%s", err, p)
+	}
+	code := Lower(p)
+	if err := pl0core.Verify(code); err != nil {
+		t.Fatalf("Verify: %v\n%s", err, p)
+	}
+	return code
+}
+
+var lowerTargets = []struct {
+	source string
+	want   string
+}{
+	{`.`, ""},
+	{`var x, y; begin x := 3; y := x * 4 + 1; write y; write x - y; writeln end.`, "13 -10 \n"},
+	// phis of loops, and swapping values
+	{`var a, b, t, i;
+	  begin
+	    a := 0; b := 1; i := 0;
+	    while i < 10 do begin t := a + b; a := b; b := t; i := i + 1 end;
+	    write a; write b
+	  end.`, "55 89 "},
+	{`var i, s;
+	  begin i := 0; s := 0; repeat begin s := s + i; i := i + 1 end until i > 100; write s end.`, "5050 "},
+	{`var x, y;
+	  begin
+	    x := 5;
+	    if odd x then y := 1 else y := 2;
+	    if x > 10 then y := y + 10;
+	    write y
+	  end.`, "1 "},
+	// variables of the main block referred to by functions stay in memory
+	{`var g, a[5];
+	  function inc(d) begin g := g + d; return g end;
+	  function sum(ap[], n) var i, s; begin i := 0; s := 0; while i < n do begin s := s + ap[i]; i := i + 1 end; return s end;
+	  var i;
+	  begin
+	    g := 0; i := 0;
+	    while i < 5 do begin a[i] := inc(i); i := i + 1 end;
+	    write sum(a, 5); write g
+	  end.`, "20 10 "},
+	// nested functions refer to the variables of the outer function
+	{`function outer(n)
+	    var k, c;
+	    function inner(x) begin c := c + 1; return x * k end;
+	  begin k := n; c := 0; return inner(2) + inner(3) + c end;
+	  begin write outer(10) end.`, "52 "},
+	// the order of side effects
+	{`var n;
+	  function next() begin n := n + 1; return n end;
+	  var x;
+	  begin n := 0; x := next() - next(); write x; write next() * 10 + next() end.`, "-1 34 "},
+	// loop-invariant and common subexpressions
+	{`function f(a, b)
+	    var i, s;
+	  begin
+	    i := 0; s := 0;
+	    while i < 10 do begin
+	      s := s + a * b + (a * b) / 2;
+	      if s > 1000 then s := s - 1000;
+	      i := i + 1
+	    end;
+	    return s
+	  end;
+	  begin write f(3, 7); write f(20, 20) end.`, "310 1000 "},
+	// return in a loop, and unreachable statements
+	{`function find(x)
+	    var i;
+	  begin
+	    i := 0;
+	    while 1 = 1 do begin
+	      if i * i >= x then return i;
+	      i := i + 1
+	    end;
+	    write 999
+	  end;
+	  begin write find(50); write find(0) end.`, "8 0 "},
+	// tail calls do not overflow the stack
+	{`function sum(n, acc) begin if n = 0 then return acc; return sum(n - 1, acc + n) end;
+	  begin write sum(100000, 0) end.`, "5000050000 "},
+	{`function first(ap[]) return ap[0];
+	  function f(n) var a[1]; begin a[0] := n; return first(a) end;
+	  begin write f(7) end.`, "7 "},
+	// procedures, which return no values
+	{`var n;
+	  procedure count(x) begin if x < 0 then return; n := n + x; count(x - 1) end;
+	  procedure swap(ap[], i, j) var t; begin t := ap[i]; ap[i] := ap[j]; ap[j] := t end;
+	  var a[2], i;
+	  begin
+	    n := 0; i := 0;
+	    while i < 1000 do begin call count(3); i := i + 1 end;
+	    a[0] := 1; a[1] := 2; swap(a, 0, 1);
+	    write n; write a[0] * 10 + a[1]
+	  end.`, "6000 21 "},
+	// for loops, with the bound evaluated once
+	{`var n, i, j, s;
+	  procedure inc(x) n := n + x;
+	  begin
+	    n := 3; s := 0;
+	    for i := 1 to n do begin inc(1); s := s + i end;
+	    write s;
+	    for i := 10 downto 1 step 3 do for j := i to 10 step -1 do write j;
+	    for i := 5 to 1 do write i;
+	    write i
+	  end.`, "6 10 5 "},
+	// short-circuit and, or and not
+	{`var n, i;
+	  function next() begin n := n + 1; return n end;
+	  begin
+	    n := 0;
+	    if next() > 5 and next() > 5 then write 1;
+	    if next() > 1 or next() > 1 then write n;
+	    i := 0;
+	    while not (i >= 3 or i * i > 3) do i := i + 1;
+	    repeat n := n + 1 until n > 10 and odd n;
+	    write i; write n
+	  end.`, "2 2 11 "},
+	// mod and builtin functions
+	{`var x, y, i, s;
+	  function gcd(a, b) begin if b = 0 then return a; return gcd(b, a mod b) end;
+	  begin
+	    x := -7; y := 3;
+	    write x mod y; write abs(x) + sqr(y); write min(x, y) * max(y, x);
+	    s := 0;
+	    for i := 1 to 10 do s := s + max(i, 5) mod 4 + abs(-2);
+	    write s; write gcd(84, 36); write max(sqr(2), abs(0 - 5))
+	  end.`, "-1 16 -21 33 12 5 "},
+	{`const debug = 0;
+	  var x;
+	  begin x := 1; if debug = 1 then write 100; while debug > 0 do x := x + 1; write x end.`, "1 "},
+	// strings and field widths
+	{`var i;
+	  begin
+	    for i := 1 to 3 do write "[", i * i:3, "]";
+	    writeln;
+	    writeln "i = ", i, i:2 - 1
+	  end.`, "[  1][  4][  9]\ni = 4 4\n"},
+	// multi-dimensional arrays
+	{`var m[3, 4], i, j;
+	  function total(p[,], rows, cols)
+	    var i, j, s;
+	  begin
+	    s := 0;
+	    for i := 0 to rows - 1 do for j := 0 to cols - 1 do s := s + p[i, j] * (i + 1);
+	    return s
+	  end;
+	  begin
+	    for i := 0 to 2 do for j := 0 to 3 do m[i, j] := i * 4 + j;
+	    write total(m, 3, 4); write m[2, 3]
+	  end.`, "164 11 "},
+	// break and continue
+	{`
+	  var i, j, n;
+	  begin
+	    i := 0;
+	    while 1 = 1 do begin
+		  i := i + 1;
+		  if odd i then continue;
+		  if i > 8 then break;
+		  write i
+	    end;
+	    n := 0;
+	    repeat begin
+		  n := n + 1;
+		  if n = 2 then continue;
+		  if n = 4 then break;
+		  write n
+	    end until n >= 10;
+	    for i := 1 to 3 do
+		  for j := 1 to 3 do begin
+	    	if j = i then continue;
+	    	if j > i then break;
+	    	write i * 10 + j
+		  end;
+	    writeln
+	  end.
+	`, "2 4 6 8 1 3 21 31 32 \n"},
+	// case
+	{`
+	  const two = 2;
+	  var i, x;
+	  begin
+	    for i := 0 to 6 do begin
+		  case i of
+		    1: x := 10;
+		    two, 3: x := 20;
+		    5: break
+		  else x := i
+		  end;
+		  write x
+	    end;
+	    case x of 1: write 1 end
+	  end.
+	`, "0 10 20 20 4 "},
+	// var parameters keep the arguments in memory
+	{`
+	  var a, b, m[3], i;
+	  procedure swap(var x, var y)
+	    var t;
+	  begin t := x; x := y; y := t end;
+	  procedure outer(var x)
+	    procedure inner(var y) begin y := y * 10; x := x + 1 end;
+	  begin x := x + 1; inner(x) end;
+	  function count(var n) begin for n := 1 to 3 do write n; return n end;
+	  procedure local()
+	    var c, d;
+	  begin c := 1; d := 2; swap(c, d); write c, d; m[0] := c; swap(m[0], m[2]) end;
+	  begin
+	    a := 1; b := 2;
+	    swap(a, b); write a, b;
+	    outer(a); write a;
+	    local(); write m[0], m[2];
+	    write count(i), i
+	  end.
+	`, "2 1 31 2 1 0 2 1 2 3 4 4 "},
+}
+
+func TestLower(t *testing.T) {
+	for nth, target := range lowerTargets {
+		for _, passes := range [][]Pass{nil, Passes} {
+			got, err := run(buildAndLower(t, target.source, passes))
+			if err != nil {
+				t.Errorf("#%d: Error: %s", nth, err)
+			} else if got != target.want {
+				t.Errorf("#%d (%d passes): Got: %s\nWant: %s", nth, len(passes), got, target.want)
+			}
+		}
+	}
+}
+
+func TestLowerExamples(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("..", "..", "examples", "*.pl0"))
+	if len(files) == 0 {
+		t.Fatal("no examples")
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instructions, err := pl0compiler.CompileSource(file, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := run(instructions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := run(buildAndLower(t, string(src), Passes))
+		if err != nil {
+			t.Errorf("%s: Error: %s", file, err)
+		} else if got != want {
+			t.Errorf("%s: Got: %s\nWant: %s", file, got, want)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	// the division by zero is kept even if its result is unused
+	source := `var x, y; begin y := 0; x := 10 / y; write 1 end.`
+	for _, passes := range [][]Pass{nil, Passes} {
+		got, err := run(buildAndLower(t, source, passes))
+		if e, ok := err.(*pl0core.Error); !ok || e.Code != pl0core.CodeDivisionByZero || got != "" {
+			t.Errorf("Got: %q, %v, Want division by zero", got, err)
+		}
+	}
+
+	// so is the check of an index
+	source = `var a[3], i, x; begin i := 3; x := a[i]; write 1 end.`
+	for _, passes := range [][]Pass{nil, Passes} {
+		got, err := run(buildAndLower(t, source, passes))
+		if e, ok := err.(*pl0core.Error); !ok || e.Code != pl0core.CodeIndexOutOfRange || got != "" {
+			t.Errorf("Got: %q, %v, Want index out of range", got, err)
+		}
+	}
+}
+
+// countOps returns the number of values of the operation in the function.
+func countOps(f *Func, op Op) int {
+	n := 0
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if v.Op == op {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func buildFunc(t *testing.T, source string, name string) *Func {
+	t.Helper()
+	p, err := BuildSource("test", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range p.Funcs {
+		if f.Name == name {
+			return f
+		}
+	}
+	t.Fatalf("no function %s", name)
+	return nil
+}
+
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		source string
+		name   string
+		pass   func(f *Func)
+		check  func(f *Func) bool
+	}{
+		// constants are propagated through phis, and branches are removed
+		{`var x, y; begin x := 2; if x > 1 then y := x * 3 else y := 0; write y end.`, "main", ConstProp,
+			func(f *Func) bool {
+				return len(f.Blocks) == 1 && countOps(f, OpMul) == 0 && countOps(f, OpPhi) == 0
+			}},
+		// loops with constant conditions
+		{`var i; begin i := 0; while i > 0 do i := i - 1; write i end.`, "main", ConstProp,
+			func(f *Func) bool { return len(f.Blocks) == 1 }},
+		// checks of constant indices in the range are removed, but not the others
+		{`var a[3], i; begin i := 1; a[2] := a[i]; write a[3] end.`, "main", func(f *Func) { ConstProp(f); DeadCode(f) },
+			func(f *Func) bool { return countOps(f, OpCheck) == 1 }},
+		// dead values are removed, but not divisions which may fail
+		{`function f(a) var x, y; begin x := a * a; y := 1 / a; return 0 end; begin write f(1) end.`, "f", DeadCode,
+			func(f *Func) bool { return countOps(f, OpMul) == 0 && countOps(f, OpDiv) == 1 }},
+		{`function f(a, b) begin write a * b + 1; if a > 0 then write b * a + 2 end; begin write f(1, 2) end.`, "f", CSE,
+			func(f *Func) bool { return countOps(f, OpMul) == 1 }},
+		// memory is not assumed to be unchanged
+		{`var g; function f(a) begin write g + a; g := 1; write g + a end; begin write f(1) end.`, "f", CSE,
+			func(f *Func) bool { return countOps(f, OpLoad) == 2 && countOps(f, OpAdd) == 2 }},
+		{`function f(a, b) var i, s; begin i := 0; s := 0; while i < 10 do begin s := s + a * b; i := i + 1 end; return s end;
+		  begin write f(1, 2) end.`, "f", LICM,
+			func(f *Func) bool {
+				loops := findLoops(f, Dominators(f))
+				for _, b := range f.Blocks {
+					for _, v := range b.Values {
+						if v.Op == OpMul && loops[0].blocks[b] {
+							return false
+						}
+					}
+				}
+				return len(loops) == 1
+			}},
+		// divisions which may fail stay in the loop
+		{`function f(a, b) var i, s; begin i := 0; s := 0; while i < a do begin s := s + 10 / b; i := i + 1 end; return s end;
+		  begin write f(1, 2) end.`, "f", LICM,
+			func(f *Func) bool {
+				loops := findLoops(f, Dominators(f))
+				for _, b := range f.Blocks {
+					for _, v := range b.Values {
+						if v.Op == OpDiv {
+							return loops[0].blocks[b]
+						}
+					}
+				}
+				return false
+			}},
+	}
+	for nth, test := range tests {
+		f := buildFunc(t, test.source, test.name)
+		test.pass(f)
+		if err := f.Verify(); err != nil {
+			t.Errorf("#%d: %v\n%s", nth, err, f)
+		} else if !test.check(f) {
+			t.Errorf("#%d: unexpected result\n%s", nth, f)
+		}
+	}
+}
+
+func TestDominators(t *testing.T) {
+	f := buildFunc(t, `var x; begin x := 1; if x > 0 then x := 2 else x := 3; while x < 10 do x := x + 1; write x end.`, "main")
+	dom := Dominators(f)
+	entry := f.Entry
+	then, els := entry.Succs[0], entry.Succs[1]
+	join := then.Succs[0]
+	if dom.Idom(then) != entry || dom.Idom(els) != entry || dom.Idom(join) != entry {
+		t.Errorf("Idom: %v %v %v, Want %v\n%s", dom.Idom(then), dom.Idom(els), dom.Idom(join), entry, f)
+	}
+	if dom.Dominates(then, join) || !dom.Dominates(entry, join) || !dom.Dominates(join, join) {
+		t.Errorf("Dominates\n%s", f)
+	}
+	if dom.Idom(entry) != nil || len(dom.Children(entry)) != 3 {
+		t.Errorf("Children: %v\n%s", dom.Children(entry), f)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	source := `var x, i; begin x := 1; i := 0; while i < 3 do begin x := x * 2; i := i + 1 end; write x end.`
+	tests := []struct {
+		corrupt func(f *Func)
+		want    string
+	}{
+		{func(f *Func) {}, ""},
+		{func(f *Func) {
+			for _, b := range f.Blocks {
+				for _, v := range b.Values {
+					if v.Op == OpPhi {
+						v.Args = v.Args[:1]
+						return
+					}
+				}
+			}
+		}, "with 1 arguments"},
+		{func(f *Func) {
+			for _, b := range f.Blocks {
+				for _, v := range b.Values {
+					if v.Op == OpMul {
+						f.Entry.newValue(OpWrite, v)
+						return
+					}
+				}
+			}
+		}, "does not dominate"},
+		{func(f *Func) { f.Entry.Succs = append(f.Entry.Succs, f.Blocks[1]) }, "inconsistent edge"},
+		{func(f *Func) { f.Entry.Kind = BlockReturn }, "return block with successors"},
+	}
+	for nth, test := range tests {
+		f := buildFunc(t, source, "main")
+		test.corrupt(f)
+		err := f.Verify()
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("#%d: %v\n%s", nth, err, f)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("#%d: Got %v, Want %s\n%s", nth, err, test.want, f)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	f := buildFunc(t, `function f(a) begin if a > 0 then return a; return -a end; begin write f(-3) end.`, "f")
+	want := `func f level 1 params 1 frame 2
+b0:
+  v1 = Param [1,-1]
+  v2 = Const 0
+  v3 = Gr v1 v2
+  If v3 -> b1 b2
+b1: <- b0
+  Return v1
+b2: <- b0
+  v6 = Neg v1
+  Return v6
+`
+	if got := f.String(); got != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+package ssa
+
+import "fmt"
+
+// Verify checks the invariants of the program.
+func (p *Program) Verify() error {
+	for _, f := range p.Funcs {
+		if err := f.Verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks the invariants of the function:
+//
+//   - the edges are consistent in both directions
+//   - the number of successors and the control match the kind of blocks
+//   - the entry has no predecessors, and all blocks are reachable
+//   - phis come first, with an argument for each predecessor
+//   - the arguments have results, and dominate their uses
+func (f *Func) Verify() error {
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("ssa: %s: %s", f.Name, fmt.Sprintf(format, args...))
+	}
+	if len(f.Blocks) == 0 || f.Blocks[0] != f.Entry {
+		return errorf("entry is not the first block")
+	}
+	if len(f.Entry.Preds) > 0 {
+		return errorf("entry %s has predecessors", f.Entry)
+	}
+	inFunc := make(map[*Block]bool)
+	defined := make(map[*Value]*Block)
+	index := make(map[*Value]int)
+	for _, b := range f.Blocks {
+		if b.Func != f || inFunc[b] {
+			return errorf("%s is not a block of the function", b)
+		}
+		inFunc[b] = true
+		for i, v := range b.Values {
+			if v.Block != b || defined[v] != nil {
+				return errorf("%s: %s is not a value of the block", b, v)
+			}
+			defined[v] = b
+			index[v] = i
+		}
+	}
+	if len(postorder(f)) != len(f.Blocks) {
+		return errorf("unreachable blocks")
+	}
+
+	dom := Dominators(f)
+	// dominates reports whether def is available at the end of b,
+	// or before the i-th value of b if i >= 0.
+	dominates := func(def *Value, b *Block, i int) bool {
+		db := defined[def]
+		if db == b {
+			return i < 0 || index[def] < i
+		}
+		return db != nil && dom.Dominates(db, b)
+	}
+	countEdges := func(list []*Block, b *Block) int {
+		n := 0
+		for _, x := range list {
+			if x == b {
+				n++
+			}
+		}
+		return n
+	}
+
+	for _, b := range f.Blocks {
+		for _, s := range b.Succs {
+			if !inFunc[s] || countEdges(s.Preds, b) != countEdges(b.Succs, s) {
+				return errorf("%s: inconsistent edge to %s", b, s)
+			}
+		}
+		for _, p := range b.Preds {
+			if !inFunc[p] || countEdges(p.Succs, b) != countEdges(b.Preds, p) {
+				return errorf("%s: inconsistent edge from %s", b, p)
+			}
+		}
+		switch b.Kind {
+		case BlockPlain:
+			if len(b.Succs) != 1 || b.Control != nil {
+				return errorf("%s: plain block with %d successors", b, len(b.Succs))
+			}
+		case BlockIf:
+			if len(b.Succs) != 2 || b.Control == nil {
+				return errorf("%s: if block without 2 successors and control", b)
+			}
+		case BlockReturn:
+			if len(b.Succs) != 0 {
+				return errorf("%s: return block with successors", b)
+			}
+		default:
+			return errorf("%s: invalid kind %d", b, b.Kind)
+		}
+		if c := b.Control; c != nil && (!c.Op.hasResult() || !dominates(c, b, -1)) {
+			return errorf("%s: control %s is not available", b, c)
+		}
+
+		phis := true
+		for i, v := range b.Values {
+			if v.Op != OpPhi {
+				phis = false
+			} else if !phis {
+				return errorf("%s: phi %s after other values", b, v)
+			}
+			if err := checkArity(v); err != nil {
+				return errorf("%s: %v", b, err)
+			}
+			for j, arg := range v.Args {
+				if !arg.Op.hasResult() {
+					return errorf("%s: argument %s of %s has no result", b, arg, v)
+				}
+				ok := false
+				if v.Op == OpPhi {
+					ok = dominates(arg, b.Preds[j], -1)
+				} else {
+					ok = dominates(arg, b, i)
+				}
+				if !ok {
+					return errorf("%s: argument %s of %s does not dominate it", b, arg, v)
+				}
+			}
+			if (v.Op == OpCall || v.Op == OpCallProc) && v.Callee == nil {
+				return errorf("%s: call %s without callee", b, v)
+			}
+		}
+	}
+	return nil
+}
+
+// checkArity checks the number of arguments of the value.
+func checkArity(v *Value) error {
+	n := -1
+	switch v.Op {
+	case OpConst, OpUndef, OpParam, OpLoad, OpAddr, OpWriteln:
+		n = 0
+	case OpStore, OpLoadElem, OpNeg, OpOdd, OpAbs, OpSqr, OpWrite, OpWriteStr:
+		n = 1
+	case OpIndex, OpCheck, OpStoreElem, OpAdd, OpSub, OpMul, OpDiv, OpMod,
+		OpEq, OpNeq, OpLs, OpGr, OpLsEq, OpGrEq, OpMin, OpMax, OpWriteWidth:
+		n = 2
+	case OpPhi:
+		n = len(v.Block.Preds)
+	case OpCall, OpCallProc:
+		if v.Callee != nil {
+			n = v.Callee.Params
+		}
+	default:
+		return fmt.Errorf("%s: invalid operation %s", v, v.Op)
+	}
+	if n >= 0 && len(v.Args) != n {
+		return fmt.Errorf("%s: %s with %d arguments", v, v.Op, len(v.Args))
+	}
+	return nil
+}
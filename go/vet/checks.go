@@ -0,0 +1,451 @@
+package vet
+
+import (
+	"fmt"
+
+	"kkpl0/ast"
+	"kkpl0/pl0compiler"
+)
+
+// MissingReturn reports functions which may reach the end without return.
+// Such a function returns whatever is on the top of the stack.
+var MissingReturn = &Check{
+	Name: "missingreturn",
+	Doc:  "report functions which may reach the end without return",
+	Run: func(pass *Pass) {
+		_, funcs := blocks(pass.Prog)
+		for _, fn := range funcs {
+			if fn != nil && !fn.Proc && !pl0compiler.Returns(fn.Body.Body) {
+				pass.Reportf(fn.Name, "function %s may reach the end without return", fn.Name.Name)
+			}
+		}
+	},
+}
+
+// Uninit reports local variables which may be used before assignment.
+// Variables are not initialized by the VM.
+var Uninit = &Check{
+	Name: "uninit",
+	Doc:  "report variables which may be used before assignment",
+	Run: func(pass *Pass) {
+		blocks, funcs := blocks(pass.Prog)
+		for i, block := range blocks {
+			a := &uninitAnalyzer{pass: pass, locals: make(map[*pl0compiler.Symbol]bool),
+				reported: make(map[*pl0compiler.Symbol]bool)}
+			for _, sym := range declaredSymbols(pass.Info, block, funcs[i]) {
+				if sym.Kind == pl0compiler.SymbolVarScalar && !sym.Param {
+					a.locals[sym] = true
+					a.scope = sym.Scope
+				}
+			}
+			if len(a.locals) > 0 {
+				a.stmt(block.Body, newAssignState())
+			}
+		}
+	},
+}
+
+// assignState is the set of variables definitely assigned.
+type assignState struct {
+	assigned    map[*pl0compiler.Symbol]bool
+	unreachable bool // after return, break or continue
+}
+
+func newAssignState() *assignState {
+	return &assignState{assigned: make(map[*pl0compiler.Symbol]bool)}
+}
+
+func (s *assignState) copy() *assignState {
+	c := &assignState{assigned: make(map[*pl0compiler.Symbol]bool), unreachable: s.unreachable}
+	for sym := range s.assigned {
+		c.assigned[sym] = true
+	}
+	return c
+}
+
+// join returns the state after either s or t.
+func (s *assignState) join(t *assignState) *assignState {
+	if s.unreachable {
+		return t
+	} else if t.unreachable {
+		return s
+	}
+	j := newAssignState()
+	for sym := range s.assigned {
+		if t.assigned[sym] {
+			j.assigned[sym] = true
+		}
+	}
+	return j
+}
+
+type uninitAnalyzer struct {
+	pass     *Pass
+	locals   map[*pl0compiler.Symbol]bool
+	scope    *pl0compiler.Scope // scope of the locals
+	reported map[*pl0compiler.Symbol]bool
+	loops    []*loopStates // enclosing loops, innermost last
+}
+
+// loopStates are the states at break and continue of a loop.
+type loopStates struct {
+	breaks    []*assignState
+	continues []*assignState
+}
+
+// loopBody analyzes the body of a loop from the state s.
+func (a *uninitAnalyzer) loopBody(body ast.Stmt, s *assignState) (*assignState, *loopStates) {
+	l := &loopStates{}
+	a.loops = append(a.loops, l)
+	s = a.stmt(body, s)
+	a.loops = a.loops[:len(a.loops)-1]
+	return s, l
+}
+
+// stmt analyzes the statement and returns the state after it.
+func (a *uninitAnalyzer) stmt(stmt ast.Stmt, s *assignState) *assignState {
+	switch n := stmt.(type) {
+	case *ast.AssignStmt:
+		for _, index := range n.Indices {
+			a.expr(index, s)
+		}
+		a.expr(n.Value, s)
+		if sym := a.pass.Info.Uses[n.Name]; a.locals[sym] {
+			s.assigned[sym] = true
+		}
+	case *ast.CompoundStmt:
+		for _, child := range n.List {
+			s = a.stmt(child, s)
+		}
+	case *ast.IfStmt:
+		a.expr(n.Cond, s)
+		then := a.stmt(n.Then, s.copy())
+		if n.Else == nil {
+			return then.join(s)
+		}
+		return then.join(a.stmt(n.Else, s.copy()))
+	case *ast.CaseStmt:
+		a.expr(n.X, s)
+		t := s
+		if n.Else != nil {
+			t = a.stmt(n.Else, s.copy())
+		}
+		for _, clause := range n.Clauses {
+			t = t.join(a.stmt(clause.Body, s.copy()))
+		}
+		return t
+	case *ast.WhileStmt:
+		a.expr(n.Cond, s)
+		a.loopBody(n.Body, s.copy())
+	case *ast.RepeatStmt:
+		var l *loopStates
+		s, l = a.loopBody(n.Body, s)
+		for _, t := range l.continues {
+			s = s.join(t)
+		}
+		a.expr(n.Cond, s)
+		for _, t := range l.breaks {
+			s = s.join(t)
+		}
+	case *ast.ForStmt:
+		a.expr(n.From, s)
+		a.expr(n.To, s)
+		if sym := a.pass.Info.Uses[n.Var]; a.locals[sym] {
+			s.assigned[sym] = true
+		}
+		a.loopBody(n.Body, s.copy())
+	case *ast.BranchStmt:
+		if len(a.loops) > 0 {
+			l := a.loops[len(a.loops)-1]
+			if n.Continue {
+				l.continues = append(l.continues, s.copy())
+			} else {
+				l.breaks = append(l.breaks, s.copy())
+			}
+		}
+		s.unreachable = true
+	case *ast.ReturnStmt:
+		a.expr(n.Result, s)
+		s.unreachable = true
+	case *ast.WriteStmt:
+		for _, item := range n.Items {
+			a.expr(item, s)
+		}
+	case *ast.WritelnStmt:
+		for _, item := range n.Items {
+			a.expr(item, s)
+		}
+	case *ast.CallStmt:
+		a.expr(n.X, s)
+	}
+	return s
+}
+
+func (a *uninitAnalyzer) expr(expr ast.Expr, s *assignState) {
+	inspect(expr, func(node ast.Node) {
+		switch n := node.(type) {
+		case *ast.Ident:
+			sym := a.pass.Info.Uses[n]
+			if a.locals[sym] && !s.assigned[sym] && !s.unreachable && !a.reported[sym] {
+				a.reported[sym] = true
+				a.pass.Reportf(n, "variable %s may be used before assignment", n.Name)
+			}
+		case *ast.CallExpr:
+			// a nested function or procedure may assign the locals
+			sym := a.pass.Info.Uses[n.Func]
+			if sym != nil && a.encloses(sym.Scope) {
+				for local := range a.locals {
+					s.assigned[local] = true
+				}
+			}
+			// and so may the callee through var parameters
+			for i, arg := range n.Args {
+				id, ok := arg.(*ast.Ident)
+				if ok && sym != nil && i < len(sym.Params) && sym.Params[i].Kind == pl0compiler.SymbolVarParam {
+					if local := a.pass.Info.Uses[id]; a.locals[local] {
+						s.assigned[local] = true
+					}
+				}
+			}
+		}
+	})
+}
+
+// encloses reports whether scope is the scope of the locals or nested in it.
+func (a *uninitAnalyzer) encloses(scope *pl0compiler.Scope) bool {
+	for ; scope != nil; scope = scope.Parent {
+		if scope == a.scope {
+			return true
+		}
+	}
+	return false
+}
+
+// usage is the usage of variables in a program.
+type usage struct {
+	info    *pl0compiler.Info
+	reads   map[*pl0compiler.Symbol]int
+	assigns map[*pl0compiler.Symbol][]*ast.AssignStmt
+}
+
+// usageOf counts reads and assignments of variables.
+// Assignments to array elements are counted as reads of the array.
+func usageOf(pass *Pass) *usage {
+	u := &usage{
+		info:    pass.Info,
+		reads:   make(map[*pl0compiler.Symbol]int),
+		assigns: make(map[*pl0compiler.Symbol][]*ast.AssignStmt),
+	}
+	blocks, _ := blocks(pass.Prog)
+	for _, block := range blocks {
+		targets := make(map[*ast.Ident]bool)
+		inspect(block.Body, func(node ast.Node) {
+			switch n := node.(type) {
+			case *ast.AssignStmt:
+				if n.Indices == nil {
+					targets[n.Name] = true
+					if sym := pass.Info.Uses[n.Name]; sym != nil {
+						u.assigns[sym] = append(u.assigns[sym], n)
+					}
+				}
+			case *ast.Ident:
+				if sym := pass.Info.Uses[n]; sym != nil && !targets[n] {
+					u.reads[sym]++
+				}
+			}
+		})
+	}
+	return u
+}
+
+// isDiscarded reports whether the variable is only assigned results of
+// function calls, as void of void := f(...).
+// Builtin functions have no side effects, and are not such calls.
+func (u *usage) isDiscarded(sym *pl0compiler.Symbol) bool {
+	if u.reads[sym] > 0 || len(u.assigns[sym]) == 0 {
+		return false
+	}
+	for _, assign := range u.assigns[sym] {
+		call, ok := assign.Value.(*ast.CallExpr)
+		if !ok || isBuiltin(u.info, call) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBuiltin reports whether the call is of a builtin function.
+func isBuiltin(info *pl0compiler.Info, call *ast.CallExpr) bool {
+	sym := info.Uses[call.Func]
+	return sym != nil && sym.Kind == pl0compiler.SymbolBuiltin
+}
+
+// Unused reports variables and parameters which are never read.
+// Variables only assigned results of function calls are reported by Discard.
+var Unused = &Check{
+	Name: "unused",
+	Doc:  "report variables and parameters which are never used",
+	Run: func(pass *Pass) {
+		u := usageOf(pass)
+		blocks, funcs := blocks(pass.Prog)
+		for i, block := range blocks {
+			for _, sym := range declaredSymbols(pass.Info, block, funcs[i]) {
+				if !sym.IsVariable() || u.reads[sym] > 0 || u.isDiscarded(sym) {
+					continue
+				}
+				if sym.Kind == pl0compiler.SymbolVarParam && len(u.assigns[sym]) > 0 {
+					// the caller reads the result
+					continue
+				}
+				kind := "variable"
+				if sym.Param {
+					kind = "parameter"
+				}
+				if len(u.assigns[sym]) > 0 {
+					pass.Reportf(sym.Decl, "%s %s is assigned but never used", kind, sym.Name)
+				} else {
+					pass.Reportf(sym.Decl, "%s %s is never used", kind, sym.Name)
+				}
+			}
+		}
+	},
+}
+
+// Shadow reports declarations which shadow constants of enclosing blocks.
+var Shadow = &Check{
+	Name: "shadow",
+	Doc:  "report declarations which shadow constants of enclosing blocks",
+	Run: func(pass *Pass) {
+		blocks, funcs := blocks(pass.Prog)
+		for i, block := range blocks {
+			for _, sym := range declaredSymbols(pass.Info, block, funcs[i]) {
+				outer := sym.Scope.Parent
+				if outer == nil {
+					continue
+				}
+				shadowed := outer.Lookup(sym.Name, sym.Decl.Pos())
+				if shadowed == nil || shadowed.Kind != pl0compiler.SymbolConst {
+					continue
+				}
+				f := pass.Reportf(sym.Decl, "%s %s shadows constant %s", sym.Kind, sym.Name, sym.Name)
+				f.Related = append(f.Related, pl0compiler.Related{
+					Pos: shadowed.Decl.Pos(),
+					End: shadowed.Decl.End(),
+					Msg: fmt.Sprintf("constant %s is declared here", sym.Name),
+				})
+			}
+		}
+	},
+}
+
+// Discard reports function calls whose results are discarded
+// by assigning them to variables never read, as void := f(...).
+var Discard = &Check{
+	Name: "discard",
+	Doc:  "report function results assigned to variables never read",
+	Run: func(pass *Pass) {
+		u := usageOf(pass)
+		for sym, assigns := range u.assigns {
+			if !u.isDiscarded(sym) {
+				continue
+			}
+			for _, assign := range assigns {
+				call := assign.Value.(*ast.CallExpr)
+				pass.Reportf(assign, "result of %s is discarded (%s is never used)",
+					call.Func.Name, sym.Name)
+			}
+		}
+	},
+}
+
+// ConstCond reports conditions which are always true or always false.
+var ConstCond = &Check{
+	Name: "constcond",
+	Doc:  "report conditions which are always true or false",
+	Run: func(pass *Pass) {
+		blocks, _ := blocks(pass.Prog)
+		for _, block := range blocks {
+			inspect(block.Body, func(node ast.Node) {
+				var cond ast.Expr
+				switch n := node.(type) {
+				case *ast.IfStmt:
+					cond = n.Cond
+				case *ast.WhileStmt:
+					cond = n.Cond
+				case *ast.RepeatStmt:
+					cond = n.Cond
+				default:
+					return
+				}
+				if value, ok := constValue(pass.Info, cond); ok {
+					pass.Reportf(cond, "condition is always %t", value != 0)
+				}
+			})
+		}
+	},
+}
+
+// constValue returns the value of the expression if it is constant.
+func constValue(info *pl0compiler.Info, expr ast.Expr) (int, bool) {
+	switch x := expr.(type) {
+	case *ast.NumberLit:
+		return x.Value, true
+	case *ast.Ident:
+		if sym := info.Uses[x]; sym != nil && sym.Kind == pl0compiler.SymbolConst {
+			return sym.Value, true
+		}
+	case *ast.ParenExpr:
+		return constValue(info, x.X)
+	case *ast.UnaryExpr:
+		v, ok := constValue(info, x.X)
+		if !ok {
+			return 0, false
+		}
+		switch x.Op {
+		case ast.OpSub:
+			return -v, true
+		case ast.OpOdd:
+			return v & 1, true
+		case ast.OpNot:
+			return boolValue(v == 0), true
+		}
+		return v, true
+	case *ast.BinaryExpr:
+		a, ok := constValue(info, x.X)
+		if !ok {
+			return 0, false
+		}
+		b, ok := constValue(info, x.Y)
+		if !ok {
+			return 0, false
+		}
+		switch x.Op {
+		case ast.OpAnd:
+			return boolValue(a != 0 && b != 0), true
+		case ast.OpOr:
+			return boolValue(a != 0 || b != 0), true
+		}
+		return pl0compiler.Eval(x.Op, a, b)
+	case *ast.CallExpr:
+		if !isBuiltin(info, x) || len(x.Args) != info.Uses[x.Func].Builtin.Params {
+			return 0, false
+		}
+		var args []int
+		for _, arg := range x.Args {
+			v, ok := constValue(info, arg)
+			if !ok {
+				return 0, false
+			}
+			args = append(args, v)
+		}
+		return info.Uses[x.Func].Builtin.Eval(args), true
+	}
+	return 0, false
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
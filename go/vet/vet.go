@@ -0,0 +1,248 @@
+// Package vet reports suspicious constructs in PL/0 programs,
+// which the compiler accepts but are almost certainly wrong.
+//
+// Each check works on the syntax tree and the symbols resolved
+// by the compiler, and can be enabled individually.
+package vet
+
+import (
+	"fmt"
+	"sort"
+
+	"kkpl0/ast"
+	"kkpl0/diag"
+	"kkpl0/pl0compiler"
+)
+
+// Check is a check of pl0vet.
+type Check struct {
+	Name string
+	Doc  string
+	Run  func(pass *Pass)
+}
+
+// Checks is the list of all checks.
+var Checks = []*Check{
+	MissingReturn,
+	Uninit,
+	Unused,
+	Shadow,
+	Discard,
+	ConstCond,
+}
+
+// Finding is a suspicious construct found by a check.
+type Finding struct {
+	Check   string
+	Pos     ast.Pos
+	End     ast.Pos
+	Msg     string
+	Related []pl0compiler.Related // may be empty
+}
+
+// Diagnostic returns the finding in the source as a warning.
+func (f *Finding) Diagnostic(sourceName string) diag.Diagnostic {
+	rangeOf := func(pos ast.Pos, end ast.Pos) *diag.Range {
+		return &diag.Range{
+			Start: diag.Position{Line: pos.Line, Column: pos.Column},
+			End:   diag.Position{Line: end.Line, Column: end.Column},
+		}
+	}
+	d := diag.Diagnostic{
+		Severity: diag.SeverityWarning,
+		Code:     f.Check,
+		Message:  f.Msg,
+		Location: diag.Location{File: sourceName, Range: rangeOf(f.Pos, f.End)},
+	}
+	for _, r := range f.Related {
+		d.Related = append(d.Related, diag.RelatedLocation{
+			Location: diag.Location{File: sourceName, Range: rangeOf(r.Pos, r.End)},
+			Message:  r.Msg,
+		})
+	}
+	return d
+}
+
+// Pass holds the program checked by a check.
+type Pass struct {
+	Prog     *ast.Program
+	Info     *pl0compiler.Info
+	check    *Check
+	findings []*Finding
+}
+
+// Reportf reports a finding at the node.
+func (pass *Pass) Reportf(node ast.Node, format string, args ...interface{}) *Finding {
+	f := &Finding{
+		Check: pass.check.Name,
+		Pos:   node.Pos(),
+		End:   node.End(),
+		Msg:   fmt.Sprintf(format, args...),
+	}
+	pass.findings = append(pass.findings, f)
+	return f
+}
+
+// Run runs the checks on the compiled program,
+// and returns the findings in order of position.
+func Run(prog *ast.Program, info *pl0compiler.Info, checks []*Check) []*Finding {
+	pass := &Pass{Prog: prog, Info: info}
+	for _, check := range checks {
+		pass.check = check
+		check.Run(pass)
+	}
+	sort.SliceStable(pass.findings, func(i, j int) bool {
+		return pass.findings[i].Pos.Before(pass.findings[j].Pos)
+	})
+	return pass.findings
+}
+
+// CheckSource compiles the source and runs the checks.
+// Compile errors are returned as pl0compiler.ErrorList.
+func CheckSource(sourceName string, src []byte, checks []*Check) ([]*Finding, error) {
+	return CheckSourceDialect(sourceName, src, pl0compiler.DialectKK, checks)
+}
+
+// CheckSourceDialect is like CheckSource, but compiles the source in the dialect.
+func CheckSourceDialect(sourceName string, src []byte, dialect pl0compiler.Dialect,
+	checks []*Check) ([]*Finding, error) {
+	prog, err := pl0compiler.ParseDialect(sourceName, src, dialect)
+	if err != nil {
+		return nil, err
+	}
+	c := pl0compiler.NewCompiler(sourceName)
+	if err = c.Compile(prog); err != nil {
+		return nil, err
+	}
+	return Run(prog, c.Info(), checks), nil
+}
+
+// blocks returns the main block and all function blocks with their
+// function declarations, which is nil for the main block.
+func blocks(prog *ast.Program) ([]*ast.Block, []*ast.FuncDecl) {
+	var blocks []*ast.Block
+	var funcs []*ast.FuncDecl
+	var walk func(block *ast.Block, fn *ast.FuncDecl)
+	walk = func(block *ast.Block, fn *ast.FuncDecl) {
+		blocks = append(blocks, block)
+		funcs = append(funcs, fn)
+		for _, decl := range block.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok {
+				walk(d.Body, d)
+			}
+		}
+	}
+	walk(prog.Block, nil)
+	return blocks, funcs
+}
+
+// inspect calls f for the statement and all statements and expressions
+// in it, but not in nested function declarations.
+func inspect(node ast.Node, f func(node ast.Node)) {
+	if node == nil {
+		return
+	}
+	f(node)
+	switch n := node.(type) {
+	case *ast.AssignStmt:
+		inspect(n.Name, f)
+		for _, index := range n.Indices {
+			inspect(index, f)
+		}
+		inspect(n.Value, f)
+	case *ast.CompoundStmt:
+		for _, s := range n.List {
+			inspect(s, f)
+		}
+	case *ast.IfStmt:
+		inspect(n.Cond, f)
+		inspect(n.Then, f)
+		if n.Else != nil {
+			inspect(n.Else, f)
+		}
+	case *ast.CaseStmt:
+		inspect(n.X, f)
+		for _, clause := range n.Clauses {
+			for _, label := range clause.Labels {
+				inspect(label, f)
+			}
+			inspect(clause.Body, f)
+		}
+		inspect(n.Else, f)
+	case *ast.WhileStmt:
+		inspect(n.Cond, f)
+		inspect(n.Body, f)
+	case *ast.RepeatStmt:
+		inspect(n.Body, f)
+		inspect(n.Cond, f)
+	case *ast.ForStmt:
+		inspect(n.Var, f)
+		inspect(n.From, f)
+		inspect(n.To, f)
+		inspect(n.Step, f)
+		inspect(n.Body, f)
+	case *ast.ReturnStmt:
+		inspect(n.Result, f)
+	case *ast.WriteStmt:
+		for _, item := range n.Items {
+			inspect(item, f)
+		}
+	case *ast.WritelnStmt:
+		for _, item := range n.Items {
+			inspect(item, f)
+		}
+	case *ast.CallStmt:
+		inspect(n.X, f)
+	case *ast.WidthExpr:
+		inspect(n.X, f)
+		inspect(n.Width, f)
+	case *ast.ParenExpr:
+		inspect(n.X, f)
+	case *ast.UnaryExpr:
+		inspect(n.X, f)
+	case *ast.BinaryExpr:
+		inspect(n.X, f)
+		inspect(n.Y, f)
+	case *ast.IndexExpr:
+		inspect(n.Name, f)
+		for _, index := range n.Indices {
+			inspect(index, f)
+		}
+	case *ast.CallExpr:
+		inspect(n.Func, f)
+		for _, arg := range n.Args {
+			inspect(arg, f)
+		}
+	}
+}
+
+// declaredSymbols returns the symbols declared in the block,
+// including the parameters of the function.
+func declaredSymbols(info *pl0compiler.Info, block *ast.Block, fn *ast.FuncDecl) []*pl0compiler.Symbol {
+	var syms []*pl0compiler.Symbol
+	add := func(id *ast.Ident) {
+		if sym := info.Defs[id]; sym != nil {
+			syms = append(syms, sym)
+		}
+	}
+	if fn != nil {
+		for _, param := range fn.Params {
+			add(param.Name)
+		}
+	}
+	for _, decl := range block.Decls {
+		switch d := decl.(type) {
+		case *ast.ConstDecl:
+			for _, spec := range d.Specs {
+				add(spec.Name)
+			}
+		case *ast.VarDecl:
+			for _, spec := range d.Specs {
+				add(spec.Name)
+			}
+		case *ast.FuncDecl:
+			add(d.Name)
+		}
+	}
+	return syms
+}
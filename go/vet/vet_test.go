@@ -0,0 +1,295 @@
+package vet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kkpl0/pl0compiler"
+)
+
+var checkTargets = []struct {
+	check  *Check
+	source string
+	want   []string
+}{
+	{MissingReturn, `
+		function f(x) if x > 0 then return 1;
+		function g(x) if x > 0 then return 1 else return 0;
+		function h(x) begin write x; return x; write 0 end;
+		var v;
+		begin v := f(1) + g(1) + h(1) end.`,
+		[]string{"2:12: function f may reach the end without return"},
+	},
+	{MissingReturn, `
+		function f(x) case x of 1: return 1; 2: return 2 end;
+		function g(x) case x of 1: return 1; 2: write x else return 0 end;
+		function h(x) case x of 1: return 1; 2: return 2 else return 0 end;
+		var v;
+		begin v := f(1) + g(1) + h(1) end.`,
+		[]string{
+			"2:12: function f may reach the end without return",
+			"3:12: function g may reach the end without return",
+		},
+	},
+	{MissingReturn, `
+		function f() begin repeat return 1 until 0 = 1 end;
+		function g(x) begin repeat begin if x > 0 then break; return 1 end until 0 = 1 end;
+		function h(x) begin while x > 0 do return 1 end;
+		var v;
+		begin v := f() + g(1) + h(1) end.`,
+		[]string{
+			"3:12: function g may reach the end without return",
+			"4:12: function h may reach the end without return",
+		},
+	},
+	{MissingReturn, `
+		procedure p(x) if x > 0 then write x;
+		begin p(1) end.`,
+		nil,
+	},
+	{Uninit, `
+		var a, b, c, d, e;
+		function set() begin d := 1; return 0 end;
+		begin
+		  if a > 0 then b := 1 else b := 2;
+		  write b;
+		  while b > 0 do c := 1;
+		  write c + c;
+		  repeat e := 1 until e > 0;
+		  b := set();
+		  write d;
+		  write e
+		end.`,
+		[]string{
+			"5:8: variable a may be used before assignment",
+			"8:11: variable c may be used before assignment",
+		},
+	},
+	{Uninit, `
+		function f(x)
+		  var r;
+		begin
+		  if x > 0 then return x else r := 0;
+		  return r
+		end;
+		begin write f(1) end.`,
+		nil,
+	},
+	{Uninit, `
+		var i, n, s, t;
+		begin
+		  n := 3;
+		  for i := 1 to n do begin s := i; t := i end;
+		  write i + s;
+		  for i := t to 1 do write i
+		end.`,
+		[]string{
+			"6:15: variable s may be used before assignment",
+			"7:14: variable t may be used before assignment",
+		},
+	},
+	{Uninit, `
+		var n, w, x;
+		begin
+		  n := 1;
+		  write "n = ", n:w;
+		  writeln "x = ", x
+		end.`,
+		[]string{
+			"5:21: variable w may be used before assignment",
+			"6:21: variable x may be used before assignment",
+		},
+	},
+	{Uninit, `
+		procedure show(x) write x;
+		procedure p()
+		  var a, b;
+		begin a := 1; show(a); call show(b) end;
+		begin p() end.`,
+		[]string{"5:36: variable b may be used before assignment"},
+	},
+	{Uninit, `
+		var i, x, y, z;
+		begin
+		  i := 0;
+		  repeat begin
+		    i := i + 1;
+		    if i > 5 then break;
+		    x := i;
+		    if odd i then continue;
+		    y := i
+		  end until i > 3;
+		  while i > 0 do begin z := i; break end;
+		  write x + y + z
+		end.`,
+		[]string{
+			"13:11: variable x may be used before assignment",
+			"13:15: variable y may be used before assignment",
+			"13:19: variable z may be used before assignment",
+		},
+	},
+	{Uninit, `
+		var i, x;
+		begin
+		  i := 0;
+		  repeat begin i := i + 1; x := i; if i > 2 then break end until i > 5;
+		  write x
+		end.`,
+		nil,
+	},
+	{Uninit, `
+		var i, x, y, z;
+		begin
+		  i := 1;
+		  case i of
+		    1, 2: begin x := 1; y := 1 end;
+		    3: begin x := 2; y := 2; z := 2 end
+		  else begin x := 3; z := 3 end
+		  end;
+		  write x + y + z
+		end.`,
+		[]string{
+			"10:15: variable y may be used before assignment",
+			"10:19: variable z may be used before assignment",
+		},
+	},
+	{Uninit, `
+		procedure set(var v) v := 1;
+		procedure p()
+		  var x, y;
+		begin set(x); write x + y end;
+		begin p() end.`,
+		[]string{"5:27: variable y may be used before assignment"},
+	},
+	{Unused, `
+		var a, void;
+		function divmod(n, d, var r) begin r := n mod d; return n / d end;
+		function unused(var u) return 0;
+		begin void := divmod(7, 2, a); void := unused(a) end.`,
+		[]string{"4:23: parameter u is never used"},
+	},
+	{Unused, `
+		var a, b, c[3], void;
+		function f(x, y, ap[])
+		  var l;
+		begin l := 1; ap[0] := x; return 0 end;
+		begin a := 1; void := f(a, 1, c) end.`,
+		[]string{
+			"2:10: variable b is never used",
+			"3:17: parameter y is never used",
+			"4:9: variable l is assigned but never used",
+		},
+	},
+	{Shadow, `
+		const n = 10, m = 1;
+		function f(n)
+		  var m;
+		  const x = 1;
+		begin m := n; return m + x end;
+		begin write f(n) end.`,
+		[]string{
+			"3:14: var n shadows constant n",
+			"4:9: var m shadows constant m",
+		},
+	},
+	{Discard, `
+		var void, r, a;
+		function f() return 1;
+		begin void := f(); void := f(); r := f(); a := abs(r); write r end.`,
+		[]string{
+			"4:9: result of f is discarded (void is never used)",
+			"4:22: result of f is discarded (void is never used)",
+		},
+	},
+	{ConstCond, `
+		const debug = 0;
+		var x;
+		begin
+		  x := 1;
+		  if debug = 1 then write x;
+		  while 1 = 1 do x := x + 1;
+		  repeat x := x - 1 until odd 3;
+		  if x > 1 / 0 then write x;
+		  if x > debug then write x;
+		  if not debug = 0 or debug > 1 then write x;
+		  if x > 0 and debug = 0 then write x;
+		  if x mod 2 = 0 then write x;
+		  if max(debug, 2 mod 3) = 2 then write x
+		end.`,
+		[]string{
+			"6:8: condition is always false",
+			"7:11: condition is always true",
+			"8:29: condition is always true",
+			"11:8: condition is always false",
+			"14:8: condition is always true",
+		},
+	},
+}
+
+func TestChecks(t *testing.T) {
+	for nth, target := range checkTargets {
+		findings, err := CheckSource("test", []byte(target.source), []*Check{target.check})
+		if err != nil {
+			t.Errorf("#%d: Error: %s", nth, err)
+			continue
+		}
+		var got []string
+		for _, f := range findings {
+			if f.Check != target.check.Name {
+				t.Errorf("#%d: Got check %s", nth, f.Check)
+			}
+			got = append(got, fmt.Sprintf("%d:%d: %s", f.Pos.Line, f.Pos.Column, f.Msg))
+		}
+		if strings.Join(got, "\n") != strings.Join(target.want, "\n") {
+			t.Errorf("#%d: %s:\nGot:\n%s\nWant:\n%s", nth, target.check.Name,
+				strings.Join(got, "\n"), strings.Join(target.want, "\n"))
+		}
+	}
+}
+
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "examples", "*.pl0"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No examples: %v", err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		findings, err := CheckSource(file, src, Checks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only qsort.pl0 uses void := f(...) of functions without return
+		for _, f := range findings {
+			if filepath.Base(file) != "qsort.pl0" ||
+				f.Check != MissingReturn.Name && f.Check != Discard.Name {
+				t.Errorf("%s:%d:%d: %s (%s)", file, f.Pos.Line, f.Pos.Column, f.Msg, f.Check)
+			}
+		}
+	}
+}
+
+func TestWirthExamples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "examples", "wirth", "*.pl0"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No examples: %v", err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		findings, err := CheckSourceDialect(file, src, pl0compiler.DialectWirth, Checks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range findings {
+			t.Errorf("%s:%d:%d: %s (%s)", file, f.Pos.Line, f.Pos.Column, f.Msg, f.Check)
+		}
+	}
+}